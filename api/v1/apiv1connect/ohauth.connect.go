@@ -35,6 +35,9 @@ const (
 const (
 	// DatabaseServiceGetUserProcedure is the fully-qualified name of the DatabaseService's GetUser RPC.
 	DatabaseServiceGetUserProcedure = "/api.v1.DatabaseService/GetUser"
+	// DatabaseServiceUpdateUserProfileProcedure is the fully-qualified name of the DatabaseService's
+	// UpdateUserProfile RPC.
+	DatabaseServiceUpdateUserProfileProcedure = "/api.v1.DatabaseService/UpdateUserProfile"
 	// DatabaseServiceGetServiceClientProcedure is the fully-qualified name of the DatabaseService's
 	// GetServiceClient RPC.
 	DatabaseServiceGetServiceClientProcedure = "/api.v1.DatabaseService/GetServiceClient"
@@ -56,27 +59,118 @@ const (
 	// DatabaseServiceCreateRefreshTokenProcedure is the fully-qualified name of the DatabaseService's
 	// CreateRefreshToken RPC.
 	DatabaseServiceCreateRefreshTokenProcedure = "/api.v1.DatabaseService/CreateRefreshToken"
+	// DatabaseServiceGetResourceServerProcedure is the fully-qualified name of the DatabaseService's
+	// GetResourceServer RPC.
+	DatabaseServiceGetResourceServerProcedure = "/api.v1.DatabaseService/GetResourceServer"
+	// DatabaseServiceListResourceServersProcedure is the fully-qualified name of the DatabaseService's
+	// ListResourceServers RPC.
+	DatabaseServiceListResourceServersProcedure = "/api.v1.DatabaseService/ListResourceServers"
+	// DatabaseServiceConsumeAuthorizationCodeProcedure is the fully-qualified name of the
+	// DatabaseService's ConsumeAuthorizationCode RPC.
+	DatabaseServiceConsumeAuthorizationCodeProcedure = "/api.v1.DatabaseService/ConsumeAuthorizationCode"
+	// DatabaseServiceRevokeAccessTokenProcedure is the fully-qualified name of the DatabaseService's
+	// RevokeAccessToken RPC.
+	DatabaseServiceRevokeAccessTokenProcedure = "/api.v1.DatabaseService/RevokeAccessToken"
+	// DatabaseServiceRevokeRefreshTokenProcedure is the fully-qualified name of the DatabaseService's
+	// RevokeRefreshToken RPC.
+	DatabaseServiceRevokeRefreshTokenProcedure = "/api.v1.DatabaseService/RevokeRefreshToken"
+	// DatabaseServiceGetUserUnaryProcedure is the fully-qualified name of the DatabaseService's
+	// GetUserUnary RPC.
+	DatabaseServiceGetUserUnaryProcedure = "/api.v1.DatabaseService/GetUserUnary"
+	// DatabaseServiceUpdateUserProfileUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's UpdateUserProfileUnary RPC.
+	DatabaseServiceUpdateUserProfileUnaryProcedure = "/api.v1.DatabaseService/UpdateUserProfileUnary"
+	// DatabaseServiceGetServiceClientUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's GetServiceClientUnary RPC.
+	DatabaseServiceGetServiceClientUnaryProcedure = "/api.v1.DatabaseService/GetServiceClientUnary"
+	// DatabaseServiceGetAuthorizationCodeUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's GetAuthorizationCodeUnary RPC.
+	DatabaseServiceGetAuthorizationCodeUnaryProcedure = "/api.v1.DatabaseService/GetAuthorizationCodeUnary"
+	// DatabaseServiceCreateAuthorizationCodeUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's CreateAuthorizationCodeUnary RPC.
+	DatabaseServiceCreateAuthorizationCodeUnaryProcedure = "/api.v1.DatabaseService/CreateAuthorizationCodeUnary"
+	// DatabaseServiceGetAccessTokenUnaryProcedure is the fully-qualified name of the DatabaseService's
+	// GetAccessTokenUnary RPC.
+	DatabaseServiceGetAccessTokenUnaryProcedure = "/api.v1.DatabaseService/GetAccessTokenUnary"
+	// DatabaseServiceCreateAccessTokenUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's CreateAccessTokenUnary RPC.
+	DatabaseServiceCreateAccessTokenUnaryProcedure = "/api.v1.DatabaseService/CreateAccessTokenUnary"
+	// DatabaseServiceGetRefreshTokenUnaryProcedure is the fully-qualified name of the DatabaseService's
+	// GetRefreshTokenUnary RPC.
+	DatabaseServiceGetRefreshTokenUnaryProcedure = "/api.v1.DatabaseService/GetRefreshTokenUnary"
+	// DatabaseServiceCreateRefreshTokenUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's CreateRefreshTokenUnary RPC.
+	DatabaseServiceCreateRefreshTokenUnaryProcedure = "/api.v1.DatabaseService/CreateRefreshTokenUnary"
+	// DatabaseServiceGetResourceServerUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's GetResourceServerUnary RPC.
+	DatabaseServiceGetResourceServerUnaryProcedure = "/api.v1.DatabaseService/GetResourceServerUnary"
+	// DatabaseServiceListResourceServersUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's ListResourceServersUnary RPC.
+	DatabaseServiceListResourceServersUnaryProcedure = "/api.v1.DatabaseService/ListResourceServersUnary"
+	// DatabaseServiceConsumeAuthorizationCodeUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's ConsumeAuthorizationCodeUnary RPC.
+	DatabaseServiceConsumeAuthorizationCodeUnaryProcedure = "/api.v1.DatabaseService/ConsumeAuthorizationCodeUnary"
+	// DatabaseServiceRevokeAccessTokenUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's RevokeAccessTokenUnary RPC.
+	DatabaseServiceRevokeAccessTokenUnaryProcedure = "/api.v1.DatabaseService/RevokeAccessTokenUnary"
+	// DatabaseServiceRevokeRefreshTokenUnaryProcedure is the fully-qualified name of the
+	// DatabaseService's RevokeRefreshTokenUnary RPC.
+	DatabaseServiceRevokeRefreshTokenUnaryProcedure = "/api.v1.DatabaseService/RevokeRefreshTokenUnary"
+	// DatabaseServiceBatchGetAccessTokensProcedure is the fully-qualified name of the DatabaseService's
+	// BatchGetAccessTokens RPC.
+	DatabaseServiceBatchGetAccessTokensProcedure = "/api.v1.DatabaseService/BatchGetAccessTokens"
+	// DatabaseServiceBatchCreateProcedure is the fully-qualified name of the DatabaseService's
+	// BatchCreate RPC.
+	DatabaseServiceBatchCreateProcedure = "/api.v1.DatabaseService/BatchCreate"
 	// DatabaseServicePingProcedure is the fully-qualified name of the DatabaseService's Ping RPC.
 	DatabaseServicePingProcedure = "/api.v1.DatabaseService/Ping"
+	// DatabaseServiceWatchProcedure is the fully-qualified name of the DatabaseService's Watch RPC.
+	DatabaseServiceWatchProcedure = "/api.v1.DatabaseService/Watch"
 )
 
 // These variables are the protoreflect.Descriptor objects for the RPCs defined in this package.
 var (
-	databaseServiceServiceDescriptor                       = v1.File_api_v1_ohauth_proto.Services().ByName("DatabaseService")
-	databaseServiceGetUserMethodDescriptor                 = databaseServiceServiceDescriptor.Methods().ByName("GetUser")
-	databaseServiceGetServiceClientMethodDescriptor        = databaseServiceServiceDescriptor.Methods().ByName("GetServiceClient")
-	databaseServiceGetAuthorizationCodeMethodDescriptor    = databaseServiceServiceDescriptor.Methods().ByName("GetAuthorizationCode")
-	databaseServiceCreateAuthorizationCodeMethodDescriptor = databaseServiceServiceDescriptor.Methods().ByName("CreateAuthorizationCode")
-	databaseServiceGetAccessTokenMethodDescriptor          = databaseServiceServiceDescriptor.Methods().ByName("GetAccessToken")
-	databaseServiceCreateAccessTokenMethodDescriptor       = databaseServiceServiceDescriptor.Methods().ByName("CreateAccessToken")
-	databaseServiceGetRefreshTokenMethodDescriptor         = databaseServiceServiceDescriptor.Methods().ByName("GetRefreshToken")
-	databaseServiceCreateRefreshTokenMethodDescriptor      = databaseServiceServiceDescriptor.Methods().ByName("CreateRefreshToken")
-	databaseServicePingMethodDescriptor                    = databaseServiceServiceDescriptor.Methods().ByName("Ping")
+	databaseServiceServiceDescriptor                             = v1.File_api_v1_ohauth_proto.Services().ByName("DatabaseService")
+	databaseServiceGetUserMethodDescriptor                       = databaseServiceServiceDescriptor.Methods().ByName("GetUser")
+	databaseServiceUpdateUserProfileMethodDescriptor             = databaseServiceServiceDescriptor.Methods().ByName("UpdateUserProfile")
+	databaseServiceGetServiceClientMethodDescriptor              = databaseServiceServiceDescriptor.Methods().ByName("GetServiceClient")
+	databaseServiceGetAuthorizationCodeMethodDescriptor          = databaseServiceServiceDescriptor.Methods().ByName("GetAuthorizationCode")
+	databaseServiceCreateAuthorizationCodeMethodDescriptor       = databaseServiceServiceDescriptor.Methods().ByName("CreateAuthorizationCode")
+	databaseServiceGetAccessTokenMethodDescriptor                = databaseServiceServiceDescriptor.Methods().ByName("GetAccessToken")
+	databaseServiceCreateAccessTokenMethodDescriptor             = databaseServiceServiceDescriptor.Methods().ByName("CreateAccessToken")
+	databaseServiceGetRefreshTokenMethodDescriptor               = databaseServiceServiceDescriptor.Methods().ByName("GetRefreshToken")
+	databaseServiceCreateRefreshTokenMethodDescriptor            = databaseServiceServiceDescriptor.Methods().ByName("CreateRefreshToken")
+	databaseServiceGetResourceServerMethodDescriptor             = databaseServiceServiceDescriptor.Methods().ByName("GetResourceServer")
+	databaseServiceListResourceServersMethodDescriptor           = databaseServiceServiceDescriptor.Methods().ByName("ListResourceServers")
+	databaseServiceConsumeAuthorizationCodeMethodDescriptor      = databaseServiceServiceDescriptor.Methods().ByName("ConsumeAuthorizationCode")
+	databaseServiceRevokeAccessTokenMethodDescriptor             = databaseServiceServiceDescriptor.Methods().ByName("RevokeAccessToken")
+	databaseServiceRevokeRefreshTokenMethodDescriptor            = databaseServiceServiceDescriptor.Methods().ByName("RevokeRefreshToken")
+	databaseServiceGetUserUnaryMethodDescriptor                  = databaseServiceServiceDescriptor.Methods().ByName("GetUserUnary")
+	databaseServiceUpdateUserProfileUnaryMethodDescriptor        = databaseServiceServiceDescriptor.Methods().ByName("UpdateUserProfileUnary")
+	databaseServiceGetServiceClientUnaryMethodDescriptor         = databaseServiceServiceDescriptor.Methods().ByName("GetServiceClientUnary")
+	databaseServiceGetAuthorizationCodeUnaryMethodDescriptor     = databaseServiceServiceDescriptor.Methods().ByName("GetAuthorizationCodeUnary")
+	databaseServiceCreateAuthorizationCodeUnaryMethodDescriptor  = databaseServiceServiceDescriptor.Methods().ByName("CreateAuthorizationCodeUnary")
+	databaseServiceGetAccessTokenUnaryMethodDescriptor           = databaseServiceServiceDescriptor.Methods().ByName("GetAccessTokenUnary")
+	databaseServiceCreateAccessTokenUnaryMethodDescriptor        = databaseServiceServiceDescriptor.Methods().ByName("CreateAccessTokenUnary")
+	databaseServiceGetRefreshTokenUnaryMethodDescriptor          = databaseServiceServiceDescriptor.Methods().ByName("GetRefreshTokenUnary")
+	databaseServiceCreateRefreshTokenUnaryMethodDescriptor       = databaseServiceServiceDescriptor.Methods().ByName("CreateRefreshTokenUnary")
+	databaseServiceGetResourceServerUnaryMethodDescriptor        = databaseServiceServiceDescriptor.Methods().ByName("GetResourceServerUnary")
+	databaseServiceListResourceServersUnaryMethodDescriptor      = databaseServiceServiceDescriptor.Methods().ByName("ListResourceServersUnary")
+	databaseServiceConsumeAuthorizationCodeUnaryMethodDescriptor = databaseServiceServiceDescriptor.Methods().ByName("ConsumeAuthorizationCodeUnary")
+	databaseServiceRevokeAccessTokenUnaryMethodDescriptor        = databaseServiceServiceDescriptor.Methods().ByName("RevokeAccessTokenUnary")
+	databaseServiceRevokeRefreshTokenUnaryMethodDescriptor       = databaseServiceServiceDescriptor.Methods().ByName("RevokeRefreshTokenUnary")
+	databaseServiceBatchGetAccessTokensMethodDescriptor          = databaseServiceServiceDescriptor.Methods().ByName("BatchGetAccessTokens")
+	databaseServiceBatchCreateMethodDescriptor                   = databaseServiceServiceDescriptor.Methods().ByName("BatchCreate")
+	databaseServicePingMethodDescriptor                          = databaseServiceServiceDescriptor.Methods().ByName("Ping")
+	databaseServiceWatchMethodDescriptor                         = databaseServiceServiceDescriptor.Methods().ByName("Watch")
 )
 
 // DatabaseServiceClient is a client for the api.v1.DatabaseService service.
 type DatabaseServiceClient interface {
+	// the original streaming RPCs, kept for the existing clients and for pipelined use.
+	// each request is answered in order; the stream ends at the first error.
 	GetUser(context.Context) *connect.BidiStreamForClient[v1.GetUserRequest, v1.GetUserResponse]
+	UpdateUserProfile(context.Context) *connect.BidiStreamForClient[v1.UpdateUserProfileRequest, v1.UpdateUserProfileResponse]
 	GetServiceClient(context.Context) *connect.BidiStreamForClient[v1.GetServiceClientRequest, v1.GetServiceClientResponse]
 	GetAuthorizationCode(context.Context) *connect.BidiStreamForClient[v1.GetAuthorizationCodeRequest, v1.GetAuthorizationCodeResponse]
 	CreateAuthorizationCode(context.Context) *connect.BidiStreamForClient[v1.CreateAuthorizationCodeRequest, v1.CreateAuthorizationCodeResponse]
@@ -84,7 +178,37 @@ type DatabaseServiceClient interface {
 	CreateAccessToken(context.Context) *connect.BidiStreamForClient[v1.CreateAccessTokenRequest, v1.CreateAccessTokenResponse]
 	GetRefreshToken(context.Context) *connect.BidiStreamForClient[v1.GetRefreshTokenRequest, v1.GetRefreshTokenResponse]
 	CreateRefreshToken(context.Context) *connect.BidiStreamForClient[v1.CreateRefreshTokenRequest, v1.CreateRefreshTokenResponse]
+	GetResourceServer(context.Context) *connect.BidiStreamForClient[v1.GetResourceServerRequest, v1.GetResourceServerResponse]
+	ListResourceServers(context.Context) *connect.BidiStreamForClient[v1.ListResourceServersRequest, v1.ListResourceServersResponse]
+	ConsumeAuthorizationCode(context.Context) *connect.BidiStreamForClient[v1.ConsumeAuthorizationCodeRequest, v1.ConsumeAuthorizationCodeResponse]
+	RevokeAccessToken(context.Context) *connect.BidiStreamForClient[v1.RevokeAccessTokenRequest, v1.RevokeAccessTokenResponse]
+	RevokeRefreshToken(context.Context) *connect.BidiStreamForClient[v1.RevokeRefreshTokenRequest, v1.RevokeRefreshTokenResponse]
+	// unary variants of the streaming RPCs. reads have no side effects, so the clients retry them.
+	GetUserUnary(context.Context, *connect.Request[v1.GetUserRequest]) (*connect.Response[v1.GetUserResponse], error)
+	UpdateUserProfileUnary(context.Context, *connect.Request[v1.UpdateUserProfileRequest]) (*connect.Response[v1.UpdateUserProfileResponse], error)
+	GetServiceClientUnary(context.Context, *connect.Request[v1.GetServiceClientRequest]) (*connect.Response[v1.GetServiceClientResponse], error)
+	GetAuthorizationCodeUnary(context.Context, *connect.Request[v1.GetAuthorizationCodeRequest]) (*connect.Response[v1.GetAuthorizationCodeResponse], error)
+	CreateAuthorizationCodeUnary(context.Context, *connect.Request[v1.CreateAuthorizationCodeRequest]) (*connect.Response[v1.CreateAuthorizationCodeResponse], error)
+	GetAccessTokenUnary(context.Context, *connect.Request[v1.GetAccessTokenRequest]) (*connect.Response[v1.GetAccessTokenResponse], error)
+	CreateAccessTokenUnary(context.Context, *connect.Request[v1.CreateAccessTokenRequest]) (*connect.Response[v1.CreateAccessTokenResponse], error)
+	GetRefreshTokenUnary(context.Context, *connect.Request[v1.GetRefreshTokenRequest]) (*connect.Response[v1.GetRefreshTokenResponse], error)
+	CreateRefreshTokenUnary(context.Context, *connect.Request[v1.CreateRefreshTokenRequest]) (*connect.Response[v1.CreateRefreshTokenResponse], error)
+	GetResourceServerUnary(context.Context, *connect.Request[v1.GetResourceServerRequest]) (*connect.Response[v1.GetResourceServerResponse], error)
+	ListResourceServersUnary(context.Context, *connect.Request[v1.ListResourceServersRequest]) (*connect.Response[v1.ListResourceServersResponse], error)
+	// marks the code consumed. fails with ABORTED if it is already consumed.
+	ConsumeAuthorizationCodeUnary(context.Context, *connect.Request[v1.ConsumeAuthorizationCodeRequest]) (*connect.Response[v1.ConsumeAuthorizationCodeResponse], error)
+	// revokes the token. fails with ABORTED if it is already revoked.
+	RevokeAccessTokenUnary(context.Context, *connect.Request[v1.RevokeAccessTokenRequest]) (*connect.Response[v1.RevokeAccessTokenResponse], error)
+	RevokeRefreshTokenUnary(context.Context, *connect.Request[v1.RevokeRefreshTokenRequest]) (*connect.Response[v1.RevokeRefreshTokenResponse], error)
+	// returns the found tokens. unknown tokens are omitted.
+	BatchGetAccessTokens(context.Context, *connect.Request[v1.BatchGetAccessTokensRequest]) (*connect.Response[v1.BatchGetAccessTokensResponse], error)
+	// creates all rows or none. fails with ALREADY_EXISTS if any of them exists.
+	BatchCreate(context.Context, *connect.Request[v1.BatchCreateRequest]) (*connect.Response[v1.BatchCreateResponse], error)
 	Ping(context.Context, *connect.Request[v1.PingRequest]) (*connect.Response[v1.PingResponse], error)
+	// streams the changes after the cursor of the request. the first response has no event and
+	// tells the current cursor. fails with OUT_OF_RANGE if the server no longer keeps the events
+	// after the cursor; the watcher must reload the rows and watch from an empty cursor.
+	Watch(context.Context, *connect.Request[v1.WatchRequest]) (*connect.ServerStreamForClient[v1.WatchResponse], error)
 }
 
 // NewDatabaseServiceClient constructs a client for the api.v1.DatabaseService service. By default,
@@ -103,6 +227,12 @@ func NewDatabaseServiceClient(httpClient connect.HTTPClient, baseURL string, opt
 			connect.WithSchema(databaseServiceGetUserMethodDescriptor),
 			connect.WithClientOptions(opts...),
 		),
+		updateUserProfile: connect.NewClient[v1.UpdateUserProfileRequest, v1.UpdateUserProfileResponse](
+			httpClient,
+			baseURL+DatabaseServiceUpdateUserProfileProcedure,
+			connect.WithSchema(databaseServiceUpdateUserProfileMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
 		getServiceClient: connect.NewClient[v1.GetServiceClientRequest, v1.GetServiceClientResponse](
 			httpClient,
 			baseURL+DatabaseServiceGetServiceClientProcedure,
@@ -145,10 +275,151 @@ func NewDatabaseServiceClient(httpClient connect.HTTPClient, baseURL string, opt
 			connect.WithSchema(databaseServiceCreateRefreshTokenMethodDescriptor),
 			connect.WithClientOptions(opts...),
 		),
+		getResourceServer: connect.NewClient[v1.GetResourceServerRequest, v1.GetResourceServerResponse](
+			httpClient,
+			baseURL+DatabaseServiceGetResourceServerProcedure,
+			connect.WithSchema(databaseServiceGetResourceServerMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		listResourceServers: connect.NewClient[v1.ListResourceServersRequest, v1.ListResourceServersResponse](
+			httpClient,
+			baseURL+DatabaseServiceListResourceServersProcedure,
+			connect.WithSchema(databaseServiceListResourceServersMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		consumeAuthorizationCode: connect.NewClient[v1.ConsumeAuthorizationCodeRequest, v1.ConsumeAuthorizationCodeResponse](
+			httpClient,
+			baseURL+DatabaseServiceConsumeAuthorizationCodeProcedure,
+			connect.WithSchema(databaseServiceConsumeAuthorizationCodeMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		revokeAccessToken: connect.NewClient[v1.RevokeAccessTokenRequest, v1.RevokeAccessTokenResponse](
+			httpClient,
+			baseURL+DatabaseServiceRevokeAccessTokenProcedure,
+			connect.WithSchema(databaseServiceRevokeAccessTokenMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		revokeRefreshToken: connect.NewClient[v1.RevokeRefreshTokenRequest, v1.RevokeRefreshTokenResponse](
+			httpClient,
+			baseURL+DatabaseServiceRevokeRefreshTokenProcedure,
+			connect.WithSchema(databaseServiceRevokeRefreshTokenMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		getUserUnary: connect.NewClient[v1.GetUserRequest, v1.GetUserResponse](
+			httpClient,
+			baseURL+DatabaseServiceGetUserUnaryProcedure,
+			connect.WithSchema(databaseServiceGetUserUnaryMethodDescriptor),
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithClientOptions(opts...),
+		),
+		updateUserProfileUnary: connect.NewClient[v1.UpdateUserProfileRequest, v1.UpdateUserProfileResponse](
+			httpClient,
+			baseURL+DatabaseServiceUpdateUserProfileUnaryProcedure,
+			connect.WithSchema(databaseServiceUpdateUserProfileUnaryMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		getServiceClientUnary: connect.NewClient[v1.GetServiceClientRequest, v1.GetServiceClientResponse](
+			httpClient,
+			baseURL+DatabaseServiceGetServiceClientUnaryProcedure,
+			connect.WithSchema(databaseServiceGetServiceClientUnaryMethodDescriptor),
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithClientOptions(opts...),
+		),
+		getAuthorizationCodeUnary: connect.NewClient[v1.GetAuthorizationCodeRequest, v1.GetAuthorizationCodeResponse](
+			httpClient,
+			baseURL+DatabaseServiceGetAuthorizationCodeUnaryProcedure,
+			connect.WithSchema(databaseServiceGetAuthorizationCodeUnaryMethodDescriptor),
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithClientOptions(opts...),
+		),
+		createAuthorizationCodeUnary: connect.NewClient[v1.CreateAuthorizationCodeRequest, v1.CreateAuthorizationCodeResponse](
+			httpClient,
+			baseURL+DatabaseServiceCreateAuthorizationCodeUnaryProcedure,
+			connect.WithSchema(databaseServiceCreateAuthorizationCodeUnaryMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		getAccessTokenUnary: connect.NewClient[v1.GetAccessTokenRequest, v1.GetAccessTokenResponse](
+			httpClient,
+			baseURL+DatabaseServiceGetAccessTokenUnaryProcedure,
+			connect.WithSchema(databaseServiceGetAccessTokenUnaryMethodDescriptor),
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithClientOptions(opts...),
+		),
+		createAccessTokenUnary: connect.NewClient[v1.CreateAccessTokenRequest, v1.CreateAccessTokenResponse](
+			httpClient,
+			baseURL+DatabaseServiceCreateAccessTokenUnaryProcedure,
+			connect.WithSchema(databaseServiceCreateAccessTokenUnaryMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		getRefreshTokenUnary: connect.NewClient[v1.GetRefreshTokenRequest, v1.GetRefreshTokenResponse](
+			httpClient,
+			baseURL+DatabaseServiceGetRefreshTokenUnaryProcedure,
+			connect.WithSchema(databaseServiceGetRefreshTokenUnaryMethodDescriptor),
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithClientOptions(opts...),
+		),
+		createRefreshTokenUnary: connect.NewClient[v1.CreateRefreshTokenRequest, v1.CreateRefreshTokenResponse](
+			httpClient,
+			baseURL+DatabaseServiceCreateRefreshTokenUnaryProcedure,
+			connect.WithSchema(databaseServiceCreateRefreshTokenUnaryMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		getResourceServerUnary: connect.NewClient[v1.GetResourceServerRequest, v1.GetResourceServerResponse](
+			httpClient,
+			baseURL+DatabaseServiceGetResourceServerUnaryProcedure,
+			connect.WithSchema(databaseServiceGetResourceServerUnaryMethodDescriptor),
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithClientOptions(opts...),
+		),
+		listResourceServersUnary: connect.NewClient[v1.ListResourceServersRequest, v1.ListResourceServersResponse](
+			httpClient,
+			baseURL+DatabaseServiceListResourceServersUnaryProcedure,
+			connect.WithSchema(databaseServiceListResourceServersUnaryMethodDescriptor),
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithClientOptions(opts...),
+		),
+		consumeAuthorizationCodeUnary: connect.NewClient[v1.ConsumeAuthorizationCodeRequest, v1.ConsumeAuthorizationCodeResponse](
+			httpClient,
+			baseURL+DatabaseServiceConsumeAuthorizationCodeUnaryProcedure,
+			connect.WithSchema(databaseServiceConsumeAuthorizationCodeUnaryMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		revokeAccessTokenUnary: connect.NewClient[v1.RevokeAccessTokenRequest, v1.RevokeAccessTokenResponse](
+			httpClient,
+			baseURL+DatabaseServiceRevokeAccessTokenUnaryProcedure,
+			connect.WithSchema(databaseServiceRevokeAccessTokenUnaryMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		revokeRefreshTokenUnary: connect.NewClient[v1.RevokeRefreshTokenRequest, v1.RevokeRefreshTokenResponse](
+			httpClient,
+			baseURL+DatabaseServiceRevokeRefreshTokenUnaryProcedure,
+			connect.WithSchema(databaseServiceRevokeRefreshTokenUnaryMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		batchGetAccessTokens: connect.NewClient[v1.BatchGetAccessTokensRequest, v1.BatchGetAccessTokensResponse](
+			httpClient,
+			baseURL+DatabaseServiceBatchGetAccessTokensProcedure,
+			connect.WithSchema(databaseServiceBatchGetAccessTokensMethodDescriptor),
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithClientOptions(opts...),
+		),
+		batchCreate: connect.NewClient[v1.BatchCreateRequest, v1.BatchCreateResponse](
+			httpClient,
+			baseURL+DatabaseServiceBatchCreateProcedure,
+			connect.WithSchema(databaseServiceBatchCreateMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
 		ping: connect.NewClient[v1.PingRequest, v1.PingResponse](
 			httpClient,
 			baseURL+DatabaseServicePingProcedure,
 			connect.WithSchema(databaseServicePingMethodDescriptor),
+			connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+			connect.WithClientOptions(opts...),
+		),
+		watch: connect.NewClient[v1.WatchRequest, v1.WatchResponse](
+			httpClient,
+			baseURL+DatabaseServiceWatchProcedure,
+			connect.WithSchema(databaseServiceWatchMethodDescriptor),
 			connect.WithClientOptions(opts...),
 		),
 	}
@@ -156,15 +427,38 @@ func NewDatabaseServiceClient(httpClient connect.HTTPClient, baseURL string, opt
 
 // databaseServiceClient implements DatabaseServiceClient.
 type databaseServiceClient struct {
-	getUser                 *connect.Client[v1.GetUserRequest, v1.GetUserResponse]
-	getServiceClient        *connect.Client[v1.GetServiceClientRequest, v1.GetServiceClientResponse]
-	getAuthorizationCode    *connect.Client[v1.GetAuthorizationCodeRequest, v1.GetAuthorizationCodeResponse]
-	createAuthorizationCode *connect.Client[v1.CreateAuthorizationCodeRequest, v1.CreateAuthorizationCodeResponse]
-	getAccessToken          *connect.Client[v1.GetAccessTokenRequest, v1.GetAccessTokenResponse]
-	createAccessToken       *connect.Client[v1.CreateAccessTokenRequest, v1.CreateAccessTokenResponse]
-	getRefreshToken         *connect.Client[v1.GetRefreshTokenRequest, v1.GetRefreshTokenResponse]
-	createRefreshToken      *connect.Client[v1.CreateRefreshTokenRequest, v1.CreateRefreshTokenResponse]
-	ping                    *connect.Client[v1.PingRequest, v1.PingResponse]
+	getUser                       *connect.Client[v1.GetUserRequest, v1.GetUserResponse]
+	updateUserProfile             *connect.Client[v1.UpdateUserProfileRequest, v1.UpdateUserProfileResponse]
+	getServiceClient              *connect.Client[v1.GetServiceClientRequest, v1.GetServiceClientResponse]
+	getAuthorizationCode          *connect.Client[v1.GetAuthorizationCodeRequest, v1.GetAuthorizationCodeResponse]
+	createAuthorizationCode       *connect.Client[v1.CreateAuthorizationCodeRequest, v1.CreateAuthorizationCodeResponse]
+	getAccessToken                *connect.Client[v1.GetAccessTokenRequest, v1.GetAccessTokenResponse]
+	createAccessToken             *connect.Client[v1.CreateAccessTokenRequest, v1.CreateAccessTokenResponse]
+	getRefreshToken               *connect.Client[v1.GetRefreshTokenRequest, v1.GetRefreshTokenResponse]
+	createRefreshToken            *connect.Client[v1.CreateRefreshTokenRequest, v1.CreateRefreshTokenResponse]
+	getResourceServer             *connect.Client[v1.GetResourceServerRequest, v1.GetResourceServerResponse]
+	listResourceServers           *connect.Client[v1.ListResourceServersRequest, v1.ListResourceServersResponse]
+	consumeAuthorizationCode      *connect.Client[v1.ConsumeAuthorizationCodeRequest, v1.ConsumeAuthorizationCodeResponse]
+	revokeAccessToken             *connect.Client[v1.RevokeAccessTokenRequest, v1.RevokeAccessTokenResponse]
+	revokeRefreshToken            *connect.Client[v1.RevokeRefreshTokenRequest, v1.RevokeRefreshTokenResponse]
+	getUserUnary                  *connect.Client[v1.GetUserRequest, v1.GetUserResponse]
+	updateUserProfileUnary        *connect.Client[v1.UpdateUserProfileRequest, v1.UpdateUserProfileResponse]
+	getServiceClientUnary         *connect.Client[v1.GetServiceClientRequest, v1.GetServiceClientResponse]
+	getAuthorizationCodeUnary     *connect.Client[v1.GetAuthorizationCodeRequest, v1.GetAuthorizationCodeResponse]
+	createAuthorizationCodeUnary  *connect.Client[v1.CreateAuthorizationCodeRequest, v1.CreateAuthorizationCodeResponse]
+	getAccessTokenUnary           *connect.Client[v1.GetAccessTokenRequest, v1.GetAccessTokenResponse]
+	createAccessTokenUnary        *connect.Client[v1.CreateAccessTokenRequest, v1.CreateAccessTokenResponse]
+	getRefreshTokenUnary          *connect.Client[v1.GetRefreshTokenRequest, v1.GetRefreshTokenResponse]
+	createRefreshTokenUnary       *connect.Client[v1.CreateRefreshTokenRequest, v1.CreateRefreshTokenResponse]
+	getResourceServerUnary        *connect.Client[v1.GetResourceServerRequest, v1.GetResourceServerResponse]
+	listResourceServersUnary      *connect.Client[v1.ListResourceServersRequest, v1.ListResourceServersResponse]
+	consumeAuthorizationCodeUnary *connect.Client[v1.ConsumeAuthorizationCodeRequest, v1.ConsumeAuthorizationCodeResponse]
+	revokeAccessTokenUnary        *connect.Client[v1.RevokeAccessTokenRequest, v1.RevokeAccessTokenResponse]
+	revokeRefreshTokenUnary       *connect.Client[v1.RevokeRefreshTokenRequest, v1.RevokeRefreshTokenResponse]
+	batchGetAccessTokens          *connect.Client[v1.BatchGetAccessTokensRequest, v1.BatchGetAccessTokensResponse]
+	batchCreate                   *connect.Client[v1.BatchCreateRequest, v1.BatchCreateResponse]
+	ping                          *connect.Client[v1.PingRequest, v1.PingResponse]
+	watch                         *connect.Client[v1.WatchRequest, v1.WatchResponse]
 }
 
 // GetUser calls api.v1.DatabaseService.GetUser.
@@ -172,6 +466,11 @@ func (c *databaseServiceClient) GetUser(ctx context.Context) *connect.BidiStream
 	return c.getUser.CallBidiStream(ctx)
 }
 
+// UpdateUserProfile calls api.v1.DatabaseService.UpdateUserProfile.
+func (c *databaseServiceClient) UpdateUserProfile(ctx context.Context) *connect.BidiStreamForClient[v1.UpdateUserProfileRequest, v1.UpdateUserProfileResponse] {
+	return c.updateUserProfile.CallBidiStream(ctx)
+}
+
 // GetServiceClient calls api.v1.DatabaseService.GetServiceClient.
 func (c *databaseServiceClient) GetServiceClient(ctx context.Context) *connect.BidiStreamForClient[v1.GetServiceClientRequest, v1.GetServiceClientResponse] {
 	return c.getServiceClient.CallBidiStream(ctx)
@@ -207,14 +506,127 @@ func (c *databaseServiceClient) CreateRefreshToken(ctx context.Context) *connect
 	return c.createRefreshToken.CallBidiStream(ctx)
 }
 
+// GetResourceServer calls api.v1.DatabaseService.GetResourceServer.
+func (c *databaseServiceClient) GetResourceServer(ctx context.Context) *connect.BidiStreamForClient[v1.GetResourceServerRequest, v1.GetResourceServerResponse] {
+	return c.getResourceServer.CallBidiStream(ctx)
+}
+
+// ListResourceServers calls api.v1.DatabaseService.ListResourceServers.
+func (c *databaseServiceClient) ListResourceServers(ctx context.Context) *connect.BidiStreamForClient[v1.ListResourceServersRequest, v1.ListResourceServersResponse] {
+	return c.listResourceServers.CallBidiStream(ctx)
+}
+
+// ConsumeAuthorizationCode calls api.v1.DatabaseService.ConsumeAuthorizationCode.
+func (c *databaseServiceClient) ConsumeAuthorizationCode(ctx context.Context) *connect.BidiStreamForClient[v1.ConsumeAuthorizationCodeRequest, v1.ConsumeAuthorizationCodeResponse] {
+	return c.consumeAuthorizationCode.CallBidiStream(ctx)
+}
+
+// RevokeAccessToken calls api.v1.DatabaseService.RevokeAccessToken.
+func (c *databaseServiceClient) RevokeAccessToken(ctx context.Context) *connect.BidiStreamForClient[v1.RevokeAccessTokenRequest, v1.RevokeAccessTokenResponse] {
+	return c.revokeAccessToken.CallBidiStream(ctx)
+}
+
+// RevokeRefreshToken calls api.v1.DatabaseService.RevokeRefreshToken.
+func (c *databaseServiceClient) RevokeRefreshToken(ctx context.Context) *connect.BidiStreamForClient[v1.RevokeRefreshTokenRequest, v1.RevokeRefreshTokenResponse] {
+	return c.revokeRefreshToken.CallBidiStream(ctx)
+}
+
+// GetUserUnary calls api.v1.DatabaseService.GetUserUnary.
+func (c *databaseServiceClient) GetUserUnary(ctx context.Context, req *connect.Request[v1.GetUserRequest]) (*connect.Response[v1.GetUserResponse], error) {
+	return c.getUserUnary.CallUnary(ctx, req)
+}
+
+// UpdateUserProfileUnary calls api.v1.DatabaseService.UpdateUserProfileUnary.
+func (c *databaseServiceClient) UpdateUserProfileUnary(ctx context.Context, req *connect.Request[v1.UpdateUserProfileRequest]) (*connect.Response[v1.UpdateUserProfileResponse], error) {
+	return c.updateUserProfileUnary.CallUnary(ctx, req)
+}
+
+// GetServiceClientUnary calls api.v1.DatabaseService.GetServiceClientUnary.
+func (c *databaseServiceClient) GetServiceClientUnary(ctx context.Context, req *connect.Request[v1.GetServiceClientRequest]) (*connect.Response[v1.GetServiceClientResponse], error) {
+	return c.getServiceClientUnary.CallUnary(ctx, req)
+}
+
+// GetAuthorizationCodeUnary calls api.v1.DatabaseService.GetAuthorizationCodeUnary.
+func (c *databaseServiceClient) GetAuthorizationCodeUnary(ctx context.Context, req *connect.Request[v1.GetAuthorizationCodeRequest]) (*connect.Response[v1.GetAuthorizationCodeResponse], error) {
+	return c.getAuthorizationCodeUnary.CallUnary(ctx, req)
+}
+
+// CreateAuthorizationCodeUnary calls api.v1.DatabaseService.CreateAuthorizationCodeUnary.
+func (c *databaseServiceClient) CreateAuthorizationCodeUnary(ctx context.Context, req *connect.Request[v1.CreateAuthorizationCodeRequest]) (*connect.Response[v1.CreateAuthorizationCodeResponse], error) {
+	return c.createAuthorizationCodeUnary.CallUnary(ctx, req)
+}
+
+// GetAccessTokenUnary calls api.v1.DatabaseService.GetAccessTokenUnary.
+func (c *databaseServiceClient) GetAccessTokenUnary(ctx context.Context, req *connect.Request[v1.GetAccessTokenRequest]) (*connect.Response[v1.GetAccessTokenResponse], error) {
+	return c.getAccessTokenUnary.CallUnary(ctx, req)
+}
+
+// CreateAccessTokenUnary calls api.v1.DatabaseService.CreateAccessTokenUnary.
+func (c *databaseServiceClient) CreateAccessTokenUnary(ctx context.Context, req *connect.Request[v1.CreateAccessTokenRequest]) (*connect.Response[v1.CreateAccessTokenResponse], error) {
+	return c.createAccessTokenUnary.CallUnary(ctx, req)
+}
+
+// GetRefreshTokenUnary calls api.v1.DatabaseService.GetRefreshTokenUnary.
+func (c *databaseServiceClient) GetRefreshTokenUnary(ctx context.Context, req *connect.Request[v1.GetRefreshTokenRequest]) (*connect.Response[v1.GetRefreshTokenResponse], error) {
+	return c.getRefreshTokenUnary.CallUnary(ctx, req)
+}
+
+// CreateRefreshTokenUnary calls api.v1.DatabaseService.CreateRefreshTokenUnary.
+func (c *databaseServiceClient) CreateRefreshTokenUnary(ctx context.Context, req *connect.Request[v1.CreateRefreshTokenRequest]) (*connect.Response[v1.CreateRefreshTokenResponse], error) {
+	return c.createRefreshTokenUnary.CallUnary(ctx, req)
+}
+
+// GetResourceServerUnary calls api.v1.DatabaseService.GetResourceServerUnary.
+func (c *databaseServiceClient) GetResourceServerUnary(ctx context.Context, req *connect.Request[v1.GetResourceServerRequest]) (*connect.Response[v1.GetResourceServerResponse], error) {
+	return c.getResourceServerUnary.CallUnary(ctx, req)
+}
+
+// ListResourceServersUnary calls api.v1.DatabaseService.ListResourceServersUnary.
+func (c *databaseServiceClient) ListResourceServersUnary(ctx context.Context, req *connect.Request[v1.ListResourceServersRequest]) (*connect.Response[v1.ListResourceServersResponse], error) {
+	return c.listResourceServersUnary.CallUnary(ctx, req)
+}
+
+// ConsumeAuthorizationCodeUnary calls api.v1.DatabaseService.ConsumeAuthorizationCodeUnary.
+func (c *databaseServiceClient) ConsumeAuthorizationCodeUnary(ctx context.Context, req *connect.Request[v1.ConsumeAuthorizationCodeRequest]) (*connect.Response[v1.ConsumeAuthorizationCodeResponse], error) {
+	return c.consumeAuthorizationCodeUnary.CallUnary(ctx, req)
+}
+
+// RevokeAccessTokenUnary calls api.v1.DatabaseService.RevokeAccessTokenUnary.
+func (c *databaseServiceClient) RevokeAccessTokenUnary(ctx context.Context, req *connect.Request[v1.RevokeAccessTokenRequest]) (*connect.Response[v1.RevokeAccessTokenResponse], error) {
+	return c.revokeAccessTokenUnary.CallUnary(ctx, req)
+}
+
+// RevokeRefreshTokenUnary calls api.v1.DatabaseService.RevokeRefreshTokenUnary.
+func (c *databaseServiceClient) RevokeRefreshTokenUnary(ctx context.Context, req *connect.Request[v1.RevokeRefreshTokenRequest]) (*connect.Response[v1.RevokeRefreshTokenResponse], error) {
+	return c.revokeRefreshTokenUnary.CallUnary(ctx, req)
+}
+
+// BatchGetAccessTokens calls api.v1.DatabaseService.BatchGetAccessTokens.
+func (c *databaseServiceClient) BatchGetAccessTokens(ctx context.Context, req *connect.Request[v1.BatchGetAccessTokensRequest]) (*connect.Response[v1.BatchGetAccessTokensResponse], error) {
+	return c.batchGetAccessTokens.CallUnary(ctx, req)
+}
+
+// BatchCreate calls api.v1.DatabaseService.BatchCreate.
+func (c *databaseServiceClient) BatchCreate(ctx context.Context, req *connect.Request[v1.BatchCreateRequest]) (*connect.Response[v1.BatchCreateResponse], error) {
+	return c.batchCreate.CallUnary(ctx, req)
+}
+
 // Ping calls api.v1.DatabaseService.Ping.
 func (c *databaseServiceClient) Ping(ctx context.Context, req *connect.Request[v1.PingRequest]) (*connect.Response[v1.PingResponse], error) {
 	return c.ping.CallUnary(ctx, req)
 }
 
+// Watch calls api.v1.DatabaseService.Watch.
+func (c *databaseServiceClient) Watch(ctx context.Context, req *connect.Request[v1.WatchRequest]) (*connect.ServerStreamForClient[v1.WatchResponse], error) {
+	return c.watch.CallServerStream(ctx, req)
+}
+
 // DatabaseServiceHandler is an implementation of the api.v1.DatabaseService service.
 type DatabaseServiceHandler interface {
+	// the original streaming RPCs, kept for the existing clients and for pipelined use.
+	// each request is answered in order; the stream ends at the first error.
 	GetUser(context.Context, *connect.BidiStream[v1.GetUserRequest, v1.GetUserResponse]) error
+	UpdateUserProfile(context.Context, *connect.BidiStream[v1.UpdateUserProfileRequest, v1.UpdateUserProfileResponse]) error
 	GetServiceClient(context.Context, *connect.BidiStream[v1.GetServiceClientRequest, v1.GetServiceClientResponse]) error
 	GetAuthorizationCode(context.Context, *connect.BidiStream[v1.GetAuthorizationCodeRequest, v1.GetAuthorizationCodeResponse]) error
 	CreateAuthorizationCode(context.Context, *connect.BidiStream[v1.CreateAuthorizationCodeRequest, v1.CreateAuthorizationCodeResponse]) error
@@ -222,7 +634,37 @@ type DatabaseServiceHandler interface {
 	CreateAccessToken(context.Context, *connect.BidiStream[v1.CreateAccessTokenRequest, v1.CreateAccessTokenResponse]) error
 	GetRefreshToken(context.Context, *connect.BidiStream[v1.GetRefreshTokenRequest, v1.GetRefreshTokenResponse]) error
 	CreateRefreshToken(context.Context, *connect.BidiStream[v1.CreateRefreshTokenRequest, v1.CreateRefreshTokenResponse]) error
+	GetResourceServer(context.Context, *connect.BidiStream[v1.GetResourceServerRequest, v1.GetResourceServerResponse]) error
+	ListResourceServers(context.Context, *connect.BidiStream[v1.ListResourceServersRequest, v1.ListResourceServersResponse]) error
+	ConsumeAuthorizationCode(context.Context, *connect.BidiStream[v1.ConsumeAuthorizationCodeRequest, v1.ConsumeAuthorizationCodeResponse]) error
+	RevokeAccessToken(context.Context, *connect.BidiStream[v1.RevokeAccessTokenRequest, v1.RevokeAccessTokenResponse]) error
+	RevokeRefreshToken(context.Context, *connect.BidiStream[v1.RevokeRefreshTokenRequest, v1.RevokeRefreshTokenResponse]) error
+	// unary variants of the streaming RPCs. reads have no side effects, so the clients retry them.
+	GetUserUnary(context.Context, *connect.Request[v1.GetUserRequest]) (*connect.Response[v1.GetUserResponse], error)
+	UpdateUserProfileUnary(context.Context, *connect.Request[v1.UpdateUserProfileRequest]) (*connect.Response[v1.UpdateUserProfileResponse], error)
+	GetServiceClientUnary(context.Context, *connect.Request[v1.GetServiceClientRequest]) (*connect.Response[v1.GetServiceClientResponse], error)
+	GetAuthorizationCodeUnary(context.Context, *connect.Request[v1.GetAuthorizationCodeRequest]) (*connect.Response[v1.GetAuthorizationCodeResponse], error)
+	CreateAuthorizationCodeUnary(context.Context, *connect.Request[v1.CreateAuthorizationCodeRequest]) (*connect.Response[v1.CreateAuthorizationCodeResponse], error)
+	GetAccessTokenUnary(context.Context, *connect.Request[v1.GetAccessTokenRequest]) (*connect.Response[v1.GetAccessTokenResponse], error)
+	CreateAccessTokenUnary(context.Context, *connect.Request[v1.CreateAccessTokenRequest]) (*connect.Response[v1.CreateAccessTokenResponse], error)
+	GetRefreshTokenUnary(context.Context, *connect.Request[v1.GetRefreshTokenRequest]) (*connect.Response[v1.GetRefreshTokenResponse], error)
+	CreateRefreshTokenUnary(context.Context, *connect.Request[v1.CreateRefreshTokenRequest]) (*connect.Response[v1.CreateRefreshTokenResponse], error)
+	GetResourceServerUnary(context.Context, *connect.Request[v1.GetResourceServerRequest]) (*connect.Response[v1.GetResourceServerResponse], error)
+	ListResourceServersUnary(context.Context, *connect.Request[v1.ListResourceServersRequest]) (*connect.Response[v1.ListResourceServersResponse], error)
+	// marks the code consumed. fails with ABORTED if it is already consumed.
+	ConsumeAuthorizationCodeUnary(context.Context, *connect.Request[v1.ConsumeAuthorizationCodeRequest]) (*connect.Response[v1.ConsumeAuthorizationCodeResponse], error)
+	// revokes the token. fails with ABORTED if it is already revoked.
+	RevokeAccessTokenUnary(context.Context, *connect.Request[v1.RevokeAccessTokenRequest]) (*connect.Response[v1.RevokeAccessTokenResponse], error)
+	RevokeRefreshTokenUnary(context.Context, *connect.Request[v1.RevokeRefreshTokenRequest]) (*connect.Response[v1.RevokeRefreshTokenResponse], error)
+	// returns the found tokens. unknown tokens are omitted.
+	BatchGetAccessTokens(context.Context, *connect.Request[v1.BatchGetAccessTokensRequest]) (*connect.Response[v1.BatchGetAccessTokensResponse], error)
+	// creates all rows or none. fails with ALREADY_EXISTS if any of them exists.
+	BatchCreate(context.Context, *connect.Request[v1.BatchCreateRequest]) (*connect.Response[v1.BatchCreateResponse], error)
 	Ping(context.Context, *connect.Request[v1.PingRequest]) (*connect.Response[v1.PingResponse], error)
+	// streams the changes after the cursor of the request. the first response has no event and
+	// tells the current cursor. fails with OUT_OF_RANGE if the server no longer keeps the events
+	// after the cursor; the watcher must reload the rows and watch from an empty cursor.
+	Watch(context.Context, *connect.Request[v1.WatchRequest], *connect.ServerStream[v1.WatchResponse]) error
 }
 
 // NewDatabaseServiceHandler builds an HTTP handler from the service implementation. It returns the
@@ -237,6 +679,12 @@ func NewDatabaseServiceHandler(svc DatabaseServiceHandler, opts ...connect.Handl
 		connect.WithSchema(databaseServiceGetUserMethodDescriptor),
 		connect.WithHandlerOptions(opts...),
 	)
+	databaseServiceUpdateUserProfileHandler := connect.NewBidiStreamHandler(
+		DatabaseServiceUpdateUserProfileProcedure,
+		svc.UpdateUserProfile,
+		connect.WithSchema(databaseServiceUpdateUserProfileMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
 	databaseServiceGetServiceClientHandler := connect.NewBidiStreamHandler(
 		DatabaseServiceGetServiceClientProcedure,
 		svc.GetServiceClient,
@@ -279,16 +727,159 @@ func NewDatabaseServiceHandler(svc DatabaseServiceHandler, opts ...connect.Handl
 		connect.WithSchema(databaseServiceCreateRefreshTokenMethodDescriptor),
 		connect.WithHandlerOptions(opts...),
 	)
+	databaseServiceGetResourceServerHandler := connect.NewBidiStreamHandler(
+		DatabaseServiceGetResourceServerProcedure,
+		svc.GetResourceServer,
+		connect.WithSchema(databaseServiceGetResourceServerMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceListResourceServersHandler := connect.NewBidiStreamHandler(
+		DatabaseServiceListResourceServersProcedure,
+		svc.ListResourceServers,
+		connect.WithSchema(databaseServiceListResourceServersMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceConsumeAuthorizationCodeHandler := connect.NewBidiStreamHandler(
+		DatabaseServiceConsumeAuthorizationCodeProcedure,
+		svc.ConsumeAuthorizationCode,
+		connect.WithSchema(databaseServiceConsumeAuthorizationCodeMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceRevokeAccessTokenHandler := connect.NewBidiStreamHandler(
+		DatabaseServiceRevokeAccessTokenProcedure,
+		svc.RevokeAccessToken,
+		connect.WithSchema(databaseServiceRevokeAccessTokenMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceRevokeRefreshTokenHandler := connect.NewBidiStreamHandler(
+		DatabaseServiceRevokeRefreshTokenProcedure,
+		svc.RevokeRefreshToken,
+		connect.WithSchema(databaseServiceRevokeRefreshTokenMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceGetUserUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceGetUserUnaryProcedure,
+		svc.GetUserUnary,
+		connect.WithSchema(databaseServiceGetUserUnaryMethodDescriptor),
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceUpdateUserProfileUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceUpdateUserProfileUnaryProcedure,
+		svc.UpdateUserProfileUnary,
+		connect.WithSchema(databaseServiceUpdateUserProfileUnaryMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceGetServiceClientUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceGetServiceClientUnaryProcedure,
+		svc.GetServiceClientUnary,
+		connect.WithSchema(databaseServiceGetServiceClientUnaryMethodDescriptor),
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceGetAuthorizationCodeUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceGetAuthorizationCodeUnaryProcedure,
+		svc.GetAuthorizationCodeUnary,
+		connect.WithSchema(databaseServiceGetAuthorizationCodeUnaryMethodDescriptor),
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceCreateAuthorizationCodeUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceCreateAuthorizationCodeUnaryProcedure,
+		svc.CreateAuthorizationCodeUnary,
+		connect.WithSchema(databaseServiceCreateAuthorizationCodeUnaryMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceGetAccessTokenUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceGetAccessTokenUnaryProcedure,
+		svc.GetAccessTokenUnary,
+		connect.WithSchema(databaseServiceGetAccessTokenUnaryMethodDescriptor),
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceCreateAccessTokenUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceCreateAccessTokenUnaryProcedure,
+		svc.CreateAccessTokenUnary,
+		connect.WithSchema(databaseServiceCreateAccessTokenUnaryMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceGetRefreshTokenUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceGetRefreshTokenUnaryProcedure,
+		svc.GetRefreshTokenUnary,
+		connect.WithSchema(databaseServiceGetRefreshTokenUnaryMethodDescriptor),
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceCreateRefreshTokenUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceCreateRefreshTokenUnaryProcedure,
+		svc.CreateRefreshTokenUnary,
+		connect.WithSchema(databaseServiceCreateRefreshTokenUnaryMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceGetResourceServerUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceGetResourceServerUnaryProcedure,
+		svc.GetResourceServerUnary,
+		connect.WithSchema(databaseServiceGetResourceServerUnaryMethodDescriptor),
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceListResourceServersUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceListResourceServersUnaryProcedure,
+		svc.ListResourceServersUnary,
+		connect.WithSchema(databaseServiceListResourceServersUnaryMethodDescriptor),
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceConsumeAuthorizationCodeUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceConsumeAuthorizationCodeUnaryProcedure,
+		svc.ConsumeAuthorizationCodeUnary,
+		connect.WithSchema(databaseServiceConsumeAuthorizationCodeUnaryMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceRevokeAccessTokenUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceRevokeAccessTokenUnaryProcedure,
+		svc.RevokeAccessTokenUnary,
+		connect.WithSchema(databaseServiceRevokeAccessTokenUnaryMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceRevokeRefreshTokenUnaryHandler := connect.NewUnaryHandler(
+		DatabaseServiceRevokeRefreshTokenUnaryProcedure,
+		svc.RevokeRefreshTokenUnary,
+		connect.WithSchema(databaseServiceRevokeRefreshTokenUnaryMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceBatchGetAccessTokensHandler := connect.NewUnaryHandler(
+		DatabaseServiceBatchGetAccessTokensProcedure,
+		svc.BatchGetAccessTokens,
+		connect.WithSchema(databaseServiceBatchGetAccessTokensMethodDescriptor),
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceBatchCreateHandler := connect.NewUnaryHandler(
+		DatabaseServiceBatchCreateProcedure,
+		svc.BatchCreate,
+		connect.WithSchema(databaseServiceBatchCreateMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
 	databaseServicePingHandler := connect.NewUnaryHandler(
 		DatabaseServicePingProcedure,
 		svc.Ping,
 		connect.WithSchema(databaseServicePingMethodDescriptor),
+		connect.WithIdempotency(connect.IdempotencyNoSideEffects),
+		connect.WithHandlerOptions(opts...),
+	)
+	databaseServiceWatchHandler := connect.NewServerStreamHandler(
+		DatabaseServiceWatchProcedure,
+		svc.Watch,
+		connect.WithSchema(databaseServiceWatchMethodDescriptor),
 		connect.WithHandlerOptions(opts...),
 	)
 	return "/api.v1.DatabaseService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case DatabaseServiceGetUserProcedure:
 			databaseServiceGetUserHandler.ServeHTTP(w, r)
+		case DatabaseServiceUpdateUserProfileProcedure:
+			databaseServiceUpdateUserProfileHandler.ServeHTTP(w, r)
 		case DatabaseServiceGetServiceClientProcedure:
 			databaseServiceGetServiceClientHandler.ServeHTTP(w, r)
 		case DatabaseServiceGetAuthorizationCodeProcedure:
@@ -303,8 +894,52 @@ func NewDatabaseServiceHandler(svc DatabaseServiceHandler, opts ...connect.Handl
 			databaseServiceGetRefreshTokenHandler.ServeHTTP(w, r)
 		case DatabaseServiceCreateRefreshTokenProcedure:
 			databaseServiceCreateRefreshTokenHandler.ServeHTTP(w, r)
+		case DatabaseServiceGetResourceServerProcedure:
+			databaseServiceGetResourceServerHandler.ServeHTTP(w, r)
+		case DatabaseServiceListResourceServersProcedure:
+			databaseServiceListResourceServersHandler.ServeHTTP(w, r)
+		case DatabaseServiceConsumeAuthorizationCodeProcedure:
+			databaseServiceConsumeAuthorizationCodeHandler.ServeHTTP(w, r)
+		case DatabaseServiceRevokeAccessTokenProcedure:
+			databaseServiceRevokeAccessTokenHandler.ServeHTTP(w, r)
+		case DatabaseServiceRevokeRefreshTokenProcedure:
+			databaseServiceRevokeRefreshTokenHandler.ServeHTTP(w, r)
+		case DatabaseServiceGetUserUnaryProcedure:
+			databaseServiceGetUserUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceUpdateUserProfileUnaryProcedure:
+			databaseServiceUpdateUserProfileUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceGetServiceClientUnaryProcedure:
+			databaseServiceGetServiceClientUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceGetAuthorizationCodeUnaryProcedure:
+			databaseServiceGetAuthorizationCodeUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceCreateAuthorizationCodeUnaryProcedure:
+			databaseServiceCreateAuthorizationCodeUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceGetAccessTokenUnaryProcedure:
+			databaseServiceGetAccessTokenUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceCreateAccessTokenUnaryProcedure:
+			databaseServiceCreateAccessTokenUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceGetRefreshTokenUnaryProcedure:
+			databaseServiceGetRefreshTokenUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceCreateRefreshTokenUnaryProcedure:
+			databaseServiceCreateRefreshTokenUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceGetResourceServerUnaryProcedure:
+			databaseServiceGetResourceServerUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceListResourceServersUnaryProcedure:
+			databaseServiceListResourceServersUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceConsumeAuthorizationCodeUnaryProcedure:
+			databaseServiceConsumeAuthorizationCodeUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceRevokeAccessTokenUnaryProcedure:
+			databaseServiceRevokeAccessTokenUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceRevokeRefreshTokenUnaryProcedure:
+			databaseServiceRevokeRefreshTokenUnaryHandler.ServeHTTP(w, r)
+		case DatabaseServiceBatchGetAccessTokensProcedure:
+			databaseServiceBatchGetAccessTokensHandler.ServeHTTP(w, r)
+		case DatabaseServiceBatchCreateProcedure:
+			databaseServiceBatchCreateHandler.ServeHTTP(w, r)
 		case DatabaseServicePingProcedure:
 			databaseServicePingHandler.ServeHTTP(w, r)
+		case DatabaseServiceWatchProcedure:
+			databaseServiceWatchHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -318,6 +953,10 @@ func (UnimplementedDatabaseServiceHandler) GetUser(context.Context, *connect.Bid
 	return connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.GetUser is not implemented"))
 }
 
+func (UnimplementedDatabaseServiceHandler) UpdateUserProfile(context.Context, *connect.BidiStream[v1.UpdateUserProfileRequest, v1.UpdateUserProfileResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.UpdateUserProfile is not implemented"))
+}
+
 func (UnimplementedDatabaseServiceHandler) GetServiceClient(context.Context, *connect.BidiStream[v1.GetServiceClientRequest, v1.GetServiceClientResponse]) error {
 	return connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.GetServiceClient is not implemented"))
 }
@@ -346,6 +985,94 @@ func (UnimplementedDatabaseServiceHandler) CreateRefreshToken(context.Context, *
 	return connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.CreateRefreshToken is not implemented"))
 }
 
+func (UnimplementedDatabaseServiceHandler) GetResourceServer(context.Context, *connect.BidiStream[v1.GetResourceServerRequest, v1.GetResourceServerResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.GetResourceServer is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) ListResourceServers(context.Context, *connect.BidiStream[v1.ListResourceServersRequest, v1.ListResourceServersResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.ListResourceServers is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) ConsumeAuthorizationCode(context.Context, *connect.BidiStream[v1.ConsumeAuthorizationCodeRequest, v1.ConsumeAuthorizationCodeResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.ConsumeAuthorizationCode is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) RevokeAccessToken(context.Context, *connect.BidiStream[v1.RevokeAccessTokenRequest, v1.RevokeAccessTokenResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.RevokeAccessToken is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) RevokeRefreshToken(context.Context, *connect.BidiStream[v1.RevokeRefreshTokenRequest, v1.RevokeRefreshTokenResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.RevokeRefreshToken is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) GetUserUnary(context.Context, *connect.Request[v1.GetUserRequest]) (*connect.Response[v1.GetUserResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.GetUserUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) UpdateUserProfileUnary(context.Context, *connect.Request[v1.UpdateUserProfileRequest]) (*connect.Response[v1.UpdateUserProfileResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.UpdateUserProfileUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) GetServiceClientUnary(context.Context, *connect.Request[v1.GetServiceClientRequest]) (*connect.Response[v1.GetServiceClientResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.GetServiceClientUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) GetAuthorizationCodeUnary(context.Context, *connect.Request[v1.GetAuthorizationCodeRequest]) (*connect.Response[v1.GetAuthorizationCodeResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.GetAuthorizationCodeUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) CreateAuthorizationCodeUnary(context.Context, *connect.Request[v1.CreateAuthorizationCodeRequest]) (*connect.Response[v1.CreateAuthorizationCodeResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.CreateAuthorizationCodeUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) GetAccessTokenUnary(context.Context, *connect.Request[v1.GetAccessTokenRequest]) (*connect.Response[v1.GetAccessTokenResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.GetAccessTokenUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) CreateAccessTokenUnary(context.Context, *connect.Request[v1.CreateAccessTokenRequest]) (*connect.Response[v1.CreateAccessTokenResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.CreateAccessTokenUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) GetRefreshTokenUnary(context.Context, *connect.Request[v1.GetRefreshTokenRequest]) (*connect.Response[v1.GetRefreshTokenResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.GetRefreshTokenUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) CreateRefreshTokenUnary(context.Context, *connect.Request[v1.CreateRefreshTokenRequest]) (*connect.Response[v1.CreateRefreshTokenResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.CreateRefreshTokenUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) GetResourceServerUnary(context.Context, *connect.Request[v1.GetResourceServerRequest]) (*connect.Response[v1.GetResourceServerResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.GetResourceServerUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) ListResourceServersUnary(context.Context, *connect.Request[v1.ListResourceServersRequest]) (*connect.Response[v1.ListResourceServersResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.ListResourceServersUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) ConsumeAuthorizationCodeUnary(context.Context, *connect.Request[v1.ConsumeAuthorizationCodeRequest]) (*connect.Response[v1.ConsumeAuthorizationCodeResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.ConsumeAuthorizationCodeUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) RevokeAccessTokenUnary(context.Context, *connect.Request[v1.RevokeAccessTokenRequest]) (*connect.Response[v1.RevokeAccessTokenResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.RevokeAccessTokenUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) RevokeRefreshTokenUnary(context.Context, *connect.Request[v1.RevokeRefreshTokenRequest]) (*connect.Response[v1.RevokeRefreshTokenResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.RevokeRefreshTokenUnary is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) BatchGetAccessTokens(context.Context, *connect.Request[v1.BatchGetAccessTokensRequest]) (*connect.Response[v1.BatchGetAccessTokensResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.BatchGetAccessTokens is not implemented"))
+}
+
+func (UnimplementedDatabaseServiceHandler) BatchCreate(context.Context, *connect.Request[v1.BatchCreateRequest]) (*connect.Response[v1.BatchCreateResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.BatchCreate is not implemented"))
+}
+
 func (UnimplementedDatabaseServiceHandler) Ping(context.Context, *connect.Request[v1.PingRequest]) (*connect.Response[v1.PingResponse], error) {
 	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.Ping is not implemented"))
 }
+
+func (UnimplementedDatabaseServiceHandler) Watch(context.Context, *connect.Request[v1.WatchRequest], *connect.ServerStream[v1.WatchResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("api.v1.DatabaseService.Watch is not implemented"))
+}
@@ -3,49 +3,83 @@ package main
 import (
 	"bufio"
 	"context"
-	"flag"
+	"crypto/tls"
 	"fmt"
+	"log"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
 
-	"github.com/joho/godotenv"
+	"github.com/yyyoichi/OhAuth0.1/internal/config"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
+	"github.com/yyyoichi/OhAuth0.1/internal/redact"
 	serviceclient "github.com/yyyoichi/OhAuth0.1/internal/service-client"
 )
 
 func main() {
-	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	l := slog.New(redact.NewHandler(slog.NewTextHandler(os.Stdout, nil)))
 	slog.SetDefault(l)
-	envPath := flag.String("source", "", "env file")
-	flag.Parse()
-	if envPath != nil {
-		if err := godotenv.Load(*envPath); err != nil {
-			panic(err)
-		}
-		slog.Info("read env file", slog.String("path", *envPath))
-	}
-
-	var srcport string
-	if srcport = os.Getenv("RESOURCE_SERVER_PORT"); srcport == "" {
-		panic("no required env found")
-	}
+	cfg := config.MustLoad(config.ServiceClient)
 
-	var authport string
-	if authport = os.Getenv("AUTHORIZATION_SERVER_PORT"); authport == "" {
-		panic("no required env found")
+	// trusts the CA of the servers, e.g. of 'dev-certs'
+	var httpClient *http.Client
+	if ca := cfg.TLS.CA; ca != "" {
+		pool, err := pki.LoadCertPool(ca)
+		if err != nil {
+			log.Fatal(err)
+		}
+		httpClient = &http.Client{Transport: &http.Transport{
+			TLSClientConfig: pki.ClientTLSConfig(pool),
+		}}
 	}
-	var uiport string
-	if uiport = os.Getenv("UI_SERVER_PORT"); uiport == "" {
-		panic("no required env found")
+	// serves the redirect and logout URIs over https
+	var redirectTLS *tls.Config
+	if cert := cfg.Client.TLS.Cert; cert != "" {
+		var err error
+		redirectTLS, err = pki.LoadMutualTLSConfig(cert, cfg.Client.TLS.Key)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	sc := bufio.NewScanner(os.Stdin)
 	brawser := serviceclient.NewBrawser(serviceclient.BrawserConfig{
-		RedirectPort:      7777,
-		AuthServerURI:     "http://localhost:" + authport,
-		ResourceServerURI: "http://localhost:" + srcport,
-		AuthUIURI:         "http://localhost:" + uiport + "/v1/auth",
+		RedirectPort:      cfg.Client.RedirectPort,
+		RedirectTLS:       redirectTLS,
+		HTTPClient:        httpClient,
+		AuthServerURI:     cfg.Auth.URL,
+		ResourceServerURI: cfg.Resource.URL,
+		AuthUIURI:         cfg.UI.URL + "/v1/auth",
 	})
 	ctx := context.Background()
+	go func() {
+		// receives back-channel logout at client.logout_uri, which is seeded to the mock service clients
+		logoutURI, err := url.Parse(cfg.Client.LogoutURI)
+		if err != nil {
+			slog.Error("cannot serve back-channel logout", slog.String("error", err.Error()))
+			return
+		}
+		path := logoutURI.Path
+		if path == "" {
+			path = "/"
+		}
+		mux := http.NewServeMux()
+		mux.Handle(path, brawser.BackChannelLogoutHandler())
+		server := &http.Server{
+			Addr:      fmt.Sprintf(":%d", cfg.Client.LogoutPort),
+			Handler:   mux,
+			TLSConfig: redirectTLS,
+		}
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil {
+			slog.Error("cannot serve back-channel logout", slog.String("error", err.Error()))
+		}
+	}()
 	go func() {
 		for {
 			fmt.Printf("\nPlease enter the command... \n")
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
+)
+
+// ローカル開発用のCAと各サービスの証明書を [dir] に発行する。
+// CAが既にあれば再利用するので、一度信頼したCAのまま証明書を更新できる。
+func main() {
+	dir := flag.String("dir", "./certs", "directory to write the certificates")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "comma separated DNS names and IP addresses of the servers")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		log.Fatal(err)
+	}
+	ca, err := loadOrCreateCA(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	names := strings.Split(*hosts, ",")
+	// server certificates of the services, the UI and the redirect URI of the CLI
+	for _, name := range []string{"auth", "resource", "database", "ui", "client"} {
+		cert, err := ca.IssueServerCertificate(names...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := write(*dir, name, cert); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// client certificates of the servers to the database server
+	for _, name := range []string{database.AuthServerName, database.ResourceServerName} {
+		cert, err := ca.IssueClientCertificate(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := write(*dir, name, cert); err != nil {
+			log.Fatal(err)
+		}
+	}
+	printEnv(os.Stdout, *dir)
+}
+
+func loadOrCreateCA(dir string) (*pki.CA, error) {
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	ca, err := pki.LoadCA(certFile, keyFile)
+	if err == nil {
+		log.Println("reusing CA", certFile)
+		return ca, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ca, err = pki.NewCA("OhAuth0.1 Development CA")
+	if err != nil {
+		return nil, err
+	}
+	return ca, write(dir, "ca", ca.TLSCertificate())
+}
+
+// write writes '<name>.pem' and '<name>-key.pem'. the key is readable only by the owner.
+func write(dir, name string, cert tls.Certificate) error {
+	certPEM, keyPEM, err := pki.EncodePEM(cert)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0o600)
+}
+
+// printEnv prints the variables of '.env.local' to use the certificates.
+func printEnv(w io.Writer, dir string) {
+	file := func(name string) string { return filepath.Join(dir, name) }
+	fmt.Fprintf(w, `# add to .env.local, and trust %s in the browser
+TLS_CA=%s
+AUTHORIZATION_SERVER_TLS_CERT=%s
+AUTHORIZATION_SERVER_TLS_KEY=%s
+AUTHORIZATION_SERVER_TLS_CLIENT_CA=%s
+RESOURCE_SERVER_TLS_CERT=%s
+RESOURCE_SERVER_TLS_KEY=%s
+DATABASE_TLS_CERT=%s
+DATABASE_TLS_KEY=%s
+DATABASE_TLS_CA=%s
+DATABASE_TLS_CLIENT_CA=%s
+AUTHORIZATION_SERVER_DATABASE_CERT=%s
+AUTHORIZATION_SERVER_DATABASE_KEY=%s
+RESOURCE_SERVER_DATABASE_CERT=%s
+RESOURCE_SERVER_DATABASE_KEY=%s
+CLIENT_APP_TLS_CERT=%s
+CLIENT_APP_TLS_KEY=%s
+`,
+		file("ca.pem"),
+		file("ca.pem"),
+		file("auth.pem"), file("auth-key.pem"), file("ca.pem"),
+		file("resource.pem"), file("resource-key.pem"),
+		file("database.pem"), file("database-key.pem"), file("ca.pem"), file("ca.pem"),
+		file(database.AuthServerName+".pem"), file(database.AuthServerName+"-key.pem"),
+		file(database.ResourceServerName+".pem"), file(database.ResourceServerName+"-key.pem"),
+		file("client.pem"), file("client-key.pem"),
+	)
+}
@@ -2,51 +2,41 @@ package main
 
 import (
 	"context"
-	"flag"
-	"fmt"
+	"errors"
 	"log"
 	"log/slog"
 	"os"
 
-	"github.com/joho/godotenv"
-	"github.com/yyyoichi/OhAuth0.1/internal/auth"
+	"github.com/yyyoichi/OhAuth0.1/internal/config"
+	"github.com/yyyoichi/OhAuth0.1/internal/lifecycle"
+	"github.com/yyyoichi/OhAuth0.1/internal/redact"
+	"github.com/yyyoichi/OhAuth0.1/internal/server"
 )
 
 func main() {
-	ctx := context.Background()
-	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	l := slog.New(redact.NewHandler(slog.NewTextHandler(os.Stdout, nil)))
 	slog.SetDefault(l)
-	envPath := flag.String("source", "", "env file")
-	flag.Parse()
-	if envPath != nil {
-		if err := godotenv.Load(*envPath); err != nil {
-			panic(err)
-		}
-		slog.Info("read env file", slog.String("path", *envPath))
+	cfg := config.MustLoad(config.ServiceAuth)
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	var dbport string
-	if dbport = os.Getenv("DATABASE_SERVER_PORT"); dbport == "" {
-		panic("no required env found")
-	}
+// run serves until a signal. The deferred calls run before main exits.
+func run(cfg *config.Config) (err error) {
+	ctx, stop := lifecycle.SignalContext(context.Background())
+	defer stop()
 
-	service, err := auth.NewService(ctx, auth.Config{
-		DatabaseServerURL: "http://localhost:" + dbport,
-	})
+	tracerProvider, shutdown, err := server.NewTracerProvider(cfg, "auth-server")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	var port string
-	if port = os.Getenv("AUTHORIZATION_SERVER_PORT"); port == "" {
-		panic("no required env found")
-	}
-	var uiport string
-	if uiport = os.Getenv("UI_SERVER_PORT"); uiport == "" {
-		panic("no required env found")
-	}
-	router := auth.SetupRouter(service, fmt.Sprintf("http://localhost:%s", uiport))
-	if err := router.Run(":" + port); err != nil {
-		log.Fatal(err)
+	// flushes the spans on exit
+	defer func() { err = errors.Join(err, shutdown(context.Background())) }()
+	s, err := server.NewAuthServer(ctx, cfg, nil, tracerProvider)
+	if err != nil {
+		return err
 	}
+	slog.Info("starting authorization server", slog.String("addr", s.Addr().String()))
+	return s.Serve(ctx)
 }
@@ -2,38 +2,40 @@ package main
 
 import (
 	"context"
-	"flag"
+	"errors"
 	"log"
 	"log/slog"
 	"os"
 
-	"github.com/joho/godotenv"
-	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"github.com/yyyoichi/OhAuth0.1/internal/config"
+	"github.com/yyyoichi/OhAuth0.1/internal/lifecycle"
+	"github.com/yyyoichi/OhAuth0.1/internal/redact"
+	"github.com/yyyoichi/OhAuth0.1/internal/server"
 )
 
 func main() {
-	ctx := context.Background()
-	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	l := slog.New(redact.NewHandler(slog.NewTextHandler(os.Stdout, nil)))
 	slog.SetDefault(l)
-
-	envPath := flag.String("source", "", "env file")
-	flag.Parse()
-	if envPath != nil {
-		if err := godotenv.Load(*envPath); err != nil {
-			panic(err)
-		}
-		slog.Info("read env file", slog.String("path", *envPath))
+	cfg := config.MustLoad(config.ServiceDatabase)
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	var dbport string
-	if dbport = os.Getenv("DATABASE_SERVER_PORT"); dbport == "" {
-		panic("no required env found")
+// run serves until a signal. The deferred calls run before main exits.
+func run(cfg *config.Config) (err error) {
+	ctx, stop := lifecycle.SignalContext(context.Background())
+	defer stop()
+
+	tracerProvider, shutdown, err := server.NewTracerProvider(cfg, "database-server")
+	if err != nil {
+		return err
 	}
-	err := database.NewDatabaseServer(database.ServerConfig{
-		Port: dbport,
-	})
+	// flushes the spans on exit
+	defer func() { err = errors.Join(err, shutdown(context.Background())) }()
+	s, err := server.NewDatabaseServer(ctx, cfg, tracerProvider)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	<-ctx.Done()
+	return s.Wait()
 }
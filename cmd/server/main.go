@@ -1,9 +1,97 @@
 package main
 
-import "log"
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
 
+	"github.com/yyyoichi/OhAuth0.1/internal/config"
+	"github.com/yyyoichi/OhAuth0.1/internal/lifecycle"
+	"github.com/yyyoichi/OhAuth0.1/internal/redact"
+	"github.com/yyyoichi/OhAuth0.1/internal/server"
+	"golang.org/x/sync/errgroup"
+)
+
+// 'server.services' のサービスを1つのプロセスで起動する。
+// 'server.transport' が 'memory' のとき、認可サーバー・リソースサーバーはポートを開かずにプロセス内で起動したデータベースサーバーを呼び出す。
 func main() {
+	l := slog.New(redact.NewHandler(slog.NewTextHandler(os.Stdout, nil)))
+	slog.SetDefault(l)
+	cfg := config.MustLoad(config.ServiceServer)
 	log.Println(`
 	WELCOME TO OhAuth0.1
 	Let's create a service like OAuth2.0 with golang!`)
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until a signal. The deferred calls run before main exits.
+func run(cfg *config.Config) (err error) {
+	ctx, stop := lifecycle.SignalContext(context.Background())
+	defer stop()
+
+	tracerProvider, shutdown, err := server.NewTracerProvider(cfg, "server")
+	if err != nil {
+		return err
+	}
+	// flushes the spans on exit
+	defer func() { err = errors.Join(err, shutdown(context.Background())) }()
+
+	services := cfg.Server.List()
+	var db *server.InProcessDatabase
+	if slices.Contains(services, config.ServiceDatabase) && cfg.Server.Transport == config.TransportMemory {
+		// the in-process database is drained after the servers which call it
+		dbctx, stopDatabase := context.WithCancel(context.WithoutCancel(ctx))
+		db, err = server.NewInProcessDatabase(dbctx, cfg, tracerProvider)
+		if err != nil {
+			stopDatabase()
+			return err
+		}
+		defer func() {
+			stopDatabase()
+			err = errors.Join(err, db.Wait())
+		}()
+		slog.Info("starting in-process database")
+	}
+
+	// every server is drained when one of them fails
+	g, ctx := errgroup.WithContext(ctx)
+	// the servers already started are drained as well when another cannot start
+	abort := func(err error) error {
+		stop()
+		return errors.Join(err, g.Wait())
+	}
+	if slices.Contains(services, config.ServiceDatabase) && db == nil {
+		s, err := server.NewDatabaseServer(ctx, cfg, tracerProvider)
+		if err != nil {
+			return abort(err)
+		}
+		g.Go(s.Wait)
+	}
+	if slices.Contains(services, config.ServiceAuth) {
+		s, err := server.NewAuthServer(ctx, cfg, db, tracerProvider)
+		if err != nil {
+			return abort(err)
+		}
+		slog.Info("starting authorization server", slog.String("addr", s.Addr().String()))
+		g.Go(func() error { return s.Serve(ctx) })
+	}
+	if slices.Contains(services, config.ServiceResource) {
+		s, err := server.NewResourceServer(ctx, cfg, db, tracerProvider)
+		if err != nil {
+			return abort(err)
+		}
+		slog.Info("starting resource server", slog.String("addr", s.Addr().String()))
+		g.Go(func() error { return s.Serve(ctx) })
+	}
+	// keeps running until the signal, even if only the in-process database is selected
+	g.Go(func() error {
+		<-ctx.Done()
+		return nil
+	})
+	return g.Wait()
 }
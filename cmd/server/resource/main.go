@@ -2,46 +2,41 @@ package main
 
 import (
 	"context"
-	"flag"
+	"errors"
 	"log"
 	"log/slog"
 	"os"
 
-	"github.com/joho/godotenv"
-	"github.com/yyyoichi/OhAuth0.1/internal/resource"
+	"github.com/yyyoichi/OhAuth0.1/internal/config"
+	"github.com/yyyoichi/OhAuth0.1/internal/lifecycle"
+	"github.com/yyyoichi/OhAuth0.1/internal/redact"
+	"github.com/yyyoichi/OhAuth0.1/internal/server"
 )
 
 func main() {
-	ctx := context.Background()
-	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	l := slog.New(redact.NewHandler(slog.NewTextHandler(os.Stdout, nil)))
 	slog.SetDefault(l)
-
-	envPath := flag.String("source", "", "env file")
-	flag.Parse()
-	if envPath != nil {
-		if err := godotenv.Load(*envPath); err != nil {
-			panic(err)
-		}
-		slog.Info("read env file", slog.String("path", *envPath))
-	}
-
-	var dbport string
-	if dbport = os.Getenv("DATABASE_SERVER_PORT"); dbport == "" {
-		panic("no required env found")
-	}
-	service, err := resource.NewService(ctx, resource.Config{
-		DatabaseServerURL: "http://localhost:" + dbport,
-	})
-	if err != nil {
+	cfg := config.MustLoad(config.ServiceResource)
+	if err := run(cfg); err != nil {
 		log.Fatal(err)
 	}
+}
+
+// run serves until a signal. The deferred calls run before main exits.
+func run(cfg *config.Config) (err error) {
+	ctx, stop := lifecycle.SignalContext(context.Background())
+	defer stop()
 
-	var port string
-	if port = os.Getenv("RESOURCE_SERVER_PORT"); port == "" {
-		panic("no required env found")
+	tracerProvider, shutdown, err := server.NewTracerProvider(cfg, "resource-server")
+	if err != nil {
+		return err
 	}
-	router := resource.SetupRouter(service)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatal(err)
+	// flushes the spans on exit
+	defer func() { err = errors.Join(err, shutdown(context.Background())) }()
+	s, err := server.NewResourceServer(ctx, cfg, nil, tracerProvider)
+	if err != nil {
+		return err
 	}
+	slog.Info("starting resource server", slog.String("addr", s.Addr().String()))
+	return s.Serve(ctx)
 }
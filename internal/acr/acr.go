@@ -0,0 +1,8 @@
+// Package acr is the authentication context classes shared by the authorization server and the resource server.
+package acr
+
+// Password is the class of a password login, the only one the authorization server supports.
+const Password = "urn:ohauth0.1:acr:password"
+
+// Supported are the classes the authorization server can satisfy.
+var Supported = []string{Password}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/expiry"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
+)
+
+// client authentication methods at the token endpoint.
+const (
+	ClientAuthMethodSecretPost    = "client_secret_post"
+	ClientAuthMethodTLS           = "tls_client_auth"             // RFC 8705 section 2.1
+	ClientAuthMethodSelfSignedTLS = "self_signed_tls_client_auth" // RFC 8705 section 2.2
+	ClientAuthMethodPrivateKeyJWT = "private_key_jwt"             // RFC 7523 section 2.2
+
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+var (
+	ErrInvalidClient               = errors.New("invalid client")
+	ErrNoMatchClientSecret         = errors.New("no match client secret")
+	ErrClientCertificateRequired   = errors.New("client certificate is required")
+	ErrInvalidClientCertificate    = errors.New("client certificate is invalid")
+	ErrUnsupportedClientAuthMethod = errors.New("unsupported client authentication method")
+	ErrClientAuthMethodNotAllowed  = errors.New("client authentication method is not allowed for the client")
+	ErrInvalidClientAssertion      = errors.New("client assertion is invalid")
+	ErrClientAssertionReplayed     = errors.New("client assertion is replayed")
+)
+
+type ClientCredentials struct {
+	ClientId     string
+	ClientSecret string
+	// 'private_key_jwt'
+	ClientAssertionType string
+	ClientAssertion     string
+	// TLS connection of the request. The peer certificate is used by mutual-TLS methods.
+	TLS *tls.ConnectionState
+}
+
+// signing algorithms accepted for client assertions. symmetric algorithms and 'none' are never accepted.
+var clientAssertionAlgorithms = []string{
+	jwt.SigningMethodRS256.Alg(), jwt.SigningMethodRS384.Alg(), jwt.SigningMethodRS512.Alg(),
+	jwt.SigningMethodPS256.Alg(), jwt.SigningMethodPS384.Alg(), jwt.SigningMethodPS512.Alg(),
+	jwt.SigningMethodES256.Alg(), jwt.SigningMethodES384.Alg(), jwt.SigningMethodES512.Alg(),
+}
+
+const (
+	// client assertions must be short-lived
+	clientAssertionMaxLifetime = time.Duration(10) * time.Minute
+	clientAssertionLeeway      = time.Duration(30) * time.Second
+)
+
+// [credentials]をクライアントに登録された方式で検証する
+func (s *Service) AuthenticateClient(ctx context.Context, credentials ClientCredentials) (*apiv1.ServiceClient, error) {
+	if credentials.ClientId == "" && credentials.ClientAssertion != "" {
+		// client_id may be omitted with a client assertion (RFC 7521 section 4.2)
+		credentials.ClientId = unverifiedAssertionSubject(credentials.ClientAssertion)
+	}
+	client, err := s.client.GetServieClientById(ctx, credentials.ClientId)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot get service client: %w", ErrInvalidClient, err)
+	}
+	method, err := presentedClientAuthMethod(client, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidClient, err)
+	}
+	if !slices.Contains(ClientAuthMethods(client), method) {
+		return nil, fmt.Errorf("%w: %w: %s", ErrInvalidClient, ErrClientAuthMethodNotAllowed, method)
+	}
+	switch method {
+	case ClientAuthMethodSecretPost:
+		if credentials.ClientSecret == "" ||
+			subtle.ConstantTimeCompare([]byte(credentials.ClientSecret), []byte(client.GetSecret())) != 1 {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidClient, ErrNoMatchClientSecret)
+		}
+	case ClientAuthMethodTLS:
+		// without the pool, Verify trusts the system roots, which issue certificates of any subject
+		if s.clientCAs == nil {
+			return nil, fmt.Errorf("%w: %w: no client CA is configured", ErrInvalidClient, ErrInvalidClientCertificate)
+		}
+		cert := pki.PeerCertificate(credentials.TLS)
+		intermediates := x509.NewCertPool()
+		for _, c := range credentials.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{
+			Roots:         s.clientCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			return nil, fmt.Errorf("%w: %w: %w", ErrInvalidClient, ErrInvalidClientCertificate, err)
+		}
+		if cert.Subject.String() != client.GetTlsClientAuthSubjectDn() {
+			return nil, fmt.Errorf("%w: %w: subject '%s' is not registered", ErrInvalidClient, ErrInvalidClientCertificate, cert.Subject)
+		}
+	case ClientAuthMethodSelfSignedTLS:
+		cert := pki.PeerCertificate(credentials.TLS)
+		if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			return nil, fmt.Errorf("%w: %w: out of validity period", ErrInvalidClient, ErrInvalidClientCertificate)
+		}
+		if pki.Thumbprint(cert) != client.GetTlsClientCertificateThumbprint() {
+			return nil, fmt.Errorf("%w: %w: certificate is not registered", ErrInvalidClient, ErrInvalidClientCertificate)
+		}
+	case ClientAuthMethodPrivateKeyJWT:
+		if err := s.verifyClientAssertion(ctx, client, credentials.ClientAssertion); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidClient, err)
+		}
+	}
+	return client, nil
+}
+
+// presentedClientAuthMethod returns the method [credentials] try to authenticate with.
+func presentedClientAuthMethod(client *apiv1.ServiceClient, credentials ClientCredentials) (string, error) {
+	switch {
+	case credentials.ClientAssertion != "" || credentials.ClientAssertionType != "":
+		if credentials.ClientAssertionType != ClientAssertionTypeJWTBearer {
+			return "", fmt.Errorf("%w: client_assertion_type '%s'", ErrUnsupportedClientAuthMethod, credentials.ClientAssertionType)
+		}
+		return ClientAuthMethodPrivateKeyJWT, nil
+	case credentials.ClientSecret != "":
+		return ClientAuthMethodSecretPost, nil
+	case pki.PeerCertificate(credentials.TLS) != nil:
+		// both mutual-TLS methods present the same; use the one registered.
+		if slices.Contains(ClientAuthMethods(client), ClientAuthMethodSelfSignedTLS) {
+			return ClientAuthMethodSelfSignedTLS, nil
+		}
+		return ClientAuthMethodTLS, nil
+	}
+	if slices.Contains(ClientAuthMethods(client), ClientAuthMethodTLS) ||
+		slices.Contains(ClientAuthMethods(client), ClientAuthMethodSelfSignedTLS) {
+		return "", ErrClientCertificateRequired
+	}
+	return ClientAuthMethodSecretPost, nil
+}
+
+// ClientAuthMethods returns the client authentication methods accepted for [client].
+func ClientAuthMethods(client *apiv1.ServiceClient) []string {
+	if m := client.GetTokenEndpointAuthMethods(); len(m) > 0 {
+		return m
+	}
+	return []string{ClientAuthMethodSecretPost}
+}
+
+// CertificateThumbprint returns 'x5t#S256' of the certificate presented on [state],
+// which issued tokens are bound to. Empty if no certificate was presented.
+func CertificateThumbprint(state *tls.ConnectionState) string {
+	cert := pki.PeerCertificate(state)
+	if cert == nil {
+		return ""
+	}
+	return pki.Thumbprint(cert)
+}
+
+// verifyClientAssertion verifies a JWT client assertion signed by a key registered on [client] (RFC 7523 section 3).
+func (s *Service) verifyClientAssertion(ctx context.Context, client *apiv1.ServiceClient, assertion string) error {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(assertion, &claims, func(token *jwt.Token) (interface{}, error) {
+		set, err := s.clientJWKS(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		kid, _ := token.Header["kid"].(string)
+		jwk, err := set.Lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		return jwk.PublicKey()
+	},
+		jwt.WithValidMethods(clientAssertionAlgorithms),
+		jwt.WithIssuer(client.GetId()),
+		jwt.WithSubject(client.GetId()),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clientAssertionLeeway),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidClientAssertion, err)
+	}
+	if !slices.ContainsFunc(claims.Audience, s.isAssertionAudience) {
+		return fmt.Errorf("%w: audience %v is not this server", ErrInvalidClientAssertion, claims.Audience)
+	}
+	if claims.ExpiresAt.Sub(time.Now()) > clientAssertionMaxLifetime {
+		return fmt.Errorf("%w: lifetime is too long", ErrInvalidClientAssertion)
+	}
+	if claims.ID == "" {
+		return fmt.Errorf("%w: jti is required", ErrInvalidClientAssertion)
+	}
+	if !s.assertions.Use(client.GetId()+":"+claims.ID, claims.ExpiresAt.Add(clientAssertionLeeway)) {
+		return ErrClientAssertionReplayed
+	}
+	return nil
+}
+
+func (s *Service) clientJWKS(ctx context.Context, client *apiv1.ServiceClient) (JSONWebKeySet, error) {
+	if jwks := client.GetJwks(); jwks != "" {
+		var set JSONWebKeySet
+		// the registered keys are broken, which the client must fix
+		if err := json.Unmarshal([]byte(jwks), &set); err != nil {
+			return JSONWebKeySet{}, fmt.Errorf("%w: malformed jwks: %w", ErrInvalidClient, err)
+		}
+		return set, nil
+	}
+	if uri := client.GetJwksUri(); uri != "" {
+		return s.jwks.Get(ctx, uri)
+	}
+	return JSONWebKeySet{}, ErrJWKSNotPublished
+}
+
+// the assertion audience is the issuer identifier or the token endpoint URL.
+func (s *Service) isAssertionAudience(aud string) bool {
+	return aud == Issuer || (s.tokenEndpoint != "" && aud == s.tokenEndpoint)
+}
+
+func unverifiedAssertionSubject(assertion string) string {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// replayCache remembers used one-time values until they expire. The zero value is ready to use.
+type replayCache struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+	// the expired values are pruned without scanning every one
+	expiries expiry.Index[string]
+}
+
+// Use marks [key] as used until [expires]. It returns false if [key] is already used.
+func (c *replayCache) Use(key string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.used == nil {
+		c.used = map[string]time.Time{}
+	}
+	for _, e := range c.expiries.Expired(time.Now()) {
+		delete(c.used, e.Key)
+	}
+	if _, found := c.used[key]; found {
+		return false
+	}
+	c.used[key] = expires
+	c.expiries.Add(key, expires)
+	return true
+}
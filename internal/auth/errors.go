@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/yyyoichi/OhAuth0.1/internal/authzdetails"
+	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	enging "github.com/yyyoichi/OhAuth0.1/internal/engine"
+)
+
+// errorResponses map the errors of the service to the responses. The first match wins.
+var errorResponses = []struct {
+	target error
+	status int
+	code   enging.Code
+}{
+	// a temporary failure to fetch the keys of the client wins over the invalid assertion it causes
+	{ErrJWKSUnavailable, http.StatusServiceUnavailable, enging.CodeUnavailable},
+
+	{ErrNoMatchPassword, http.StatusBadRequest, enging.CodeInvalidCredentials},
+	{ErrInvalidPrompt, http.StatusBadRequest, enging.CodeInvalidRequest},
+	{ErrInvalidMaxAge, http.StatusBadRequest, enging.CodeInvalidRequest},
+	{ErrLoginRequired, http.StatusUnauthorized, enging.CodeLoginRequired},
+	{ErrConsentRequired, http.StatusUnauthorized, enging.CodeConsentRequired},
+	{ErrUnmetAuthenticationRequirements, http.StatusUnauthorized, enging.CodeUnmetAuthenticationRequirements},
+	{ErrInvalidScope, http.StatusBadRequest, enging.CodeInvalidScope},
+	{ErrInvalidTarget, http.StatusBadRequest, enging.CodeInvalidTarget},
+	{authzdetails.ErrInvalidDetails, http.StatusBadRequest, enging.CodeInvalidAuthorizationDetails},
+	{authzdetails.ErrUnknownType, http.StatusBadRequest, enging.CodeInvalidAuthorizationDetails},
+
+	{ErrInvalidClient, http.StatusBadRequest, enging.CodeInvalidClient},
+	{ErrNoMatchClientSecret, http.StatusBadRequest, enging.CodeInvalidClient},
+	{ErrClientCertificateRequired, http.StatusBadRequest, enging.CodeInvalidClient},
+	{ErrInvalidClientCertificate, http.StatusBadRequest, enging.CodeInvalidClient},
+	{ErrUnsupportedClientAuthMethod, http.StatusBadRequest, enging.CodeInvalidClient},
+	{ErrClientAuthMethodNotAllowed, http.StatusBadRequest, enging.CodeInvalidClient},
+	{ErrInvalidClientAssertion, http.StatusBadRequest, enging.CodeInvalidClient},
+	{ErrClientAssertionReplayed, http.StatusBadRequest, enging.CodeInvalidClient},
+	{ErrJWKNotFound, http.StatusBadRequest, enging.CodeInvalidClient},
+	{ErrUnsupportedJWK, http.StatusBadRequest, enging.CodeInvalidClient},
+	{ErrJWKSNotPublished, http.StatusBadRequest, enging.CodeInvalidClient},
+
+	{ErrGrantIssuedToOtherClient, http.StatusBadRequest, enging.CodeInvalidGrant},
+	{ErrAuthorizationCodeExpired, http.StatusUnauthorized, enging.CodeAuthorizationCodeExpired},
+	{ErrAuthorizationCodeUsed, http.StatusBadRequest, enging.CodeAuthorizationCodeUsed},
+	{ErrRefreshTokenExpired, http.StatusUnauthorized, enging.CodeRefreshTokenExpired},
+	{ErrRefreshTokenRevoked, http.StatusBadRequest, enging.CodeRefreshTokenRevoked},
+	{ErrTokenExchangeNotAllowed, http.StatusForbidden, enging.CodeUnauthorizedClient},
+	{ErrInvalidSubjectToken, http.StatusBadRequest, enging.CodeInvalidSubjectToken},
+	{ErrInvalidActorToken, http.StatusBadRequest, enging.CodeInvalidActorToken},
+	{ErrUnsupportedTokenType, http.StatusBadRequest, enging.CodeUnsupportedTokenType},
+
+	{ErrSessionNotFound, http.StatusBadRequest, enging.CodeSessionNotFound},
+	{ErrInvalidPostLogoutRedirectUri, http.StatusBadRequest, enging.CodeInvalidPostLogoutRedirectUri},
+}
+
+// ErrorResponse maps [err] of the service to the response, e.g. [ErrAuthorizationCodeExpired] to 401 'invalid_grant'.
+// The errors of the database are mapped by [enging.FromError], and a temporary failure of it wins.
+func ErrorResponse(err error) *enging.Error {
+	if errors.Is(err, database.ErrUnavailable) {
+		return enging.FromError(err)
+	}
+	for _, r := range errorResponses {
+		if errors.Is(err, r.target) {
+			return enging.New(r.status, r.code, r.target.Error()).Wrap(err)
+		}
+	}
+	return enging.FromError(err)
+}
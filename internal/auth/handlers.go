@@ -11,14 +11,21 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/authzdetails"
 	"github.com/yyyoichi/OhAuth0.1/internal/database"
 	enging "github.com/yyyoichi/OhAuth0.1/internal/engine"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+	"github.com/yyyoichi/OhAuth0.1/internal/tracing"
 )
 
 var JWT_SECRET = []byte("JWT_SECRET")
 
 func SetupRouter(service *Service, allowOrigins ...string) *gin.Engine {
 	router := gin.Default()
+	if service.tracerProvider != nil {
+		router.Use(tracing.Gin(service.tracerProvider))
+	}
+	router.Use(interceptor.GinRequestID())
 	// cross origin
 	router.Use(cors.New(cors.Config{
 		AllowOrigins: allowOrigins,
@@ -45,19 +52,16 @@ func SetupRouter(service *Service, allowOrigins ...string) *gin.Engine {
 
 	v1.GET("/clients/:client_id", func(ctx *gin.Context) {
 		var req ServiceClientGetRequest
-		if err := ctx.BindUri(&req); err != nil {
-			ctx.SecureJSON(http.StatusBadRequest, enging.BadRequestMessage)
+		if err := ctx.ShouldBindUri(&req); err != nil {
+			enging.RespondProblem(ctx, enging.New(http.StatusBadRequest, enging.CodeInvalidRequest, "client_id is required").Wrap(err))
 			return
 		}
-		slog.InfoContext(ctx, "recieve", "body", req)
-		client, err := service.client.GetServieClientById(ctx, req.ClientId)
+		tracing.SetAttributes(ctx.Request.Context(), tracing.ClientIdKey.String(req.ClientId))
+		slog.InfoContext(ctx.Request.Context(), "recieve", "body", req)
+		client, err := service.client.GetServieClientById(ctx.Request.Context(), req.ClientId)
 		if err != nil {
-			slog.ErrorContext(ctx, fmt.Sprintf("cannot get client: %v", err))
-			if errors.Is(err, database.ErrNotFound) {
-				ctx.SecureJSON(http.StatusNotFound, enging.NotFoundMessage)
-				return
-			}
-			ctx.SecureJSON(http.StatusInternalServerError, enging.InternalServerErrorMessage)
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot get client: %v", err))
+			enging.RespondProblem(ctx, ErrorResponse(err))
 			return
 		}
 
@@ -72,25 +76,27 @@ func SetupRouter(service *Service, allowOrigins ...string) *gin.Engine {
 	v1.POST("/authentication", func(ctx *gin.Context) {
 		var req AuthenticationRequest
 		if err := ctx.ShouldBindJSON(&req); err != nil {
-			ctx.SecureJSON(http.StatusBadRequest, enging.BadRequestMessage)
+			enging.RespondProblem(ctx, invalidRequest(err))
 			return
 		}
-		slog.InfoContext(ctx, "recieve", "body", req)
-		claims, err := service.Authentication(ctx, req.UserId, req.Password)
+		tracing.SetAttributes(ctx.Request.Context(), tracing.ClientIdKey.String(req.ClientId))
+		slog.InfoContext(ctx.Request.Context(), "recieve", "body", req)
+		claims, err := service.Authentication(ctx.Request.Context(), req.UserId, req.Password)
 		if err != nil {
-			slog.ErrorContext(ctx, fmt.Sprintf("cannot authenticate: %v", err))
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot authenticate: %v", err))
 			if errors.Is(err, database.ErrNotFound) || errors.Is(err, ErrNoMatchPassword) {
-				ctx.SecureJSON(http.StatusBadRequest, gin.H{"status": "Invalid Id or Password"})
+				// not telling which is wrong
+				enging.RespondProblem(ctx, enging.New(http.StatusBadRequest, enging.CodeInvalidCredentials, "Invalid Id or Password").Wrap(err))
 				return
 			}
-			ctx.SecureJSON(http.StatusInternalServerError, enging.InternalServerErrorMessage)
+			enging.RespondProblem(ctx, ErrorResponse(err))
 			return
 		}
 		claims.ClientId = req.ClientId // !
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 		ss, err := token.SignedString(JWT_SECRET)
 		if err != nil {
-			ctx.SecureJSON(http.StatusInternalServerError, enging.InternalServerErrorMessage)
+			enging.RespondProblem(ctx, err)
 			return
 		}
 
@@ -102,28 +108,63 @@ func SetupRouter(service *Service, allowOrigins ...string) *gin.Engine {
 	v1.POST("/authorization", func(ctx *gin.Context) {
 		var req AuthorizationRequest
 		if err := ctx.ShouldBindJSON(&req); err != nil {
-			ctx.SecureJSON(http.StatusBadRequest, enging.BadRequestMessage)
+			enging.RespondOAuth(ctx, invalidRequest(err))
+			return
+		}
+		tracing.SetAttributes(ctx.Request.Context(), tracing.ClientIdKey.String(req.ClientId))
+		requirements := AuthenticationRequirements{
+			Prompt:    req.Prompt,
+			MaxAge:    req.MaxAge,
+			AcrValues: req.AcrValues,
+		}
+		// without the login, 'prompt=none' cannot ask the user to log in
+		invalidSession := func(msg string) *enging.Error {
+			if requirements.HasPrompt(PromptNone) {
+				return enging.New(http.StatusUnauthorized, enging.CodeLoginRequired, msg)
+			}
+			return enging.New(http.StatusBadRequest, enging.CodeInvalidRequest, msg)
+		}
+		if req.JWT == "" {
+			enging.RespondOAuth(ctx, invalidSession("jwt is required"))
 			return
 		}
-		claims, err := service.ParseMyClaims(ctx, req.JWT, JWT_SECRET)
+		claims, err := service.ParseMyClaims(ctx.Request.Context(), req.JWT, JWT_SECRET)
 		if err != nil {
-			slog.ErrorContext(ctx, fmt.Sprintf("cannot parse jwt: %v", err))
-			ctx.SecureJSON(http.StatusBadRequest, enging.BadRequestMessage)
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot parse jwt: %v", err))
+			enging.RespondOAuth(ctx, invalidSession("jwt is invalid").Wrap(err))
 			return
 		}
 		if claims.ClientId != req.ClientId {
-			slog.ErrorContext(ctx, fmt.Sprintf("cannot match clientId jwt:%s, req:%s", claims.ClientId, req.ClientId))
-			ctx.SecureJSON(http.StatusBadRequest, enging.BadRequestMessage)
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot match clientId jwt:%s, req:%s", claims.ClientId, req.ClientId))
+			enging.RespondOAuth(ctx, enging.New(http.StatusBadRequest, enging.CodeInvalidRequest, "client_id does not match the jwt"))
+			return
+		}
+		authTime, acr, err := service.CheckAuthentication(claims, requirements)
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot meet authentication requirements: %v", err))
+			enging.RespondOAuth(ctx, ErrorResponse(err))
 			return
 		}
 
-		authorization, err := service.NewAuthorizationCode(ctx, NewAuthorizationCodeConfig{
-			UserId:          claims.Subject,
-			ServiceClientId: claims.ClientId,
+		details, err := authzdetails.Parse(req.AuthorizationDetails)
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot parse authorization details: %v", err))
+			enging.RespondOAuth(ctx, enging.New(http.StatusBadRequest, enging.CodeInvalidAuthorizationDetails, authzdetails.ErrInvalidDetails.Error()).Wrap(err))
+			return
+		}
+
+		authorization, err := service.NewAuthorizationCode(ctx.Request.Context(), NewAuthorizationCodeConfig{
+			UserId:               claims.Subject,
+			ServiceClientId:      claims.ClientId,
+			Scope:                req.Scope,
+			AuthTime:             authTime,
+			Acr:                  acr,
+			Resource:             req.Resource,
+			AuthorizationDetails: details,
 		})
 		if err != nil {
-			slog.ErrorContext(ctx, fmt.Sprintf("cannot get authorization code: %v", err))
-			ctx.SecureJSON(http.StatusInternalServerError, enging.InternalServerErrorMessage)
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot get authorization code: %v", err))
+			enging.RespondOAuth(ctx, ErrorResponse(err))
 			return
 		}
 
@@ -135,42 +176,185 @@ func SetupRouter(service *Service, allowOrigins ...string) *gin.Engine {
 	v1.POST("/accesstoken", func(ctx *gin.Context) {
 		var req AccessTokenRequest
 		if err := ctx.ShouldBindJSON(&req); err != nil {
-			ctx.SecureJSON(http.StatusBadRequest, enging.BadRequestMessage)
+			enging.RespondOAuth(ctx, invalidRequest(err))
+			return
+		}
+		tracing.SetAttributes(ctx.Request.Context(), tracing.ClientIdKey.String(req.ClientId), tracing.GrantTypeKey.String(req.GrantType))
+		// client authentication
+		client, err := service.AuthenticateClient(ctx.Request.Context(), ClientCredentials{
+			ClientId:            req.ClientId,
+			ClientSecret:        req.ClientSecret,
+			ClientAssertionType: req.ClientAssertionType,
+			ClientAssertion:     req.ClientAssertion,
+			TLS:                 ctx.Request.TLS,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot authenticate client[%s]: %v", req.ClientId, err))
+			enging.RespondOAuth(ctx, clientAuthenticationError(err, http.StatusBadRequest))
 			return
 		}
-		// client secret
-		if client, err := service.client.GetServieClientById(ctx, req.ClientId); err != nil {
-			slog.ErrorContext(ctx, fmt.Sprintf("cannot get service client[%s]: %v", req.ClientId, err))
-			ctx.SecureJSON(http.StatusBadRequest, enging.BadRequestMessage)
-		} else if req.ClientSecret != client.Secret {
-			slog.ErrorContext(ctx, fmt.Sprintf("client secret[%s] is invalid: %v", req.ClientSecret, err))
-			ctx.SecureJSON(http.StatusBadRequest, enging.BadRequestMessage)
+		// the client may be identified by the assertion or the certificate
+		tracing.SetAttributes(ctx.Request.Context(), tracing.ClientIdKey.String(client.GetId()))
+		// bind tokens to the client certificate if presented (RFC 8705)
+		thumbprint := CertificateThumbprint(ctx.Request.TLS)
+
+		if req.GrantType == GrantTypeTokenExchange {
+			token, err := service.ExchangeToken(ctx.Request.Context(), TokenExchangeConfig{
+				ClientId:              client.GetId(),
+				SubjectToken:          req.SubjectToken,
+				SubjectTokenType:      req.SubjectTokenType,
+				ActorToken:            req.ActorToken,
+				ActorTokenType:        req.ActorTokenType,
+				Audience:              req.Audience,
+				Scope:                 req.Scope,
+				CertificateThumbprint: thumbprint,
+			})
+			if err != nil {
+				slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot exchange token: %v", err))
+				enging.RespondOAuth(ctx, ErrorResponse(err))
+				return
+			}
+			var resp AccessTokenResponse
+			resp.AccessToken = token.GetToken()
+			resp.ExpiresIn = uint(time.Until(token.Expires.AsTime()).Seconds())
+			resp.IssuedTokenType = TokenTypeAccessToken
+			resp.Scope = token.GetScope()
+			resp.AuthorizationDetails = authzdetails.FromProto(token.GetAuthorizationDetails())
+			ctx.SecureJSON(http.StatusOK, resp)
 			return
 		}
 
 		var token *apiv1.AccessToken
 		var refresh *apiv1.RefreshToken
-		var err error
 		switch {
 		case req.Code != "":
-			token, refresh, err = service.NewAccessToken(ctx, req.Code)
+			token, refresh, err = service.NewAccessToken(ctx.Request.Context(), NewAccessTokenConfig{
+				Code:                  req.Code,
+				ClientId:              client.GetId(),
+				Resource:              req.Resource,
+				CertificateThumbprint: thumbprint,
+			})
 		case req.RefreshToken != "":
-			token, refresh, err = service.UpdateAccessToken(ctx, req.RefreshToken)
+			token, refresh, err = service.UpdateAccessToken(ctx.Request.Context(), UpdateAccessTokenConfig{
+				RefreshToken:          req.RefreshToken,
+				ClientId:              client.GetId(),
+				Resource:              req.Resource,
+				CertificateThumbprint: thumbprint,
+			})
+		default:
+			enging.RespondOAuth(ctx, enging.New(http.StatusBadRequest, enging.CodeInvalidRequest, "either code or refresh_token is required"))
+			return
 		}
 		if err != nil {
-			slog.ErrorContext(ctx, fmt.Sprintf("cannot get tokens: %v", err))
-			if errors.Is(err, ErrAuthorizationCodeExpired) || errors.Is(err, ErrRefreshTokenExpired) {
-				ctx.SecureJSON(http.StatusUnauthorized, enging.StatusUnauthorizedErrorMessage)
-				return
-			}
-			ctx.SecureJSON(http.StatusInternalServerError, enging.InternalServerErrorMessage)
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot get tokens: %v", err))
+			enging.RespondOAuth(ctx, ErrorResponse(err))
 			return
 		}
 		var resp AccessTokenResponse
 		resp.AccessToken = token.GetToken()
 		resp.RefreshToken = refresh.GetToken()
 		resp.ExpiresIn = uint(time.Until(token.Expires.AsTime()).Seconds())
+		resp.AuthorizationDetails = authzdetails.FromProto(token.GetAuthorizationDetails())
+		ctx.SecureJSON(http.StatusOK, resp)
+	})
+
+	v1.POST("/end_session", func(ctx *gin.Context) {
+		var req EndSessionRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			enging.RespondProblem(ctx, invalidRequest(err))
+			return
+		}
+		claims, err := service.ParseMyClaims(ctx.Request.Context(), req.JWT, JWT_SECRET)
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot parse jwt: %v", err))
+			enging.RespondProblem(ctx, enging.New(http.StatusBadRequest, enging.CodeInvalidRequest, "jwt is invalid").Wrap(err))
+			return
+		}
+		if req.ClientId == "" {
+			req.ClientId = claims.ClientId
+		}
+		tracing.SetAttributes(ctx.Request.Context(), tracing.ClientIdKey.String(req.ClientId))
+		redirect, err := service.EndSession(ctx.Request.Context(), EndSessionConfig{
+			SessionId:             claims.ID,
+			UserId:                claims.Subject,
+			ClientId:              req.ClientId,
+			PostLogoutRedirectUri: req.PostLogoutRedirectUri,
+			State:                 req.State,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot end session: %v", err))
+			enging.RespondProblem(ctx, ErrorResponse(err))
+			return
+		}
+		var resp EndSessionResponse
+		resp.RedirectUri = redirect
+		ctx.SecureJSON(http.StatusOK, resp)
+	})
+
+	v1.POST("/introspection", func(ctx *gin.Context) {
+		var req IntrospectionRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			enging.RespondOAuth(ctx, invalidRequest(err))
+			return
+		}
+		tracing.SetAttributes(ctx.Request.Context(), tracing.ClientIdKey.String(req.ClientId))
+		client, err := service.AuthenticateClient(ctx.Request.Context(), ClientCredentials{
+			ClientId:            req.ClientId,
+			ClientSecret:        req.ClientSecret,
+			ClientAssertionType: req.ClientAssertionType,
+			ClientAssertion:     req.ClientAssertion,
+			TLS:                 ctx.Request.TLS,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot authenticate client[%s]: %v", req.ClientId, err))
+			enging.RespondOAuth(ctx, clientAuthenticationError(err, http.StatusUnauthorized))
+			return
+		}
+		token, err := service.validAccessToken(ctx.Request.Context(), req.Token)
+		if err == nil && token.GetServiceClientId() != client.GetId() {
+			// a client introspects only its own tokens. the others are not told apart from unknown ones (RFC 7662 section 2.2)
+			slog.WarnContext(ctx.Request.Context(), fmt.Sprintf("client[%s] cannot introspect a token of client[%s]", client.GetId(), token.GetServiceClientId()))
+			err = database.ErrNotFound
+		}
+		if err != nil {
+			if !errors.Is(err, database.ErrNotFound) && !errors.Is(err, ErrAccessTokenExpired) {
+				slog.ErrorContext(ctx.Request.Context(), fmt.Sprintf("cannot introspect token: %v", err))
+				enging.RespondOAuth(ctx, err)
+				return
+			}
+			ctx.SecureJSON(http.StatusOK, IntrospectionResponse{Active: false})
+			return
+		}
+		var resp IntrospectionResponse
+		resp.Active = true
+		resp.Scope = token.GetScope()
+		resp.ClientId = token.GetServiceClientId()
+		resp.Sub = token.GetUserId()
+		resp.Exp = token.GetExpires().AsTime().Unix()
+		resp.Aud = token.GetAudience()
+		resp.Iss = Issuer
+		resp.TokenType = "Bearer"
+		if token.GetAuthTime() != nil {
+			resp.AuthTime = token.GetAuthTime().AsTime().Unix()
+		}
+		resp.Acr = token.GetAcr()
+		resp.AuthorizationDetails = authzdetails.FromProto(token.GetAuthorizationDetails())
 		ctx.SecureJSON(http.StatusOK, resp)
 	})
 	return router
 }
+
+// invalidRequest is the response to a request which cannot be bound.
+func invalidRequest(err error) *enging.Error {
+	return enging.New(http.StatusBadRequest, enging.CodeInvalidRequest, "request is malformed").Wrap(err)
+}
+
+// clientAuthenticationError is the response to a failed client authentication, 'invalid_client' with [status]
+// unless the database is unavailable.
+func clientAuthenticationError(err error, status int) *enging.Error {
+	e := ErrorResponse(err)
+	if e.Status < http.StatusInternalServerError {
+		e = enging.New(status, enging.CodeInvalidClient, ErrInvalidClient.Error()).Wrap(err)
+	}
+	return e
+}
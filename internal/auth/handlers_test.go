@@ -3,15 +3,37 @@ package auth
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/acr"
+	"github.com/yyyoichi/OhAuth0.1/internal/authzdetails"
 	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	enging "github.com/yyyoichi/OhAuth0.1/internal/engine"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
 	server_test "github.com/yyyoichi/OhAuth0.1/internal/test"
+	"github.com/yyyoichi/OhAuth0.1/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestHandlerOK(t *testing.T) {
@@ -72,6 +94,7 @@ func TestHandlerOK(t *testing.T) {
 					req.ClientId = "501"
 					req.ResponseType = "code"
 					req.Scope = "profile:view"
+					req.Resource = []string{database.RESOURCE_URI}
 					b, err := json.Marshal(req)
 					assert.NoError(t, err)
 					return bytes.NewBuffer(b)
@@ -117,7 +140,7 @@ func TestHandlerOK(t *testing.T) {
 						ServiceClientId: "501",
 					})
 					assert.NoError(t, err)
-					_, refresh, err := service.NewAccessToken(context.Background(), authorization.Code)
+					_, refresh, err := service.NewAccessToken(context.Background(), NewAccessTokenConfig{Code: authorization.Code, ClientId: authorization.ServiceClientId})
 					assert.NoError(t, err)
 					var req AccessTokenRequest
 					req.ClientId = authorization.ServiceClientId
@@ -142,3 +165,732 @@ func TestHandlerOK(t *testing.T) {
 		})
 	}
 }
+
+func TestAccessTokenMutualTLS(t *testing.T) {
+	ctx := context.Background()
+	cert, err := pki.NewSelfSignedCertificate("self-signed-client")
+	assert.NoError(t, err)
+	other, err := pki.NewSelfSignedCertificate("self-signed-client")
+	assert.NoError(t, err)
+	db, _ := database.NewDatabase()
+	assert.NoError(t, db.CreateServiceClient(ctx, &apiv1.ServiceClient{
+		Id:                             "self-signed",
+		TokenEndpointAuthMethods:       []string{ClientAuthMethodSelfSignedTLS},
+		TlsClientCertificateThumbprint: pki.Thumbprint(cert.Leaf),
+	}))
+	service := &Service{
+		client: db,
+	}
+	router := SetupRouter(service, "*")
+	body := func() io.Reader {
+		authorization, err := service.NewAuthorizationCode(ctx, NewAuthorizationCodeConfig{
+			UserId:          "1",
+			ServiceClientId: "self-signed",
+		})
+		assert.NoError(t, err)
+		var req AccessTokenRequest
+		req.ClientId = "self-signed"
+		req.Code = authorization.Code
+		req.GrantType = "authorization_code"
+		b, err := json.Marshal(req)
+		assert.NoError(t, err)
+		return bytes.NewBuffer(b)
+	}
+	config := server_test.Config{
+		Router: router,
+		Method: http.MethodPost,
+		Path:   "/api/v1/accesstoken",
+	}
+	test := map[string]struct {
+		options []server_test.Option
+		expCode int
+	}{
+		"registered certificate": {
+			options: []server_test.Option{
+				server_test.WithBody(body()),
+				server_test.WithTLS(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}}),
+			},
+			expCode: http.StatusOK,
+		},
+		"other certificate": {
+			options: []server_test.Option{
+				server_test.WithBody(body()),
+				server_test.WithTLS(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{other.Leaf}}),
+			},
+			expCode: http.StatusBadRequest,
+		},
+		"no tls": {
+			options: []server_test.Option{server_test.WithBody(body())},
+			expCode: http.StatusBadRequest,
+		},
+	}
+	for scenario, tt := range test {
+		t.Run(scenario, func(t *testing.T) {
+			_, resp := server_test.Serve(t, config, tt.options...)
+			assert.Equalf(t, tt.expCode, resp.Code, resp.Body.String())
+			if tt.expCode != http.StatusOK {
+				return
+			}
+			var body AccessTokenResponse
+			assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+			token, err := db.GetAccessTokenByToken(ctx, body.AccessToken)
+			assert.NoError(t, err)
+			assert.Equal(t, pki.Thumbprint(cert.Leaf), token.CertificateThumbprint)
+		})
+	}
+}
+
+func TestAccessTokenOtherClient(t *testing.T) {
+	ctx := context.Background()
+	db, _ := database.NewDatabase()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	jwk, err := NewJSONWebKey(&key.PublicKey, "ec")
+	assert.NoError(t, err)
+	jwks, err := json.Marshal(JSONWebKeySet{Keys: []JSONWebKey{jwk}})
+	assert.NoError(t, err)
+	assert.NoError(t, db.CreateServiceClient(ctx, &apiv1.ServiceClient{
+		Id:                       "private-key-jwt",
+		TokenEndpointAuthMethods: []string{ClientAuthMethodPrivateKeyJWT},
+		Jwks:                     string(jwks),
+	}))
+	assertion := func() string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+			Issuer:    "private-key-jwt",
+			Subject:   "private-key-jwt",
+			Audience:  jwt.ClaimStrings{Issuer},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			ID:        uuid.NewString(),
+		})
+		token.Header["kid"] = "ec"
+		ss, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return ss
+	}
+	service := &Service{client: db}
+	router := SetupRouter(service, "*")
+	config := server_test.Config{
+		Router: router,
+		Method: http.MethodPost,
+		Path:   "/api/v1/accesstoken",
+	}
+	serve := func(req AccessTokenRequest) *httptest.ResponseRecorder {
+		b, err := json.Marshal(req)
+		assert.NoError(t, err)
+		_, resp := server_test.Serve(t, config, server_test.WithBody(bytes.NewBuffer(b)))
+		return resp
+	}
+	assertInvalidGrant := func(resp *httptest.ResponseRecorder) {
+		assert.Equalf(t, http.StatusBadRequest, resp.Code, resp.Body.String())
+		var body enging.OAuthErrorResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.Equal(t, "invalid_grant", body.Error)
+		assert.Equal(t, enging.CodeInvalidGrant, body.Code)
+	}
+	// issued to 501, redeemed by 500
+	code, err := service.NewAuthorizationCode(ctx, NewAuthorizationCodeConfig{UserId: "1", ServiceClientId: "501"})
+	assert.NoError(t, err)
+	t.Run("authorization_code", func(t *testing.T) {
+		assertInvalidGrant(serve(AccessTokenRequest{GrantType: GrantTypeAuthorizationCode, ClientId: "500", ClientSecret: "secret", Code: code.Code}))
+	})
+	t.Run("authorization_code by private_key_jwt", func(t *testing.T) {
+		assertInvalidGrant(serve(AccessTokenRequest{
+			GrantType:           GrantTypeAuthorizationCode,
+			ClientAssertionType: ClientAssertionTypeJWTBearer,
+			ClientAssertion:     assertion(),
+			Code:                code.Code,
+		}))
+	})
+	// the code is still usable by its client
+	resp := serve(AccessTokenRequest{GrantType: GrantTypeAuthorizationCode, ClientId: "501", ClientSecret: "secret", Code: code.Code})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var token AccessTokenResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &token))
+	t.Run("refresh_token", func(t *testing.T) {
+		assertInvalidGrant(serve(AccessTokenRequest{GrantType: GrantTypeRefreshToken, ClientId: "500", ClientSecret: "secret", RefreshToken: token.RefreshToken}))
+		assertInvalidGrant(serve(AccessTokenRequest{
+			GrantType:           GrantTypeRefreshToken,
+			ClientAssertionType: ClientAssertionTypeJWTBearer,
+			ClientAssertion:     assertion(),
+			RefreshToken:        token.RefreshToken,
+		}))
+		refresh, err := db.GetRefreshTokenByToken(ctx, token.RefreshToken)
+		assert.NoError(t, err)
+		assert.Nil(t, refresh.GetRevokedAt())
+	})
+}
+
+func TestAccessTokenExchange(t *testing.T) {
+	ctx := context.Background()
+	db, _ := database.NewDatabase()
+	assert.NoError(t, db.CreateAccessToken(ctx, &apiv1.AccessToken{
+		Token:           "subject",
+		UserId:          "1",
+		ServiceClientId: "501",
+		Scope:           "profile:view",
+		Expires:         timestamppb.New(time.Now().Add(time.Hour)),
+	}))
+	assert.NoError(t, db.CreateResourceServer(ctx, &apiv1.ResourceServer{
+		Uri:    "https://downstream.example",
+		Scopes: []string{"profile:view"},
+	}))
+	service := &Service{
+		client: db,
+		exchangePolicy: TokenExchangePolicy{
+			{ClientId: "500", Audiences: []string{"https://downstream.example"}, Scopes: []string{"profile:view"}},
+		},
+	}
+	router := SetupRouter(service, "*")
+	body := func(clientId string) io.Reader {
+		var req AccessTokenRequest
+		req.GrantType = GrantTypeTokenExchange
+		req.ClientId = clientId
+		req.ClientSecret = "secret"
+		req.SubjectToken = "subject"
+		req.SubjectTokenType = TokenTypeAccessToken
+		req.Audience = []string{"https://downstream.example"}
+		b, err := json.Marshal(req)
+		assert.NoError(t, err)
+		return bytes.NewBuffer(b)
+	}
+	config := server_test.Config{
+		Router: router,
+		Method: http.MethodPost,
+		Path:   "/api/v1/accesstoken",
+	}
+
+	_, resp := server_test.Serve(t, config, server_test.WithBody(body("500")))
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var token AccessTokenResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &token))
+	assert.NotEmpty(t, token.AccessToken)
+	assert.Empty(t, token.RefreshToken)
+	assert.Equal(t, TokenTypeAccessToken, token.IssuedTokenType)
+	assert.Equal(t, "profile:view", token.Scope)
+
+	_, resp = server_test.Serve(t, config, server_test.WithBody(body("501")))
+	assert.Equalf(t, http.StatusForbidden, resp.Code, resp.Body.String())
+}
+
+func TestAuthorizationDetails(t *testing.T) {
+	ctx := context.Background()
+	db, _ := database.NewDatabase()
+	service := &Service{
+		client: db,
+	}
+	router := SetupRouter(service, "*")
+	authorize := func(details string) *httptest.ResponseRecorder {
+		claims, err := service.Authentication(ctx, "1", "password")
+		assert.NoError(t, err)
+		claims.ClientId = "501"
+		ss, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JWT_SECRET)
+		assert.NoError(t, err)
+		var req AuthorizationRequest
+		req.JWT = ss
+		req.ClientId = "501"
+		req.ResponseType = "code"
+		req.Scope = "profile:view"
+		req.AuthorizationDetails = details
+		b, err := json.Marshal(req)
+		assert.NoError(t, err)
+		_, resp := server_test.Serve(t, server_test.Config{
+			Router: router,
+			Method: http.MethodPost,
+			Path:   "/api/v1/authorization",
+		}, server_test.WithBody(bytes.NewBuffer(b)))
+		return resp
+	}
+	post := func(path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		assert.NoError(t, err)
+		_, resp := server_test.Serve(t, server_test.Config{
+			Router: router,
+			Method: http.MethodPost,
+			Path:   path,
+		}, server_test.WithBody(bytes.NewBuffer(b)))
+		return resp
+	}
+
+	resp := authorize(`[{"type":"profile","actions":["view"],"datatypes":["name"]}]`)
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var authorization AuthorizationResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &authorization))
+
+	resp = post("/api/v1/accesstoken", AccessTokenRequest{
+		GrantType:    GrantTypeAuthorizationCode,
+		ClientId:     "501",
+		ClientSecret: "secret",
+		Code:         authorization.Code,
+	})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var token AccessTokenResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &token))
+	expDetails := []authzdetails.Detail{
+		{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionView}, Datatypes: []string{authzdetails.DatatypeName}},
+	}
+	assert.Equal(t, expDetails, token.AuthorizationDetails)
+
+	// introspection
+	resp = post("/api/v1/introspection", IntrospectionRequest{Token: token.AccessToken, ClientId: "501", ClientSecret: "secret"})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var introspection IntrospectionResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &introspection))
+	assert.True(t, introspection.Active)
+	assert.Equal(t, "1", introspection.Sub)
+	assert.Equal(t, "501", introspection.ClientId)
+	assert.Equal(t, []string{database.RESOURCE_URI}, introspection.Aud)
+	assert.Equal(t, expDetails, introspection.AuthorizationDetails)
+
+	resp = post("/api/v1/introspection", IntrospectionRequest{Token: "unknown", ClientId: "501", ClientSecret: "secret"})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.JSONEq(t, `{"active":false}`, resp.Body.String())
+	// the token of another client
+	resp = post("/api/v1/introspection", IntrospectionRequest{Token: token.AccessToken, ClientId: "500", ClientSecret: "secret"})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.JSONEq(t, `{"active":false}`, resp.Body.String())
+	resp = post("/api/v1/introspection", IntrospectionRequest{Token: token.AccessToken, ClientId: "501", ClientSecret: "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	for scenario, details := range map[string]string{
+		"not json":     `[`,
+		"unknown type": `[{"type":"document","actions":["read"]}]`,
+		"bad location": `[{"type":"profile","actions":["view"],"locations":["https://unknown.example"]}]`,
+	} {
+		resp := authorize(details)
+		assert.Equalf(t, http.StatusBadRequest, resp.Code, scenario)
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	ctx := context.Background()
+	received := make(chan string, 2)
+	rp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.PostFormValue("logout_token")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer rp.Close()
+	db, _ := database.NewDatabase()
+	assert.NoError(t, db.CreateServiceClient(ctx, &apiv1.ServiceClient{
+		Id:                     "600",
+		Secret:                 "secret600",
+		RedirectUri:            "http://localhost:7777",
+		Scope:                  "profile:view",
+		PostLogoutRedirectUris: []string{"http://localhost:7777/logout"},
+		BackchannelLogoutUri:   rp.URL,
+	}))
+	// not notified without a secret to sign the logout token
+	assert.NoError(t, db.CreateServiceClient(ctx, &apiv1.ServiceClient{
+		Id:                   "601",
+		RedirectUri:          "http://localhost:7778",
+		Scope:                "profile:view",
+		BackchannelLogoutUri: rp.URL,
+	}))
+	service := &Service{
+		client: db,
+	}
+	router := SetupRouter(service, "*")
+	post := func(path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		assert.NoError(t, err)
+		_, resp := server_test.Serve(t, server_test.Config{
+			Router: router,
+			Method: http.MethodPost,
+			Path:   path,
+		}, server_test.WithBody(bytes.NewBuffer(b)))
+		return resp
+	}
+	claims, err := service.Authentication(ctx, "1", "password")
+	assert.NoError(t, err)
+	claims.ClientId = "600"
+	ss, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JWT_SECRET)
+	assert.NoError(t, err)
+	authorize := AuthorizationRequest{JWT: ss, ClientId: "600", ResponseType: "code", Scope: "profile:view"}
+	resp := post("/api/v1/authorization", authorize)
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	// the same session
+	other := *claims
+	other.ClientId = "601"
+	otherss, err := jwt.NewWithClaims(jwt.SigningMethodHS256, other).SignedString(JWT_SECRET)
+	assert.NoError(t, err)
+	resp = post("/api/v1/authorization", AuthorizationRequest{JWT: otherss, ClientId: "601", ResponseType: "code", Scope: "profile:view"})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	// not registered redirect uri keeps the session
+	resp = post("/api/v1/end_session", EndSessionRequest{JWT: ss, PostLogoutRedirectUri: "https://evil.example"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = post("/api/v1/end_session", EndSessionRequest{JWT: ss, PostLogoutRedirectUri: "http://localhost:7777/logout", State: "xyz"})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var endSession EndSessionResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &endSession))
+	assert.Equal(t, "http://localhost:7777/logout?state=xyz", endSession.RedirectUri)
+
+	select {
+	case logoutToken := <-received:
+		logout, err := ParseLogoutToken(logoutToken, "600", "secret600")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", logout.Subject)
+		assert.Equal(t, claims.ID, logout.Sid)
+		_, err = ParseLogoutToken(logoutToken, "600", "other")
+		assert.Error(t, err)
+	default:
+		t.Fatal("no back-channel logout")
+	}
+	assert.Empty(t, received, "client without a secret is notified")
+
+	// the session is terminated
+	resp = post("/api/v1/authorization", authorize)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = post("/api/v1/end_session", EndSessionRequest{JWT: ss})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestBackChannelLogoutTLS(t *testing.T) {
+	ctx := context.Background()
+	rp := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer rp.Close()
+	client := &apiv1.ServiceClient{Id: "600", Secret: "secret600", BackchannelLogoutUri: rp.URL}
+	db, _ := database.NewDatabase()
+
+	// the certificate of the client is not trusted by the system CAs
+	service, err := NewService(ctx, Config{Database: db})
+	assert.NoError(t, err)
+	assert.Error(t, service.sendLogoutToken(ctx, client, "1", "sid"))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(rp.Certificate())
+	service, err = NewService(ctx, Config{Database: db, LogoutRootCAs: pool})
+	assert.NoError(t, err)
+	assert.NoError(t, service.sendLogoutToken(ctx, client, "1", "sid"))
+}
+
+func TestAuthorizationStepUp(t *testing.T) {
+	ctx := context.Background()
+	db, _ := database.NewDatabase()
+	service := &Service{
+		client: db,
+	}
+	router := SetupRouter(service, "*")
+	authorize := func(claims *MyClaims, fn func(req *AuthorizationRequest)) *httptest.ResponseRecorder {
+		ss, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JWT_SECRET)
+		assert.NoError(t, err)
+		req := AuthorizationRequest{JWT: ss, ClientId: "500", ResponseType: "code", Scope: "profile:view profile:edit"}
+		fn(&req)
+		b, err := json.Marshal(req)
+		assert.NoError(t, err)
+		_, resp := server_test.Serve(t, server_test.Config{
+			Router: router,
+			Method: http.MethodPost,
+			Path:   "/api/v1/authorization",
+		}, server_test.WithBody(bytes.NewBuffer(b)))
+		return resp
+	}
+	claims, err := service.Authentication(ctx, "1", "password")
+	assert.NoError(t, err)
+	claims.ClientId = "500"
+
+	maxAge := int64(60)
+	resp := authorize(claims, func(req *AuthorizationRequest) {
+		req.Prompt = PromptLogin
+		req.MaxAge = &maxAge
+		req.AcrValues = acr.Password
+	})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var authorization AuthorizationResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &authorization))
+	code, err := db.GetAuthorizationCodeByCode(ctx, authorization.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, "profile:view profile:edit", code.Scope)
+	assert.Equal(t, acr.Password, code.Acr)
+	assert.Equal(t, claims.IssuedAt.Unix(), code.AuthTime.AsTime().Unix())
+
+	// an old login needs a new one
+	claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	resp = authorize(claims, func(req *AuthorizationRequest) { req.MaxAge = &maxAge })
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = authorize(claims, func(req *AuthorizationRequest) { req.Prompt = PromptLogin })
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = authorize(claims, func(req *AuthorizationRequest) { req.Prompt = "unknown" })
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = authorize(claims, func(req *AuthorizationRequest) { req.Scope = "profile:delete" })
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	assertError := func(resp *httptest.ResponseRecorder, status int, oauthError string) {
+		assert.Equalf(t, status, resp.Code, resp.Body.String())
+		var body enging.OAuthErrorResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.Equal(t, oauthError, body.Error)
+	}
+	// 'prompt=login' needs a new login even if recent
+	claims, err = service.Authentication(ctx, "1", "password")
+	assert.NoError(t, err)
+	claims.ClientId = "500"
+	resp = authorize(claims, func(req *AuthorizationRequest) { req.Prompt = PromptLogin })
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	resp = authorize(claims, func(req *AuthorizationRequest) { req.Prompt = PromptLogin })
+	assertError(resp, http.StatusUnauthorized, "login_required")
+	// 'prompt=none' reuses the session the client joined
+	resp = authorize(claims, func(req *AuthorizationRequest) { req.Prompt = PromptNone })
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	resp = authorize(claims, func(req *AuthorizationRequest) {
+		req.Prompt = PromptNone
+		req.JWT = ""
+	})
+	assertError(resp, http.StatusUnauthorized, "login_required")
+	resp = authorize(claims, func(req *AuthorizationRequest) {
+		req.Prompt = PromptNone
+		req.JWT = "invalid"
+	})
+	assertError(resp, http.StatusUnauthorized, "login_required")
+	resp = authorize(claims, func(req *AuthorizationRequest) { req.JWT = "" })
+	assertError(resp, http.StatusBadRequest, "invalid_request")
+	fresh, err := service.Authentication(ctx, "1", "password")
+	assert.NoError(t, err)
+	fresh.ClientId = "500"
+	resp = authorize(fresh, func(req *AuthorizationRequest) { req.Prompt = PromptNone })
+	assertError(resp, http.StatusUnauthorized, "consent_required")
+
+	negative := int64(-1)
+	resp = authorize(fresh, func(req *AuthorizationRequest) { req.MaxAge = &negative })
+	assertError(resp, http.StatusBadRequest, "invalid_request")
+}
+
+func TestHandlerTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	db, _ := database.NewDatabase()
+	service := &Service{
+		client:         db,
+		tracerProvider: provider,
+	}
+	router := SetupRouter(service, "*")
+	post := func(path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		assert.NoError(t, err)
+		_, resp := server_test.Serve(t, server_test.Config{
+			Router: router,
+			Method: http.MethodPost,
+			Path:   path,
+		}, server_test.WithBody(bytes.NewBuffer(b)))
+		return resp
+	}
+
+	resp := post("/api/v1/authentication", AuthenticationRequest{UserId: "1", Password: "password", ClientId: "501"})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var authentication AuthenticationResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &authentication))
+	resp = post("/api/v1/authorization", AuthorizationRequest{JWT: authentication.JWT, ClientId: "501", ResponseType: "code", Scope: "profile:view"})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var authorization AuthorizationResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &authorization))
+	resp = post("/api/v1/accesstoken", AccessTokenRequest{
+		GrantType:    GrantTypeAuthorizationCode,
+		ClientId:     "501",
+		ClientSecret: "secret",
+		Code:         authorization.Code,
+	})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var token AccessTokenResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &token))
+	resp = post("/api/v1/accesstoken", AccessTokenRequest{
+		GrantType:    GrantTypeRefreshToken,
+		ClientId:     "501",
+		ClientSecret: "wrong",
+		RefreshToken: token.RefreshToken,
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 4) {
+		return
+	}
+	attrs := func(i int) map[attribute.Key]string {
+		m := map[attribute.Key]string{}
+		for _, kv := range spans[i].Attributes {
+			m[kv.Key] = kv.Value.Emit()
+		}
+		return m
+	}
+	assert.Equal(t, "POST /api/v1/accesstoken", spans[2].Name)
+	assert.Equal(t, "501", attrs(2)[tracing.ClientIdKey])
+	assert.Equal(t, GrantTypeAuthorizationCode, attrs(2)[tracing.GrantTypeKey])
+	assert.Equal(t, "success", attrs(2)[tracing.ResultKey])
+	assert.Equal(t, GrantTypeRefreshToken, attrs(3)[tracing.GrantTypeKey])
+	assert.Equal(t, "failure", attrs(3)[tracing.ResultKey])
+
+	// no secret is recorded
+	secrets := []string{"password", "secret", "wrong", authentication.JWT, authorization.Code, token.AccessToken, token.RefreshToken}
+	for i, span := range spans {
+		for key, value := range attrs(i) {
+			for _, secret := range secrets {
+				assert.NotContainsf(t, value, secret, "%s of %s", key, span.Name)
+			}
+		}
+		for _, event := range span.Events {
+			for _, kv := range event.Attributes {
+				for _, secret := range secrets {
+					assert.NotContains(t, kv.Value.Emit(), secret)
+				}
+			}
+		}
+	}
+}
+
+func TestHandlerLogs(t *testing.T) {
+	// without the redacting handler, the call sites must not log secrets by themselves
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	ctx := context.Background()
+	db, _ := database.NewDatabase()
+	const clientSecret = "client-secret-4f9a"
+	assert.NoError(t, db.CreateServiceClient(ctx, &apiv1.ServiceClient{
+		Id:          "900",
+		Name:        "Logging",
+		Secret:      clientSecret,
+		RedirectUri: database.REDIRECT_URI,
+		Scope:       "profile:view",
+	}))
+	service := &Service{client: db}
+	router := SetupRouter(service, "*")
+	post := func(path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		assert.NoError(t, err)
+		_, resp := server_test.Serve(t, server_test.Config{
+			Router: router,
+			Method: http.MethodPost,
+			Path:   path,
+		}, server_test.WithBody(bytes.NewBuffer(b)))
+		return resp
+	}
+
+	resp := post("/api/v1/authentication", AuthenticationRequest{UserId: "1", Password: "password", ClientId: "900"})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var authentication AuthenticationResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &authentication))
+	resp = post("/api/v1/authorization", AuthorizationRequest{JWT: authentication.JWT, ClientId: "900", ResponseType: "code", Scope: "profile:view"})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var authorization AuthorizationResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &authorization))
+	resp = post("/api/v1/accesstoken", AccessTokenRequest{GrantType: GrantTypeAuthorizationCode, ClientId: "900", ClientSecret: clientSecret, Code: authorization.Code})
+	assert.Equalf(t, http.StatusOK, resp.Code, resp.Body.String())
+	var token AccessTokenResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &token))
+
+	// failures are logged
+	resp = post("/api/v1/accesstoken", AccessTokenRequest{GrantType: GrantTypeAuthorizationCode, ClientId: "900", ClientSecret: clientSecret, Code: authorization.Code})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = post("/api/v1/accesstoken", AccessTokenRequest{GrantType: GrantTypeRefreshToken, ClientId: "900", ClientSecret: clientSecret + "-wrong", RefreshToken: token.RefreshToken})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = post("/api/v1/introspection", IntrospectionRequest{Token: token.AccessToken, ClientId: "900", ClientSecret: clientSecret + "-wrong"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = post("/api/v1/authorization", AuthorizationRequest{JWT: authentication.JWT + "x", ClientId: "900", ResponseType: "code", Scope: "profile:view"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	assert.Contains(t, buf.String(), "recieve")
+	// the key is not the password
+	logs := strings.ReplaceAll(buf.String(), `"password":`, "")
+	for _, secret := range []string{"password", clientSecret, authentication.JWT, authorization.Code, token.AccessToken, token.RefreshToken} {
+		assert.NotEmpty(t, secret)
+		assert.NotContains(t, logs, secret)
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	db, _ := database.NewDatabase()
+	service := &Service{client: db}
+	router := SetupRouter(service, "*")
+	serve := func(method, path string, body any) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		assert.NoError(t, err)
+		_, resp := server_test.Serve(t, server_test.Config{
+			Router: router,
+			Method: method,
+			Path:   path,
+		}, server_test.WithBody(bytes.NewBuffer(b)))
+		return resp
+	}
+
+	// the problem details
+	resp := serve(http.MethodGet, "/api/v1/clients/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, enging.ProblemContentType, resp.Header().Get("Content-Type"))
+	var problem enging.Problem
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &problem))
+	assert.Equal(t, enging.CodeNotFound, problem.Code)
+	assert.Equal(t, http.StatusNotFound, problem.Status)
+	assert.Equal(t, resp.Header().Get(interceptor.RequestIDHeader), problem.RequestId)
+	assert.NotEmpty(t, problem.RequestId)
+
+	resp = serve(http.MethodPost, "/api/v1/authentication", AuthenticationRequest{UserId: "1", Password: "wrong", ClientId: "500"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &problem))
+	assert.Equal(t, enging.CodeInvalidCredentials, problem.Code)
+
+	// the error responses of OAuth
+	test := map[string]struct {
+		path      string
+		body      any
+		expStatus int
+		expError  string
+		expCode   enging.Code
+	}{
+		"malformed": {
+			path: "/api/v1/accesstoken", body: "{", expStatus: http.StatusBadRequest,
+			expError: "invalid_request", expCode: enging.CodeInvalidRequest,
+		},
+		"wrong secret": {
+			path: "/api/v1/accesstoken", body: AccessTokenRequest{GrantType: GrantTypeAuthorizationCode, ClientId: "500", ClientSecret: "wrong", Code: "code"},
+			expStatus: http.StatusBadRequest, expError: "invalid_client", expCode: enging.CodeInvalidClient,
+		},
+		"no code": {
+			path: "/api/v1/accesstoken", body: AccessTokenRequest{GrantType: GrantTypeAuthorizationCode, ClientId: "500", ClientSecret: "secret"},
+			expStatus: http.StatusBadRequest, expError: "invalid_request", expCode: enging.CodeInvalidRequest,
+		},
+		"introspection": {
+			path: "/api/v1/introspection", body: IntrospectionRequest{Token: "token", ClientId: "500", ClientSecret: "wrong"},
+			expStatus: http.StatusUnauthorized, expError: "invalid_client", expCode: enging.CodeInvalidClient,
+		},
+	}
+	for scenario, tt := range test {
+		t.Run(scenario, func(t *testing.T) {
+			resp := serve(http.MethodPost, tt.path, tt.body)
+			assert.Equalf(t, tt.expStatus, resp.Code, resp.Body.String())
+			assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+			var body enging.OAuthErrorResponse
+			assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+			assert.Equal(t, tt.expError, body.Error)
+			assert.Equal(t, tt.expCode, body.Code)
+			assert.NotEmpty(t, body.RequestId)
+		})
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	test := map[string]struct {
+		err       error
+		expStatus int
+		expCode   enging.Code
+	}{
+		"code expired":  {fmt.Errorf("cannot get tokens: %w", ErrAuthorizationCodeExpired), http.StatusUnauthorized, enging.CodeAuthorizationCodeExpired},
+		"client":        {fmt.Errorf("%w: %w", ErrInvalidClient, ErrNoMatchClientSecret), http.StatusBadRequest, enging.CodeInvalidClient},
+		"not allowed":   {ErrTokenExchangeNotAllowed, http.StatusForbidden, enging.CodeUnauthorizedClient},
+		"not found":     {database.ErrNotFound, http.StatusNotFound, enging.CodeNotFound},
+		"unavailable":   {fmt.Errorf("%w: %w", ErrInvalidClient, database.ErrTimeout), http.StatusServiceUnavailable, enging.CodeUnavailable},
+		"jwks":          {fmt.Errorf("%w: %w", ErrInvalidClientAssertion, ErrJWKSUnavailable), http.StatusServiceUnavailable, enging.CodeUnavailable},
+		"authz details": {fmt.Errorf("%w: bad location", authzdetails.ErrInvalidDetails), http.StatusBadRequest, enging.CodeInvalidAuthorizationDetails},
+	}
+	for scenario, tt := range test {
+		t.Run(scenario, func(t *testing.T) {
+			e := ErrorResponse(tt.err)
+			assert.Equal(t, tt.expStatus, e.Status)
+			assert.Equal(t, tt.expCode, e.Code)
+			assert.ErrorIs(t, e, tt.err)
+		})
+	}
+	assert.Equal(t, "invalid_grant", enging.OAuthError(ErrorResponse(ErrRefreshTokenRevoked).Code))
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JSON Web Key (RFC 7517) of a public key. Supports 'RSA' and 'EC' keys.
+type (
+	JSONWebKey struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid,omitempty"`
+		Use string `json:"use,omitempty"`
+		Alg string `json:"alg,omitempty"`
+		// RSA
+		N string `json:"n,omitempty"`
+		E string `json:"e,omitempty"`
+		// EC
+		Crv string `json:"crv,omitempty"`
+		X   string `json:"x,omitempty"`
+		Y   string `json:"y,omitempty"`
+	}
+	JSONWebKeySet struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+)
+
+var (
+	ErrJWKNotFound      = errors.New("jwk is not found")
+	ErrUnsupportedJWK   = errors.New("unsupported jwk")
+	ErrJWKSUnavailable  = errors.New("jwks is unavailable")
+	ErrJWKSNotPublished = errors.New("client has no jwks")
+)
+
+func NewJSONWebKey(key crypto.PublicKey, kid string) (JSONWebKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JSONWebKey{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JSONWebKey{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Crv: k.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	}
+	return JSONWebKey{}, fmt.Errorf("%w: %T", ErrUnsupportedJWK, key)
+}
+
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("%w: n: %w", ErrUnsupportedJWK, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("%w: e: %w", ErrUnsupportedJWK, err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: crv '%s'", ErrUnsupportedJWK, k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("%w: x: %w", ErrUnsupportedJWK, err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("%w: y: %w", ErrUnsupportedJWK, err)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("%w: point is not on curve", ErrUnsupportedJWK)
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("%w: kty '%s'", ErrUnsupportedJWK, k.Kty)
+}
+
+// Lookup returns the signing key identified by [kid]. Empty [kid] is allowed only if the set has a single key.
+func (s JSONWebKeySet) Lookup(kid string) (JSONWebKey, error) {
+	for _, k := range s.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if k.Kid == kid || (kid == "" && len(s.Keys) == 1) {
+			return k, nil
+		}
+	}
+	return JSONWebKey{}, fmt.Errorf("%w: kid '%s'", ErrJWKNotFound, kid)
+}
+
+// jwksCache fetches and caches the JWK Set published on 'jwks_uri'. The zero value is ready to use.
+type jwksCache struct {
+	mu      sync.Mutex
+	entries map[string]jwksCacheEntry
+	// http client to fetch, http.DefaultClient with a timeout if nil.
+	httpClient *http.Client
+}
+
+type jwksCacheEntry struct {
+	set     JSONWebKeySet
+	expires time.Time
+}
+
+const (
+	jwksCacheTTL     = time.Duration(5) * time.Minute
+	jwksFetchTimeout = time.Duration(5) * time.Second
+	jwksMaxBodySize  = 1 << 20
+)
+
+func (c *jwksCache) Get(ctx context.Context, uri string) (JSONWebKeySet, error) {
+	c.mu.Lock()
+	entry, found := c.entries[uri]
+	c.mu.Unlock()
+	if found && time.Now().Before(entry.expires) {
+		return entry.set, nil
+	}
+	set, err := c.fetch(ctx, uri)
+	if err != nil {
+		return JSONWebKeySet{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]jwksCacheEntry{}
+	}
+	c.entries[uri] = jwksCacheEntry{set: set, expires: time.Now().Add(jwksCacheTTL)}
+	return set, nil
+}
+
+func (c *jwksCache) fetch(ctx context.Context, uri string) (JSONWebKeySet, error) {
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return JSONWebKeySet{}, fmt.Errorf("%w: %w", ErrJWKSUnavailable, err)
+	}
+	httpClient := c.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return JSONWebKeySet{}, fmt.Errorf("%w: %w", ErrJWKSUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return JSONWebKeySet{}, fmt.Errorf("%w: status code is %d", ErrJWKSUnavailable, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, jwksMaxBodySize))
+	if err != nil {
+		return JSONWebKeySet{}, fmt.Errorf("%w: %w", ErrJWKSUnavailable, err)
+	}
+	var set JSONWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return JSONWebKeySet{}, fmt.Errorf("%w: %w", ErrJWKSUnavailable, err)
+	}
+	return set, nil
+}
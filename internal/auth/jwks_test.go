@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONWebKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+
+	for scenario, pub := range map[string]any{"RSA": &rsaKey.PublicKey, "EC": &ecKey.PublicKey} {
+		t.Run(scenario, func(t *testing.T) {
+			jwk, err := NewJSONWebKey(pub, "kid")
+			assert.NoError(t, err)
+			assert.Equal(t, scenario, jwk.Kty)
+			// through json
+			b, err := json.Marshal(JSONWebKeySet{Keys: []JSONWebKey{jwk}})
+			assert.NoError(t, err)
+			var set JSONWebKeySet
+			assert.NoError(t, json.Unmarshal(b, &set))
+			found, err := set.Lookup("kid")
+			assert.NoError(t, err)
+			act, err := found.PublicKey()
+			assert.NoError(t, err)
+			assert.Equal(t, pub, act)
+		})
+	}
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{{Kty: "EC", Kid: "a"}, {Kty: "EC", Kid: "b", Use: "enc"}}}
+	_, err = set.Lookup("a")
+	assert.NoError(t, err)
+	_, err = set.Lookup("b")
+	assert.ErrorIs(t, err, ErrJWKNotFound)
+	_, err = set.Lookup("")
+	assert.ErrorIs(t, err, ErrJWKNotFound)
+	_, err = JSONWebKey{Kty: "oct"}.PublicKey()
+	assert.ErrorIs(t, err, ErrUnsupportedJWK)
+	_, err = JSONWebKey{Kty: "EC", Crv: "P-256", X: "AA", Y: "AA"}.PublicKey()
+	assert.ErrorIs(t, err, ErrUnsupportedJWK)
+}
+
+func TestJWKSCache(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	jwk, err := NewJSONWebKey(&key.PublicKey, "kid")
+	assert.NoError(t, err)
+	var count atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+		if r.URL.Path != "/jwks" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{jwk}})
+	}))
+	defer server.Close()
+
+	var cache jwksCache
+	ctx := context.Background()
+	for range 3 {
+		set, err := cache.Get(ctx, server.URL+"/jwks")
+		assert.NoError(t, err)
+		assert.Len(t, set.Keys, 1)
+	}
+	assert.EqualValues(t, 1, count.Load()) // cached
+
+	_, err = cache.Get(ctx, server.URL+"/notfound")
+	assert.ErrorIs(t, err, ErrJWKSUnavailable)
+}
@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/expiry"
+)
+
+// BackChannelLogoutEvent is the 'events' member of logout tokens (OpenID Connect Back-Channel Logout 1.0 section 2.4).
+const BackChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+var (
+	ErrSessionNotFound              = errors.New("session is not found")
+	ErrInvalidPostLogoutRedirectUri = errors.New("post_logout_redirect_uri is not registered")
+	// logout tokens are signed with the client secret.
+	ErrNoClientSecret = errors.New("client has no secret to sign the logout token")
+)
+
+// sessions of the authorization server live as long as the authentication JWT.
+const sessionLifetime = time.Duration(10) * time.Minute
+
+const (
+	logoutTokenLifetime = time.Duration(2) * time.Minute
+	logoutTimeout       = time.Duration(5) * time.Second
+)
+
+type (
+	// LogoutClaims are the claims of a logout token signed with HS256 and the client secret.
+	LogoutClaims struct {
+		Sid    string         `json:"sid,omitempty"`
+		Events map[string]any `json:"events"`
+		jwt.RegisteredClaims
+	}
+	session struct {
+		userId  string
+		expires time.Time
+		// clients which are issued an authorization code in the session
+		clients []string
+	}
+	// sessionStore keeps login sessions by session id. The zero value is ready to use.
+	sessionStore struct {
+		mu       sync.Mutex
+		sessions map[string]*session
+		expiries expiry.Index[string]
+	}
+)
+
+// Start starts a session of [userId] and returns the session id.
+func (s *sessionStore) Start(userId string, expires time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = map[string]*session{}
+	}
+	// evict expired sessions
+	for _, e := range s.expiries.Expired(time.Now()) {
+		if ss, found := s.sessions[e.Key]; found && ss.expires.Equal(e.Expires) {
+			delete(s.sessions, e.Key)
+		}
+	}
+	sid := uuid.NewString()
+	s.sessions[sid] = &session{userId: userId, expires: expires}
+	s.expiries.Add(sid, expires)
+	return sid
+}
+
+// joinCondition is the state of the session required to join it, by 'prompt'.
+type joinCondition uint8
+
+const (
+	joinAny joinCondition = iota
+	// no client has joined the session yet
+	joinFresh
+	// the client has joined the session before
+	joinAgain
+)
+
+// Join records that [clientId] took part in the session [sid], if the session meets [condition].
+func (s *sessionStore) Join(sid, clientId string, condition joinCondition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, found := s.sessions[sid]
+	if !found || time.Now().After(ss.expires) {
+		return fmt.Errorf("%w: %w", ErrLoginRequired, ErrSessionNotFound)
+	}
+	joined := slices.Contains(ss.clients, clientId)
+	switch {
+	case condition == joinFresh && len(ss.clients) > 0:
+		return fmt.Errorf("%w: session is used by %v", ErrLoginRequired, ss.clients)
+	case condition == joinAgain && !joined:
+		return fmt.Errorf("%w: client[%s] has not joined the session", ErrConsentRequired, clientId)
+	}
+	if !joined {
+		ss.clients = append(ss.clients, clientId)
+	}
+	return nil
+}
+
+// End terminates the session [sid] of [userId] and returns it.
+func (s *sessionStore) End(sid, userId string) (session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, found := s.sessions[sid]
+	if !found || ss.userId != userId {
+		return session{}, ErrSessionNotFound
+	}
+	delete(s.sessions, sid)
+	return *ss, nil
+}
+
+type EndSessionConfig struct {
+	// session id, the 'jti' of the authentication JWT
+	SessionId string
+	UserId    string
+	// client which initiates the logout. required with [PostLogoutRedirectUri].
+	ClientId              string
+	PostLogoutRedirectUri string
+	State                 string
+}
+
+// セッションを終了し、参加したクライアントへバックチャネルログアウトを通知する。
+// 戻り値はログアウト後のリダイレクト先(指定がなければ空)
+func (s *Service) EndSession(ctx context.Context, config EndSessionConfig) (string, error) {
+	var redirect string
+	if config.PostLogoutRedirectUri != "" {
+		client, err := s.client.GetServieClientById(ctx, config.ClientId)
+		if err != nil {
+			return "", fmt.Errorf("%w: cannot get service client: %w", ErrInvalidClient, err)
+		}
+		if !slices.Contains(client.GetPostLogoutRedirectUris(), config.PostLogoutRedirectUri) {
+			return "", fmt.Errorf("%w: '%s'", ErrInvalidPostLogoutRedirectUri, config.PostLogoutRedirectUri)
+		}
+		u, err := url.Parse(config.PostLogoutRedirectUri)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidPostLogoutRedirectUri, err)
+		}
+		if config.State != "" {
+			q := u.Query()
+			q.Set("state", config.State)
+			u.RawQuery = q.Encode()
+		}
+		redirect = u.String()
+	}
+	ss, err := s.sessions.End(config.SessionId, config.UserId)
+	if err != nil {
+		return "", err
+	}
+	s.notifyLogout(ctx, config.SessionId, ss)
+	return redirect, nil
+}
+
+// notifyLogout sends logout tokens to the clients of the session [ss]. Failures are logged and ignored.
+func (s *Service) notifyLogout(ctx context.Context, sid string, ss session) {
+	var wg sync.WaitGroup
+	for _, clientId := range ss.clients {
+		client, err := s.client.GetServieClientById(ctx, clientId)
+		if err != nil {
+			slog.ErrorContext(ctx, fmt.Sprintf("cannot get service client[%s]: %v", clientId, err))
+			continue
+		}
+		if client.GetBackchannelLogoutUri() == "" {
+			continue
+		}
+		if client.GetSecret() == "" {
+			// the logout token would be signed with an empty key, which anyone can forge
+			slog.WarnContext(ctx, fmt.Sprintf("cannot notify logout to client[%s] without a client secret", client.GetId()))
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.sendLogoutToken(ctx, client, ss.userId, sid); err != nil {
+				slog.ErrorContext(ctx, fmt.Sprintf("cannot notify logout to client[%s]: %v", client.GetId(), err))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Service) sendLogoutToken(ctx context.Context, client *apiv1.ServiceClient, userId, sid string) error {
+	token, err := NewLogoutToken(client, userId, sid)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, logoutTimeout)
+	defer cancel()
+	body := url.Values{"logout_token": {token}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.GetBackchannelLogoutUri(), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpClient := s.logoutHTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("status code is %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// NewLogoutToken returns a logout token for [client], signed with the client secret.
+// [ErrNoClientSecret] is returned if [client] has no secret.
+func NewLogoutToken(client *apiv1.ServiceClient, userId, sid string) (string, error) {
+	if client.GetSecret() == "" {
+		return "", ErrNoClientSecret
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, LogoutClaims{
+		Sid:    sid,
+		Events: map[string]any{BackChannelLogoutEvent: map[string]any{}},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Subject:   userId,
+			Audience:  jwt.ClaimStrings{client.GetId()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(logoutTokenLifetime)),
+			ID:        uuid.NewString(),
+		},
+	})
+	token.Header["typ"] = "logout+jwt"
+	return token.SignedString([]byte(client.GetSecret()))
+}
+
+// ParseLogoutToken verifies a logout token sent to the client [clientId] (section 2.6).
+func ParseLogoutToken(logoutToken, clientId, clientSecret string) (*LogoutClaims, error) {
+	var claims LogoutClaims
+	_, err := jwt.ParseWithClaims(logoutToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(clientSecret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(clientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if _, found := claims.Events[BackChannelLogoutEvent]; !found {
+		return nil, errors.New("logout token has no back-channel logout event")
+	}
+	if claims.Subject == "" && claims.Sid == "" {
+		return nil, errors.New("logout token has neither sub nor sid")
+	}
+	return &claims, nil
+}
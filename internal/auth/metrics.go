@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics counts the authentications, and the codes and tokens by the client.
+// A nil *Metrics counts nothing.
+type Metrics struct {
+	authentications    *prometheus.CounterVec
+	authorizationCodes *prometheus.CounterVec
+	accessTokens       *prometheus.CounterVec
+	refreshTokens      *prometheus.CounterVec
+}
+
+// NewMetrics returns the metrics registered to [registerer]. A nil [registerer] keeps them unregistered.
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		authentications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_authentications_total",
+			Help: "User authentications by the result, 'success' or 'failure'.",
+		}, []string{"result"}),
+		authorizationCodes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_authorization_codes_total",
+			Help: "Authorization codes by the client and the event, 'issued' or 'redeemed'.",
+		}, []string{"client_id", "event"}),
+		accessTokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_access_tokens_issued_total",
+			Help: "Issued access tokens by the client and the grant type.",
+		}, []string{"client_id", "grant_type"}),
+		refreshTokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_refresh_tokens_total",
+			Help: "Refresh tokens by the client and the event, 'issued', 'refreshed' or 'revoked'.",
+		}, []string{"client_id", "event"}),
+	}
+	if registerer != nil {
+		registerer.MustRegister(m.authentications, m.authorizationCodes, m.accessTokens, m.refreshTokens)
+	}
+	return m
+}
+
+func (m *Metrics) authenticated(err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.authentications.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) codeIssued(clientId string) {
+	if m == nil {
+		return
+	}
+	m.authorizationCodes.WithLabelValues(clientId, "issued").Inc()
+}
+
+func (m *Metrics) codeRedeemed(clientId string) {
+	if m == nil {
+		return
+	}
+	m.authorizationCodes.WithLabelValues(clientId, "redeemed").Inc()
+}
+
+func (m *Metrics) tokenIssued(clientId, grantType string) {
+	if m == nil {
+		return
+	}
+	m.accessTokens.WithLabelValues(clientId, grantType).Inc()
+}
+
+// refreshTokenEvent counts an 'issued', 'refreshed' or 'revoked' refresh token.
+func (m *Metrics) refreshTokenEvent(clientId, event string) {
+	if m == nil {
+		return
+	}
+	m.refreshTokens.WithLabelValues(clientId, event).Inc()
+}
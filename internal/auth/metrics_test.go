@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/yyyoichi/OhAuth0.1/internal/database"
+)
+
+func TestMetrics(t *testing.T) {
+	db, _ := database.NewDatabase()
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry)
+	tservice := &Service{client: db, metrics: metrics}
+	ctx := context.Background()
+
+	_, err := tservice.Authentication(ctx, "1", "password")
+	assert.NoError(t, err)
+	_, err = tservice.Authentication(ctx, "1", "invalidpass")
+	assert.ErrorIs(t, err, ErrNoMatchPassword)
+	_, err = tservice.Authentication(ctx, "99", "password")
+	assert.ErrorIs(t, err, database.ErrNotFound)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.authentications.WithLabelValues("success")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.authentications.WithLabelValues("failure")))
+
+	code, err := tservice.NewAuthorizationCode(ctx, NewAuthorizationCodeConfig{UserId: "1", ServiceClientId: "500"})
+	assert.NoError(t, err)
+	_, refresh, err := tservice.NewAccessToken(ctx, NewAccessTokenConfig{Code: code.Code, ClientId: "500"})
+	assert.NoError(t, err)
+	// a used code is not counted again
+	_, _, err = tservice.NewAccessToken(ctx, NewAccessTokenConfig{Code: code.Code, ClientId: "500"})
+	assert.ErrorIs(t, err, ErrAuthorizationCodeUsed)
+	_, _, err = tservice.UpdateAccessToken(ctx, UpdateAccessTokenConfig{RefreshToken: refresh.Token, ClientId: "500"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.authorizationCodes.WithLabelValues("500", "issued")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.authorizationCodes.WithLabelValues("500", "redeemed")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.accessTokens.WithLabelValues("500", GrantTypeAuthorizationCode)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.accessTokens.WithLabelValues("500", GrantTypeRefreshToken)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.refreshTokens.WithLabelValues("500", "issued")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.refreshTokens.WithLabelValues("500", "refreshed")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.refreshTokens.WithLabelValues("500", "revoked")))
+
+	// a nil metrics counts nothing
+	assert.NotPanics(t, func() {
+		var m *Metrics
+		m.authenticated(nil)
+		m.tokenIssued("500", GrantTypeTokenExchange)
+	})
+}
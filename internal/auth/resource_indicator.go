@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"github.com/yyyoichi/OhAuth0.1/internal/scope"
+)
+
+// resolveResources returns the resource servers a grant of [requestedScope] is addressed to (RFC 8707).
+// Without [resources], every registered resource server which accepts some of [requestedScope] is the target.
+func (s *Service) resolveResources(ctx context.Context, resources []string, requestedScope string) ([]*apiv1.ResourceServer, error) {
+	if len(resources) == 0 {
+		all, err := s.client.ListResourceServers(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list resource servers: %w", err)
+		}
+		var targets []*apiv1.ResourceServer
+		for _, r := range all {
+			if scope.Intersect(requestedScope, scope.Join(r.GetScopes())) != "" {
+				targets = append(targets, r)
+			}
+		}
+		return targets, nil
+	}
+	var targets []*apiv1.ResourceServer
+	for _, uri := range resources {
+		if err := validResourceURI(uri); err != nil {
+			return nil, err
+		}
+		r, err := s.client.GetResourceServerByUri(ctx, uri)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return nil, fmt.Errorf("%w: resource '%s' is not registered", ErrInvalidTarget, uri)
+			}
+			return nil, fmt.Errorf("cannot get resource server: %w", err)
+		}
+		if scope.Intersect(requestedScope, scope.Join(r.GetScopes())) == "" {
+			return nil, fmt.Errorf("%w: resource '%s' accepts none of '%s'", ErrInvalidTarget, uri, requestedScope)
+		}
+		targets = append(targets, r)
+	}
+	return targets, nil
+}
+
+// narrowResources returns [requested] if it is a subset of the [granted] resources, or [granted] if nothing is requested.
+func narrowResources(requested, granted []string) ([]string, error) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+	for _, uri := range requested {
+		if !slices.Contains(granted, uri) {
+			return nil, fmt.Errorf("%w: resource '%s' is not granted", ErrInvalidTarget, uri)
+		}
+	}
+	return requested, nil
+}
+
+// audienceScope returns [granted] scope limited to what the resource servers [audience] accept.
+func (s *Service) audienceScope(ctx context.Context, audience []string, granted string) (string, error) {
+	targets, err := s.resolveResources(ctx, audience, granted)
+	if err != nil {
+		return "", err
+	}
+	var accepted []string
+	for _, r := range targets {
+		accepted = append(accepted, r.GetScopes()...)
+	}
+	return scope.Intersect(granted, scope.Join(accepted)), nil
+}
+
+// a resource indicator is an absolute URI without a fragment (RFC 8707 section 2).
+func validResourceURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return fmt.Errorf("%w: resource '%s' is not an absolute URI", ErrInvalidTarget, uri)
+	}
+	return nil
+}
+
+func resourceURIs(resourceServers []*apiv1.ResourceServer) []string {
+	uris := make([]string, 0, len(resourceServers))
+	for _, r := range resourceServers {
+		uris = append(uris, r.GetUri())
+	}
+	return uris
+}
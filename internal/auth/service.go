@@ -2,32 +2,86 @@ package auth
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
 	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/authzdetails"
 	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
+	"github.com/yyyoichi/OhAuth0.1/internal/scope"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type (
 	Service struct {
 		client clientInterface
+		// trusted CAs for 'tls_client_auth'
+		clientCAs *x509.CertPool
+		// token endpoint URL accepted as the audience of client assertions
+		tokenEndpoint string
+		jwks          jwksCache
+		assertions    replayCache
+		// which clients may exchange tokens for which audiences (RFC 8693)
+		exchangePolicy TokenExchangePolicy
+		// login sessions and the http client to notify their logout to clients
+		sessions         sessionStore
+		logoutHTTPClient *http.Client
+		metrics          *Metrics
+		tracerProvider   trace.TracerProvider
 	}
 	clientInterface interface {
 		GetUserById(ctx context.Context, id string) (*apiv1.UserProfile, error)
 		GetServieClientById(ctx context.Context, id string) (*apiv1.ServiceClient, error)
 		CreateAuthorizationCode(ctx context.Context, row *apiv1.AuthorizationCode) error
 		GetAuthorizationCodeByCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error)
+		ConsumeAuthorizationCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error)
+		GetAccessTokenByToken(ctx context.Context, token string) (*apiv1.AccessToken, error)
 		CreateAccessToken(ctx context.Context, row *apiv1.AccessToken) error
 		GetRefreshTokenByToken(ctx context.Context, token string) (*apiv1.RefreshToken, error)
+		RevokeRefreshToken(ctx context.Context, token string) (*apiv1.RefreshToken, error)
 		CreateRefreshToken(ctx context.Context, row *apiv1.RefreshToken) error
+		BatchCreate(ctx context.Context, b database.Batch) error
+		GetResourceServerByUri(ctx context.Context, uri string) (*apiv1.ResourceServer, error)
+		ListResourceServers(ctx context.Context) ([]*apiv1.ResourceServer, error)
+		Ping(ctx context.Context) error
 	}
 	Config struct {
 		DatabaseServerURL string
+		// in-memory database called in the process instead of the database server. Optional.
+		Database *database.Database
+		// TLS config and key to authenticate to the database server. Optional.
+		DatabaseTLS *tls.Config
+		DatabaseKey string
+		// connects to the database server instead of the network, e.g. the one in the process. Optional.
+		DatabaseDial func(ctx context.Context, network, addr string) (net.Conn, error)
+		// deadline, retries and circuit breaker of the calls to the database server. Optional.
+		DatabaseTimeout time.Duration
+		DatabaseRetry   database.RetryConfig
+		DatabaseBreaker database.BreakerConfig
+		// registers the metrics of the service and the calls to the database server. Optional.
+		Registerer prometheus.Registerer
+		// traces the requests and the calls to the database server. Optional.
+		TracerProvider trace.TracerProvider
+		// CAs which issue client certificates for 'tls_client_auth'. Optional.
+		ClientCAs *x509.CertPool
+		// CAs trusted to call the back-channel logout URIs of the clients. the system CAs if nil.
+		LogoutRootCAs *x509.CertPool
+		// public URL of the token endpoint, e.g. 'http://localhost:8080/api/v1/accesstoken'. Optional.
+		TokenEndpointURL string
+		// token exchange is denied for every client if empty.
+		TokenExchangePolicy TokenExchangePolicy
 	}
 	MyClaims struct {
 		ClientId string `json:"client_id"`
@@ -35,39 +89,94 @@ type (
 	}
 )
 
+// issuer identifier of this authorization server
+const Issuer = "OhAuth0.1"
+
 var (
 	ErrNoMatchPassword          = errors.New("no match password")
 	ErrAuthorizationCodeExpired = errors.New("authorization code is expired")
 	ErrRefreshTokenExpired      = errors.New("refresh token is expired")
+	ErrAccessTokenExpired       = errors.New("access token is expired")
+	ErrAuthorizationCodeUsed    = errors.New("authorization code is already used")
+	ErrRefreshTokenRevoked      = errors.New("refresh token is revoked")
+	ErrGrantIssuedToOtherClient = errors.New("grant is issued to another client")
 )
 
 func NewService(ctx context.Context, config Config) (*Service, error) {
-	client, err := database.NewDatabaseClient(ctx, database.ClientConfig{
-		URL: config.DatabaseServerURL,
-	})
-	if err != nil {
-		return nil, err
+	var client clientInterface
+	if config.Database != nil {
+		client = config.Database
+	} else {
+		var dbMetrics *interceptor.Metrics
+		if config.Registerer != nil {
+			dbMetrics = interceptor.NewMetrics(config.Registerer)
+		}
+		c, err := database.NewDatabaseClient(ctx, database.ClientConfig{
+			URL:     config.DatabaseServerURL,
+			TLS:     config.DatabaseTLS,
+			Key:     config.DatabaseKey,
+			Timeout: config.DatabaseTimeout,
+			Retry:   config.DatabaseRetry,
+			Breaker: config.DatabaseBreaker,
+			Metrics: dbMetrics,
+			Dial:    config.DatabaseDial,
+
+			TracerProvider: config.TracerProvider,
+		})
+		if err != nil {
+			return nil, err
+		}
+		client = c
 	}
-	return &Service{
-		client: client,
-	}, nil
+	service := &Service{
+		client:         client,
+		clientCAs:      config.ClientCAs,
+		tokenEndpoint:  config.TokenEndpointURL,
+		exchangePolicy: config.TokenExchangePolicy,
+		tracerProvider: config.TracerProvider,
+	}
+	if config.LogoutRootCAs != nil {
+		service.logoutHTTPClient = &http.Client{Transport: &http.Transport{
+			TLSClientConfig: pki.ClientTLSConfig(config.LogoutRootCAs),
+		}}
+	}
+	if config.Registerer != nil {
+		service.metrics = NewMetrics(config.Registerer)
+	}
+	return service, nil
+}
+
+// Ping reports whether the database server is reachable.
+func (s *Service) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx)
 }
 
 // UserIdと有効期限を詰めたClaimsを返す
 func (s *Service) Authentication(ctx context.Context, id, password string) (*MyClaims, error) {
 	u, err := s.client.GetUserById(ctx, id)
 	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			s.metrics.authenticated(err)
+		}
 		return nil, fmt.Errorf("cannot get user: %w", err)
 	}
 	if u.Password != password {
+		s.metrics.authenticated(ErrNoMatchPassword)
 		return nil, ErrNoMatchPassword
 	}
+	s.metrics.authenticated(nil)
 	tz, _ := time.LoadLocation("Asia/Tokyo")
+	now := time.Now().In(tz)
+	expires := now.Add(sessionLifetime)
 	return &MyClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    "OhAuth0.1",
+			Issuer:    Issuer,
 			Subject:   u.GetId(),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(10) * time.Minute).In(tz)),
+			ExpiresAt: jwt.NewNumericDate(expires),
+			// authentication time
+			IssuedAt: jwt.NewNumericDate(now),
+			// session id
+			ID: s.sessions.Start(u.GetId(), expires),
 		},
 	}, nil
 }
@@ -99,43 +208,121 @@ func (s *Service) ParseMyClaims(ctx context.Context, ss string, secret []byte) (
 
 type NewAuthorizationCodeConfig struct {
 	UserId, ServiceClientId string
-	// Scope string
+	// requested scope. must be registered on the client. defaults to 'profile:view'.
+	Scope string
+	// when and how the user authenticated. see [Service.CheckAuthentication].
+	AuthTime time.Time
+	Acr      string
+	// resource indicators (RFC 8707). defaults to every resource server which accepts the scope.
+	Resource []string
+	// validated 'authorization_details' (RFC 9396). Locations must be in the resources.
+	AuthorizationDetails []authzdetails.Detail
 }
 
 // 認可コードを発行する
 func (s *Service) NewAuthorizationCode(ctx context.Context, config NewAuthorizationCodeConfig) (*apiv1.AuthorizationCode, error) {
+	requestedScope := "profile:view"
+	if config.Scope != "" {
+		client, err := s.client.GetServieClientById(ctx, config.ServiceClientId)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get service client: %w", err)
+		}
+		if !scope.Subset(config.Scope, client.GetScope()) {
+			return nil, fmt.Errorf("%w: '%s' is not registered", ErrInvalidScope, config.Scope)
+		}
+		requestedScope = scope.Join(scope.Parse(config.Scope))
+	}
+	resources, err := s.resolveResources(ctx, config.Resource, requestedScope)
+	if err != nil {
+		return nil, err
+	}
+	resourceUris := resourceURIs(resources)
+	for _, d := range config.AuthorizationDetails {
+		for _, l := range d.Locations {
+			if !slices.Contains(resourceUris, l) {
+				return nil, fmt.Errorf("%w: location '%s' is not a requested resource", authzdetails.ErrInvalidDetails, l)
+			}
+		}
+	}
 	row := apiv1.AuthorizationCode{
 		UserId:          config.UserId,
 		ServiceClientId: config.ServiceClientId,
 		Expires:         timestamppb.New(time.Now().Add(time.Duration(10) * time.Minute)),
-		Scope:           "profile:view",
+		Scope:           requestedScope,
 		Code:            uuid.NewString(),
+		Resource:        resourceUris,
+
+		AuthorizationDetails: authzdetails.ToProto(config.AuthorizationDetails),
+		Acr:                  config.Acr,
+	}
+	if !config.AuthTime.IsZero() {
+		row.AuthTime = timestamppb.New(config.AuthTime)
 	}
 	if err := s.client.CreateAuthorizationCode(ctx, &row); err != nil {
 		return nil, err
 	}
+	s.metrics.codeIssued(row.ServiceClientId)
 	return &row, nil
 }
 
+type NewAccessTokenConfig struct {
+	Code string
+	// the authenticated client, which the code must be issued to.
+	ClientId string
+	// resource indicators (RFC 8707) the access token is addressed to.
+	// must be granted by the authorization code. defaults to all of them.
+	Resource []string
+	// x5t#S256 of the client certificate to bind the access token to. Optional.
+	CertificateThumbprint string
+}
+
 // 認可コード[code]を検証しアクセストークンを発行する
-func (s *Service) NewAccessToken(ctx context.Context, code string) (
+func (s *Service) NewAccessToken(ctx context.Context, config NewAccessTokenConfig) (
 	*apiv1.AccessToken,
 	*apiv1.RefreshToken,
 	error,
 ) {
-	authorization, err := s.client.GetAuthorizationCodeByCode(ctx, code)
+	authorization, err := s.client.GetAuthorizationCodeByCode(ctx, config.Code)
 	if err != nil {
 		return nil, nil, err
 	}
+	// the code is left unused for its client
+	if authorization.ServiceClientId != config.ClientId {
+		return nil, nil, fmt.Errorf("%w: code of client[%s]", ErrGrantIssuedToOtherClient, authorization.ServiceClientId)
+	}
 	if time.Now().After(authorization.Expires.AsTime()) {
 		return nil, nil, ErrAuthorizationCodeExpired
 	}
+	if authorization.GetConsumedAt() != nil {
+		return nil, nil, ErrAuthorizationCodeUsed
+	}
+	audience, err := narrowResources(config.Resource, authorization.Resource)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokenScope, err := s.audienceScope(ctx, audience, authorization.Scope)
+	if err != nil {
+		return nil, nil, err
+	}
 	token := apiv1.AccessToken{
 		Token:           uuid.NewString(),
 		UserId:          authorization.UserId,
 		ServiceClientId: authorization.ServiceClientId,
-		Scope:           authorization.Scope,
+		Scope:           tokenScope,
 		Expires:         timestamppb.New(time.Now().AddDate(0, 0, 3)),
+		Audience:        audience,
+
+		CertificateThumbprint: config.CertificateThumbprint,
+		AuthorizationDetails:  detailsForAudience(authorization.AuthorizationDetails, audience),
+		AuthTime:              authorization.AuthTime,
+		Acr:                   authorization.Acr,
+	}
+	// a code is used only once, even by concurrent requests
+	if _, err := s.client.ConsumeAuthorizationCode(ctx, config.Code); err != nil {
+		if errors.Is(err, database.ErrConflict) {
+			return nil, nil, fmt.Errorf("%w: %w", ErrAuthorizationCodeUsed, err)
+		}
+		return nil, nil, err
 	}
 	refresh := apiv1.RefreshToken{
 		Token:           uuid.NewString(),
@@ -143,50 +330,110 @@ func (s *Service) NewAccessToken(ctx context.Context, code string) (
 		ServiceClientId: authorization.ServiceClientId,
 		Scope:           authorization.Scope,
 		Expires:         timestamppb.New(time.Now().AddDate(0, 1, 0)),
+		Resource:        authorization.Resource,
+
+		AuthorizationDetails: authorization.AuthorizationDetails,
+		AuthTime:             authorization.AuthTime,
+		Acr:                  authorization.Acr,
 	}
-	if err := s.client.CreateAccessToken(ctx, &token); err != nil {
-		return nil, nil, err
-	}
-	if err := s.client.CreateRefreshToken(ctx, &refresh); err != nil {
+	if err := s.client.BatchCreate(ctx, database.Batch{
+		AccessTokens:  []*apiv1.AccessToken{&token},
+		RefreshTokens: []*apiv1.RefreshToken{&refresh},
+	}); err != nil {
 		return nil, nil, err
 	}
+	s.metrics.codeRedeemed(token.ServiceClientId)
+	s.metrics.tokenIssued(token.ServiceClientId, GrantTypeAuthorizationCode)
+	s.metrics.refreshTokenEvent(refresh.ServiceClientId, "issued")
 
 	return &token, &refresh, nil
 }
 
+type UpdateAccessTokenConfig struct {
+	RefreshToken string
+	// the authenticated client, which the refresh token must be issued to.
+	ClientId string
+	// resource indicators (RFC 8707) the access token is addressed to.
+	// must be granted by the refresh token. defaults to all of them.
+	Resource []string
+	// x5t#S256 of the client certificate to bind the access token to. Optional.
+	CertificateThumbprint string
+}
+
 // [refreshToken]から新しくアクセストークンを発行する
-func (s *Service) UpdateAccessToken(ctx context.Context, refreshToken string) (
+func (s *Service) UpdateAccessToken(ctx context.Context, config UpdateAccessTokenConfig) (
 	*apiv1.AccessToken,
 	*apiv1.RefreshToken,
 	error,
 ) {
-	refresh, err := s.client.GetRefreshTokenByToken(ctx, refreshToken)
+	refresh, err := s.client.GetRefreshTokenByToken(ctx, config.RefreshToken)
 	if err != nil {
 		return nil, nil, err
 	}
+	// the refresh token is not rotated for the other client
+	if refresh.ServiceClientId != config.ClientId {
+		return nil, nil, fmt.Errorf("%w: refresh token of client[%s]", ErrGrantIssuedToOtherClient, refresh.ServiceClientId)
+	}
 	if time.Now().After(refresh.Expires.AsTime()) {
 		return nil, nil, ErrRefreshTokenExpired
 	}
+	if refresh.GetRevokedAt() != nil {
+		return nil, nil, ErrRefreshTokenRevoked
+	}
+	audience, err := narrowResources(config.Resource, refresh.Resource)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokenScope, err := s.audienceScope(ctx, audience, refresh.Scope)
+	if err != nil {
+		return nil, nil, err
+	}
 	updateToken := apiv1.AccessToken{
 		Token:           uuid.NewString(),
 		UserId:          refresh.UserId,
 		ServiceClientId: refresh.ServiceClientId,
-		Scope:           refresh.Scope,
+		Scope:           tokenScope,
 		Expires:         timestamppb.New(time.Now().AddDate(0, 0, 3)),
+		Audience:        audience,
+
+		CertificateThumbprint: config.CertificateThumbprint,
+		AuthorizationDetails:  detailsForAudience(refresh.AuthorizationDetails, audience),
+		AuthTime:              refresh.AuthTime,
+		Acr:                   refresh.Acr,
 	}
+	// rotation: the presented refresh token is revoked, even by concurrent requests
+	if _, err := s.client.RevokeRefreshToken(ctx, config.RefreshToken); err != nil {
+		if errors.Is(err, database.ErrConflict) {
+			return nil, nil, fmt.Errorf("%w: %w", ErrRefreshTokenRevoked, err)
+		}
+		return nil, nil, err
+	}
+	s.metrics.refreshTokenEvent(refresh.ServiceClientId, "revoked")
 	updateRefresh := apiv1.RefreshToken{
 		Token:           uuid.NewString(),
 		UserId:          refresh.UserId,
 		ServiceClientId: refresh.ServiceClientId,
 		Scope:           refresh.Scope,
 		Expires:         timestamppb.New(time.Now().AddDate(0, 1, 0)),
+		Resource:        refresh.Resource,
+
+		AuthorizationDetails: refresh.AuthorizationDetails,
+		AuthTime:             refresh.AuthTime,
+		Acr:                  refresh.Acr,
 	}
-	if err := s.client.CreateAccessToken(ctx, &updateToken); err != nil {
-		return nil, nil, err
-	}
-	if err := s.client.CreateRefreshToken(ctx, &updateRefresh); err != nil {
+	if err := s.client.BatchCreate(ctx, database.Batch{
+		AccessTokens:  []*apiv1.AccessToken{&updateToken},
+		RefreshTokens: []*apiv1.RefreshToken{&updateRefresh},
+	}); err != nil {
 		return nil, nil, err
 	}
+	s.metrics.tokenIssued(updateToken.ServiceClientId, GrantTypeRefreshToken)
+	s.metrics.refreshTokenEvent(updateRefresh.ServiceClientId, "refreshed")
 
 	return &updateToken, &updateRefresh, nil
 }
+
+// the granted [details] which are usable at [audience]
+func detailsForAudience(details []*apiv1.AuthorizationDetail, audience []string) []*apiv1.AuthorizationDetail {
+	return authzdetails.ToProto(authzdetails.ForAudience(authzdetails.FromProto(details), audience))
+}
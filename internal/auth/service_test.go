@@ -2,13 +2,26 @@ package auth
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/acr"
+	"github.com/yyyoichi/OhAuth0.1/internal/authzdetails"
 	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -117,10 +130,185 @@ func TestService(t *testing.T) {
 			assert.NotEmpty(t, refresh.Token)
 			assert.False(t, refresh.Expires.AsTime().IsZero())
 		}
-		token, refresh, err := tservice.NewAccessToken(ctx, code.Code)
+		// the code of another client is not redeemed, nor used up
+		_, _, err = tservice.NewAccessToken(ctx, NewAccessTokenConfig{Code: code.Code, ClientId: "OTHER_CLIENT"})
+		assert.ErrorIs(t, err, ErrGrantIssuedToOtherClient)
+		token, refresh, err := tservice.NewAccessToken(ctx, NewAccessTokenConfig{Code: code.Code, ClientId: CLIENT_ID})
 		testTokens(token, refresh)
-		token, refresh, err = tservice.UpdateAccessToken(ctx, refresh.Token)
+		// a code is used only once
+		_, _, err = tservice.NewAccessToken(ctx, NewAccessTokenConfig{Code: code.Code, ClientId: CLIENT_ID})
+		assert.ErrorIs(t, err, ErrAuthorizationCodeUsed)
+		// so is the refresh token of another client
+		_, _, err = tservice.UpdateAccessToken(ctx, UpdateAccessTokenConfig{RefreshToken: refresh.Token, ClientId: "OTHER_CLIENT"})
+		assert.ErrorIs(t, err, ErrGrantIssuedToOtherClient)
+		rotated := refresh.Token
+		token, refresh, err = tservice.UpdateAccessToken(ctx, UpdateAccessTokenConfig{RefreshToken: refresh.Token, ClientId: CLIENT_ID})
 		testTokens(token, refresh)
+		// the rotated refresh token is revoked
+		_, _, err = tservice.UpdateAccessToken(ctx, UpdateAccessTokenConfig{RefreshToken: rotated, ClientId: CLIENT_ID})
+		assert.ErrorIs(t, err, ErrRefreshTokenRevoked)
+	})
+	t.Run("resource indicators", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		db, _ := database.NewDatabase()
+		tservice := &Service{client: db}
+		assert.NoError(t, db.CreateResourceServer(ctx, &apiv1.ResourceServer{
+			Uri:    "https://calendar.example",
+			Scopes: []string{"calendar:view"},
+		}))
+		assert.NoError(t, db.CreateResourceServer(ctx, &apiv1.ResourceServer{
+			Uri:    "https://photo.example",
+			Scopes: []string{"profile:view", "photo:view"},
+		}))
+
+		// defaults to every resource server which accepts the scope
+		code, err := tservice.NewAuthorizationCode(ctx, NewAuthorizationCodeConfig{UserId: "1", ServiceClientId: "500"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{database.RESOURCE_URI, "https://photo.example"}, code.Resource)
+		token, refresh, err := tservice.NewAccessToken(ctx, NewAccessTokenConfig{Code: code.Code, ClientId: "500"})
+		assert.NoError(t, err)
+		assert.Equal(t, code.Resource, token.Audience)
+		assert.Equal(t, code.Resource, refresh.Resource)
+		// narrowed on refresh
+		token, refresh, err = tservice.UpdateAccessToken(ctx, UpdateAccessTokenConfig{
+			RefreshToken: refresh.Token,
+			ClientId:     "500",
+			Resource:     []string{"https://photo.example"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"https://photo.example"}, token.Audience)
+		assert.Equal(t, "profile:view", token.Scope)
+		_, _, err = tservice.UpdateAccessToken(ctx, UpdateAccessTokenConfig{
+			RefreshToken: refresh.Token,
+			ClientId:     "500",
+			Resource:     []string{"https://calendar.example"},
+		})
+		assert.ErrorIs(t, err, ErrInvalidTarget)
+
+		code, err = tservice.NewAuthorizationCode(ctx, NewAuthorizationCodeConfig{
+			UserId:          "1",
+			ServiceClientId: "500",
+			Resource:        []string{database.RESOURCE_URI},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{database.RESOURCE_URI}, code.Resource)
+		_, _, err = tservice.NewAccessToken(ctx, NewAccessTokenConfig{
+			Code:     code.Code,
+			ClientId: "500",
+			Resource: []string{"https://photo.example"},
+		})
+		assert.ErrorIs(t, err, ErrInvalidTarget)
+
+		for scenario, resource := range map[string]string{
+			"not registered":    "https://unknown.example",
+			"relative":          "/api/v1",
+			"fragment":          database.RESOURCE_URI + "#profile",
+			"no accepted scope": "https://calendar.example",
+		} {
+			_, err := tservice.NewAuthorizationCode(ctx, NewAuthorizationCodeConfig{
+				UserId:          "1",
+				ServiceClientId: "500",
+				Resource:        []string{resource},
+			})
+			assert.ErrorIsf(t, err, ErrInvalidTarget, scenario)
+		}
+	})
+
+	t.Run("authorization details", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		db, _ := database.NewDatabase()
+		tservice := &Service{client: db}
+		assert.NoError(t, db.CreateResourceServer(ctx, &apiv1.ResourceServer{
+			Uri:    "https://photo.example",
+			Scopes: []string{"profile:view"},
+		}))
+		details := []authzdetails.Detail{
+			{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionView}, Datatypes: []string{authzdetails.DatatypeName}},
+			{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionView}, Locations: []string{"https://photo.example"}},
+		}
+		code, err := tservice.NewAuthorizationCode(ctx, NewAuthorizationCodeConfig{
+			UserId:               "1",
+			ServiceClientId:      "500",
+			AuthorizationDetails: details,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, details, authzdetails.FromProto(code.AuthorizationDetails))
+
+		token, refresh, err := tservice.NewAccessToken(ctx, NewAccessTokenConfig{Code: code.Code, ClientId: "500"})
+		assert.NoError(t, err)
+		assert.Equal(t, details, authzdetails.FromProto(token.AuthorizationDetails))
+		assert.Equal(t, details, authzdetails.FromProto(refresh.AuthorizationDetails))
+		// only details usable at the audience
+		token, _, err = tservice.UpdateAccessToken(ctx, UpdateAccessTokenConfig{
+			RefreshToken: refresh.Token,
+			ClientId:     "500",
+			Resource:     []string{database.RESOURCE_URI},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, details[:1], authzdetails.FromProto(token.AuthorizationDetails))
+
+		_, err = tservice.NewAuthorizationCode(ctx, NewAuthorizationCodeConfig{
+			UserId:          "1",
+			ServiceClientId: "500",
+			Resource:        []string{database.RESOURCE_URI},
+			AuthorizationDetails: []authzdetails.Detail{
+				{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionView}, Locations: []string{"https://photo.example"}},
+			},
+		})
+		assert.ErrorIs(t, err, authzdetails.ErrInvalidDetails)
+	})
+
+	t.Run("CheckAuthentication", func(t *testing.T) {
+		t.Parallel()
+		tservice := newLocalService()
+		// a new login session of client 500, which [joined] before
+		loggedIn := func(ago time.Duration, joined bool) *MyClaims {
+			sid := tservice.sessions.Start("1", time.Now().Add(time.Minute))
+			if joined {
+				assert.NoError(t, tservice.sessions.Join(sid, "500", joinAny))
+			}
+			return &MyClaims{ClientId: "500", RegisteredClaims: jwt.RegisteredClaims{ID: sid, IssuedAt: jwt.NewNumericDate(time.Now().Add(-ago))}}
+		}
+		maxAge := func(sec int64) *int64 { return &sec }
+		test := map[string]struct {
+			claims *MyClaims
+			req    AuthenticationRequirements
+			expAcr string
+			expErr error
+		}{
+			"no requirements":           {loggedIn(time.Hour, false), AuthenticationRequirements{}, acr.Password, nil},
+			"joined before":             {loggedIn(time.Hour, true), AuthenticationRequirements{}, acr.Password, nil},
+			"max_age":                   {loggedIn(time.Minute, false), AuthenticationRequirements{MaxAge: maxAge(120)}, acr.Password, nil},
+			"max_age exceeded":          {loggedIn(3*time.Minute, false), AuthenticationRequirements{MaxAge: maxAge(120)}, "", ErrLoginRequired},
+			"max_age=0":                 {loggedIn(time.Second, false), AuthenticationRequirements{MaxAge: maxAge(0)}, "", ErrLoginRequired},
+			"negative max_age":          {loggedIn(time.Second, false), AuthenticationRequirements{MaxAge: maxAge(-1)}, "", ErrInvalidMaxAge},
+			"prompt=login":              {loggedIn(time.Hour, false), AuthenticationRequirements{Prompt: "login consent"}, acr.Password, nil},
+			"prompt=login used session": {loggedIn(time.Second, true), AuthenticationRequirements{Prompt: "login"}, "", ErrLoginRequired},
+			"prompt=none":               {loggedIn(time.Hour, true), AuthenticationRequirements{Prompt: "none"}, acr.Password, nil},
+			"prompt=none not consented": {loggedIn(time.Second, false), AuthenticationRequirements{Prompt: "none"}, "", ErrConsentRequired},
+			"prompt=none old login":     {loggedIn(time.Hour, true), AuthenticationRequirements{Prompt: "none", MaxAge: maxAge(60)}, "", ErrLoginRequired},
+			"prompt=none ended session": {&MyClaims{ClientId: "500", RegisteredClaims: jwt.RegisteredClaims{ID: "ended", IssuedAt: jwt.NewNumericDate(time.Now())}}, AuthenticationRequirements{Prompt: "none"}, "", ErrLoginRequired},
+			"prompt=none with login":    {loggedIn(time.Minute, false), AuthenticationRequirements{Prompt: "none login"}, "", ErrInvalidPrompt},
+			"prompt=consent":            {loggedIn(time.Minute, true), AuthenticationRequirements{Prompt: "consent"}, acr.Password, nil},
+			"unknown prompt":            {loggedIn(time.Minute, false), AuthenticationRequirements{Prompt: "select_account"}, "", ErrInvalidPrompt},
+			"acr_values":                {loggedIn(time.Minute, false), AuthenticationRequirements{AcrValues: "urn:mfa " + acr.Password}, acr.Password, nil},
+			"unsupported acr_values":    {loggedIn(time.Minute, false), AuthenticationRequirements{AcrValues: "urn:mfa"}, "", ErrUnmetAuthenticationRequirements},
+			"unknown authentication at": {&MyClaims{}, AuthenticationRequirements{}, "", ErrLoginRequired},
+		}
+		for scenario, tt := range test {
+			t.Run(scenario, func(t *testing.T) {
+				authTime, acr, err := tservice.CheckAuthentication(tt.claims, tt.req)
+				if tt.expErr != nil {
+					assert.ErrorIs(t, err, tt.expErr)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expAcr, acr)
+				assert.WithinDuration(t, tt.claims.IssuedAt.Time, authTime, 0)
+			})
+		}
 	})
 
 	t.Run("expired", func(t *testing.T) {
@@ -134,7 +322,7 @@ func TestService(t *testing.T) {
 		})
 		assert.NoError(t, err)
 
-		_, _, err = tservice.NewAccessToken(ctx, "example")
+		_, _, err = tservice.NewAccessToken(ctx, NewAccessTokenConfig{Code: "example"})
 		assert.ErrorIs(t, ErrAuthorizationCodeExpired, err)
 
 		err = tservice.client.CreateRefreshToken(ctx, &apiv1.RefreshToken{
@@ -142,8 +330,342 @@ func TestService(t *testing.T) {
 			Expires: timestamppb.New(time.Now().Add(time.Duration(-1) * time.Minute)),
 		})
 		assert.NoError(t, err)
-		_, _, err = tservice.UpdateAccessToken(ctx, "example")
+		_, _, err = tservice.UpdateAccessToken(ctx, UpdateAccessTokenConfig{RefreshToken: "example"})
 		assert.ErrorIs(t, ErrRefreshTokenExpired, err)
 
 	})
+	t.Run("AuthenticateClient", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		ca, err := pki.NewCA("client ca")
+		assert.NoError(t, err)
+		otherCA, err := pki.NewCA("other ca")
+		assert.NoError(t, err)
+		issued, err := ca.IssueClientCertificate("mtls-client")
+		assert.NoError(t, err)
+		untrusted, err := otherCA.IssueClientCertificate("mtls-client")
+		assert.NoError(t, err)
+		selfSigned, err := pki.NewSelfSignedCertificate("self-signed-client")
+		assert.NoError(t, err)
+		otherSelfSigned, err := pki.NewSelfSignedCertificate("self-signed-client")
+		assert.NoError(t, err)
+
+		db, _ := database.NewDatabase()
+		tservice := &Service{
+			client:    db,
+			clientCAs: ca.CertPool(),
+		}
+		assert.NoError(t, db.CreateServiceClient(ctx, &apiv1.ServiceClient{
+			Id:                       "tls",
+			TokenEndpointAuthMethods: []string{ClientAuthMethodTLS},
+			TlsClientAuthSubjectDn:   issued.Leaf.Subject.String(),
+		}))
+		assert.NoError(t, db.CreateServiceClient(ctx, &apiv1.ServiceClient{
+			Id:                             "self-signed",
+			TokenEndpointAuthMethods:       []string{ClientAuthMethodSelfSignedTLS},
+			TlsClientCertificateThumbprint: pki.Thumbprint(selfSigned.Leaf),
+		}))
+		connection := func(cert tls.Certificate) *tls.ConnectionState {
+			return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}}
+		}
+		test := map[string]struct {
+			credentials ClientCredentials
+			expErr      error
+		}{
+			"secret":                     {ClientCredentials{ClientId: "500", ClientSecret: "secret"}, nil},
+			"invalid secret":             {ClientCredentials{ClientId: "500", ClientSecret: "invalid"}, ErrNoMatchClientSecret},
+			"empty secret":               {ClientCredentials{ClientId: "500"}, ErrNoMatchClientSecret},
+			"unknown client":             {ClientCredentials{ClientId: "999", ClientSecret: "secret"}, database.ErrNotFound},
+			"tls":                        {ClientCredentials{ClientId: "tls", TLS: connection(issued)}, nil},
+			"tls no certificate":         {ClientCredentials{ClientId: "tls"}, ErrClientCertificateRequired},
+			"tls with secret":            {ClientCredentials{ClientId: "tls", ClientSecret: "secret"}, ErrClientAuthMethodNotAllowed},
+			"tls untrusted":              {ClientCredentials{ClientId: "tls", TLS: connection(untrusted)}, ErrInvalidClientCertificate},
+			"tls self signed":            {ClientCredentials{ClientId: "tls", TLS: connection(selfSigned)}, ErrInvalidClientCertificate},
+			"self signed":                {ClientCredentials{ClientId: "self-signed", TLS: connection(selfSigned)}, nil},
+			"self signed other":          {ClientCredentials{ClientId: "self-signed", TLS: connection(otherSelfSigned)}, ErrInvalidClientCertificate},
+			"self signed no certificate": {ClientCredentials{ClientId: "self-signed"}, ErrClientCertificateRequired},
+		}
+		for scenario, tt := range test {
+			t.Run(scenario, func(t *testing.T) {
+				client, err := tservice.AuthenticateClient(ctx, tt.credentials)
+				if tt.expErr == nil {
+					assert.NoError(t, err)
+					assert.Equal(t, tt.credentials.ClientId, client.Id)
+				} else {
+					assert.ErrorIs(t, err, ErrInvalidClient)
+					assert.ErrorIs(t, err, tt.expErr)
+				}
+			})
+		}
+		t.Run("tls without client CA", func(t *testing.T) {
+			// 'auth.tls.client_ca' is not set
+			_, err := (&Service{client: db}).AuthenticateClient(ctx, ClientCredentials{ClientId: "tls", TLS: connection(issued)})
+			assert.ErrorIs(t, err, ErrInvalidClient)
+			assert.ErrorIs(t, err, ErrInvalidClientCertificate)
+			assert.ErrorContains(t, err, "no client CA is configured")
+		})
+	})
+	t.Run("AuthenticateClient private_key_jwt", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		jwks := func(keys ...JSONWebKey) string {
+			b, err := json.Marshal(JSONWebKeySet{Keys: keys})
+			assert.NoError(t, err)
+			return string(b)
+		}
+		rsaJWK, err := NewJSONWebKey(&rsaKey.PublicKey, "rsa")
+		assert.NoError(t, err)
+		ecJWK, err := NewJSONWebKey(&ecKey.PublicKey, "ec")
+		assert.NoError(t, err)
+		jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(jwks(ecJWK)))
+		}))
+		defer jwksServer.Close()
+
+		db, _ := database.NewDatabase()
+		tservice := &Service{
+			client:        db,
+			tokenEndpoint: "http://localhost:8080/api/v1/accesstoken",
+		}
+		assert.NoError(t, db.CreateServiceClient(ctx, &apiv1.ServiceClient{
+			Id:                       "inline",
+			TokenEndpointAuthMethods: []string{ClientAuthMethodPrivateKeyJWT},
+			Jwks:                     jwks(rsaJWK, ecJWK),
+		}))
+		assert.NoError(t, db.CreateServiceClient(ctx, &apiv1.ServiceClient{
+			Id:                       "uri",
+			TokenEndpointAuthMethods: []string{ClientAuthMethodPrivateKeyJWT, ClientAuthMethodSecretPost},
+			Secret:                   "secret",
+			JwksUri:                  jwksServer.URL,
+		}))
+
+		type claimsFunc func(c *jwt.RegisteredClaims)
+		assertion := func(method jwt.SigningMethod, key any, kid, clientId string, fn claimsFunc) string {
+			claims := jwt.RegisteredClaims{
+				Issuer:    clientId,
+				Subject:   clientId,
+				Audience:  jwt.ClaimStrings{Issuer},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+				ID:        uuid.NewString(),
+			}
+			if fn != nil {
+				fn(&claims)
+			}
+			token := jwt.NewWithClaims(method, claims)
+			token.Header["kid"] = kid
+			ss, err := token.SignedString(key)
+			assert.NoError(t, err)
+			return ss
+		}
+		credentials := func(clientId, assertion string) ClientCredentials {
+			return ClientCredentials{
+				ClientId:            clientId,
+				ClientAssertionType: ClientAssertionTypeJWTBearer,
+				ClientAssertion:     assertion,
+			}
+		}
+		replayed := assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", nil)
+		_, err = tservice.AuthenticateClient(ctx, credentials("inline", replayed))
+		assert.NoError(t, err)
+
+		test := map[string]struct {
+			credentials ClientCredentials
+			expErr      error
+		}{
+			"RS256":    {credentials("inline", assertion(jwt.SigningMethodRS256, rsaKey, "rsa", "inline", nil)), nil},
+			"ES256":    {credentials("inline", assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", nil)), nil},
+			"jwks_uri": {credentials("uri", assertion(jwt.SigningMethodES256, ecKey, "ec", "uri", nil)), nil},
+			"token endpoint audience": {credentials("inline", assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", func(c *jwt.RegisteredClaims) {
+				c.Audience = jwt.ClaimStrings{"http://localhost:8080/api/v1/accesstoken"}
+			})), nil},
+			"omitted client_id": {credentials("", assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", nil)), nil},
+			"secret on uri":     {ClientCredentials{ClientId: "uri", ClientSecret: "secret"}, nil},
+			"secret on inline":  {ClientCredentials{ClientId: "inline", ClientSecret: "secret"}, ErrClientAuthMethodNotAllowed},
+			"not allowed":       {credentials("500", assertion(jwt.SigningMethodES256, ecKey, "ec", "500", nil)), ErrClientAuthMethodNotAllowed},
+			"replayed":          {credentials("inline", replayed), ErrClientAssertionReplayed},
+			"other key":         {credentials("inline", assertion(jwt.SigningMethodES256, otherKey, "ec", "inline", nil)), ErrInvalidClientAssertion},
+			"unknown kid":       {credentials("inline", assertion(jwt.SigningMethodES256, ecKey, "unknown", "inline", nil)), ErrInvalidClientAssertion},
+			"HS256":             {credentials("inline", assertion(jwt.SigningMethodHS256, []byte("secret"), "ec", "inline", nil)), ErrInvalidClientAssertion},
+			"other audience": {credentials("inline", assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", func(c *jwt.RegisteredClaims) {
+				c.Audience = jwt.ClaimStrings{"https://example.com"}
+			})), ErrInvalidClientAssertion},
+			"other issuer": {credentials("inline", assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", func(c *jwt.RegisteredClaims) {
+				c.Issuer = "uri"
+			})), ErrInvalidClientAssertion},
+			"expired": {credentials("inline", assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", func(c *jwt.RegisteredClaims) {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			})), ErrInvalidClientAssertion},
+			"no exp": {credentials("inline", assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", func(c *jwt.RegisteredClaims) {
+				c.ExpiresAt = nil
+			})), ErrInvalidClientAssertion},
+			"long lifetime": {credentials("inline", assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", func(c *jwt.RegisteredClaims) {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+			})), ErrInvalidClientAssertion},
+			"no jti": {credentials("inline", assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", func(c *jwt.RegisteredClaims) {
+				c.ID = ""
+			})), ErrInvalidClientAssertion},
+			"unknown assertion type": {ClientCredentials{
+				ClientId:            "inline",
+				ClientAssertionType: "urn:example",
+				ClientAssertion:     assertion(jwt.SigningMethodES256, ecKey, "ec", "inline", nil),
+			}, ErrUnsupportedClientAuthMethod},
+		}
+		for scenario, tt := range test {
+			t.Run(scenario, func(t *testing.T) {
+				_, err := tservice.AuthenticateClient(ctx, tt.credentials)
+				if tt.expErr == nil {
+					assert.NoError(t, err)
+				} else {
+					assert.ErrorIs(t, err, ErrInvalidClient)
+					assert.ErrorIs(t, err, tt.expErr)
+				}
+			})
+		}
+	})
+	t.Run("replayCache", func(t *testing.T) {
+		t.Parallel()
+		var c replayCache
+		now := time.Now()
+		assert.True(t, c.Use("a", now.Add(-time.Second)))
+		assert.True(t, c.Use("b", now.Add(time.Hour)))
+		assert.False(t, c.Use("b", now.Add(time.Hour)))
+		// the expired values are pruned and usable again
+		assert.True(t, c.Use("a", now.Add(time.Hour)))
+		assert.False(t, c.Use("a", now.Add(time.Hour)))
+		assert.Len(t, c.used, 2)
+		assert.Equal(t, 2, c.expiries.Len())
+	})
+	t.Run("ExchangeToken", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		db, _ := database.NewDatabase()
+		tservice := &Service{
+			client: db,
+			exchangePolicy: TokenExchangePolicy{
+				{ClientId: "500", Audiences: []string{"https://downstream.example"}, Scopes: []string{"profile:view"}},
+			},
+		}
+		assert.NoError(t, db.CreateResourceServer(ctx, &apiv1.ResourceServer{
+			Uri:    "https://downstream.example",
+			Scopes: []string{"profile:view"},
+		}))
+		expires := time.Now().Add(time.Duration(30) * time.Minute)
+		assert.NoError(t, db.CreateAccessToken(ctx, &apiv1.AccessToken{
+			Token:           "subject",
+			UserId:          "1",
+			ServiceClientId: "501",
+			Scope:           "profile:view profile:edit",
+			Expires:         timestamppb.New(expires),
+		}))
+		assert.NoError(t, db.CreateAccessToken(ctx, &apiv1.AccessToken{
+			Token:           "expired-subject",
+			UserId:          "1",
+			ServiceClientId: "501",
+			Scope:           "profile:view",
+			Expires:         timestamppb.New(time.Now().Add(-time.Minute)),
+		}))
+		assert.NoError(t, db.CreateAccessToken(ctx, &apiv1.AccessToken{
+			Token:           "actor",
+			UserId:          "service-account",
+			ServiceClientId: "500",
+			Scope:           "",
+			Expires:         timestamppb.New(expires),
+		}))
+		assert.NoError(t, db.CreateAccessToken(ctx, &apiv1.AccessToken{
+			Token:           "other-actor",
+			UserId:          "service-account",
+			ServiceClientId: "501",
+			Expires:         timestamppb.New(expires),
+		}))
+		config := func(fn func(c *TokenExchangeConfig)) TokenExchangeConfig {
+			c := TokenExchangeConfig{
+				ClientId:         "500",
+				SubjectToken:     "subject",
+				SubjectTokenType: TokenTypeAccessToken,
+				Audience:         []string{"https://downstream.example"},
+				Scope:            "profile:view",
+			}
+			if fn != nil {
+				fn(&c)
+			}
+			return c
+		}
+
+		token, err := tservice.ExchangeToken(ctx, config(nil))
+		assert.NoError(t, err)
+		assert.Equal(t, "1", token.UserId)
+		assert.Equal(t, "500", token.ServiceClientId)
+		assert.Equal(t, "profile:view", token.Scope)
+		assert.Equal(t, []string{"https://downstream.example"}, token.Audience)
+		assert.Equal(t, "500", token.Actor.ClientId)
+		assert.False(t, token.Expires.AsTime().After(expires))
+		stored, err := db.GetAccessTokenByToken(ctx, token.Token)
+		assert.NoError(t, err)
+		assert.EqualExportedValues(t, token, stored)
+
+		// exchange again: the delegation chain is recorded
+		assert.NoError(t, tservice.exchangePolicy.Allow("500", "https://downstream.example", "profile:view"))
+		chained, err := tservice.ExchangeToken(ctx, config(func(c *TokenExchangeConfig) {
+			c.SubjectToken = token.Token
+			c.ActorToken = "actor"
+			c.ActorTokenType = TokenTypeAccessToken
+		}))
+		assert.NoError(t, err)
+		assert.Equal(t, "service-account", chained.Actor.Subject)
+		assert.Equal(t, "500", chained.Actor.ClientId)
+		assert.Equal(t, "500", chained.Actor.Actor.ClientId)
+
+		test := map[string]struct {
+			config TokenExchangeConfig
+			expErr error
+		}{
+			"not allowed client":    {config(func(c *TokenExchangeConfig) { c.ClientId = "501" }), ErrTokenExchangeNotAllowed},
+			"not allowed audience":  {config(func(c *TokenExchangeConfig) { c.Audience = []string{"https://other.example"} }), ErrTokenExchangeNotAllowed},
+			"not allowed scope":     {config(func(c *TokenExchangeConfig) { c.Scope = "profile:edit" }), ErrTokenExchangeNotAllowed},
+			"default scope":         {config(func(c *TokenExchangeConfig) { c.Scope = "" }), ErrTokenExchangeNotAllowed},
+			"exceed subject scope":  {config(func(c *TokenExchangeConfig) { c.Scope = "admin" }), ErrInvalidScope},
+			"no audience":           {config(func(c *TokenExchangeConfig) { c.Audience = nil }), ErrInvalidTarget},
+			"unknown subject":       {config(func(c *TokenExchangeConfig) { c.SubjectToken = "unknown" }), database.ErrNotFound},
+			"expired subject":       {config(func(c *TokenExchangeConfig) { c.SubjectToken = "expired-subject" }), ErrAccessTokenExpired},
+			"subject token type":    {config(func(c *TokenExchangeConfig) { c.SubjectTokenType = "urn:ietf:params:oauth:token-type:jwt" }), ErrUnsupportedTokenType},
+			"unknown actor":         {config(func(c *TokenExchangeConfig) { c.ActorToken, c.ActorTokenType = "unknown", TokenTypeAccessToken }), ErrInvalidActorToken},
+			"actor token type":      {config(func(c *TokenExchangeConfig) { c.ActorToken = "actor" }), ErrUnsupportedTokenType},
+			"actor of other client": {config(func(c *TokenExchangeConfig) { c.ActorToken, c.ActorTokenType = "other-actor", TokenTypeAccessToken }), ErrInvalidActorToken},
+		}
+		for scenario, tt := range test {
+			t.Run(scenario, func(t *testing.T) {
+				_, err := tservice.ExchangeToken(ctx, tt.config)
+				assert.ErrorIs(t, err, tt.expErr)
+			})
+		}
+	})
+}
+
+func TestSessionStore(t *testing.T) {
+	var store sessionStore
+	expired := store.Start("1", time.Now().Add(-time.Second))
+	alive := store.Start("1", time.Now().Add(time.Minute))
+	// expired sessions are evicted when a session starts
+	_ = store.Start("2", time.Now().Add(time.Minute))
+	assert.NotContains(t, store.sessions, expired)
+	assert.Contains(t, store.sessions, alive)
+	assert.ErrorIs(t, store.Join(expired, "500", joinAny), ErrSessionNotFound)
+	assert.ErrorIs(t, store.Join(alive, "500", joinAgain), ErrConsentRequired)
+	assert.NoError(t, store.Join(alive, "500", joinFresh))
+	// the session is not fresh any more
+	assert.ErrorIs(t, store.Join(alive, "500", joinFresh), ErrLoginRequired)
+	assert.NoError(t, store.Join(alive, "500", joinAgain))
+	assert.NoError(t, store.Join(alive, "500", joinAny))
+
+	_, err := store.End(alive, "2")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	ss, err := store.End(alive, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"500"}, ss.clients)
 }
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yyyoichi/OhAuth0.1/internal/acr"
+)
+
+// values of the 'prompt' parameter (OpenID Connect Core 1.0 section 3.1.2.1)
+const (
+	PromptNone    = "none"
+	PromptLogin   = "login"
+	PromptConsent = "consent"
+)
+
+var (
+	ErrInvalidPrompt                   = errors.New("invalid prompt")
+	ErrInvalidMaxAge                   = errors.New("invalid max_age")
+	ErrLoginRequired                   = errors.New("login is required")
+	ErrConsentRequired                 = errors.New("consent is required")
+	ErrUnmetAuthenticationRequirements = errors.New("authentication requirements are not met")
+)
+
+type AuthenticationRequirements struct {
+	// space-delimited 'prompt'
+	Prompt string
+	// seconds since the last login. nil means no limit.
+	MaxAge *int64
+	// space-delimited 'acr_values' in order of preference
+	AcrValues string
+}
+
+// HasPrompt reports whether 'prompt' of [req] contains [prompt].
+func (req AuthenticationRequirements) HasPrompt(prompt string) bool {
+	return slices.Contains(strings.Fields(req.Prompt), prompt)
+}
+
+// CheckAuthentication checks the login of [claims] meets [req], joins the client to the session of the login, and
+// returns the authentication time and context class to record on the grant.
+//
+// The session remembers the clients which were issued a code after the user consented on the UI.
+//   - 'prompt=login' requires a fresh login, whose session no client has joined yet.
+//   - 'prompt=none' requires a valid session, which the client joined before.
+//   - 'prompt=consent' is met by the UI, which always asks for the consent.
+func (s *Service) CheckAuthentication(claims *MyClaims, req AuthenticationRequirements) (time.Time, string, error) {
+	prompts := strings.Fields(req.Prompt)
+	for _, p := range prompts {
+		if p != PromptNone && p != PromptLogin && p != PromptConsent {
+			return time.Time{}, "", fmt.Errorf("%w: '%s'", ErrInvalidPrompt, p)
+		}
+	}
+	if slices.Contains(prompts, PromptNone) && len(prompts) > 1 {
+		return time.Time{}, "", fmt.Errorf("%w: 'none' with other values", ErrInvalidPrompt)
+	}
+	if req.MaxAge != nil && *req.MaxAge < 0 {
+		return time.Time{}, "", fmt.Errorf("%w: %d", ErrInvalidMaxAge, *req.MaxAge)
+	}
+	if claims.IssuedAt == nil {
+		return time.Time{}, "", fmt.Errorf("%w: unknown authentication time", ErrLoginRequired)
+	}
+	authTime := claims.IssuedAt.Time
+	if req.MaxAge != nil && time.Since(authTime) > time.Duration(*req.MaxAge)*time.Second {
+		return time.Time{}, "", fmt.Errorf("%w: authenticated at %s", ErrLoginRequired, authTime)
+	}
+
+	class := acr.Password
+	if req.AcrValues != "" {
+		i := slices.IndexFunc(strings.Fields(req.AcrValues), func(v string) bool {
+			return slices.Contains(acr.Supported, v)
+		})
+		if i < 0 {
+			return time.Time{}, "", fmt.Errorf("%w: acr_values '%s'", ErrUnmetAuthenticationRequirements, req.AcrValues)
+		}
+		class = strings.Fields(req.AcrValues)[i]
+	}
+
+	condition := joinAny
+	switch {
+	case slices.Contains(prompts, PromptLogin):
+		condition = joinFresh
+	case slices.Contains(prompts, PromptNone):
+		condition = joinAgain
+	}
+	if err := s.sessions.Join(claims.ID, claims.ClientId, condition); err != nil {
+		return time.Time{}, "", err
+	}
+	return authTime, class, nil
+}
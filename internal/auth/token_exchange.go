@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/scope"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange" // RFC 8693
+
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var (
+	ErrInvalidSubjectToken     = errors.New("subject token is invalid")
+	ErrInvalidActorToken       = errors.New("actor token is invalid")
+	ErrUnsupportedTokenType    = errors.New("unsupported token type")
+	ErrTokenExchangeNotAllowed = errors.New("token exchange is not allowed")
+	ErrInvalidTarget           = errors.New("requested audience or resource is invalid")
+	ErrInvalidScope            = errors.New("requested scope is invalid")
+)
+
+// exchanged tokens live no longer than this, nor than the subject token.
+const exchangedTokenLifetime = time.Duration(1) * time.Hour
+
+type (
+	// TokenExchangeRule allows the client [ClientId] to exchange tokens for [Audiences] with up to [Scopes].
+	TokenExchangeRule struct {
+		ClientId  string
+		Audiences []string
+		Scopes    []string
+	}
+	// TokenExchangePolicy decides which clients may exchange tokens for which audiences and scopes.
+	// The empty policy denies every exchange.
+	TokenExchangePolicy []TokenExchangeRule
+)
+
+// Allow returns nil if [clientId] may exchange a token for [audience] with [requestedScope].
+func (p TokenExchangePolicy) Allow(clientId, audience, requestedScope string) error {
+	for _, rule := range p {
+		if rule.ClientId != clientId || !slices.Contains(rule.Audiences, audience) {
+			continue
+		}
+		if !scope.Subset(requestedScope, scope.Join(rule.Scopes)) {
+			return fmt.Errorf("%w: scope '%s' for '%s'", ErrTokenExchangeNotAllowed, requestedScope, audience)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: client '%s' for '%s'", ErrTokenExchangeNotAllowed, clientId, audience)
+}
+
+type TokenExchangeConfig struct {
+	// authenticated client which requests the exchange
+	ClientId         string
+	SubjectToken     string
+	SubjectTokenType string
+	ActorToken       string // optional
+	ActorTokenType   string
+	Audience         []string
+	// requested scope. defaults to the scope of the subject token.
+	Scope string
+	// x5t#S256 of the client certificate to bind the access token to. Optional.
+	CertificateThumbprint string
+}
+
+// [SubjectToken]を検証し、[Audience]と[Scope]に絞った委譲トークンを発行する
+func (s *Service) ExchangeToken(ctx context.Context, config TokenExchangeConfig) (*apiv1.AccessToken, error) {
+	if config.SubjectTokenType != TokenTypeAccessToken {
+		return nil, fmt.Errorf("%w: subject_token_type '%s'", ErrUnsupportedTokenType, config.SubjectTokenType)
+	}
+	subject, err := s.validAccessToken(ctx, config.SubjectToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSubjectToken, err)
+	}
+	// who acts on behalf of the subject
+	actor := &apiv1.Actor{
+		ClientId: config.ClientId,
+		Actor:    subject.GetActor(),
+	}
+	if config.ActorToken != "" {
+		if config.ActorTokenType != TokenTypeAccessToken {
+			return nil, fmt.Errorf("%w: actor_token_type '%s'", ErrUnsupportedTokenType, config.ActorTokenType)
+		}
+		act, err := s.validAccessToken(ctx, config.ActorToken)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidActorToken, err)
+		}
+		// a client acts only with its own token, so it cannot record another client as the actor
+		if act.GetServiceClientId() != config.ClientId {
+			return nil, fmt.Errorf("%w: issued to another client '%s'", ErrInvalidActorToken, act.GetServiceClientId())
+		}
+		actor.Subject = act.GetUserId()
+	}
+
+	requested := config.Scope
+	if requested == "" {
+		requested = subject.GetScope()
+	}
+	if !scope.Subset(requested, subject.GetScope()) {
+		return nil, fmt.Errorf("%w: '%s' exceeds the subject token", ErrInvalidScope, requested)
+	}
+	if len(config.Audience) == 0 {
+		return nil, fmt.Errorf("%w: audience is required", ErrInvalidTarget)
+	}
+	for _, aud := range config.Audience {
+		if err := s.exchangePolicy.Allow(config.ClientId, aud, requested); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.resolveResources(ctx, config.Audience, requested); err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(exchangedTokenLifetime)
+	if subjectExpires := subject.GetExpires().AsTime(); subjectExpires.Before(expires) {
+		expires = subjectExpires
+	}
+	token := apiv1.AccessToken{
+		Token:           uuid.NewString(),
+		UserId:          subject.GetUserId(),
+		ServiceClientId: config.ClientId,
+		Scope:           scope.Join(scope.Parse(requested)),
+		Expires:         timestamppb.New(expires),
+		Audience:        config.Audience,
+		Actor:           actor,
+
+		CertificateThumbprint: config.CertificateThumbprint,
+		AuthorizationDetails:  detailsForAudience(subject.GetAuthorizationDetails(), config.Audience),
+		AuthTime:              subject.GetAuthTime(),
+		Acr:                   subject.GetAcr(),
+	}
+	if err := s.client.CreateAccessToken(ctx, &token); err != nil {
+		return nil, err
+	}
+	s.metrics.tokenIssued(token.ServiceClientId, GrantTypeTokenExchange)
+	return &token, nil
+}
+
+func (s *Service) validAccessToken(ctx context.Context, accesstoken string) (*apiv1.AccessToken, error) {
+	token, err := s.client.GetAccessTokenByToken(ctx, accesstoken)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get access token: %w", err)
+	}
+	if time.Now().After(token.GetExpires().AsTime()) || token.GetRevokedAt() != nil {
+		return nil, ErrAccessTokenExpired
+	}
+	return token, nil
+}
@@ -1,5 +1,12 @@
 package auth
 
+import (
+	"log/slog"
+
+	"github.com/yyyoichi/OhAuth0.1/internal/authzdetails"
+	"github.com/yyyoichi/OhAuth0.1/internal/redact"
+)
+
 type (
 	ServiceClientGetRequest struct {
 		ClientId string `uri:"client_id" binding:"required"`
@@ -28,10 +35,19 @@ type (
 // 認可リクエスト(OAuth2.0)
 type (
 	AuthorizationRequest struct {
-		JWT          string `json:"jwt" binding:"required"`
+		// the login session. required, though its absence with 'prompt=none' is 'login_required'.
+		JWT          string `json:"jwt" binding:"-"`
 		ClientId     string `json:"client_id" binding:"required"`
 		ResponseType string `json:"response_type" binding:"required"` // must 'code'
 		Scope        string `json:"scope" binding:"required"`
+		// resource indicators (RFC 8707)
+		Resource []string `json:"resource" binding:"-"`
+		// JSON array of authorization details (RFC 9396)
+		AuthorizationDetails string `json:"authorization_details" binding:"-"`
+		// authentication requirements (OpenID Connect Core 1.0 section 3.1.2.1)
+		Prompt    string `json:"prompt" binding:"-"`
+		MaxAge    *int64 `json:"max_age" binding:"-"`
+		AcrValues string `json:"acr_values" binding:"-"`
 	}
 	AuthorizationResponse struct {
 		Code string `json:"code"`
@@ -42,14 +58,160 @@ type (
 type (
 	AccessTokenRequest struct {
 		GrantType    string `json:"grant_type" binding:"required"` // must 'authorization_code'
-		ClientId     string `json:"client_id" binding:"required"`
-		ClientSecret string `json:"client_secret" binding:"required"`
+		ClientId     string `json:"client_id" binding:"-"`         // may be omitted with 'client_assertion'
+		ClientSecret string `json:"client_secret" binding:"-"`     // empty on mutual-TLS or 'private_key_jwt' client authentication
 		Code         string `json:"code" binding:"-"`
 		RefreshToken string `json:"refresh_token" binding:"-"`
+		// resource indicators (RFC 8707)
+		Resource []string `json:"resource" binding:"-"`
+		// 'private_key_jwt' client authentication (RFC 7523)
+		ClientAssertionType string `json:"client_assertion_type" binding:"-"`
+		ClientAssertion     string `json:"client_assertion" binding:"-"`
+		// token exchange (RFC 8693)
+		SubjectToken     string   `json:"subject_token" binding:"-"`
+		SubjectTokenType string   `json:"subject_token_type" binding:"-"`
+		ActorToken       string   `json:"actor_token" binding:"-"`
+		ActorTokenType   string   `json:"actor_token_type" binding:"-"`
+		Audience         []string `json:"audience" binding:"-"`
+		Scope            string   `json:"scope" binding:"-"`
 	}
 	AccessTokenResponse struct {
 		AccessToken  string `json:"access_token"`
 		ExpiresIn    uint   `json:"expires_in"`
 		RefreshToken string `json:"refresh_token"`
+		// token exchange (RFC 8693)
+		IssuedTokenType string `json:"issued_token_type,omitempty"`
+		Scope           string `json:"scope,omitempty"`
+		// granted authorization details (RFC 9396)
+		AuthorizationDetails []authzdetails.Detail `json:"authorization_details,omitempty"`
+	}
+)
+
+// ログアウト(OpenID Connect RP-Initiated Logout)
+type (
+	EndSessionRequest struct {
+		JWT                   string `json:"jwt" binding:"required"`
+		ClientId              string `json:"client_id" binding:"-"`
+		PostLogoutRedirectUri string `json:"post_logout_redirect_uri" binding:"-"`
+		State                 string `json:"state" binding:"-"`
+	}
+	EndSessionResponse struct {
+		// empty if no post_logout_redirect_uri is requested
+		RedirectUri string `json:"redirect_uri"`
+	}
+)
+
+// トークンイントロスペクション(RFC 7662)
+type (
+	IntrospectionRequest struct {
+		Token        string `json:"token" binding:"required"`
+		ClientId     string `json:"client_id" binding:"-"`
+		ClientSecret string `json:"client_secret" binding:"-"`
+		// 'private_key_jwt' client authentication (RFC 7523)
+		ClientAssertionType string `json:"client_assertion_type" binding:"-"`
+		ClientAssertion     string `json:"client_assertion" binding:"-"`
+	}
+	// only 'active' is set if the token is not active, or issued to another client than the requester.
+	IntrospectionResponse struct {
+		Active    bool     `json:"active"`
+		Scope     string   `json:"scope,omitempty"`
+		ClientId  string   `json:"client_id,omitempty"`
+		Sub       string   `json:"sub,omitempty"`
+		Exp       int64    `json:"exp,omitempty"`
+		Aud       []string `json:"aud,omitempty"`
+		Iss       string   `json:"iss,omitempty"`
+		TokenType string   `json:"token_type,omitempty"`
+		// authentication of the user (RFC 9470 section 6.2)
+		AuthTime int64  `json:"auth_time,omitempty"`
+		Acr      string `json:"acr,omitempty"`
+		// granted authorization details (RFC 9396 section 9.2)
+		AuthorizationDetails []authzdetails.Detail `json:"authorization_details,omitempty"`
 	}
 )
+
+// The requests and responses are logged with the passwords, secrets, codes and tokens masked.
+
+// LogValue implements slog.LogValuer.
+func (r AuthenticationRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("client_id", r.ClientId),
+		slog.String("user_id", r.UserId),
+		redact.String("password", r.Password),
+	)
+}
+
+// LogValue implements slog.LogValuer.
+func (r AuthenticationResponse) LogValue() slog.Value {
+	return slog.GroupValue(redact.String("jwt", r.JWT))
+}
+
+// LogValue implements slog.LogValuer.
+func (r AuthorizationRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		redact.String("jwt", r.JWT),
+		slog.String("client_id", r.ClientId),
+		slog.String("response_type", r.ResponseType),
+		slog.String("scope", r.Scope),
+		slog.Any("resource", r.Resource),
+		slog.String("authorization_details", r.AuthorizationDetails),
+		slog.String("prompt", r.Prompt),
+		slog.String("acr_values", r.AcrValues),
+	)
+}
+
+// LogValue implements slog.LogValuer.
+func (r AuthorizationResponse) LogValue() slog.Value {
+	return slog.GroupValue(redact.String("authorization_code", r.Code))
+}
+
+// LogValue implements slog.LogValuer.
+func (r AccessTokenRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("grant_type", r.GrantType),
+		slog.String("client_id", r.ClientId),
+		redact.String("client_secret", r.ClientSecret),
+		redact.String("authorization_code", r.Code),
+		redact.String("refresh_token", r.RefreshToken),
+		slog.Any("resource", r.Resource),
+		slog.String("client_assertion_type", r.ClientAssertionType),
+		redact.String("client_assertion", r.ClientAssertion),
+		redact.String("subject_token", r.SubjectToken),
+		slog.String("subject_token_type", r.SubjectTokenType),
+		redact.String("actor_token", r.ActorToken),
+		slog.String("actor_token_type", r.ActorTokenType),
+		slog.Any("audience", r.Audience),
+		slog.String("scope", r.Scope),
+	)
+}
+
+// LogValue implements slog.LogValuer.
+func (r AccessTokenResponse) LogValue() slog.Value {
+	return slog.GroupValue(
+		redact.String("access_token", r.AccessToken),
+		slog.Uint64("expires_in", uint64(r.ExpiresIn)),
+		redact.String("refresh_token", r.RefreshToken),
+		slog.String("issued_token_type", r.IssuedTokenType),
+		slog.String("scope", r.Scope),
+	)
+}
+
+// LogValue implements slog.LogValuer.
+func (r EndSessionRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		redact.String("jwt", r.JWT),
+		slog.String("client_id", r.ClientId),
+		slog.String("post_logout_redirect_uri", r.PostLogoutRedirectUri),
+		slog.String("state", r.State),
+	)
+}
+
+// LogValue implements slog.LogValuer.
+func (r IntrospectionRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		redact.String("token", r.Token),
+		slog.String("client_id", r.ClientId),
+		redact.String("client_secret", r.ClientSecret),
+		slog.String("client_assertion_type", r.ClientAssertionType),
+		redact.String("client_assertion", r.ClientAssertion),
+	)
+}
@@ -0,0 +1,176 @@
+// Package authzdetails implements 'authorization_details' of Rich Authorization Requests (RFC 9396).
+package authzdetails
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+)
+
+// Detail is an entry of 'authorization_details' (RFC 9396 section 2).
+type Detail struct {
+	Type       string   `json:"type"`
+	Locations  []string `json:"locations,omitempty"`
+	Actions    []string `json:"actions,omitempty"`
+	Datatypes  []string `json:"datatypes,omitempty"`
+	Identifier string   `json:"identifier,omitempty"`
+}
+
+// Schema of an authorization details type. Entries may only use the listed values.
+type Schema struct {
+	// human-readable name shown on the consent screen
+	Description string
+	Actions     []string
+	Datatypes   []string
+	// whether 'identifier' is required
+	Identifier bool
+}
+
+const (
+	// read or write fields of the user profile. datatypes narrow the fields,
+	// and the identifier is the id of the user whose profile it is.
+	TypeProfile = "profile"
+
+	ActionView = "view"
+	// only the 'profile' field is editable
+	ActionEdit = "edit"
+
+	DatatypeName    = "name"
+	DatatypeAge     = "age"
+	DatatypeProfile = "profile"
+)
+
+// Schemas of supported authorization details types.
+var Schemas = map[string]Schema{
+	TypeProfile: {
+		Description: "View or edit your profile",
+		Actions:     []string{ActionView, ActionEdit},
+		Datatypes:   []string{DatatypeName, DatatypeAge, DatatypeProfile},
+	},
+}
+
+var (
+	ErrInvalidDetails = errors.New("invalid authorization details")
+	ErrUnknownType    = errors.New("unknown authorization details type")
+)
+
+// Parse decodes and validates the JSON array [data]. Empty [data] is no details.
+func Parse(data string) ([]Detail, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var details []Detail
+	if err := json.Unmarshal([]byte(data), &details); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDetails, err)
+	}
+	if err := Validate(details); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// Validate checks every entry of [details] against its schema.
+func Validate(details []Detail) error {
+	for i, d := range details {
+		schema, found := Schemas[d.Type]
+		if !found {
+			return fmt.Errorf("%w: [%d] '%s'", ErrUnknownType, i, d.Type)
+		}
+		if len(d.Actions) == 0 {
+			return fmt.Errorf("%w: [%d] actions is required", ErrInvalidDetails, i)
+		}
+		for _, a := range d.Actions {
+			if !slices.Contains(schema.Actions, a) {
+				return fmt.Errorf("%w: [%d] unknown action '%s'", ErrInvalidDetails, i, a)
+			}
+		}
+		for _, dt := range d.Datatypes {
+			if !slices.Contains(schema.Datatypes, dt) {
+				return fmt.Errorf("%w: [%d] unknown datatype '%s'", ErrInvalidDetails, i, dt)
+			}
+		}
+		for _, l := range d.Locations {
+			if u, err := url.Parse(l); err != nil || !u.IsAbs() {
+				return fmt.Errorf("%w: [%d] location '%s' is not an absolute URI", ErrInvalidDetails, i, l)
+			}
+		}
+		if schema.Identifier && d.Identifier == "" {
+			return fmt.Errorf("%w: [%d] identifier is required", ErrInvalidDetails, i)
+		}
+	}
+	return nil
+}
+
+// ForAudience returns the entries of [details] usable at any of [audience].
+// entries without locations are usable everywhere.
+func ForAudience(details []Detail, audience []string) []Detail {
+	var out []Detail
+	for _, d := range details {
+		if len(d.Locations) == 0 || slices.ContainsFunc(d.Locations, func(l string) bool {
+			return slices.Contains(audience, l)
+		}) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// OfType returns the entries of [details] whose type is [typ].
+func OfType(details []Detail, typ string) []Detail {
+	var out []Detail
+	for _, d := range details {
+		if d.Type == typ {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Allows reports whether any entry of [details] permits [action] on [datatype] of the object [identifier].
+// entries without datatypes permit every datatype, and entries without identifier every object.
+// empty [datatype] is any datatype.
+func Allows(details []Detail, action, datatype, identifier string) bool {
+	return slices.ContainsFunc(details, func(d Detail) bool {
+		return slices.Contains(d.Actions, action) &&
+			(datatype == "" || len(d.Datatypes) == 0 || slices.Contains(d.Datatypes, datatype)) &&
+			(d.Identifier == "" || d.Identifier == identifier)
+	})
+}
+
+func FromProto(details []*apiv1.AuthorizationDetail) []Detail {
+	if len(details) == 0 {
+		return nil
+	}
+	out := make([]Detail, 0, len(details))
+	for _, d := range details {
+		out = append(out, Detail{
+			Type:       d.GetType(),
+			Locations:  d.GetLocations(),
+			Actions:    d.GetActions(),
+			Datatypes:  d.GetDatatypes(),
+			Identifier: d.GetIdentifier(),
+		})
+	}
+	return out
+}
+
+func ToProto(details []Detail) []*apiv1.AuthorizationDetail {
+	if len(details) == 0 {
+		return nil
+	}
+	out := make([]*apiv1.AuthorizationDetail, 0, len(details))
+	for _, d := range details {
+		out = append(out, &apiv1.AuthorizationDetail{
+			Type:       d.Type,
+			Locations:  d.Locations,
+			Actions:    d.Actions,
+			Datatypes:  d.Datatypes,
+			Identifier: d.Identifier,
+		})
+	}
+	return out
+}
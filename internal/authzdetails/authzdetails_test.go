@@ -0,0 +1,73 @@
+package authzdetails
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	details, err := Parse(`[{"type":"profile","actions":["view"],"datatypes":["name","age"],"locations":["http://localhost:8088"]}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, []Detail{{
+		Type:      TypeProfile,
+		Locations: []string{"http://localhost:8088"},
+		Actions:   []string{ActionView},
+		Datatypes: []string{DatatypeName, DatatypeAge},
+	}}, details)
+	assert.Equal(t, details, FromProto(ToProto(details)))
+
+	details, err = Parse(`[{"type":"profile","actions":["view","edit"],"datatypes":["profile"],"identifier":"1"}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, []Detail{{
+		Type:       TypeProfile,
+		Actions:    []string{ActionView, ActionEdit},
+		Datatypes:  []string{DatatypeProfile},
+		Identifier: "1",
+	}}, details)
+	details, err = Parse("")
+	assert.NoError(t, err)
+	assert.Empty(t, details)
+
+	test := map[string]struct {
+		data   string
+		expErr error
+	}{
+		"not json":         {`{`, ErrInvalidDetails},
+		"not array":        {`{"type":"profile"}`, ErrInvalidDetails},
+		"unknown type":     {`[{"type":"document","actions":["read"]}]`, ErrUnknownType},
+		"no actions":       {`[{"type":"profile"}]`, ErrInvalidDetails},
+		"unknown action":   {`[{"type":"profile","actions":["delete"]}]`, ErrInvalidDetails},
+		"unknown datatype": {`[{"type":"profile","actions":["view"],"datatypes":["password"]}]`, ErrInvalidDetails},
+		"relative":         {`[{"type":"profile","actions":["view"],"locations":["/api"]}]`, ErrInvalidDetails},
+	}
+	for scenario, tt := range test {
+		t.Run(scenario, func(t *testing.T) {
+			_, err := Parse(tt.data)
+			assert.ErrorIs(t, err, tt.expErr)
+		})
+	}
+}
+
+func TestAllows(t *testing.T) {
+	details := []Detail{
+		{Type: TypeProfile, Actions: []string{ActionView}, Datatypes: []string{DatatypeName}, Locations: []string{"https://a.example"}},
+		{Type: "other", Actions: []string{ActionView}},
+	}
+	assert.Len(t, ForAudience(details, []string{"https://a.example"}), 2)
+	assert.Len(t, ForAudience(details, []string{"https://b.example"}), 1)
+
+	profile := OfType(details, TypeProfile)
+	assert.Len(t, profile, 1)
+	assert.True(t, Allows(profile, ActionView, DatatypeName, "1"))
+	assert.True(t, Allows(profile, ActionView, "", "1"))
+	assert.False(t, Allows(profile, ActionView, DatatypeAge, "1"))
+	assert.False(t, Allows(profile, ActionEdit, DatatypeName, "1"))
+	assert.True(t, Allows([]Detail{{Type: TypeProfile, Actions: []string{ActionView}}}, ActionView, DatatypeAge, "1"))
+
+	// on a specific object
+	edit := []Detail{{Type: TypeProfile, Actions: []string{ActionEdit}, Datatypes: []string{DatatypeProfile}, Identifier: "1"}}
+	assert.True(t, Allows(edit, ActionEdit, DatatypeProfile, "1"))
+	assert.False(t, Allows(edit, ActionEdit, DatatypeProfile, "2"))
+	assert.False(t, Allows(edit, ActionView, DatatypeProfile, "1"))
+}
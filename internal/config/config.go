@@ -0,0 +1,232 @@
+// Package config is the configuration of the servers and the CLI.
+//
+// The values come from, in the order of precedence, the flags, the environment variables (and the env file
+// of '-source'), the YAML or TOML file of '-config', and the defaults. Each field is named by its key in the file,
+// e.g. 'auth.port', which is also the name of the flag, and by the 'env' tag.
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yyyoichi/OhAuth0.1/internal/tracing"
+)
+
+type (
+	Config struct {
+		Database Database `yaml:"database" toml:"database"`
+		Auth     Auth     `yaml:"auth" toml:"auth"`
+		Resource Resource `yaml:"resource" toml:"resource"`
+		UI       UI       `yaml:"ui" toml:"ui"`
+		Client   Client   `yaml:"client" toml:"client"`
+		Shutdown Shutdown `yaml:"shutdown" toml:"shutdown"`
+		Tracing  Tracing  `yaml:"tracing" toml:"tracing"`
+		Server   Server   `yaml:"server" toml:"server"`
+		TLS      TLS      `yaml:"tls" toml:"tls"`
+	}
+	Database struct {
+		Port int `yaml:"port" toml:"port" env:"DATABASE_SERVER_PORT" usage:"port of the database server"`
+		// the clients call this. defaults to 'http://localhost:<port>', or https with tls.ca.
+		URL              string      `yaml:"url" toml:"url" env:"DATABASE_SERVER_URL" usage:"URL of the database server"`
+		Storage          string      `yaml:"storage" toml:"storage" env:"DATABASE_STORAGE" usage:"'memory' or 'file'"`
+		DataDir          string      `yaml:"data_dir" toml:"data_dir" env:"DATABASE_DATA_DIR" usage:"directory of the 'file' storage"`
+		SweepInterval    Duration    `yaml:"sweep_interval" toml:"sweep_interval" env:"DATABASE_SWEEP_INTERVAL" usage:"interval to evict expired codes and tokens"`
+		SweepGracePeriod Duration    `yaml:"sweep_grace_period" toml:"sweep_grace_period" env:"DATABASE_SWEEP_GRACE_PERIOD" usage:"grace period before evicting"`
+		TokenPepper      string      `yaml:"token_pepper" toml:"token_pepper" env:"DATABASE_TOKEN_PEPPER" secret:"true" usage:"key of the hashes of codes and tokens"`
+		TLS              DatabaseTLS `yaml:"tls" toml:"tls"`
+		// keys of the callers without client certificates
+		AuthServerKey     string   `yaml:"auth_server_key" toml:"auth_server_key" env:"DATABASE_AUTH_SERVER_KEY" secret:"true" usage:"key of the authorization server"`
+		ResourceServerKey string   `yaml:"resource_server_key" toml:"resource_server_key" env:"DATABASE_RESOURCE_SERVER_KEY" secret:"true" usage:"key of the resource server"`
+		ClientTimeout     Duration `yaml:"client_timeout" toml:"client_timeout" env:"DATABASE_CLIENT_TIMEOUT" usage:"deadline of each call to the database server"`
+		// for development only
+		Insecure bool `yaml:"insecure" toml:"insecure" env:"DATABASE_INSECURE" usage:"accept any caller without keys nor client CA, and the keys over plain HTTP"`
+	}
+	DatabaseTLS struct {
+		Cert string `yaml:"cert" toml:"cert" env:"DATABASE_TLS_CERT" usage:"certificate file of the database server"`
+		Key  string `yaml:"key" toml:"key" env:"DATABASE_TLS_KEY" usage:"key file of the database server"`
+		// the clients trust this
+		CA string `yaml:"ca" toml:"ca" env:"DATABASE_TLS_CA" usage:"CA file of the database server"`
+		// CA of the client certificates
+		ClientCA string `yaml:"client_ca" toml:"client_ca" env:"DATABASE_TLS_CLIENT_CA" usage:"CA file of the client certificates"`
+	}
+	Auth struct {
+		Port int `yaml:"port" toml:"port" env:"AUTHORIZATION_SERVER_PORT" usage:"port of the authorization server"`
+		// defaults to 'http://localhost:<port>', or https with tls.cert
+		URL string  `yaml:"url" toml:"url" env:"AUTHORIZATION_SERVER_URL" usage:"URL of the authorization server"`
+		TLS AuthTLS `yaml:"tls" toml:"tls"`
+		// client certificate to the database server
+		DatabaseCert string `yaml:"database_cert" toml:"database_cert" env:"AUTHORIZATION_SERVER_DATABASE_CERT" usage:"client certificate file to the database server"`
+		DatabaseKey  string `yaml:"database_key" toml:"database_key" env:"AUTHORIZATION_SERVER_DATABASE_KEY" usage:"client key file to the database server"`
+		// clients allowed to exchange tokens (RFC 8693), e.g. '500|https://api.example.com|profile:view'. every exchange is denied if empty.
+		TokenExchange string `yaml:"token_exchange" toml:"token_exchange" env:"AUTHORIZATION_SERVER_TOKEN_EXCHANGE" usage:"rules of '<client_id>|<audiences>|<scopes>' separated by ';'"`
+	}
+	AuthTLS struct {
+		Cert string `yaml:"cert" toml:"cert" env:"AUTHORIZATION_SERVER_TLS_CERT" usage:"certificate file of the authorization server"`
+		Key  string `yaml:"key" toml:"key" env:"AUTHORIZATION_SERVER_TLS_KEY" usage:"key file of the authorization server"`
+		// CA of the client certificates for 'tls_client_auth'
+		ClientCA string `yaml:"client_ca" toml:"client_ca" env:"AUTHORIZATION_SERVER_TLS_CLIENT_CA" usage:"CA file of the client certificates"`
+	}
+	Resource struct {
+		Port int `yaml:"port" toml:"port" env:"RESOURCE_SERVER_PORT" usage:"port of the resource server"`
+		// the resource indicator of the server. defaults to 'http://localhost:<port>', or https with tls.cert
+		URL            string      `yaml:"url" toml:"url" env:"RESOURCE_SERVER_URL" usage:"URL of the resource server"`
+		TLS            ResourceTLS `yaml:"tls" toml:"tls"`
+		DatabaseCert   string      `yaml:"database_cert" toml:"database_cert" env:"RESOURCE_SERVER_DATABASE_CERT" usage:"client certificate file to the database server"`
+		DatabaseKey    string      `yaml:"database_key" toml:"database_key" env:"RESOURCE_SERVER_DATABASE_KEY" usage:"client key file to the database server"`
+		TokenCacheSize int         `yaml:"token_cache_size" toml:"token_cache_size" env:"RESOURCE_TOKEN_CACHE_SIZE" usage:"validated access tokens cached. -1 disables the cache"`
+		TokenCacheTTL  Duration    `yaml:"token_cache_ttl" toml:"token_cache_ttl" env:"RESOURCE_TOKEN_CACHE_TTL" usage:"lifetime of the cached tokens"`
+	}
+	ResourceTLS struct {
+		Cert string `yaml:"cert" toml:"cert" env:"RESOURCE_SERVER_TLS_CERT" usage:"certificate file of the resource server"`
+		Key  string `yaml:"key" toml:"key" env:"RESOURCE_SERVER_TLS_KEY" usage:"key file of the resource server"`
+	}
+	UI struct {
+		Port int `yaml:"port" toml:"port" env:"UI_SERVER_PORT" usage:"port of the UI"`
+		// the origin of the UI. defaults to 'http://localhost:<port>'
+		URL string `yaml:"url" toml:"url" env:"UI_SERVER_URL" usage:"URL of the UI"`
+	}
+	Client struct {
+		RedirectPort int `yaml:"redirect_port" toml:"redirect_port" env:"CLIENT_APP_REDIRECT_PORT" usage:"port to receive the authorization code"`
+		// registered to the mock service clients. defaults to 'http://localhost:<redirect_port>', or https with tls.cert
+		RedirectURI string `yaml:"redirect_uri" toml:"redirect_uri" env:"CLIENT_APP_REDIRECT_URI" usage:"redirect URI of the CLI"`
+		LogoutPort  int    `yaml:"logout_port" toml:"logout_port" env:"CLIENT_APP_LOGOUT_PORT" usage:"port to receive the back-channel logout tokens"`
+		// registered to the mock service clients. defaults to 'http://localhost:<logout_port>/backchannel_logout', or https with tls.cert
+		LogoutURI string    `yaml:"logout_uri" toml:"logout_uri" env:"CLIENT_APP_LOGOUT_URI" usage:"back-channel logout URI of the CLI"`
+		TLS       ClientTLS `yaml:"tls" toml:"tls"`
+	}
+	ClientTLS struct {
+		Cert string `yaml:"cert" toml:"cert" env:"CLIENT_APP_TLS_CERT" usage:"certificate file of the redirect and logout URIs"`
+		Key  string `yaml:"key" toml:"key" env:"CLIENT_APP_TLS_KEY" usage:"key file of the redirect and logout URIs"`
+	}
+	Shutdown struct {
+		DrainTimeout Duration `yaml:"drain_timeout" toml:"drain_timeout" env:"SHUTDOWN_DRAIN_TIMEOUT" usage:"wait for the in-flight requests on exit"`
+	}
+	Tracing struct {
+		Exporter string `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER" usage:"'none', 'stdout' or 'otlp'"`
+		// the OTEL_EXPORTER_OTLP_* environment variables, or 'http://localhost:4318' if empty.
+		Endpoint string `yaml:"endpoint" toml:"endpoint" env:"TRACING_ENDPOINT" usage:"URL of the OTLP/HTTP collector for the 'otlp' exporter"`
+	}
+	TLS struct {
+		// e.g. the CA of 'dev-certs'. the system CAs are trusted if empty.
+		CA string `yaml:"ca" toml:"ca" env:"TLS_CA" usage:"CA file trusted to call the servers and the back-channel logout URIs"`
+	}
+	// Server is the all-in-one server of 'cmd/server'.
+	Server struct {
+		Services  string `yaml:"services" toml:"services" env:"SERVER_SERVICES" usage:"comma separated services to run in the process: database, auth, resource"`
+		Transport string `yaml:"transport" toml:"transport" env:"SERVER_TRANSPORT" usage:"'memory' or 'connect' to the database"`
+	}
+)
+
+const (
+	// the auth and resource services call the in-memory database server in the process, which listens on no port.
+	TransportMemory = "memory"
+	// the auth and resource services call the database server at 'database.url'.
+	TransportConnect = "connect"
+)
+
+// Default returns the defaults. The ports are of '.env.example'.
+func Default() Config {
+	return Config{
+		Database: Database{Port: 3306, Storage: "memory"},
+		Auth:     Auth{Port: 8080},
+		Resource: Resource{Port: 8088},
+		UI:       UI{Port: 3000},
+		Client:   Client{RedirectPort: 7777, LogoutPort: 7778},
+		Tracing:  Tracing{Exporter: tracing.ExporterNone},
+		Server:   Server{Services: "database,auth,resource", Transport: TransportMemory},
+	}
+}
+
+// resolve fills the URLs of the services from their ports, and trims the trailing slashes to join the paths.
+func (c *Config) resolve() {
+	for _, u := range []*string{&c.Database.URL, &c.Auth.URL, &c.Resource.URL, &c.UI.URL, &c.Client.RedirectURI, &c.Client.LogoutURI} {
+		*u = strings.TrimRight(*u, "/")
+	}
+	if c.Database.URL == "" {
+		c.Database.URL = localURL(c.Database.TLS.CA, c.Database.Port)
+	}
+	if c.Auth.URL == "" {
+		c.Auth.URL = localURL(c.Auth.TLS.Cert, c.Auth.Port)
+	}
+	if c.Resource.URL == "" {
+		c.Resource.URL = localURL(c.Resource.TLS.Cert, c.Resource.Port)
+	}
+	if c.UI.URL == "" {
+		c.UI.URL = localURL("", c.UI.Port)
+	}
+	if c.Client.RedirectURI == "" {
+		c.Client.RedirectURI = localURL(c.Client.TLS.Cert, c.Client.RedirectPort)
+	}
+	if c.Client.LogoutURI == "" {
+		c.Client.LogoutURI = localURL(c.Client.TLS.Cert, c.Client.LogoutPort) + "/backchannel_logout"
+	}
+}
+
+// localURL returns the URL on localhost, which is https if [tlsFile] is set.
+func localURL(tlsFile string, port int) string {
+	scheme := "http"
+	if tlsFile != "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://localhost:%d", scheme, port)
+}
+
+// List returns the services to run, e.g. [ServiceAuth, ServiceResource] of 'auth, resource'.
+func (s Server) List() []Service {
+	var services []Service
+	for _, name := range strings.Split(s.Services, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			services = append(services, Service(name))
+		}
+	}
+	return services
+}
+
+// TokenExchangeRule allows the client to exchange tokens for the audiences with up to the scopes.
+type TokenExchangeRule struct {
+	ClientId  string
+	Audiences []string
+	Scopes    []string
+}
+
+// TokenExchangeRules parses 'auth.token_exchange'. Each rule is '<client_id>|<audiences>|<scopes>',
+// where the audiences and the scopes are separated by spaces, and the rules by ';'.
+func (a Auth) TokenExchangeRules() ([]TokenExchangeRule, error) {
+	var rules []TokenExchangeRule
+	for _, text := range strings.Split(a.TokenExchange, ";") {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		parts := strings.Split(text, "|")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("'%s' is not '<client_id>|<audiences>|<scopes>'", text)
+		}
+		rule := TokenExchangeRule{
+			ClientId:  strings.TrimSpace(parts[0]),
+			Audiences: strings.Fields(parts[1]),
+			Scopes:    strings.Fields(parts[2]),
+		}
+		if rule.ClientId == "" || len(rule.Audiences) == 0 {
+			return nil, fmt.Errorf("'%s' has no client_id or audience", text)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Duration is a [time.Duration] written as '30s' in the files and the variables.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
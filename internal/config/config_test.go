@@ -0,0 +1,263 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yyyoichi/OhAuth0.1/internal/redact"
+	"gopkg.in/yaml.v3"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", `
+auth:
+  port: 9000
+  url: https://auth.example.com/
+resource:
+  token_cache_ttl: 1m
+database:
+  tls:
+    ca: ca.pem
+`)
+	tomlFile := writeFile(t, "config.toml", `
+[auth]
+port = 9000
+url = "https://auth.example.com"
+
+[resource]
+token_cache_ttl = "1m"
+
+[database.tls]
+ca = "ca.pem"
+`)
+	for _, file := range []string{yamlFile, tomlFile} {
+		t.Run(filepath.Ext(file), func(t *testing.T) {
+			t.Setenv("AUTHORIZATION_SERVER_PORT", "9001")
+			t.Setenv("RESOURCE_SERVER_PORT", "9002")
+			t.Setenv("DATABASE_CLIENT_TIMEOUT", "")
+			config, err := Load("test", []string{"-config", file, "-resource.port", "9003"}, &bytes.Buffer{}, ServiceAuth, ServiceResource)
+			if !assert.NoError(t, err) {
+				return
+			}
+			// env overrides the file, and the flag overrides env
+			assert.Equal(t, 9001, config.Auth.Port)
+			assert.Equal(t, 9003, config.Resource.Port)
+			assert.Equal(t, Duration(time.Minute), config.Resource.TokenCacheTTL)
+			// the URLs
+			assert.Equal(t, "https://auth.example.com", config.Auth.URL)
+			assert.Equal(t, "http://localhost:9003", config.Resource.URL)
+			assert.Equal(t, "https://localhost:3306", config.Database.URL)
+			// the defaults
+			assert.Equal(t, "http://localhost:3000", config.UI.URL)
+			assert.Equal(t, 7777, config.Client.RedirectPort)
+		})
+	}
+}
+
+func TestLoadTLS(t *testing.T) {
+	config, err := Load("test", []string{
+		"-auth.tls.cert", "auth.pem", "-auth.tls.key", "auth-key.pem",
+		"-client.tls.cert", "client.pem", "-client.tls.key", "client-key.pem",
+	}, &bytes.Buffer{}, ServiceAuth, ServiceClient)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// https with the certificates
+	assert.Equal(t, "https://localhost:8080", config.Auth.URL)
+	assert.Equal(t, "https://localhost:7777", config.Client.RedirectURI)
+	assert.Equal(t, "https://localhost:7778/backchannel_logout", config.Client.LogoutURI)
+	assert.Equal(t, "http://localhost:8088", config.Resource.URL)
+
+	_, err = Load("test", []string{
+		"-resource.url", "http://localhost:8088",
+		"-resource.tls.cert", "resource.pem",
+		"-auth.tls.client_ca", "ca.pem",
+	}, &bytes.Buffer{}, ServiceAuth, ServiceResource)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "resource.url: must be https with resource.tls.cert")
+		assert.Contains(t, err.Error(), "resource.tls.cert: must be set with resource.tls.key")
+		assert.Contains(t, err.Error(), "auth.tls.cert: is required")
+	}
+}
+
+func TestServerList(t *testing.T) {
+	config, err := Load("test", []string{"-server.services", " auth, database ,", "-server.transport", "connect", "-database.insecure"}, &bytes.Buffer{}, ServiceServer)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, config.Database.Insecure)
+	assert.Equal(t, []Service{ServiceAuth, ServiceDatabase}, config.Server.List())
+	assert.Equal(t, []Service{ServiceDatabase, ServiceAuth, ServiceResource}, Default().Server.List())
+}
+
+func TestTokenExchangeRules(t *testing.T) {
+	auth := Auth{TokenExchange: "500|https://a.example https://b.example|profile:view profile:edit; 501|https://a.example|;"}
+	rules, err := auth.TokenExchangeRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []TokenExchangeRule{
+		{ClientId: "500", Audiences: []string{"https://a.example", "https://b.example"}, Scopes: []string{"profile:view", "profile:edit"}},
+		{ClientId: "501", Audiences: []string{"https://a.example"}, Scopes: []string{}},
+	}, rules)
+
+	rules, err = Auth{}.TokenExchangeRules()
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+}
+
+func TestLoadSource(t *testing.T) {
+	source := writeFile(t, ".env.local", "UI_SERVER_PORT=3100\nCLIENT_APP_REDIRECT_PORT=7700\n")
+	t.Cleanup(func() {
+		os.Unsetenv("UI_SERVER_PORT")
+		os.Unsetenv("CLIENT_APP_REDIRECT_PORT")
+	})
+	config, err := Load("test", []string{"-source", source}, &bytes.Buffer{}, ServiceClient)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "http://localhost:3100", config.UI.URL)
+	assert.Equal(t, 7700, config.Client.RedirectPort)
+
+	_, err = Load("test", []string{"-source", source + ".missing"}, &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	test := map[string]struct {
+		args     []string
+		env      map[string]string
+		services []Service
+		expErrs  []string
+	}{
+		"not a number": {
+			env:     map[string]string{"AUTHORIZATION_SERVER_PORT": "80a"},
+			expErrs: []string{"AUTHORIZATION_SERVER_PORT: '80a' is not a number"},
+		},
+		"bad duration": {
+			args:    []string{"-shutdown.drain_timeout", "10"},
+			expErrs: []string{"-shutdown.drain_timeout: "},
+		},
+		"unknown key": {
+			args:    []string{"-config", writeFile(t, "typo.yaml", "auth:\n  prot: 80\n")},
+			expErrs: []string{"field prot not found"},
+		},
+		"extension": {
+			args:    []string{"-config", writeFile(t, "config.json", "{}")},
+			expErrs: []string{"unknown config file extension '.json'"},
+		},
+		"all problems": {
+			args: []string{
+				"-auth.port", "0",
+				"-ui.url", "localhost:3000",
+				"-database.url", "http://db.internal:3306/api",
+				"-database.storage", "disk",
+				"-auth.database_cert", "cert.pem",
+				"-tracing.exporter", "jaeger",
+			},
+			services: []Service{ServiceAuth, ServiceDatabase},
+			expErrs: []string{
+				"auth.port: 0 is not a port",
+				"ui.url: 'localhost:3000' is not an http or https URL",
+				"database.url: 'http://db.internal:3306/api' must not have a path, query or fragment",
+				"database.storage: 'disk' is not one of 'memory', 'file'",
+				"auth.database_cert: must be set with auth.database_key",
+				"tracing.exporter: 'jaeger' is not one of 'none', 'stdout', 'otlp'",
+			},
+		},
+		"otlp": {
+			args:    []string{"-tracing.exporter", "otlp", "-tracing.endpoint", "localhost:4318"},
+			expErrs: []string{"tracing.endpoint: 'localhost:4318' is not an http or https URL"},
+		},
+		"client": {
+			args:     []string{"-client.logout_port", "7777", "-client.logout_uri", "localhost:7778"},
+			services: []Service{ServiceClient},
+			expErrs: []string{
+				"client.logout_uri: 'localhost:7778' is not an http or https URL",
+				"client.logout_port: client.redirect_port listens on the same port 7777",
+			},
+		},
+		"server": {
+			args: []string{
+				"-server.services", "auth,resource,ui",
+				"-server.transport", "grpc",
+				"-resource.port", "8080",
+			},
+			services: []Service{ServiceServer},
+			expErrs: []string{
+				"server.transport: 'grpc' is not one of 'memory', 'connect'",
+				"server.services: unknown service 'ui'",
+				"server.services: auth and resource listen on the same port 8080",
+			},
+		},
+		"server without database": {
+			args:     []string{"-server.services", "auth"},
+			services: []Service{ServiceServer},
+			expErrs:  []string{"server.services: must have 'database' with server.transport 'memory'"},
+		},
+		"not a boolean": {
+			env:     map[string]string{"DATABASE_INSECURE": "yes please"},
+			expErrs: []string{"DATABASE_INSECURE: 'yes please' is not a boolean"},
+		},
+		"database callers": {
+			services: []Service{ServiceDatabase},
+			expErrs:  []string{"database.auth_server_key: is required without database.tls.client_ca or database.insecure"},
+		},
+		"database keys over plain HTTP": {
+			args:     []string{"-database.resource_server_key", "key"},
+			services: []Service{ServiceDatabase},
+			expErrs:  []string{"database.tls.cert: is required to receive the keys without database.insecure"},
+		},
+		"token exchange": {
+			args:     []string{"-auth.token_exchange", "500|https://a.example|profile:view; 501|profile:view"},
+			services: []Service{ServiceAuth},
+			expErrs:  []string{"auth.token_exchange: '501|profile:view' is not '<client_id>|<audiences>|<scopes>'"},
+		},
+		"file storage": {
+			args:     []string{"-database.storage", "file"},
+			services: []Service{ServiceDatabase},
+			expErrs:  []string{"database.data_dir: is required", "database.token_pepper: is required"},
+		},
+	}
+	for scenario, tt := range test {
+		t.Run(scenario, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := Load("test", tt.args, &bytes.Buffer{}, tt.services...)
+			if !assert.Error(t, err) {
+				return
+			}
+			for _, exp := range tt.expErrs {
+				assert.Contains(t, err.Error(), exp)
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var buf bytes.Buffer
+	t.Setenv("DATABASE_TOKEN_PEPPER", "pepper-0123456789abcdef")
+	_, err := Load("test", []string{"-print-config", "-database.auth_server_key", "auth-key-value"}, &buf)
+	assert.ErrorIs(t, err, ErrPrinted)
+	assert.NotContains(t, buf.String(), "pepper-0123456789abcdef")
+	assert.NotContains(t, buf.String(), "auth-key-value")
+
+	// it can be read again
+	var printed Config
+	assert.NoError(t, yaml.Unmarshal(buf.Bytes(), &printed))
+	assert.Equal(t, redact.Mask, printed.Database.TokenPepper)
+	assert.Equal(t, redact.Mask, printed.Database.AuthServerKey)
+	assert.Equal(t, "", printed.Database.ResourceServerKey)
+	assert.Equal(t, "http://localhost:8080", printed.Auth.URL)
+	assert.True(t, strings.Contains(buf.String(), "drain_timeout: 0s"), buf.String())
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/yyyoichi/OhAuth0.1/internal/redact"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrPrinted is returned by [Load] after printing the config for '-print-config'.
+var ErrPrinted = errors.New("config is printed")
+
+// Load loads the config of the command line [args] of the program [name], and validates it for [services].
+// With '-print-config' it writes the config to [w] and returns [ErrPrinted].
+func Load(name string, args []string, w io.Writer, services ...Service) (*Config, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	source := fs.String("source", "", "env file")
+	file := fs.String("config", "", "YAML or TOML config file")
+	printConfig := fs.Bool("print-config", false, "print the effective config and exit")
+	// the flags of the fields are applied last
+	overrides := map[string]string{}
+	for _, f := range fields(&Config{}) {
+		set := func(v string) error {
+			overrides[f.key] = v
+			return nil
+		}
+		// e.g. '-database.insecure' without a value
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(f.key, f.usage, set)
+		} else {
+			fs.Func(f.key, f.usage, set)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *source != "" {
+		if err := godotenv.Load(*source); err != nil {
+			return nil, fmt.Errorf("cannot read env file: %w", err)
+		}
+	}
+	config := Default()
+	if *file != "" {
+		if err := decodeFile(*file, &config); err != nil {
+			return nil, err
+		}
+	}
+	var errs []error
+	for _, f := range fields(&config) {
+		if v, found := os.LookupEnv(f.env); found && v != "" {
+			if err := f.set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+			}
+		}
+		if v, found := overrides[f.key]; found {
+			if err := f.set(v); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", f.key, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	config.resolve()
+	if err := config.Validate(services...); err != nil {
+		return nil, err
+	}
+	if *printConfig {
+		if err := config.Print(w); err != nil {
+			return nil, err
+		}
+		return nil, ErrPrinted
+	}
+	return &config, nil
+}
+
+// MustLoad loads the config of the command line for [services]. It exits after the help or '-print-config',
+// and on an invalid config.
+func MustLoad(services ...Service) *Config {
+	config, err := Load(filepath.Base(os.Args[0]), os.Args[1:], os.Stdout, services...)
+	if errors.Is(err, ErrPrinted) || errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+		os.Exit(2)
+	}
+	return config
+}
+
+// decodeFile decodes the YAML or TOML file by the extension. Unknown keys are errors to find typos.
+func decodeFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("cannot decode config file '%s': %w", path, err)
+		}
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(config); err != nil {
+			return fmt.Errorf("cannot decode config file '%s': %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unknown config file extension '%s', want '.yaml', '.yml' or '.toml'", ext)
+	}
+	return nil
+}
+
+// Print writes the config as YAML. The secrets are masked.
+func (c Config) Print(w io.Writer) error {
+	for _, f := range fields(&c) {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString(redact.Mask)
+		}
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// field is a value of the config, e.g. 'auth.port'.
+type field struct {
+	// the key in the file, and the name of the flag
+	key    string
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// fields returns the values of [config] in the order of the declaration.
+func fields(config *Config) []field {
+	var fields []field
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			sf := v.Type().Field(i)
+			key := prefix + sf.Tag.Get("yaml")
+			if sf.Type.Kind() == reflect.Struct {
+				walk(key+".", v.Field(i))
+				continue
+			}
+			fields = append(fields, field{
+				key:    key,
+				env:    sf.Tag.Get("env"),
+				usage:  sf.Tag.Get("usage"),
+				secret: sf.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(config).Elem())
+	return fields
+}
+
+func (f field) set(s string) error {
+	if u, ok := f.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(s)
+	case reflect.Int:
+		v, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("'%s' is not a number", s)
+		}
+		f.value.SetInt(int64(v))
+	case reflect.Bool:
+		v, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", s)
+		}
+		f.value.SetBool(v)
+	default:
+		return fmt.Errorf("unsupported kind %s", f.value.Kind())
+	}
+	return nil
+}
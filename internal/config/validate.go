@@ -0,0 +1,257 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/yyyoichi/OhAuth0.1/internal/tracing"
+)
+
+// Service is a program which uses the config. [Config.Validate] checks the values it uses.
+type Service string
+
+const (
+	ServiceDatabase Service = "database"
+	ServiceAuth     Service = "auth"
+	ServiceResource Service = "resource"
+	// the CLI of the service client
+	ServiceClient Service = "client"
+	// the all-in-one server, which runs the services of 'server.services'
+	ServiceServer Service = "server"
+)
+
+// Validate checks the values used by [services], and returns all the problems found.
+func (c *Config) Validate(services ...Service) error {
+	var v validator
+	v.oneOf("tracing.exporter", c.Tracing.Exporter, "", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP)
+	if c.Tracing.Exporter == tracing.ExporterOTLP && c.Tracing.Endpoint != "" {
+		v.endpoint("tracing.endpoint", c.Tracing.Endpoint)
+	}
+	v.nonNegative("shutdown.drain_timeout", int64(c.Shutdown.DrainTimeout))
+	// the in-process database of the all-in-one server generates the keys of its callers
+	inProcess := slices.Contains(services, ServiceServer) && c.Server.Transport == TransportMemory
+	if slices.Contains(services, ServiceServer) {
+		services = append(slices.Clone(services), c.validateServer(&v)...)
+	}
+	for _, service := range services {
+		switch service {
+		case ServiceDatabase:
+			v.port("database.port", c.Database.Port)
+			v.oneOf("database.storage", c.Database.Storage, "", "memory", "file")
+			if c.Database.Storage == "file" {
+				v.required("database.data_dir", c.Database.DataDir)
+				v.required("database.token_pepper", c.Database.TokenPepper)
+			}
+			v.nonNegative("database.sweep_interval", int64(c.Database.SweepInterval))
+			v.nonNegative("database.sweep_grace_period", int64(c.Database.SweepGracePeriod))
+			v.pair("database.tls.cert", c.Database.TLS.Cert, "database.tls.key", c.Database.TLS.Key)
+			if c.Database.TLS.ClientCA != "" {
+				v.required("database.tls.cert", c.Database.TLS.Cert)
+			}
+			if !inProcess {
+				c.validateCallers(&v)
+			}
+			// seeded to the mock rows
+			v.url("resource.url", c.Resource.URL)
+			v.url("client.redirect_uri", c.Client.RedirectURI)
+			v.endpoint("client.logout_uri", c.Client.LogoutURI)
+		case ServiceAuth:
+			v.port("auth.port", c.Auth.Port)
+			v.url("auth.url", c.Auth.URL)
+			v.url("ui.url", c.UI.URL)
+			v.pair("auth.tls.cert", c.Auth.TLS.Cert, "auth.tls.key", c.Auth.TLS.Key)
+			v.https("auth.url", c.Auth.URL, "auth.tls.cert", c.Auth.TLS.Cert)
+			if c.Auth.TLS.ClientCA != "" {
+				v.required("auth.tls.cert", c.Auth.TLS.Cert)
+			}
+			c.validateDatabaseClient(&v)
+			v.pair("auth.database_cert", c.Auth.DatabaseCert, "auth.database_key", c.Auth.DatabaseKey)
+			if _, err := c.Auth.TokenExchangeRules(); err != nil {
+				v.add("auth.token_exchange", err.Error())
+			}
+		case ServiceResource:
+			v.port("resource.port", c.Resource.Port)
+			v.url("resource.url", c.Resource.URL)
+			v.pair("resource.tls.cert", c.Resource.TLS.Cert, "resource.tls.key", c.Resource.TLS.Key)
+			v.https("resource.url", c.Resource.URL, "resource.tls.cert", c.Resource.TLS.Cert)
+			c.validateDatabaseClient(&v)
+			v.pair("resource.database_cert", c.Resource.DatabaseCert, "resource.database_key", c.Resource.DatabaseKey)
+			if c.Resource.TokenCacheSize < -1 {
+				v.add("resource.token_cache_size", "must be -1 or more")
+			}
+			v.nonNegative("resource.token_cache_ttl", int64(c.Resource.TokenCacheTTL))
+		case ServiceServer:
+			// validated above
+		case ServiceClient:
+			v.port("client.redirect_port", c.Client.RedirectPort)
+			v.url("client.redirect_uri", c.Client.RedirectURI)
+			v.pair("client.tls.cert", c.Client.TLS.Cert, "client.tls.key", c.Client.TLS.Key)
+			v.https("client.redirect_uri", c.Client.RedirectURI, "client.tls.cert", c.Client.TLS.Cert)
+			v.port("client.logout_port", c.Client.LogoutPort)
+			v.endpoint("client.logout_uri", c.Client.LogoutURI)
+			v.https("client.logout_uri", c.Client.LogoutURI, "client.tls.cert", c.Client.TLS.Cert)
+			if c.Client.LogoutPort == c.Client.RedirectPort {
+				v.add("client.logout_port", fmt.Sprintf("client.redirect_port listens on the same port %d", c.Client.LogoutPort))
+			}
+			v.url("auth.url", c.Auth.URL)
+			v.url("resource.url", c.Resource.URL)
+			v.url("ui.url", c.UI.URL)
+		default:
+			v.add("service", fmt.Sprintf("unknown service '%s'", service))
+		}
+	}
+	return v.err()
+}
+
+// validateServer checks 'server', and returns the services to run.
+func (c *Config) validateServer(v *validator) []Service {
+	v.oneOf("server.transport", c.Server.Transport, TransportMemory, TransportConnect)
+	services := c.Server.List()
+	if len(services) == 0 {
+		v.add("server.services", "is required")
+	}
+	ports := map[int]Service{}
+	for _, service := range services {
+		var port int
+		switch service {
+		case ServiceDatabase:
+			if c.Server.Transport == TransportMemory {
+				if c.Database.Storage == "file" {
+					v.add("database.storage", "must be 'memory' with server.transport 'memory'")
+				}
+				continue
+			}
+			port = c.Database.Port
+		case ServiceAuth:
+			port = c.Auth.Port
+		case ServiceResource:
+			port = c.Resource.Port
+		default:
+			v.add("server.services", fmt.Sprintf("unknown service '%s'", service))
+			continue
+		}
+		if other, ok := ports[port]; ok && other != service {
+			v.add("server.services", fmt.Sprintf("%s and %s listen on the same port %d", other, service, port))
+		}
+		ports[port] = service
+	}
+	if c.Server.Transport == TransportMemory && !slices.Contains(services, ServiceDatabase) &&
+		(slices.Contains(services, ServiceAuth) || slices.Contains(services, ServiceResource)) {
+		v.add("server.services", "must have 'database' with server.transport 'memory'")
+	}
+	return slices.DeleteFunc(services, func(s Service) bool {
+		return s != ServiceDatabase && s != ServiceAuth && s != ServiceResource
+	})
+}
+
+// validateCallers checks that the database server authenticates the callers, unless it is insecure.
+func (c *Config) validateCallers(v *validator) {
+	if c.Database.Insecure {
+		return
+	}
+	hasKey := c.Database.AuthServerKey != "" || c.Database.ResourceServerKey != ""
+	if !hasKey && c.Database.TLS.ClientCA == "" {
+		v.add("database.auth_server_key", "is required without database.tls.client_ca or database.insecure")
+	}
+	if hasKey && c.Database.TLS.Cert == "" {
+		v.add("database.tls.cert", "is required to receive the keys without database.insecure")
+	}
+}
+
+func (c *Config) validateDatabaseClient(v *validator) {
+	v.url("database.url", c.Database.URL)
+	v.nonNegative("database.client_timeout", int64(c.Database.ClientTimeout))
+	v.https("database.url", c.Database.URL, "database.tls.ca", c.Database.TLS.CA)
+}
+
+// validator collects the problems, each once.
+type validator struct {
+	errs []error
+	seen map[string]bool
+}
+
+func (v *validator) add(key, problem string) {
+	msg := fmt.Sprintf("%s: %s", key, problem)
+	if v.seen == nil {
+		v.seen = map[string]bool{}
+	}
+	if v.seen[msg] {
+		return
+	}
+	v.seen[msg] = true
+	v.errs = append(v.errs, errors.New(msg))
+}
+
+func (v *validator) err() error {
+	return errors.Join(v.errs...)
+}
+
+func (v *validator) required(key, value string) {
+	if value == "" {
+		v.add(key, "is required")
+	}
+}
+
+func (v *validator) port(key string, port int) {
+	if port < 1 || port > 65535 {
+		v.add(key, fmt.Sprintf("%d is not a port", port))
+	}
+}
+
+func (v *validator) nonNegative(key string, value int64) {
+	if value < 0 {
+		v.add(key, "must not be negative")
+	}
+}
+
+func (v *validator) oneOf(key, value string, allowed ...string) {
+	if slices.Contains(allowed, value) {
+		return
+	}
+	v.add(key, fmt.Sprintf("'%s' is not one of '%s'", value, strings.Join(slices.DeleteFunc(allowed, func(a string) bool { return a == "" }), "', '")))
+}
+
+// url checks an absolute http(s) URL without a trailing path, e.g. 'https://auth.example.com:8443'.
+func (v *validator) url(key, value string) {
+	u, err := url.Parse(value)
+	switch {
+	case err != nil:
+		v.add(key, err.Error())
+	case u.Scheme != "http" && u.Scheme != "https":
+		v.add(key, fmt.Sprintf("'%s' is not an http or https URL", value))
+	case u.Host == "":
+		v.add(key, fmt.Sprintf("'%s' has no host", value))
+	case u.Path != "" || u.RawQuery != "" || u.Fragment != "":
+		v.add(key, fmt.Sprintf("'%s' must not have a path, query or fragment", value))
+	}
+}
+
+// endpoint checks that the value is an http or https URL, which may have a path.
+func (v *validator) endpoint(key, value string) {
+	u, err := url.Parse(value)
+	switch {
+	case err != nil:
+		v.add(key, err.Error())
+	case u.Scheme != "http" && u.Scheme != "https":
+		v.add(key, fmt.Sprintf("'%s' is not an http or https URL", value))
+	case u.Host == "":
+		v.add(key, fmt.Sprintf("'%s' has no host", value))
+	}
+}
+
+// https checks that the URL is https if the TLS file is set.
+func (v *validator) https(key, value, tlsKey, tlsFile string) {
+	if tlsFile != "" && strings.HasPrefix(value, "http://") {
+		v.add(key, fmt.Sprintf("must be https with %s", tlsKey))
+	}
+}
+
+// pair checks that both or neither of the files are set, e.g. a certificate and its key.
+func (v *validator) pair(key1, value1, key2, value2 string) {
+	if (value1 == "") != (value2 == "") {
+		v.add(key1, fmt.Sprintf("must be set with %s", key2))
+	}
+}
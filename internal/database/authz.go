@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/yyyoichi/OhAuth0.1/api/v1/apiv1connect"
+)
+
+var (
+	ErrUnauthenticated  = errors.New("unauthenticated")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Role is what a caller may do with the database service.
+type Role string
+
+const (
+	// the authorization server calls every RPC.
+	RoleAuthServer Role = "auth"
+	// the resource server reads tokens, and reads and updates the profiles it serves.
+	// the passwords of the users are cleared for it.
+	RoleResourceServer Role = "resource"
+)
+
+// procedures allowed for each role. nil allows all.
+var rolePermissions = map[Role][]string{
+	RoleAuthServer: nil,
+	RoleResourceServer: {
+		apiv1connect.DatabaseServiceGetAccessTokenUnaryProcedure,
+		apiv1connect.DatabaseServiceGetAccessTokenProcedure,
+		apiv1connect.DatabaseServiceBatchGetAccessTokensProcedure,
+		apiv1connect.DatabaseServiceGetUserUnaryProcedure,
+		apiv1connect.DatabaseServiceGetUserProcedure,
+		apiv1connect.DatabaseServiceUpdateUserProfileUnaryProcedure,
+		apiv1connect.DatabaseServiceUpdateUserProfileProcedure,
+		apiv1connect.DatabaseServiceWatchProcedure,
+		apiv1connect.DatabaseServicePingProcedure,
+	},
+}
+
+func (r Role) allows(procedure string) bool {
+	procedures, found := rolePermissions[r]
+	return found && (procedures == nil || slices.Contains(procedures, procedure))
+}
+
+// common names of the client certificates of the servers
+const (
+	AuthServerName     = "auth-server"
+	ResourceServerName = "resource-server"
+)
+
+// Callers returns the authorization server and the resource server with their keys.
+// A server without a key must present a client certificate.
+func Callers(authServerKey, resourceServerKey string) []Caller {
+	return []Caller{
+		{Name: AuthServerName, Role: RoleAuthServer, Key: authServerKey},
+		{Name: ResourceServerName, Role: RoleResourceServer, Key: resourceServerKey},
+	}
+}
+
+// Caller is a service allowed to call the database service.
+type Caller struct {
+	// common name of the client certificate of the caller
+	Name string
+	Role Role
+	// shared key sent as a bearer token by a caller without a client certificate. Optional.
+	Key string
+}
+
+type (
+	tlsStateKey struct{}
+	callerKey   struct{}
+)
+
+// callerFrom returns the caller authenticated by the interceptor. It is not found if the server accepts any caller.
+func callerFrom(ctx context.Context) (*Caller, bool) {
+	caller, ok := ctx.Value(callerKey{}).(*Caller)
+	return caller, ok
+}
+
+// withTLSState passes the TLS connection of the request to the interceptors, which cannot see it.
+func withTLSState(path string, next http.Handler) (string, http.Handler) {
+	return path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			r = r.WithContext(context.WithValue(r.Context(), tlsStateKey{}, r.TLS))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authInterceptor authenticates the callers by their verified client certificates or keys,
+// and authorizes each RPC by their roles.
+type authInterceptor struct {
+	callers []Caller
+	// accepts the keys over plain HTTP
+	insecure bool
+}
+
+func newAuthInterceptor(callers []Caller, insecure bool) *authInterceptor {
+	return &authInterceptor{callers: callers, insecure: insecure}
+}
+
+// authenticate returns the caller of a request.
+func (i *authInterceptor) authenticate(ctx context.Context, header http.Header) (*Caller, error) {
+	state, ok := ctx.Value(tlsStateKey{}).(*tls.ConnectionState)
+	if ok && len(state.VerifiedChains) > 0 {
+		name := state.VerifiedChains[0][0].Subject.CommonName
+		for _, c := range i.callers {
+			if c.Name == name {
+				return &c, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: unknown certificate '%s'", ErrUnauthenticated, name)
+	}
+	key, found := strings.CutPrefix(header.Get("Authorization"), "Bearer ")
+	if !found || key == "" {
+		return nil, fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+	}
+	// a key sent in plain text may be read on the way
+	if !ok && !i.insecure {
+		return nil, fmt.Errorf("%w: key over plain HTTP", ErrUnauthenticated)
+	}
+	for _, c := range i.callers {
+		if c.Key != "" && subtle.ConstantTimeCompare([]byte(c.Key), []byte(key)) == 1 {
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown key", ErrUnauthenticated)
+}
+
+// authorize returns [ctx] with the caller, which the handlers read by [callerFrom].
+func (i *authInterceptor) authorize(ctx context.Context, procedure string, header http.Header) (context.Context, error) {
+	caller, err := i.authenticate(ctx, header)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeUnauthenticated, err)
+	}
+	if !caller.Role.allows(procedure) {
+		return nil, connect.NewError(connect.CodePermissionDenied, fmt.Errorf("%w: '%s' cannot call %s", ErrPermissionDenied, caller.Name, procedure))
+	}
+	return context.WithValue(ctx, callerKey{}, caller), nil
+}
+
+func (i *authInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		ctx, err := i.authorize(ctx, req.Spec().Procedure, req.Header())
+		if err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+func (i *authInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *authInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		ctx, err := i.authorize(ctx, conn.Spec().Procedure, conn.RequestHeader())
+		if err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	}
+}
+
+// keyInterceptor sends the key of the client as a bearer token.
+type keyInterceptor struct {
+	key string
+}
+
+func (i *keyInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		req.Header().Set("Authorization", "Bearer "+i.key)
+		return next(ctx, req)
+	}
+}
+
+func (i *keyInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		conn := next(ctx, spec)
+		conn.RequestHeader().Set("Authorization", "Bearer "+i.key)
+		return conn
+	}
+}
+
+func (i *keyInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
+)
+
+func TestRolePermissions(t *testing.T) {
+	assert.True(t, RoleAuthServer.allows("/api.v1.DatabaseService/CreateAccessToken"))
+	assert.True(t, RoleResourceServer.allows("/api.v1.DatabaseService/GetAccessToken"))
+	assert.False(t, RoleResourceServer.allows("/api.v1.DatabaseService/CreateAccessToken"))
+	assert.False(t, RoleResourceServer.allows("/api.v1.DatabaseService/GetServiceClient"))
+	assert.False(t, Role("unknown").allows("/api.v1.DatabaseService/Ping"))
+}
+
+func TestServerAuthentication(t *testing.T) {
+	ca, err := pki.NewCA("test CA")
+	assert.NoError(t, err)
+	serverCert, err := ca.IssueServerCertificate("localhost")
+	assert.NoError(t, err)
+	port := "3370"
+	_, err = NewDatabaseServer(context.Background(), ServerConfig{
+		Port: port,
+		TLS: &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    ca.CertPool(),
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+		},
+		Callers: Callers("auth-key", ""),
+	})
+	assert.NoError(t, err)
+
+	newClient := func(cert *tls.Certificate, key string) *Client {
+		config := &tls.Config{RootCAs: ca.CertPool()}
+		if cert != nil {
+			config.Certificates = []tls.Certificate{*cert}
+		}
+		client, err := NewDatabaseClient(context.Background(), ClientConfig{
+			URL: "https://localhost:" + port,
+			TLS: config,
+			Key: key,
+		})
+		assert.NoError(t, err)
+		return client
+	}
+	ctx := context.Background()
+	auth := newClient(nil, "auth-key")
+	// wait for the server
+	require.Eventually(t, func() bool {
+		_, err := auth.GetUserById(ctx, "1")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	t.Run("auth server by key", func(t *testing.T) {
+		assert.NoError(t, auth.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "authz-token"}))
+		_, err := auth.GetServieClientById(ctx, "500")
+		assert.NoError(t, err)
+		user, err := auth.GetUserById(ctx, "1")
+		assert.NoError(t, err)
+		assert.NotEmpty(t, user.Password)
+		user, err = auth.UpdateUserProfile(ctx, "1", "by auth server")
+		assert.NoError(t, err)
+		assert.NotEmpty(t, user.Password)
+	})
+	t.Run("resource server by certificate", func(t *testing.T) {
+		cert, err := ca.IssueClientCertificate(ResourceServerName)
+		assert.NoError(t, err)
+		resource := newClient(&cert, "")
+		token, err := resource.GetAccessTokenByToken(ctx, "authz-token")
+		assert.NoError(t, err)
+		assert.Equal(t, "authz-token", token.Token)
+		// the password is of the authorization server only
+		user, err := resource.UpdateUserProfile(ctx, "1", "by resource server")
+		assert.NoError(t, err)
+		assert.Equal(t, "by resource server", user.Profile)
+		assert.Empty(t, user.Password)
+		user, err = resource.CompareAndSwapUserProfile(ctx, "1", user.Version, "by resource server again")
+		assert.NoError(t, err)
+		assert.Empty(t, user.Password)
+		user, err = resource.GetUserById(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "by resource server again", user.Profile)
+		assert.Empty(t, user.Password)
+		// so are the streams
+		updates := resource.client.UpdateUserProfile(ctx)
+		assert.NoError(t, updates.Send(&apiv1.UpdateUserProfileRequest{Id: "1", Profile: "by stream"}))
+		resp, err := updates.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, "by stream", resp.GetUser().GetProfile())
+		assert.Empty(t, resp.GetUser().GetPassword())
+		assert.NoError(t, updates.CloseRequest())
+		assert.NoError(t, updates.CloseResponse())
+		err = resource.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "minted"})
+		assert.ErrorIs(t, err, ErrPermissionDenied)
+		_, err = resource.GetServieClientById(ctx, "500")
+		assert.ErrorIs(t, err, ErrPermissionDenied)
+		// streams are authorized as well
+		stream := resource.client.CreateAccessToken(ctx)
+		_ = stream.Send(&apiv1.CreateAccessTokenRequest{Token: &apiv1.AccessToken{Token: "minted"}})
+		_, err = stream.Receive()
+		assert.ErrorIs(t, resource.parseConnectError(err), ErrPermissionDenied)
+	})
+	t.Run("unauthenticated", func(t *testing.T) {
+		_, err := newClient(nil, "").GetUserById(ctx, "1")
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+		_, err = newClient(nil, "wrong").GetUserById(ctx, "1")
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+		// a certificate of an unknown service
+		cert, err := ca.IssueClientCertificate("someone")
+		assert.NoError(t, err)
+		_, err = newClient(&cert, "auth-key").GetUserById(ctx, "1")
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+		// a certificate of another CA is rejected by TLS
+		other, err := pki.NewCA("other CA")
+		assert.NoError(t, err)
+		cert, err = other.IssueClientCertificate(AuthServerName)
+		assert.NoError(t, err)
+		_, err = newClient(&cert, "").GetUserById(ctx, "1")
+		assert.Error(t, err)
+	})
+	t.Run("plaintext", func(t *testing.T) {
+		client, err := NewDatabaseClient(ctx, ClientConfig{URL: "http://localhost:" + port, Key: "auth-key"})
+		assert.NoError(t, err)
+		_, err = client.GetUserById(ctx, "1")
+		assert.Error(t, err)
+	})
+}
+
+func TestServerRequiresCallers(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewDatabaseServer(ctx, ServerConfig{Port: "3374"})
+	assert.Error(t, err)
+
+	// the keys are sent in plain text without TLS
+	newServer := func(port string, insecure bool) *Client {
+		_, err := NewDatabaseServer(ctx, ServerConfig{Port: port, Callers: Callers("auth-key", ""), Insecure: insecure})
+		assert.NoError(t, err)
+		client, err := NewDatabaseClient(ctx, ClientConfig{URL: "http://localhost:" + port, Key: "auth-key"})
+		assert.NoError(t, err)
+		return client
+	}
+	secure := newServer("3375", false)
+	assert.Eventually(t, func() bool {
+		_, err := secure.GetUserById(ctx, "1")
+		return errors.Is(err, ErrUnauthenticated)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	insecure := newServer("3376", true)
+	assert.Eventually(t, func() bool {
+		_, err := insecure.GetUserById(ctx, "1")
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
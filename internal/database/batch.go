@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+)
+
+// Batch is the rows created together by [Storage.BatchCreate].
+type Batch struct {
+	AuthorizationCodes []*apiv1.AuthorizationCode
+	AccessTokens       []*apiv1.AccessToken
+	RefreshTokens      []*apiv1.RefreshToken
+}
+
+func (b Batch) records() []*apiv1.StorageRecord {
+	records := make([]*apiv1.StorageRecord, 0, len(b.AuthorizationCodes)+len(b.AccessTokens)+len(b.RefreshTokens))
+	for _, row := range b.AuthorizationCodes {
+		records = append(records, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AuthorizationCode{AuthorizationCode: row}})
+	}
+	for _, row := range b.AccessTokens {
+		records = append(records, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AccessToken{AccessToken: row}})
+	}
+	for _, row := range b.RefreshTokens {
+		records = append(records, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_RefreshToken{RefreshToken: row}})
+	}
+	return records
+}
+
+// exists reports whether any row of [b] is in [db] or appears twice in [b]. must be called with db.mu held.
+func (db *Database) exists(b Batch) bool {
+	codes := make(map[string]struct{}, len(b.AuthorizationCodes))
+	for _, row := range b.AuthorizationCodes {
+		if _, found := db.authorizationCodeByCode[row.Code]; found {
+			return true
+		}
+		if _, found := codes[row.Code]; found {
+			return true
+		}
+		codes[row.Code] = struct{}{}
+	}
+	accessTokens := make(map[string]struct{}, len(b.AccessTokens))
+	for _, row := range b.AccessTokens {
+		if _, found := db.accessTokenByToken[row.Token]; found {
+			return true
+		}
+		if _, found := accessTokens[row.Token]; found {
+			return true
+		}
+		accessTokens[row.Token] = struct{}{}
+	}
+	refreshTokens := make(map[string]struct{}, len(b.RefreshTokens))
+	for _, row := range b.RefreshTokens {
+		if _, found := db.refreshTokenByToken[row.Token]; found {
+			return true
+		}
+		if _, found := refreshTokens[row.Token]; found {
+			return true
+		}
+		refreshTokens[row.Token] = struct{}{}
+	}
+	return false
+}
+
+// BatchCreate creates all rows of [b], or returns [ErrAlreadyExists] without creating any.
+func (db *Database) BatchCreate(ctx context.Context, b Batch) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.exists(b) {
+		return ErrAlreadyExists
+	}
+	for _, record := range b.records() {
+		db.applyLocked(record)
+	}
+	return nil
+}
+
+// BatchCreate implements Storage. The rows are logged by a single write,
+// though a crash in the middle of it may keep the first rows of [b].
+func (s *FileStorage) BatchCreate(ctx context.Context, b Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.mu.RLock()
+	exists := s.mem.exists(b)
+	s.mem.mu.RUnlock()
+	if exists {
+		return ErrAlreadyExists
+	}
+	return s.write(b.records()...)
+}
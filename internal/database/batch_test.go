@@ -0,0 +1,215 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/api/v1/apiv1connect"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// batchTestClient connects to a server shared by the tests and benchmarks of this file.
+var batchTestClient = sync.OnceValues(func() (*Client, error) {
+	port := "3368"
+	if _, err := NewDatabaseServer(context.Background(), ServerConfig{Port: port, Insecure: true}); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	client, err := NewDatabaseClient(ctx, ClientConfig{
+		URL: "http://localhost:" + port,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// waits for the server
+	wait, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	for client.Ping(wait) != nil {
+		if err := wait.Err(); err != nil {
+			return nil, fmt.Errorf("server is not ready: %w", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return client, nil
+})
+
+func TestBatchGetAccessTokens(t *testing.T) {
+	client, err := batchTestClient()
+	assert.NoError(t, err)
+	ctx := context.Background()
+	err = client.BatchCreate(ctx, Batch{
+		AccessTokens: []*apiv1.AccessToken{
+			{Token: "batch-get-1", UserId: "1", Expires: timestamppb.Now()},
+			{Token: "batch-get-2", UserId: "2", Expires: timestamppb.Now()},
+		},
+	})
+	assert.NoError(t, err)
+
+	tokens, err := client.BatchGetAccessTokens(ctx, []string{"batch-get-1", "notfound", "batch-get-2"})
+	assert.NoError(t, err)
+	if assert.Len(t, tokens, 2) {
+		assert.Equal(t, "batch-get-1", tokens[0].Token)
+		assert.Equal(t, "1", tokens[0].UserId)
+		assert.Equal(t, "batch-get-2", tokens[1].Token)
+		assert.Equal(t, "2", tokens[1].UserId)
+	}
+	tokens, err = client.BatchGetAccessTokens(ctx, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+}
+
+func TestStream(t *testing.T) {
+	client, err := batchTestClient()
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	stream := client.client.GetUser(ctx)
+	for _, id := range []string{"1", "2"} {
+		assert.NoError(t, stream.Send(&apiv1.GetUserRequest{Id: id}))
+		resp, err := stream.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, id, resp.GetUser().GetId())
+	}
+	// the stream ends at the first error
+	assert.NoError(t, stream.Send(&apiv1.GetUserRequest{Id: "999"}))
+	_, err = stream.Receive()
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	assert.NoError(t, stream.CloseRequest())
+	assert.NoError(t, stream.CloseResponse())
+}
+
+func TestStreamDeadlines(t *testing.T) {
+	const timeout = time.Duration(100) * time.Millisecond
+	db, err := NewDatabase()
+	assert.NoError(t, err)
+	newClient := func(wrap func(string, http.Handler) (string, http.Handler)) apiv1connect.DatabaseServiceClient {
+		mux := http.NewServeMux()
+		mux.Handle(wrap(apiv1connect.NewDatabaseServiceHandler(&handler{Storage: db, hasher: &TokenHasher{}})))
+		server := httptest.NewUnstartedServer(h2c.NewHandler(mux, &http2.Server{}))
+		server.Config.ReadTimeout, server.Config.WriteTimeout = timeout, timeout
+		server.Start()
+		t.Cleanup(server.Close)
+		client, err := NewDatabaseClient(context.Background(), ClientConfig{URL: server.URL})
+		assert.NoError(t, err)
+		return client.client
+	}
+	// the stream is used again after the timeouts
+	hold := func(client apiv1connect.DatabaseServiceClient) error {
+		stream := client.GetUser(context.Background())
+		defer stream.CloseResponse()
+		for range 2 {
+			if err := stream.Send(&apiv1.GetUserRequest{Id: "1"}); err != nil {
+				return err
+			}
+			if _, err := stream.Receive(); err != nil {
+				return err
+			}
+			time.Sleep(3 * timeout)
+		}
+		return stream.CloseRequest()
+	}
+	assert.Error(t, hold(newClient(func(path string, h http.Handler) (string, http.Handler) { return path, h })))
+	assert.NoError(t, hold(newClient(withoutStreamDeadlines)))
+
+	// the unary RPCs keep them
+	assert.True(t, streamingProcedures[apiv1connect.DatabaseServiceGetUserProcedure])
+	assert.True(t, streamingProcedures[apiv1connect.DatabaseServiceWatchProcedure])
+	assert.False(t, streamingProcedures[apiv1connect.DatabaseServiceGetUserUnaryProcedure])
+}
+
+func BenchmarkGetAccessToken(b *testing.B) {
+	client, err := batchTestClient()
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	token := "bench-token"
+	if err := client.CreateAccessToken(ctx, &apiv1.AccessToken{Token: token, Expires: timestamppb.Now()}); err != nil && !errors.Is(err, ErrAlreadyExists) {
+		b.Fatal(err)
+	}
+
+	b.Run("unary", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := client.GetAccessTokenByToken(ctx, token); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	// a new stream per call, as the client did before the unary RPCs
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			stream := client.client.GetAccessToken(ctx)
+			if err := stream.Send(&apiv1.GetAccessTokenRequest{Token: token}); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := stream.Receive(); err != nil {
+				b.Fatal(err)
+			}
+			stream.CloseRequest()
+			stream.CloseResponse()
+		}
+	})
+	b.Run("pipelined", func(b *testing.B) {
+		b.ReportAllocs()
+		stream := client.client.GetAccessToken(ctx)
+		defer stream.CloseResponse()
+		defer stream.CloseRequest()
+		for range b.N {
+			if err := stream.Send(&apiv1.GetAccessTokenRequest{Token: token}); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := stream.Receive(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkBatchGetAccessTokens(b *testing.B) {
+	client, err := batchTestClient()
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	tokens := make([]string, 10)
+	var batch Batch
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("bench-batch-token-%d", i)
+		batch.AccessTokens = append(batch.AccessTokens, &apiv1.AccessToken{Token: tokens[i], Expires: timestamppb.Now()})
+	}
+	if err := client.BatchCreate(ctx, batch); err != nil && !errors.Is(err, ErrAlreadyExists) {
+		b.Fatal(err)
+	}
+
+	b.Run("unary", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			for _, token := range tokens {
+				if _, err := client.GetAccessTokenByToken(ctx, token); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := client.BatchGetAccessTokens(ctx, tokens); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -3,19 +3,46 @@ package database
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"connectrpc.com/connect"
 	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
 	"github.com/yyyoichi/OhAuth0.1/api/v1/apiv1connect"
+	"github.com/yyyoichi/OhAuth0.1/internal/dberr"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+	"github.com/yyyoichi/OhAuth0.1/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/http2"
 )
 
 type (
 	ClientConfig struct {
+		// 'https://' to connect with TLS
 		URL string
+		// trusted CAs and the client certificate for TLS. Optional.
+		TLS *tls.Config
+		// sent as a bearer token to authenticate without a client certificate. Optional.
+		Key string
+		// deadline of each unary call. default 3 seconds. negative disables it.
+		Timeout time.Duration
+		// deadlines of the procedures, e.g. [apiv1connect.DatabaseServiceBatchCreateProcedure], overriding Timeout.
+		Timeouts map[string]time.Duration
+		// retries of the idempotent reads which the server failed to answer
+		Retry RetryConfig
+		// fails the calls fast while the server keeps failing
+		Breaker BreakerConfig
+		// observes each attempt of the calls. Optional.
+		Metrics *interceptor.Metrics
+		// traces the calls, including their retries. Optional.
+		TracerProvider trace.TracerProvider
+		// connects to the server of a plaintext URL instead of the network, e.g. [InProcessServer.Dial]. Optional.
+		Dial func(ctx context.Context, network, addr string) (net.Conn, error)
 	}
 	Client struct {
 		client apiv1connect.DatabaseServiceClient
@@ -23,147 +50,284 @@ type (
 )
 
 func NewDatabaseClient(ctx context.Context, config ClientConfig) (*Client, error) {
-	httpClient := &http.Client{
-		Transport: &http2.Transport{
-			AllowHTTP: true,
-			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
-				return net.Dial(network, addr)
-			},
-			ReadIdleTimeout: time.Duration(10) * time.Second, //10s接続がなかったらpingを開始
-			PingTimeout:     time.Duration(15) * time.Second, //15sのpingに対する応答を待機
-		},
+	transport := &http2.Transport{
+		ReadIdleTimeout: time.Duration(10) * time.Second, //10s接続がなかったらpingを開始
+		PingTimeout:     time.Duration(15) * time.Second, //15sのpingに対する応答を待機
+	}
+	if strings.HasPrefix(config.URL, "https://") {
+		transport.TLSClientConfig = config.TLS
+	} else {
+		transport.AllowHTTP = true
+		dial := config.Dial
+		if dial == nil {
+			var dialer net.Dialer
+			dial = dialer.DialContext
+		}
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		}
+	}
+	var interceptors []connect.Interceptor
+	if config.TracerProvider != nil {
+		tracer, err := tracing.NewInterceptor(config.TracerProvider)
+		if err != nil {
+			return nil, err
+		}
+		interceptors = append(interceptors, tracer)
+	}
+	// each attempt of the retries is logged and observed
+	interceptors = append(interceptors,
+		interceptor.NewRequestID(),
+		newResilienceInterceptor(config),
+		interceptor.NewLogging(nil),
+	)
+	if config.Metrics != nil {
+		interceptors = append(interceptors, config.Metrics)
+	}
+	if config.Key != "" {
+		interceptors = append(interceptors, &keyInterceptor{key: config.Key})
 	}
 	var client Client
 	client.client = apiv1connect.NewDatabaseServiceClient(
-		httpClient,
+		&http.Client{Transport: transport},
 		config.URL,
+		connect.WithInterceptors(interceptors...),
 	)
 	return &client, nil
 }
 
 func (c *Client) GetUserById(ctx context.Context, id string) (*apiv1.UserProfile, error) {
-	cc := c.client.GetUser(ctx)
-	if err := cc.Send(&apiv1.GetUserRequest{
+	resp, err := c.client.GetUserUnary(ctx, connect.NewRequest(&apiv1.GetUserRequest{
 		Id: id,
-	}); err != nil {
-		return nil, err
+	}))
+	if err != nil {
+		return nil, c.parseConnectError(err)
 	}
-	resp, err := cc.Receive()
+	return resp.Msg.GetUser(), nil
+}
+
+func (c *Client) UpdateUserProfile(ctx context.Context, id, profile string) (*apiv1.UserProfile, error) {
+	resp, err := c.client.UpdateUserProfileUnary(ctx, connect.NewRequest(&apiv1.UpdateUserProfileRequest{
+		Id:      id,
+		Profile: profile,
+	}))
 	if err != nil {
-		err := c.parseConnectError(err)
-		return nil, err
+		return nil, c.parseConnectError(err)
 	}
-	return resp.GetUser(), nil
+	return resp.Msg.GetUser(), nil
+}
+
+// CompareAndSwapUserProfile updates the profile if the user is at [version]. zero [version] matches any.
+func (c *Client) CompareAndSwapUserProfile(ctx context.Context, id string, version uint64, profile string) (*apiv1.UserProfile, error) {
+	resp, err := c.client.UpdateUserProfileUnary(ctx, connect.NewRequest(&apiv1.UpdateUserProfileRequest{
+		Id:      id,
+		Profile: profile,
+		Version: version,
+	}))
+	if err != nil {
+		return nil, c.parseConnectError(err)
+	}
+	return resp.Msg.GetUser(), nil
 }
 
 func (c *Client) GetServieClientById(ctx context.Context, id string) (*apiv1.ServiceClient, error) {
-	cc := c.client.GetServiceClient(ctx)
-	if err := cc.Send(&apiv1.GetServiceClientRequest{
+	resp, err := c.client.GetServiceClientUnary(ctx, connect.NewRequest(&apiv1.GetServiceClientRequest{
 		Id: id,
-	}); err != nil {
-		return nil, err
-	}
-	resp, err := cc.Receive()
+	}))
 	if err != nil {
-		err := c.parseConnectError(err)
-		return nil, err
+		return nil, c.parseConnectError(err)
 	}
-	return resp.GetClient(), nil
+	return resp.Msg.GetClient(), nil
 }
 
 func (c *Client) GetAuthorizationCodeByCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error) {
-	cc := c.client.GetAuthorizationCode(ctx)
-	if err := cc.Send(&apiv1.GetAuthorizationCodeRequest{
+	resp, err := c.client.GetAuthorizationCodeUnary(ctx, connect.NewRequest(&apiv1.GetAuthorizationCodeRequest{
 		Code: code,
-	}); err != nil {
-		return nil, err
-	}
-	resp, err := cc.Receive()
+	}))
 	if err != nil {
-		err := c.parseConnectError(err)
-		return nil, err
+		return nil, c.parseConnectError(err)
 	}
-	return resp.GetCode(), nil
+	return resp.Msg.GetCode(), nil
 }
 
 func (c *Client) CreateAuthorizationCode(ctx context.Context, row *apiv1.AuthorizationCode) error {
-	cc := c.client.CreateAuthorizationCode(ctx)
-	if err := cc.Send(&apiv1.CreateAuthorizationCodeRequest{
+	_, err := c.client.CreateAuthorizationCodeUnary(ctx, connect.NewRequest(&apiv1.CreateAuthorizationCodeRequest{
 		Code: row,
-	}); err != nil {
-		return err
-	}
-	_, err := cc.Receive()
+	}))
 	if err != nil {
-		err := c.parseConnectError(err)
-		return err
+		return c.parseConnectError(err)
 	}
 	return nil
 }
 
 func (c *Client) GetAccessTokenByToken(ctx context.Context, token string) (*apiv1.AccessToken, error) {
-	cc := c.client.GetAccessToken(ctx)
-	if err := cc.Send(&apiv1.GetAccessTokenRequest{
+	resp, err := c.client.GetAccessTokenUnary(ctx, connect.NewRequest(&apiv1.GetAccessTokenRequest{
 		Token: token,
-	}); err != nil {
-		return nil, err
-	}
-	resp, err := cc.Receive()
+	}))
 	if err != nil {
-		err := c.parseConnectError(err)
-		return nil, err
+		return nil, c.parseConnectError(err)
 	}
-	return resp.GetToken(), nil
+	return resp.Msg.GetToken(), nil
 }
 
 func (c *Client) CreateAccessToken(ctx context.Context, row *apiv1.AccessToken) error {
-	cc := c.client.CreateAccessToken(ctx)
-	if err := cc.Send(&apiv1.CreateAccessTokenRequest{
+	_, err := c.client.CreateAccessTokenUnary(ctx, connect.NewRequest(&apiv1.CreateAccessTokenRequest{
 		Token: row,
-	}); err != nil {
-		return err
-	}
-	_, err := cc.Receive()
+	}))
 	if err != nil {
-		err := c.parseConnectError(err)
-		return err
+		return c.parseConnectError(err)
 	}
 	return nil
 }
 
 func (c *Client) GetRefreshTokenByToken(ctx context.Context, token string) (*apiv1.RefreshToken, error) {
-	cc := c.client.GetRefreshToken(ctx)
-	if err := cc.Send(&apiv1.GetRefreshTokenRequest{
+	resp, err := c.client.GetRefreshTokenUnary(ctx, connect.NewRequest(&apiv1.GetRefreshTokenRequest{
 		Token: token,
-	}); err != nil {
-		return nil, err
-	}
-	resp, err := cc.Receive()
+	}))
 	if err != nil {
-		err := c.parseConnectError(err)
-		return nil, err
+		return nil, c.parseConnectError(err)
 	}
-	return resp.GetToken(), nil
+	return resp.Msg.GetToken(), nil
 }
 
 func (c *Client) CreateRefreshToken(ctx context.Context, row *apiv1.RefreshToken) error {
-	cc := c.client.CreateRefreshToken(ctx)
-	if err := cc.Send(&apiv1.CreateRefreshTokenRequest{
+	_, err := c.client.CreateRefreshTokenUnary(ctx, connect.NewRequest(&apiv1.CreateRefreshTokenRequest{
 		Token: row,
-	}); err != nil {
-		return err
+	}))
+	if err != nil {
+		return c.parseConnectError(err)
 	}
-	_, err := cc.Receive()
+	return nil
+}
+
+func (c *Client) GetResourceServerByUri(ctx context.Context, uri string) (*apiv1.ResourceServer, error) {
+	resp, err := c.client.GetResourceServerUnary(ctx, connect.NewRequest(&apiv1.GetResourceServerRequest{
+		Uri: uri,
+	}))
 	if err != nil {
-		err := c.parseConnectError(err)
-		return err
+		return nil, c.parseConnectError(err)
+	}
+	return resp.Msg.GetResourceServer(), nil
+}
+
+func (c *Client) ListResourceServers(ctx context.Context) ([]*apiv1.ResourceServer, error) {
+	resp, err := c.client.ListResourceServersUnary(ctx, connect.NewRequest(&apiv1.ListResourceServersRequest{}))
+	if err != nil {
+		return nil, c.parseConnectError(err)
+	}
+	return resp.Msg.GetResourceServers(), nil
+}
+
+func (c *Client) ConsumeAuthorizationCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error) {
+	resp, err := c.client.ConsumeAuthorizationCodeUnary(ctx, connect.NewRequest(&apiv1.ConsumeAuthorizationCodeRequest{
+		Code: code,
+	}))
+	if err != nil {
+		return nil, c.parseConnectError(err)
+	}
+	return resp.Msg.GetCode(), nil
+}
+
+func (c *Client) RevokeAccessToken(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+	resp, err := c.client.RevokeAccessTokenUnary(ctx, connect.NewRequest(&apiv1.RevokeAccessTokenRequest{
+		Token: token,
+	}))
+	if err != nil {
+		return nil, c.parseConnectError(err)
+	}
+	return resp.Msg.GetToken(), nil
+}
+
+func (c *Client) RevokeRefreshToken(ctx context.Context, token string) (*apiv1.RefreshToken, error) {
+	resp, err := c.client.RevokeRefreshTokenUnary(ctx, connect.NewRequest(&apiv1.RevokeRefreshTokenRequest{
+		Token: token,
+	}))
+	if err != nil {
+		return nil, c.parseConnectError(err)
+	}
+	return resp.Msg.GetToken(), nil
+}
+
+// BatchGetAccessTokens returns the found tokens in one round trip. unknown tokens are omitted.
+func (c *Client) BatchGetAccessTokens(ctx context.Context, tokens []string) ([]*apiv1.AccessToken, error) {
+	resp, err := c.client.BatchGetAccessTokens(ctx, connect.NewRequest(&apiv1.BatchGetAccessTokensRequest{
+		Tokens: tokens,
+	}))
+	if err != nil {
+		return nil, c.parseConnectError(err)
+	}
+	return resp.Msg.GetTokens(), nil
+}
+
+// BatchCreate creates all rows of [b] in one round trip, or returns [ErrAlreadyExists] without creating any.
+func (c *Client) BatchCreate(ctx context.Context, b Batch) error {
+	_, err := c.client.BatchCreate(ctx, connect.NewRequest(&apiv1.BatchCreateRequest{
+		Codes:         b.AuthorizationCodes,
+		AccessTokens:  b.AccessTokens,
+		RefreshTokens: b.RefreshTokens,
+	}))
+	if err != nil {
+		return c.parseConnectError(err)
 	}
 	return nil
 }
 
+// Watch calls [fn] with each event after [cursor] and the cursor to resume after it, until [ctx] is done
+// or [fn] fails. An empty [cursor] watches from now. The stream is reopened from the last cursor when it breaks.
+// [ErrCursorExpired] is returned if the server lost the events after the cursor; reload the rows and watch again.
+func (c *Client) Watch(ctx context.Context, cursor string, fn func(cursor string, event *apiv1.WatchEvent) error) error {
+	for {
+		retry, err := c.watch(ctx, &cursor, fn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !retry {
+			return err
+		}
+		slog.WarnContext(ctx, "watch is broken", slog.String("cursor", cursor), slog.Any("error", err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+const watchRetryInterval = time.Duration(1) * time.Second
+
+// watch streams the events until the stream breaks, advancing [cursor].
+func (c *Client) watch(ctx context.Context, cursor *string, fn func(cursor string, event *apiv1.WatchEvent) error) (retry bool, err error) {
+	// closing the stream reads it to the end, which a watch has not. cancel it first.
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.client.Watch(ctx, connect.NewRequest(&apiv1.WatchRequest{
+		Cursor: *cursor,
+	}))
+	if err != nil {
+		cancel()
+		return true, c.parseConnectError(err)
+	}
+	defer stream.Close()
+	defer cancel()
+	for stream.Receive() {
+		resp := stream.Msg()
+		// the first response only tells the cursor
+		if resp.GetEvent() != nil {
+			if err := fn(resp.GetCursor(), resp.GetEvent()); err != nil {
+				return false, err
+			}
+		}
+		*cursor = resp.GetCursor()
+	}
+	err = c.parseConnectError(stream.Err())
+	if errors.Is(err, ErrCursorExpired) || connect.CodeOf(err) == connect.CodeInvalidArgument {
+		return false, err
+	}
+	return true, err
+}
+
 func (c *Client) Ping(ctx context.Context) error {
 	_, err := c.client.Ping(ctx, &connect.Request[apiv1.PingRequest]{})
-	return err
+	return c.parseConnectError(err)
 }
 
 func (c *Client) parseConnectError(err error) error {
@@ -171,11 +335,28 @@ func (c *Client) parseConnectError(err error) error {
 	if !ok {
 		return err
 	}
+	// keeps when the breaker lets a call through again
+	var retry *dberr.RetryError
+	if errors.As(err, &retry) {
+		return retry
+	}
 	switch connectErr.Code() {
+	case connect.CodeUnavailable:
+		return fmt.Errorf("%w: %s", ErrUnavailable, connectErr.Message())
+	case connect.CodeDeadlineExceeded:
+		return fmt.Errorf("%w: %s", ErrTimeout, connectErr.Message())
 	case connect.CodeAlreadyExists:
 		return ErrAlreadyExists
 	case connect.CodeNotFound:
 		return ErrNotFound
+	case connect.CodeAborted:
+		return fmt.Errorf("%w: %s", ErrConflict, connectErr.Message())
+	case connect.CodeOutOfRange:
+		return fmt.Errorf("%w: %s", ErrCursorExpired, connectErr.Message())
+	case connect.CodeUnauthenticated:
+		return fmt.Errorf("%w: %s", ErrUnauthenticated, connectErr.Message())
+	case connect.CodePermissionDenied:
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, connectErr.Message())
 	}
 	return err
 }
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Conditional updates return a new row, or [ErrConflict] if [row] is not in the expected state.
+// Callers replace the row under the write lock, so a check and its update are atomic.
+
+// swapUserProfile updates the profile if [row] is at [version]. zero [version] matches any.
+func swapUserProfile(row *apiv1.UserProfile, version uint64, profile string) (*apiv1.UserProfile, error) {
+	if version != 0 && row.GetVersion() != version {
+		return nil, fmt.Errorf("%w: user is at version %d, not %d", ErrConflict, row.GetVersion(), version)
+	}
+	updated := proto.Clone(row).(*apiv1.UserProfile)
+	updated.Profile = profile
+	updated.Version++
+	return updated, nil
+}
+
+func consumeAuthorizationCode(row *apiv1.AuthorizationCode, now time.Time) (*apiv1.AuthorizationCode, error) {
+	if row.GetConsumedAt() != nil {
+		return nil, fmt.Errorf("%w: code is already consumed", ErrConflict)
+	}
+	consumed := proto.Clone(row).(*apiv1.AuthorizationCode)
+	consumed.ConsumedAt = timestamppb.New(now)
+	return consumed, nil
+}
+
+func revokeAccessToken(row *apiv1.AccessToken, now time.Time) (*apiv1.AccessToken, error) {
+	if row.GetRevokedAt() != nil {
+		return nil, fmt.Errorf("%w: token is already revoked", ErrConflict)
+	}
+	revoked := proto.Clone(row).(*apiv1.AccessToken)
+	revoked.RevokedAt = timestamppb.New(now)
+	return revoked, nil
+}
+
+func revokeRefreshToken(row *apiv1.RefreshToken, now time.Time) (*apiv1.RefreshToken, error) {
+	if row.GetRevokedAt() != nil {
+		return nil, fmt.Errorf("%w: token is already revoked", ErrConflict)
+	}
+	revoked := proto.Clone(row).(*apiv1.RefreshToken)
+	revoked.RevokedAt = timestamppb.New(now)
+	return revoked, nil
+}
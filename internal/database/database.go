@@ -3,24 +3,36 @@ package database
 import (
 	"context"
 	"errors"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/dberr"
+	"github.com/yyyoichi/OhAuth0.1/internal/expiry"
 )
 
-// Define a simple to understand the structure of OAuth2.0
+// Database is the in-memory [Storage]. All rows are lost when the process exits.
 type Database struct {
 	userById                map[string]*apiv1.UserProfile
 	serviceClientById       map[string]*apiv1.ServiceClient
 	authorizationCodeByCode map[string]*apiv1.AuthorizationCode
 	accessTokenByToken      map[string]*apiv1.AccessToken
 	refreshTokenByToken     map[string]*apiv1.RefreshToken
-	mu                      sync.Mutex
+	resourceServerByUri     map[string]*apiv1.ResourceServer
+	// expiry of codes and tokens for [Database.Sweep]
+	expiries expiry.Index[expiryKey]
+	// rows are replaced, not mutated, so readers may keep returned rows.
+	mu sync.RWMutex
 }
 
 const (
 	CLIENT_SECRET = "secret"
 	REDIRECT_URI  = "http://localhost:7777"
+	RESOURCE_URI  = "http://localhost:8088"
+	// back-channel logout receiver of the CLI
+	BACKCHANNEL_LOGOUT_URI = "http://localhost:7778/backchannel_logout"
 )
 
 var (
@@ -29,7 +41,10 @@ var (
 		Name:        "Professional Q&A",
 		Secret:      CLIENT_SECRET,
 		RedirectUri: REDIRECT_URI,
-		Scope:       "profile:view",
+		Scope:       "profile:view profile:edit",
+
+		PostLogoutRedirectUris: []string{REDIRECT_URI},
+		BackchannelLogoutUri:   BACKCHANNEL_LOGOUT_URI,
 	}
 	MockServiceClient501 = apiv1.ServiceClient{
 		Id:          "501",
@@ -37,10 +52,42 @@ var (
 		Secret:      CLIENT_SECRET,
 		RedirectUri: REDIRECT_URI,
 		Scope:       "profile:view",
+
+		PostLogoutRedirectUris: []string{REDIRECT_URI},
+		BackchannelLogoutUri:   BACKCHANNEL_LOGOUT_URI,
+	}
+	MockResourceServer = apiv1.ResourceServer{
+		Uri:    RESOURCE_URI,
+		Name:   "Profile API",
+		Scopes: []string{"profile:view", "profile:edit"},
 	}
 )
 
+// Seed is the URLs of the mock rows of a new database. The zero value seeds the http URLs on localhost.
+type Seed struct {
+	// redirect URI of the mock service clients. default [REDIRECT_URI]
+	RedirectURI string
+	// URI of the mock resource server. default [RESOURCE_URI]
+	ResourceURI string
+	// back-channel logout URI of the mock service clients. default [BACKCHANNEL_LOGOUT_URI]
+	BackchannelLogoutURI string
+}
+
 func NewDatabase() (*Database, error) {
+	return NewSeededDatabase(Seed{})
+}
+
+// NewSeededDatabase returns the database with the mock rows at the URLs of [seed], e.g. https ones.
+func NewSeededDatabase(seed Seed) (*Database, error) {
+	if seed.RedirectURI == "" {
+		seed.RedirectURI = REDIRECT_URI
+	}
+	if seed.ResourceURI == "" {
+		seed.ResourceURI = RESOURCE_URI
+	}
+	if seed.BackchannelLogoutURI == "" {
+		seed.BackchannelLogoutURI = BACKCHANNEL_LOGOUT_URI
+	}
 	var db Database
 	db.userById = map[string]*apiv1.UserProfile{
 		"1": {
@@ -49,6 +96,7 @@ func NewDatabase() (*Database, error) {
 			Name:     "Taro",
 			Age:      20,
 			Profile:  "Hello🎈",
+			Version:  1,
 		},
 		"2": {
 			Id:       "2",
@@ -56,6 +104,7 @@ func NewDatabase() (*Database, error) {
 			Name:     "Hanako",
 			Age:      20,
 			Profile:  "Hello🌸",
+			Version:  1,
 		},
 	}
 	db.serviceClientById = map[string]*apiv1.ServiceClient{
@@ -63,24 +112,44 @@ func NewDatabase() (*Database, error) {
 			Id:          MockServiceClient500.Id,
 			Name:        MockServiceClient500.Name,
 			Secret:      MockServiceClient500.Secret,
-			RedirectUri: MockServiceClient500.RedirectUri,
+			RedirectUri: seed.RedirectURI,
 			Scope:       MockServiceClient500.Scope,
+
+			PostLogoutRedirectUris: []string{seed.RedirectURI},
+			BackchannelLogoutUri:   seed.BackchannelLogoutURI,
 		},
 		"501": {
 			Id:          MockServiceClient501.Id,
 			Name:        MockServiceClient501.Name,
 			Secret:      MockServiceClient501.Secret,
-			RedirectUri: MockServiceClient501.RedirectUri,
+			RedirectUri: seed.RedirectURI,
 			Scope:       MockServiceClient501.Scope,
+
+			PostLogoutRedirectUris: []string{seed.RedirectURI},
+			BackchannelLogoutUri:   seed.BackchannelLogoutURI,
 		},
 	}
 	db.authorizationCodeByCode = make(map[string]*apiv1.AuthorizationCode)
 	db.accessTokenByToken = make(map[string]*apiv1.AccessToken)
 	db.refreshTokenByToken = make(map[string]*apiv1.RefreshToken)
+	db.resourceServerByUri = map[string]*apiv1.ResourceServer{
+		seed.ResourceURI: {
+			Uri:    seed.ResourceURI,
+			Name:   MockResourceServer.Name,
+			Scopes: MockResourceServer.Scopes,
+		},
+	}
 	return &db, nil
 }
 
+// Ping always succeeds, since the database is in the memory.
+func (db *Database) Ping(context.Context) error {
+	return nil
+}
+
 func (db *Database) GetUserById(ctx context.Context, id string) (*apiv1.UserProfile, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	u, found := db.userById[id]
 	if !found {
 		return nil, ErrNotFound
@@ -88,7 +157,40 @@ func (db *Database) GetUserById(ctx context.Context, id string) (*apiv1.UserProf
 	return u, nil
 }
 
+func (db *Database) UpdateUserProfile(ctx context.Context, id, profile string) (*apiv1.UserProfile, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u, found := db.userById[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+	updated, err := swapUserProfile(u, 0, profile)
+	if err != nil {
+		return nil, err
+	}
+	db.userById[id] = updated
+	return updated, nil
+}
+
+// CompareAndSwapUserProfile updates the profile if the user is at [version].
+func (db *Database) CompareAndSwapUserProfile(ctx context.Context, id string, version uint64, profile string) (*apiv1.UserProfile, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u, found := db.userById[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+	updated, err := swapUserProfile(u, version, profile)
+	if err != nil {
+		return nil, err
+	}
+	db.userById[id] = updated
+	return updated, nil
+}
+
 func (db *Database) GetServieClientById(ctx context.Context, id string) (*apiv1.ServiceClient, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	c, found := db.serviceClientById[id]
 	if !found {
 		return nil, ErrNotFound
@@ -96,7 +198,19 @@ func (db *Database) GetServieClientById(ctx context.Context, id string) (*apiv1.
 	return c, nil
 }
 
+func (db *Database) CreateServiceClient(ctx context.Context, row *apiv1.ServiceClient) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, found := db.serviceClientById[row.Id]; found {
+		return ErrAlreadyExists
+	}
+	db.serviceClientById[row.Id] = row
+	return nil
+}
+
 func (db *Database) GetAuthorizationCodeByCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	c, found := db.authorizationCodeByCode[code]
 	if !found {
 		return nil, ErrNotFound
@@ -111,10 +225,29 @@ func (db *Database) CreateAuthorizationCode(ctx context.Context, row *apiv1.Auth
 		return ErrAlreadyExists
 	}
 	db.authorizationCodeByCode[row.Code] = row
+	db.index(kindAuthorizationCode, row.Code, row.GetExpires())
 	return nil
 }
 
+// ConsumeAuthorizationCode marks the code consumed if it is unused, and returns it.
+func (db *Database) ConsumeAuthorizationCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, found := db.authorizationCodeByCode[code]
+	if !found {
+		return nil, ErrNotFound
+	}
+	consumed, err := consumeAuthorizationCode(c, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	db.authorizationCodeByCode[code] = consumed
+	return consumed, nil
+}
+
 func (db *Database) GetAccessTokenByToken(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	t, found := db.accessTokenByToken[token]
 	if !found {
 		return nil, ErrNotFound
@@ -129,10 +262,29 @@ func (db *Database) CreateAccessToken(ctx context.Context, row *apiv1.AccessToke
 		return ErrAlreadyExists
 	}
 	db.accessTokenByToken[row.Token] = row
+	db.index(kindAccessToken, row.Token, row.GetExpires())
 	return nil
 }
 
+// RevokeAccessToken revokes the token if it is active, and returns it.
+func (db *Database) RevokeAccessToken(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, found := db.accessTokenByToken[token]
+	if !found {
+		return nil, ErrNotFound
+	}
+	revoked, err := revokeAccessToken(t, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	db.accessTokenByToken[token] = revoked
+	return revoked, nil
+}
+
 func (db *Database) GetRefreshTokenByToken(ctx context.Context, token string) (*apiv1.RefreshToken, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	t, found := db.refreshTokenByToken[token]
 	if !found {
 		return nil, ErrNotFound
@@ -147,10 +299,155 @@ func (db *Database) CreateRefreshToken(ctx context.Context, row *apiv1.RefreshTo
 		return ErrAlreadyExists
 	}
 	db.refreshTokenByToken[row.Token] = row
+	db.index(kindRefreshToken, row.Token, row.GetExpires())
+	return nil
+}
+
+// RevokeRefreshToken revokes the token if it is active, and returns it.
+func (db *Database) RevokeRefreshToken(ctx context.Context, token string) (*apiv1.RefreshToken, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t, found := db.refreshTokenByToken[token]
+	if !found {
+		return nil, ErrNotFound
+	}
+	revoked, err := revokeRefreshToken(t, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	db.refreshTokenByToken[token] = revoked
+	return revoked, nil
+}
+
+func (db *Database) GetResourceServerByUri(ctx context.Context, uri string) (*apiv1.ResourceServer, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	r, found := db.resourceServerByUri[uri]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return r, nil
+}
+
+func (db *Database) ListResourceServers(ctx context.Context) ([]*apiv1.ResourceServer, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	rows := make([]*apiv1.ResourceServer, 0, len(db.resourceServerByUri))
+	for _, r := range db.resourceServerByUri {
+		rows = append(rows, r)
+	}
+	slices.SortFunc(rows, func(a, b *apiv1.ResourceServer) int {
+		return strings.Compare(a.Uri, b.Uri)
+	})
+	return rows, nil
+}
+
+func (db *Database) CreateResourceServer(ctx context.Context, row *apiv1.ResourceServer) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, found := db.resourceServerByUri[row.Uri]; found {
+		return ErrAlreadyExists
+	}
+	db.resourceServerByUri[row.Uri] = row
 	return nil
 }
 
+// Close implements Storage. The in-memory storage has nothing to release.
+func (db *Database) Close() error {
+	return nil
+}
+
+// apply writes [record] replacing the row of the same key.
+func (db *Database) apply(record *apiv1.StorageRecord) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.applyLocked(record)
+}
+
+// applyLocked is [Database.apply] called with db.mu held.
+func (db *Database) applyLocked(record *apiv1.StorageRecord) {
+	switch row := record.GetRow().(type) {
+	case *apiv1.StorageRecord_User:
+		db.userById[row.User.GetId()] = row.User
+	case *apiv1.StorageRecord_ServiceClient:
+		db.serviceClientById[row.ServiceClient.GetId()] = row.ServiceClient
+	case *apiv1.StorageRecord_AuthorizationCode:
+		db.authorizationCodeByCode[row.AuthorizationCode.GetCode()] = row.AuthorizationCode
+		db.index(kindAuthorizationCode, row.AuthorizationCode.GetCode(), row.AuthorizationCode.GetExpires())
+	case *apiv1.StorageRecord_AccessToken:
+		db.accessTokenByToken[row.AccessToken.GetToken()] = row.AccessToken
+		db.index(kindAccessToken, row.AccessToken.GetToken(), row.AccessToken.GetExpires())
+	case *apiv1.StorageRecord_RefreshToken:
+		db.refreshTokenByToken[row.RefreshToken.GetToken()] = row.RefreshToken
+		db.index(kindRefreshToken, row.RefreshToken.GetToken(), row.RefreshToken.GetExpires())
+	case *apiv1.StorageRecord_ResourceServer:
+		db.resourceServerByUri[row.ResourceServer.GetUri()] = row.ResourceServer
+	}
+}
+
+// snapshot returns all rows.
+func (db *Database) snapshot() *apiv1.StorageSnapshot {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var s apiv1.StorageSnapshot
+	for _, row := range db.userById {
+		s.Users = append(s.Users, row)
+	}
+	for _, row := range db.serviceClientById {
+		s.ServiceClients = append(s.ServiceClients, row)
+	}
+	for _, row := range db.authorizationCodeByCode {
+		s.AuthorizationCodes = append(s.AuthorizationCodes, row)
+	}
+	for _, row := range db.accessTokenByToken {
+		s.AccessTokens = append(s.AccessTokens, row)
+	}
+	for _, row := range db.refreshTokenByToken {
+		s.RefreshTokens = append(s.RefreshTokens, row)
+	}
+	for _, row := range db.resourceServerByUri {
+		s.ResourceServers = append(s.ResourceServers, row)
+	}
+	return &s
+}
+
+// restore replaces all rows with [s].
+func (db *Database) restore(s *apiv1.StorageSnapshot) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.userById = make(map[string]*apiv1.UserProfile, len(s.GetUsers()))
+	for _, row := range s.GetUsers() {
+		db.userById[row.GetId()] = row
+	}
+	db.serviceClientById = make(map[string]*apiv1.ServiceClient, len(s.GetServiceClients()))
+	for _, row := range s.GetServiceClients() {
+		db.serviceClientById[row.GetId()] = row
+	}
+	db.expiries.Reset()
+	db.authorizationCodeByCode = make(map[string]*apiv1.AuthorizationCode, len(s.GetAuthorizationCodes()))
+	for _, row := range s.GetAuthorizationCodes() {
+		db.authorizationCodeByCode[row.GetCode()] = row
+		db.index(kindAuthorizationCode, row.GetCode(), row.GetExpires())
+	}
+	db.accessTokenByToken = make(map[string]*apiv1.AccessToken, len(s.GetAccessTokens()))
+	for _, row := range s.GetAccessTokens() {
+		db.accessTokenByToken[row.GetToken()] = row
+		db.index(kindAccessToken, row.GetToken(), row.GetExpires())
+	}
+	db.refreshTokenByToken = make(map[string]*apiv1.RefreshToken, len(s.GetRefreshTokens()))
+	for _, row := range s.GetRefreshTokens() {
+		db.refreshTokenByToken[row.GetToken()] = row
+		db.index(kindRefreshToken, row.GetToken(), row.GetExpires())
+	}
+	db.resourceServerByUri = make(map[string]*apiv1.ResourceServer, len(s.GetResourceServers()))
+	for _, row := range s.GetResourceServers() {
+		db.resourceServerByUri[row.GetUri()] = row
+	}
+}
+
 var (
-	ErrNotFound      = errors.New("not found")
+	ErrNotFound      = dberr.ErrNotFound
 	ErrAlreadyExists = errors.New("already exists")
+	// the row is not in the expected state, e.g. already consumed or at another version.
+	ErrConflict = errors.New("conflict")
 )
@@ -2,6 +2,9 @@ package database
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,10 +18,17 @@ func TestDatabase(t *testing.T) {
 			db, _ := NewDatabase()
 			return db
 		}(),
+		"file": func() *FileStorage {
+			s, err := OpenFileStorage(StorageConfig{DataDir: t.TempDir()})
+			assert.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+			return s
+		}(),
 		"remote": func() *Client {
 			port := "3366"
-			err := NewDatabaseServer(ServerConfig{
-				Port: port,
+			_, err := NewDatabaseServer(context.Background(), ServerConfig{
+				Port:     port,
+				Insecure: true,
 			})
 			assert.NoError(t, err)
 			var client *Client
@@ -58,6 +68,22 @@ func testdb(t *testing.T, db databaseInterface) {
 	assert.NotZero(t, user)
 	_, err = db.GetUserById(ctx, "999")
 	assert.ErrorIs(t, ErrNotFound, err)
+	updated, err := db.UpdateUserProfile(ctx, "2", "Updated🌸")
+	assert.NoError(t, err)
+	assert.Equal(t, "Updated🌸", updated.Profile)
+	user, err = db.GetUserById(ctx, "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "Updated🌸", user.Profile)
+	_, err = db.UpdateUserProfile(ctx, "999", "")
+	assert.ErrorIs(t, ErrNotFound, err)
+	swapped, err := db.CompareAndSwapUserProfile(ctx, "2", updated.Version, "Swapped🌸")
+	assert.NoError(t, err)
+	assert.Equal(t, updated.Version+1, swapped.Version)
+	_, err = db.CompareAndSwapUserProfile(ctx, "2", updated.Version, "Stale🌸")
+	assert.ErrorIs(t, err, ErrConflict)
+	user, err = db.GetUserById(ctx, "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "Swapped🌸", user.Profile)
 	client, err := db.GetServieClientById(ctx, "500")
 	assert.NoError(t, err)
 	assert.NotZero(t, client)
@@ -80,6 +106,13 @@ func testdb(t *testing.T, db databaseInterface) {
 	assert.ErrorIs(t, ErrAlreadyExists, err)
 	_, err = db.GetAuthorizationCodeByCode(ctx, "notfound")
 	assert.ErrorIs(t, ErrNotFound, err)
+	consumed, err := db.ConsumeAuthorizationCode(ctx, expcode.Code)
+	assert.NoError(t, err)
+	assert.NotNil(t, consumed.ConsumedAt)
+	_, err = db.ConsumeAuthorizationCode(ctx, expcode.Code)
+	assert.ErrorIs(t, err, ErrConflict)
+	_, err = db.ConsumeAuthorizationCode(ctx, "notfound")
+	assert.ErrorIs(t, err, ErrNotFound)
 
 	exptoken := &apiv1.AccessToken{
 		Token:           "token",
@@ -97,6 +130,11 @@ func testdb(t *testing.T, db databaseInterface) {
 	assert.ErrorIs(t, ErrAlreadyExists, err)
 	_, err = db.GetAccessTokenByToken(ctx, "notfound")
 	assert.ErrorIs(t, ErrNotFound, err)
+	revoked, err := db.RevokeAccessToken(ctx, exptoken.Token)
+	assert.NoError(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+	_, err = db.RevokeAccessToken(ctx, exptoken.Token)
+	assert.ErrorIs(t, err, ErrConflict)
 
 	exprefresh := &apiv1.RefreshToken{
 		Token:           "token",
@@ -114,15 +152,130 @@ func testdb(t *testing.T, db databaseInterface) {
 	assert.ErrorIs(t, ErrAlreadyExists, err)
 	_, err = db.GetRefreshTokenByToken(ctx, "notfound")
 	assert.ErrorIs(t, ErrNotFound, err)
+	revokedRefresh, err := db.RevokeRefreshToken(ctx, exprefresh.Token)
+	assert.NoError(t, err)
+	assert.NotNil(t, revokedRefresh.RevokedAt)
+	refresh, err = db.GetRefreshTokenByToken(ctx, exprefresh.Token)
+	assert.NoError(t, err)
+	assert.NotNil(t, refresh.RevokedAt)
+	_, err = db.RevokeRefreshToken(ctx, exprefresh.Token)
+	assert.ErrorIs(t, err, ErrConflict)
+
+	batch := Batch{
+		AuthorizationCodes: []*apiv1.AuthorizationCode{{Code: "batch-code", UserId: "11", Expires: NOW}},
+		AccessTokens:       []*apiv1.AccessToken{{Token: "batch-token", UserId: "11", Expires: NOW}},
+		RefreshTokens:      []*apiv1.RefreshToken{{Token: "batch-token", UserId: "11", Expires: NOW}},
+	}
+	err = db.BatchCreate(ctx, batch)
+	assert.NoError(t, err)
+	token, err = db.GetAccessTokenByToken(ctx, "batch-token")
+	assert.NoError(t, err)
+	assert.EqualExportedValues(t, batch.AccessTokens[0], token)
+	refresh, err = db.GetRefreshTokenByToken(ctx, "batch-token")
+	assert.NoError(t, err)
+	assert.EqualExportedValues(t, batch.RefreshTokens[0], refresh)
+	// all or nothing
+	err = db.BatchCreate(ctx, Batch{
+		AccessTokens:  []*apiv1.AccessToken{{Token: "batch-new", UserId: "11", Expires: NOW}},
+		RefreshTokens: []*apiv1.RefreshToken{{Token: "batch-token", UserId: "11", Expires: NOW}},
+	})
+	assert.ErrorIs(t, err, ErrAlreadyExists)
+	_, err = db.GetAccessTokenByToken(ctx, "batch-new")
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = db.BatchCreate(ctx, Batch{
+		AccessTokens: []*apiv1.AccessToken{{Token: "batch-twice"}, {Token: "batch-twice"}},
+	})
+	assert.ErrorIs(t, err, ErrAlreadyExists)
+
+	resourceServer, err := db.GetResourceServerByUri(ctx, RESOURCE_URI)
+	assert.NoError(t, err)
+	assert.EqualExportedValues(t, &MockResourceServer, resourceServer)
+	_, err = db.GetResourceServerByUri(ctx, "notfound")
+	assert.ErrorIs(t, ErrNotFound, err)
+	resourceServers, err := db.ListResourceServers(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, resourceServers, 1)
 }
 
 type databaseInterface interface {
 	GetUserById(ctx context.Context, id string) (*apiv1.UserProfile, error)
+	UpdateUserProfile(ctx context.Context, id, profile string) (*apiv1.UserProfile, error)
+	CompareAndSwapUserProfile(ctx context.Context, id string, version uint64, profile string) (*apiv1.UserProfile, error)
 	GetServieClientById(ctx context.Context, id string) (*apiv1.ServiceClient, error)
 	GetAuthorizationCodeByCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error)
 	CreateAuthorizationCode(ctx context.Context, row *apiv1.AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error)
 	GetAccessTokenByToken(ctx context.Context, token string) (*apiv1.AccessToken, error)
 	CreateAccessToken(ctx context.Context, row *apiv1.AccessToken) error
+	RevokeAccessToken(ctx context.Context, token string) (*apiv1.AccessToken, error)
 	GetRefreshTokenByToken(ctx context.Context, token string) (*apiv1.RefreshToken, error)
 	CreateRefreshToken(ctx context.Context, row *apiv1.RefreshToken) error
+	RevokeRefreshToken(ctx context.Context, token string) (*apiv1.RefreshToken, error)
+	BatchCreate(ctx context.Context, b Batch) error
+	GetResourceServerByUri(ctx context.Context, uri string) (*apiv1.ResourceServer, error)
+	ListResourceServers(ctx context.Context) ([]*apiv1.ResourceServer, error)
+}
+
+func TestDatabaseConcurrency(t *testing.T) {
+	ctx := context.Background()
+	db, _ := NewDatabase()
+	assert.NoError(t, db.CreateAuthorizationCode(ctx, &apiv1.AuthorizationCode{Code: "code"}))
+
+	var wg sync.WaitGroup
+	var consumed atomic.Int32
+	for i := range 20 {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if _, err := db.ConsumeAuthorizationCode(ctx, "code"); err == nil {
+				consumed.Add(1)
+			} else {
+				assert.ErrorIs(t, err, ErrConflict)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_ = db.CreateAccessToken(ctx, &apiv1.AccessToken{Token: fmt.Sprintf("token%d", i)})
+			_, _ = db.UpdateUserProfile(ctx, "1", fmt.Sprintf("profile%d", i))
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = db.GetAccessTokenByToken(ctx, fmt.Sprintf("token%d", i))
+			_, _ = db.GetUserById(ctx, "1")
+			_, _ = db.GetAuthorizationCodeByCode(ctx, "code")
+		}()
+	}
+	wg.Wait()
+	// a code is consumed only once
+	assert.EqualValues(t, 1, consumed.Load())
+	user, err := db.GetUserById(ctx, "1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 21, user.Version)
+}
+
+func TestNewSeededDatabase(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSeededDatabase(Seed{
+		RedirectURI:          "https://localhost:7777",
+		ResourceURI:          "https://localhost:8088",
+		BackchannelLogoutURI: "https://localhost:7778/backchannel_logout",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	client, err := db.GetServieClientById(ctx, MockServiceClient500.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://localhost:7777", client.GetRedirectUri())
+	assert.Equal(t, []string{"https://localhost:7777"}, client.GetPostLogoutRedirectUris())
+	assert.Equal(t, "https://localhost:7778/backchannel_logout", client.GetBackchannelLogoutUri())
+	_, err = db.GetResourceServerByUri(ctx, "https://localhost:8088")
+	assert.NoError(t, err)
+	_, err = db.GetResourceServerByUri(ctx, RESOURCE_URI)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// the zero value seeds the http URLs
+	db, _ = NewSeededDatabase(Seed{})
+	client, _ = db.GetServieClientById(ctx, MockServiceClient500.Id)
+	assert.Equal(t, REDIRECT_URI, client.GetRedirectUri())
+	assert.Equal(t, BACKCHANNEL_LOGOUT_URI, client.GetBackchannelLogoutUri())
 }
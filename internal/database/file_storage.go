@@ -0,0 +1,422 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.pb"
+
+	defaultSnapshotInterval = time.Duration(1) * time.Minute
+	// length and CRC-32 of a record in the write-ahead log
+	walHeaderSize = 8
+)
+
+// FileStorage is the durable [Storage] in a data directory.
+// Rows are served from memory. Every write is appended to a write-ahead log and fsynced
+// before it is applied, and the log is folded into a snapshot periodically.
+// On open, the snapshot is loaded and the log is replayed.
+type FileStorage struct {
+	mem *Database
+	dir string
+
+	// serializes writes, the log and snapshots.
+	mu sync.Mutex
+	// nil after Close.
+	wal *os.File
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	// closes once, even if called concurrently
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// OpenFileStorage opens the storage in [config.DataDir], creating it with the seed rows of [NewDatabase] if empty.
+func OpenFileStorage(config StorageConfig) (*FileStorage, error) {
+	if config.DataDir == "" {
+		return nil, errors.New("data directory is required")
+	}
+	if config.SnapshotInterval <= 0 {
+		config.SnapshotInterval = defaultSnapshotInterval
+	}
+	if err := os.MkdirAll(config.DataDir, 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create data directory: %w", err)
+	}
+	mem, err := NewSeededDatabase(config.Seed)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStorage{
+		mem:  mem,
+		dir:  config.DataDir,
+		done: make(chan struct{}),
+	}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(config.SnapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				if err := s.Snapshot(); err != nil {
+					slog.Error("cannot take snapshot", slog.String("error", err.Error()))
+				}
+			}
+		}
+	}()
+	return s, nil
+}
+
+func (s *FileStorage) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cannot read snapshot: %w", err)
+	}
+	var snapshot apiv1.StorageSnapshot
+	if err := proto.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("cannot decode snapshot: %w", err)
+	}
+	s.mem.restore(&snapshot)
+	return nil
+}
+
+// replay applies the records of the log and opens it for appending.
+// A torn record at the tail, left by a crash while writing, is discarded.
+func (s *FileStorage) replay() error {
+	f, err := os.OpenFile(filepath.Join(s.dir, walFileName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot open log: %w", err)
+	}
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		record, n, err := readRecord(r)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			slog.Warn("discard the tail of the log", slog.Int64("offset", offset), slog.String("error", err.Error()))
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return fmt.Errorf("cannot truncate log: %w", err)
+			}
+			break
+		}
+		s.mem.apply(record)
+		offset += n
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot seek log: %w", err)
+	}
+	s.wal = f
+	return nil
+}
+
+func readRecord(r io.Reader) (*apiv1.StorageRecord, int64, error) {
+	var header [walHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, errors.New("torn header")
+		}
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, fmt.Errorf("torn record: %w", err)
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+	var record apiv1.StorageRecord
+	if err := proto.Unmarshal(data, &record); err != nil {
+		return nil, 0, err
+	}
+	return &record, walHeaderSize + int64(size), nil
+}
+
+// write logs [record] and applies it. must be called with s.mu held.
+func (s *FileStorage) write(records ...*apiv1.StorageRecord) error {
+	if s.wal == nil {
+		return errors.New("storage is closed")
+	}
+	var buf []byte
+	for _, record := range records {
+		data, err := proto.Marshal(record)
+		if err != nil {
+			return err
+		}
+		header := make([]byte, walHeaderSize)
+		binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+		binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(data))
+		buf = append(buf, header...)
+		buf = append(buf, data...)
+	}
+	if _, err := s.wal.Write(buf); err != nil {
+		return fmt.Errorf("cannot write log: %w", err)
+	}
+	if err := s.wal.Sync(); err != nil {
+		return fmt.Errorf("cannot sync log: %w", err)
+	}
+	for _, record := range records {
+		s.mem.apply(record)
+	}
+	return nil
+}
+
+// create writes [record] unless [get] finds the row.
+func (s *FileStorage) create(get func() error, record *apiv1.StorageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := get(); err == nil {
+		return ErrAlreadyExists
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return s.write(record)
+}
+
+// Snapshot writes all rows to the snapshot and empties the log.
+func (s *FileStorage) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot()
+}
+
+func (s *FileStorage) snapshot() error {
+	if s.wal == nil {
+		return errors.New("storage is closed")
+	}
+	if info, err := s.wal.Stat(); err == nil && info.Size() == 0 {
+		// nothing changed since the last snapshot
+		if _, err := os.Stat(filepath.Join(s.dir, snapshotFileName)); err == nil {
+			return nil
+		}
+	}
+	data, err := proto.Marshal(s.mem.snapshot())
+	if err != nil {
+		return err
+	}
+	// replace the snapshot atomically
+	tmp := filepath.Join(s.dir, snapshotFileName+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot create snapshot: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot sync snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFileName)); err != nil {
+		return fmt.Errorf("cannot replace snapshot: %w", err)
+	}
+	if dir, err := os.Open(s.dir); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	// the log is folded into the snapshot
+	if err := s.wal.Truncate(0); err != nil {
+		return fmt.Errorf("cannot truncate log: %w", err)
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot seek log: %w", err)
+	}
+	return nil
+}
+
+// Close implements Storage. It takes the last snapshot. The later calls return the error of the first.
+func (s *FileStorage) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closeErr = errors.Join(s.snapshot(), s.wal.Close())
+		s.wal = nil
+	})
+	return s.closeErr
+}
+
+func (s *FileStorage) GetUserById(ctx context.Context, id string) (*apiv1.UserProfile, error) {
+	return s.mem.GetUserById(ctx, id)
+}
+
+func (s *FileStorage) UpdateUserProfile(ctx context.Context, id, profile string) (*apiv1.UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.mem.GetUserById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := swapUserProfile(u, 0, profile)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.write(&apiv1.StorageRecord{Row: &apiv1.StorageRecord_User{User: updated}}); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (s *FileStorage) CompareAndSwapUserProfile(ctx context.Context, id string, version uint64, profile string) (*apiv1.UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, err := s.mem.GetUserById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := swapUserProfile(u, version, profile)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.write(&apiv1.StorageRecord{Row: &apiv1.StorageRecord_User{User: updated}}); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (s *FileStorage) GetServieClientById(ctx context.Context, id string) (*apiv1.ServiceClient, error) {
+	return s.mem.GetServieClientById(ctx, id)
+}
+
+func (s *FileStorage) CreateServiceClient(ctx context.Context, row *apiv1.ServiceClient) error {
+	return s.create(func() error {
+		_, err := s.mem.GetServieClientById(ctx, row.Id)
+		return err
+	}, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_ServiceClient{ServiceClient: row}})
+}
+
+func (s *FileStorage) GetAuthorizationCodeByCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error) {
+	return s.mem.GetAuthorizationCodeByCode(ctx, code)
+}
+
+func (s *FileStorage) CreateAuthorizationCode(ctx context.Context, row *apiv1.AuthorizationCode) error {
+	return s.create(func() error {
+		_, err := s.mem.GetAuthorizationCodeByCode(ctx, row.Code)
+		return err
+	}, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AuthorizationCode{AuthorizationCode: row}})
+}
+
+func (s *FileStorage) ConsumeAuthorizationCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.mem.GetAuthorizationCodeByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	consumed, err := consumeAuthorizationCode(c, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.write(&apiv1.StorageRecord{Row: &apiv1.StorageRecord_AuthorizationCode{AuthorizationCode: consumed}}); err != nil {
+		return nil, err
+	}
+	return consumed, nil
+}
+
+func (s *FileStorage) GetAccessTokenByToken(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+	return s.mem.GetAccessTokenByToken(ctx, token)
+}
+
+func (s *FileStorage) CreateAccessToken(ctx context.Context, row *apiv1.AccessToken) error {
+	return s.create(func() error {
+		_, err := s.mem.GetAccessTokenByToken(ctx, row.Token)
+		return err
+	}, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AccessToken{AccessToken: row}})
+}
+
+func (s *FileStorage) RevokeAccessToken(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.mem.GetAccessTokenByToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := revokeAccessToken(t, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.write(&apiv1.StorageRecord{Row: &apiv1.StorageRecord_AccessToken{AccessToken: revoked}}); err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+func (s *FileStorage) GetRefreshTokenByToken(ctx context.Context, token string) (*apiv1.RefreshToken, error) {
+	return s.mem.GetRefreshTokenByToken(ctx, token)
+}
+
+func (s *FileStorage) CreateRefreshToken(ctx context.Context, row *apiv1.RefreshToken) error {
+	return s.create(func() error {
+		_, err := s.mem.GetRefreshTokenByToken(ctx, row.Token)
+		return err
+	}, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_RefreshToken{RefreshToken: row}})
+}
+
+func (s *FileStorage) RevokeRefreshToken(ctx context.Context, token string) (*apiv1.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.mem.GetRefreshTokenByToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := revokeRefreshToken(t, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.write(&apiv1.StorageRecord{Row: &apiv1.StorageRecord_RefreshToken{RefreshToken: revoked}}); err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+func (s *FileStorage) GetResourceServerByUri(ctx context.Context, uri string) (*apiv1.ResourceServer, error) {
+	return s.mem.GetResourceServerByUri(ctx, uri)
+}
+
+func (s *FileStorage) ListResourceServers(ctx context.Context) ([]*apiv1.ResourceServer, error) {
+	return s.mem.ListResourceServers(ctx)
+}
+
+func (s *FileStorage) CreateResourceServer(ctx context.Context, row *apiv1.ResourceServer) error {
+	return s.create(func() error {
+		_, err := s.mem.GetResourceServerByUri(ctx, row.Uri)
+		return err
+	}, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_ResourceServer{ResourceServer: row}})
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestFileStorage(t *testing.T) {
+	ctx := context.Background()
+	row := &apiv1.AccessToken{
+		Token:           "token",
+		UserId:          "1",
+		ServiceClientId: "500",
+		Expires:         timestamppb.Now(),
+		Scope:           "profile:view",
+	}
+	// crash stops the storage without the last snapshot.
+	crash := func(s *FileStorage) {
+		close(s.done)
+		s.wg.Wait()
+		s.wal.Close()
+		s.wal = nil
+	}
+	verify := func(t *testing.T, dir string) {
+		s, err := OpenFileStorage(StorageConfig{DataDir: dir})
+		assert.NoError(t, err)
+		defer s.Close()
+		token, err := s.GetAccessTokenByToken(ctx, row.Token)
+		assert.NoError(t, err)
+		assert.EqualExportedValues(t, row, token)
+		user, err := s.GetUserById(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "Updated🎈", user.Profile)
+		_, err = s.GetUserById(ctx, "2")
+		assert.NoError(t, err)
+	}
+	write := func(t *testing.T, dir string) *FileStorage {
+		s, err := OpenFileStorage(StorageConfig{DataDir: dir})
+		assert.NoError(t, err)
+		assert.NoError(t, s.CreateAccessToken(ctx, row))
+		_, err = s.UpdateUserProfile(ctx, "1", "Updated🎈")
+		assert.NoError(t, err)
+		return s
+	}
+
+	t.Run("close", func(t *testing.T) {
+		dir := t.TempDir()
+		s := write(t, dir)
+		assert.NoError(t, s.Close())
+		info, err := os.Stat(filepath.Join(dir, walFileName))
+		assert.NoError(t, err)
+		assert.Zero(t, info.Size())
+		verify(t, dir)
+	})
+	t.Run("replay log", func(t *testing.T) {
+		dir := t.TempDir()
+		crash(write(t, dir))
+		verify(t, dir)
+	})
+	t.Run("snapshot and log", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := OpenFileStorage(StorageConfig{DataDir: dir})
+		assert.NoError(t, err)
+		assert.NoError(t, s.CreateAccessToken(ctx, row))
+		assert.NoError(t, s.Snapshot())
+		_, err = s.UpdateUserProfile(ctx, "1", "Updated🎈")
+		assert.NoError(t, err)
+		crash(s)
+		verify(t, dir)
+	})
+	t.Run("torn tail", func(t *testing.T) {
+		dir := t.TempDir()
+		crash(write(t, dir))
+		f, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND, 0o600)
+		assert.NoError(t, err)
+		_, err = f.Write([]byte{0, 0, 1, 0, 9})
+		assert.NoError(t, err)
+		f.Close()
+		verify(t, dir)
+
+		// new records follow the last complete record
+		s, err := OpenFileStorage(StorageConfig{DataDir: dir})
+		assert.NoError(t, err)
+		assert.NoError(t, s.CreateRefreshToken(ctx, &apiv1.RefreshToken{Token: "refresh"}))
+		crash(s)
+		s, err = OpenFileStorage(StorageConfig{DataDir: dir})
+		assert.NoError(t, err)
+		defer s.Close()
+		_, err = s.GetRefreshTokenByToken(ctx, "refresh")
+		assert.NoError(t, err)
+	})
+	t.Run("closed", func(t *testing.T) {
+		s, err := OpenFileStorage(StorageConfig{DataDir: t.TempDir()})
+		assert.NoError(t, err)
+		assert.NoError(t, s.Close())
+		assert.NoError(t, s.Close())
+		assert.Error(t, s.CreateAccessToken(ctx, row))
+	})
+	t.Run("closed concurrently", func(t *testing.T) {
+		s, err := OpenFileStorage(StorageConfig{DataDir: t.TempDir()})
+		assert.NoError(t, err)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, s.Close())
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestNewStorage(t *testing.T) {
+	s, err := NewStorage(StorageConfig{})
+	assert.NoError(t, err)
+	assert.IsType(t, &Database{}, s)
+	s, err = NewStorage(StorageConfig{Driver: StorageFile, DataDir: t.TempDir()})
+	assert.NoError(t, err)
+	assert.IsType(t, &FileStorage{}, s)
+	assert.NoError(t, s.Close())
+	_, err = NewStorage(StorageConfig{Driver: StorageFile})
+	assert.Error(t, err)
+	_, err = NewStorage(StorageConfig{Driver: "sql"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"connectrpc.com/connect"
+	"github.com/yyyoichi/OhAuth0.1/api/v1/apiv1connect"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthHandler implements the standard gRPC health checking protocol.
+// The server and the database service are serving until [done] is closed by the shutdown.
+type healthHandler struct {
+	done <-chan struct{}
+}
+
+func (h *healthHandler) status(service string) (healthv1.HealthCheckResponse_ServingStatus, bool) {
+	if service != "" && service != apiv1connect.DatabaseServiceName {
+		return healthv1.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	select {
+	case <-h.done:
+		return healthv1.HealthCheckResponse_NOT_SERVING, true
+	default:
+		return healthv1.HealthCheckResponse_SERVING, true
+	}
+}
+
+// Check implements healthv1connect.HealthHandler.
+func (h *healthHandler) Check(_ context.Context, req *connect.Request[healthv1.HealthCheckRequest]) (*connect.Response[healthv1.HealthCheckResponse], error) {
+	status, found := h.status(req.Msg.GetService())
+	if !found {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("unknown service '%s'", req.Msg.GetService()))
+	}
+	return connect.NewResponse(&healthv1.HealthCheckResponse{Status: status}), nil
+}
+
+// Watch implements healthv1connect.HealthHandler. The status changes only once, by the shutdown.
+func (h *healthHandler) Watch(ctx context.Context, req *connect.Request[healthv1.HealthCheckRequest], stream *connect.ServerStream[healthv1.HealthCheckResponse]) error {
+	status, found := h.status(req.Msg.GetService())
+	if err := stream.Send(&healthv1.HealthCheckResponse{Status: status}); err != nil {
+		return err
+	}
+	if !found || status == healthv1.HealthCheckResponse_NOT_SERVING {
+		<-ctx.Done()
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-h.done:
+	}
+	return stream.Send(&healthv1.HealthCheckResponse{Status: healthv1.HealthCheckResponse_NOT_SERVING})
+}
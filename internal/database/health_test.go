@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	healthv1connect "github.com/yyyoichi/OhAuth0.1/api/grpc/health/v1/grpc_health_v1connect"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/api/v1/apiv1connect"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthHandler(t *testing.T) {
+	done := make(chan struct{})
+	h := &healthHandler{done: done}
+	check := func(service string) (healthv1.HealthCheckResponse_ServingStatus, error) {
+		resp, err := h.Check(context.Background(), connect.NewRequest(&healthv1.HealthCheckRequest{Service: service}))
+		if err != nil {
+			return healthv1.HealthCheckResponse_UNKNOWN, err
+		}
+		return resp.Msg.GetStatus(), nil
+	}
+	for _, service := range []string{"", apiv1connect.DatabaseServiceName} {
+		status, err := check(service)
+		assert.NoError(t, err)
+		assert.Equal(t, healthv1.HealthCheckResponse_SERVING, status)
+	}
+	_, err := check("unknown")
+	assert.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	close(done)
+	status, err := check("")
+	assert.NoError(t, err)
+	assert.Equal(t, healthv1.HealthCheckResponse_NOT_SERVING, status)
+}
+
+func TestServerShutdown(t *testing.T) {
+	port := "3371"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, err := NewDatabaseServer(ctx, ServerConfig{Port: port, Insecure: true})
+	assert.NoError(t, err)
+	// the port is taken
+	_, err = NewDatabaseServer(ctx, ServerConfig{Port: port, Insecure: true})
+	assert.Error(t, err)
+
+	client, err := NewDatabaseClient(ctx, ClientConfig{URL: "http://localhost:" + port})
+	assert.NoError(t, err)
+	assert.NoError(t, client.Ping(ctx))
+	health := healthv1connect.NewHealthClient(http.DefaultClient, "http://localhost:"+port)
+	resp, err := health.Check(ctx, connect.NewRequest(&healthv1.HealthCheckRequest{}))
+	assert.NoError(t, err)
+	assert.Equal(t, healthv1.HealthCheckResponse_SERVING, resp.Msg.GetStatus())
+
+	// a watch is ended by the shutdown, so that it does not hold the drain
+	sctx, scancel := context.WithCancel(context.Background())
+	defer scancel()
+	stream, err := client.client.Watch(sctx, connect.NewRequest(&apiv1.WatchRequest{}))
+	assert.NoError(t, err)
+	assert.True(t, stream.Receive())
+
+	cancel()
+	assert.False(t, stream.Receive())
+	assert.Equal(t, connect.CodeUnavailable, connect.CodeOf(stream.Err()))
+	assert.NoError(t, server.Wait())
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/yyyoichi/OhAuth0.1/internal/lifecycle"
+)
+
+// InProcessURL is the URL of an [InProcessServer], which its clients dial by [InProcessServer.Dial].
+const InProcessURL = "http://in-process"
+
+// InProcessServer is the database server which serves the clients in the process over in-memory connections,
+// without listening on the network. The storage, the token hashes, the watchers, the sweeper and the metrics
+// are built as [NewDatabaseServer] does.
+type InProcessServer struct {
+	*lifecycle.Server
+	listener *pipeListener
+
+	// keys of the callers, generated for the process
+	AuthServerKey, ResourceServerKey string
+}
+
+// NewInProcessServer starts the server of [config], whose port, TLS and callers are ignored.
+// Expired rows are swept until [ctx] is done, and then the server is drained and the storage is closed.
+func NewInProcessServer(ctx context.Context, config ServerConfig) (*InProcessServer, error) {
+	authKey, err := newCallerKey()
+	if err != nil {
+		return nil, err
+	}
+	resourceKey, err := newCallerKey()
+	if err != nil {
+		return nil, err
+	}
+	config.TLS = nil
+	config.Callers = Callers(authKey, resourceKey)
+	// the keys are sent over plain HTTP, but never leave the process
+	config.Insecure = true
+	listener := newPipeListener()
+	s, err := newServer(ctx, config, &http.Server{}, listener)
+	if err != nil {
+		return nil, err
+	}
+	s.Start(ctx)
+	return &InProcessServer{
+		Server:            s,
+		AuthServerKey:     authKey,
+		ResourceServerKey: resourceKey,
+		listener:          listener,
+	}, nil
+}
+
+// Dial connects to the server. It is set to [ClientConfig.Dial] with [InProcessURL].
+func (s *InProcessServer) Dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	return s.listener.dial(ctx)
+}
+
+func newCallerKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// pipeListener accepts the connections of [net.Pipe] dialed in the process.
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+	case <-ctx.Done():
+	}
+	server.Close()
+	client.Close()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, net.ErrClosed
+}
+
+// Accept implements net.Listener.
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener.
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr implements net.Listener.
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "in-process" }
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tables of [RowCounts]
+const (
+	TableUsers              = "users"
+	TableServiceClients     = "service_clients"
+	TableAuthorizationCodes = "authorization_codes"
+	TableAccessTokens       = "access_tokens"
+	TableRefreshTokens      = "refresh_tokens"
+	TableResourceServers    = "resource_servers"
+)
+
+// RowCounts is the number of the stored rows by the table.
+type RowCounts map[string]int
+
+// CountRows returns the number of the stored rows of each table.
+func (db *Database) CountRows() RowCounts {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return RowCounts{
+		TableUsers:              len(db.userById),
+		TableServiceClients:     len(db.serviceClientById),
+		TableAuthorizationCodes: len(db.authorizationCodeByCode),
+		TableAccessTokens:       len(db.accessTokenByToken),
+		TableRefreshTokens:      len(db.refreshTokenByToken),
+		TableResourceServers:    len(db.resourceServerByUri),
+	}
+}
+
+// CountRows implements Storage.
+func (s *FileStorage) CountRows() RowCounts {
+	return s.mem.CountRows()
+}
+
+// rowsCollector reports the stored rows as gauges when the metrics are gathered.
+type rowsCollector struct {
+	storage Storage
+	desc    *prometheus.Desc
+}
+
+func newRowsCollector(storage Storage) *rowsCollector {
+	return &rowsCollector{
+		storage: storage,
+		desc:    prometheus.NewDesc("database_rows", "Stored rows by the table.", []string{"table"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *rowsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *rowsCollector) Collect(ch chan<- prometheus.Metric) {
+	for table, n := range c.storage.CountRows() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), table)
+	}
+}
+
+// sweeperCollector reports the [SweeperStats] when the metrics are gathered.
+type sweeperCollector struct {
+	sweeper *Sweeper
+	runs    *prometheus.Desc
+	lastRun *prometheus.Desc
+	evicted *prometheus.Desc
+}
+
+func newSweeperCollector(sweeper *Sweeper) *sweeperCollector {
+	return &sweeperCollector{
+		sweeper: sweeper,
+		runs:    prometheus.NewDesc("database_sweeper_runs_total", "Sweeps of the expired rows.", nil, nil),
+		lastRun: prometheus.NewDesc("database_sweeper_last_run_timestamp_seconds", "Time of the last sweep.", nil, nil),
+		evicted: prometheus.NewDesc("database_sweeper_evicted_total", "Evicted expired rows by the table.", []string{"table"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *sweeperCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.runs
+	ch <- c.lastRun
+	ch <- c.evicted
+}
+
+// Collect implements prometheus.Collector.
+func (c *sweeperCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.sweeper.Stats()
+	ch <- prometheus.MustNewConstMetric(c.runs, prometheus.CounterValue, float64(stats.Runs))
+	var lastRun float64
+	if !stats.LastRun.IsZero() {
+		lastRun = float64(stats.LastRun.UnixNano()) / 1e9
+	}
+	ch <- prometheus.MustNewConstMetric(c.lastRun, prometheus.GaugeValue, lastRun)
+	for table, n := range map[string]int{
+		TableAuthorizationCodes: stats.Evicted.AuthorizationCodes,
+		TableAccessTokens:       stats.Evicted.AccessTokens,
+		TableRefreshTokens:      stats.Evicted.RefreshTokens,
+	} {
+		ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, float64(n), table)
+	}
+}
+
+// token types of [revocations], as the 'token_type_hint' of RFC 7009
+const (
+	tokenTypeAccessToken  = "access_token"
+	tokenTypeRefreshToken = "refresh_token"
+)
+
+// revocations counts the revoked tokens by the type. A nil *revocations counts nothing.
+type revocations struct {
+	counter *prometheus.CounterVec
+}
+
+func newRevocations(registerer prometheus.Registerer) *revocations {
+	r := &revocations{
+		counter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "database_tokens_revoked_total",
+			Help: "Revoked tokens by the type, 'access_token' or 'refresh_token'.",
+		}, []string{"token_type"}),
+	}
+	registerer.MustRegister(r.counter)
+	return r
+}
+
+func (r *revocations) revoked(tokenType string) {
+	if r == nil {
+		return
+	}
+	r.counter.WithLabelValues(tokenType).Inc()
+}
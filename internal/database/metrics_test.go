@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestRowsCollector(t *testing.T) {
+	db, err := NewDatabase()
+	assert.NoError(t, err)
+	assert.NoError(t, db.CreateAccessToken(context.Background(), &apiv1.AccessToken{
+		Token:   "token",
+		Expires: timestamppb.New(time.Now().Add(time.Hour)),
+	}))
+	counts := db.CountRows()
+	assert.Len(t, counts, 6)
+	assert.Equal(t, 1, counts[TableAccessTokens])
+	assert.Zero(t, counts[TableRefreshTokens])
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newRowsCollector(&watchedStorage{Storage: db, journal: NewJournal(0)}))
+	expected := fmt.Sprintf(`
+# HELP database_rows Stored rows by the table.
+# TYPE database_rows gauge
+database_rows{table="access_tokens"} 1
+database_rows{table="authorization_codes"} 0
+database_rows{table="refresh_tokens"} 0
+database_rows{table="resource_servers"} %d
+database_rows{table="service_clients"} %d
+database_rows{table="users"} %d
+`, counts[TableResourceServers], counts[TableServiceClients], counts[TableUsers])
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "database_rows"))
+}
+
+func TestRevocations(t *testing.T) {
+	db, err := NewDatabase()
+	assert.NoError(t, err)
+	hasher, err := NewTokenHasher([]byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	registry := prometheus.NewRegistry()
+	h := &handler{Storage: db, hasher: hasher, revocations: newRevocations(registry)}
+	ctx := context.Background()
+	expires := timestamppb.New(time.Now().Add(time.Hour))
+	_, err = h.CreateAccessTokenUnary(ctx, connect.NewRequest(&apiv1.CreateAccessTokenRequest{Token: &apiv1.AccessToken{Token: "access", Expires: expires}}))
+	assert.NoError(t, err)
+	_, err = h.CreateRefreshTokenUnary(ctx, connect.NewRequest(&apiv1.CreateRefreshTokenRequest{Token: &apiv1.RefreshToken{Token: "refresh", Expires: expires}}))
+	assert.NoError(t, err)
+
+	_, err = h.RevokeAccessTokenUnary(ctx, connect.NewRequest(&apiv1.RevokeAccessTokenRequest{Token: "access"}))
+	assert.NoError(t, err)
+	_, err = h.RevokeRefreshTokenUnary(ctx, connect.NewRequest(&apiv1.RevokeRefreshTokenRequest{Token: "refresh"}))
+	assert.NoError(t, err)
+	// a failed revocation is not counted
+	_, err = h.RevokeAccessTokenUnary(ctx, connect.NewRequest(&apiv1.RevokeAccessTokenRequest{Token: "access"}))
+	assert.Error(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(h.revocations.counter.WithLabelValues(tokenTypeAccessToken)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(h.revocations.counter.WithLabelValues(tokenTypeRefreshToken)))
+
+	// a nil revocations counts nothing
+	assert.NotPanics(t, func() {
+		var r *revocations
+		r.revoked(tokenTypeAccessToken)
+	})
+}
+
+func TestSweeperCollector(t *testing.T) {
+	db, err := NewDatabase()
+	assert.NoError(t, err)
+	now := time.Now()
+	assert.NoError(t, db.CreateAccessToken(context.Background(), &apiv1.AccessToken{
+		Token:   "expired",
+		Expires: timestamppb.New(now.Add(-time.Hour)),
+	}))
+	sweeper := NewSweeper(db, SweeperConfig{GracePeriod: time.Minute})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newSweeperCollector(sweeper))
+	sweeper.Sweep(now)
+	sweeper.Sweep(now.Add(time.Minute))
+
+	expected := fmt.Sprintf(`
+# HELP database_sweeper_evicted_total Evicted expired rows by the table.
+# TYPE database_sweeper_evicted_total counter
+database_sweeper_evicted_total{table="access_tokens"} 1
+database_sweeper_evicted_total{table="authorization_codes"} 0
+database_sweeper_evicted_total{table="refresh_tokens"} 0
+# HELP database_sweeper_last_run_timestamp_seconds Time of the last sweep.
+# TYPE database_sweeper_last_run_timestamp_seconds gauge
+database_sweeper_last_run_timestamp_seconds %g
+# HELP database_sweeper_runs_total Sweeps of the expired rows.
+# TYPE database_sweeper_runs_total counter
+database_sweeper_runs_total 2
+`, float64(now.Add(time.Minute).UnixNano())/1e9)
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected)))
+}
@@ -0,0 +1,235 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/yyyoichi/OhAuth0.1/internal/dberr"
+)
+
+// the errors live in [dberr] for the packages responding them without importing the database.
+var (
+	ErrUnavailable = dberr.ErrUnavailable
+	ErrTimeout     = dberr.ErrTimeout
+	ErrCircuitOpen = dberr.ErrCircuitOpen
+)
+
+const (
+	defaultTimeout          = time.Duration(3) * time.Second
+	defaultMaxAttempts      = 3
+	defaultRetryBaseDelay   = time.Duration(50) * time.Millisecond
+	defaultRetryMaxDelay    = time.Duration(1) * time.Second
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = time.Duration(5) * time.Second
+)
+
+type (
+	RetryConfig struct {
+		// attempts of an idempotent read including the first. default 3. 1 disables retries.
+		MaxAttempts int
+		// the n-th retry waits a random delay up to BaseDelay*2^n, capped by MaxDelay.
+		// default 50 milliseconds and 1 second.
+		BaseDelay time.Duration
+		MaxDelay  time.Duration
+	}
+	BreakerConfig struct {
+		// consecutive failures which open the breaker. default 5. negative disables the breaker.
+		FailureThreshold int
+		// an open breaker lets a trial call through after this. default 5 seconds.
+		OpenTimeout time.Duration
+	}
+	// resilienceInterceptor bounds each unary call by a deadline, retries the idempotent ones,
+	// and fails fast while the server keeps failing.
+	resilienceInterceptor struct {
+		timeout  time.Duration
+		timeouts map[string]time.Duration
+		retry    RetryConfig
+		breaker  *breaker
+	}
+)
+
+func newResilienceInterceptor(config ClientConfig) *resilienceInterceptor {
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.Retry.MaxAttempts <= 0 {
+		config.Retry.MaxAttempts = defaultMaxAttempts
+	}
+	if config.Retry.BaseDelay <= 0 {
+		config.Retry.BaseDelay = defaultRetryBaseDelay
+	}
+	if config.Retry.MaxDelay <= 0 {
+		config.Retry.MaxDelay = defaultRetryMaxDelay
+	}
+	i := &resilienceInterceptor{
+		timeout:  config.Timeout,
+		timeouts: config.Timeouts,
+		retry:    config.Retry,
+	}
+	if config.Breaker.FailureThreshold >= 0 {
+		i.breaker = newBreaker(config.Breaker)
+	}
+	return i
+}
+
+func (i *resilienceInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		attempts := 1
+		// only the calls without side effects are safe to send twice
+		if req.Spec().IdempotencyLevel != connect.IdempotencyUnknown {
+			attempts = i.retry.MaxAttempts
+		}
+		var err error
+		for attempt := 0; attempt < attempts; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					return nil, err
+				case <-time.After(i.backoff(attempt)):
+				}
+			}
+			var resp connect.AnyResponse
+			resp, err = i.call(ctx, req, next)
+			if err == nil || !retryable(ctx, err) {
+				return resp, err
+			}
+		}
+		return nil, err
+	}
+}
+
+// call sends [req] once within the deadline of the procedure.
+func (i *resilienceInterceptor) call(ctx context.Context, req connect.AnyRequest, next connect.UnaryFunc) (connect.AnyResponse, error) {
+	if i.breaker != nil {
+		if err := i.breaker.allow(); err != nil {
+			return nil, connect.NewError(connect.CodeUnavailable, err)
+		}
+	}
+	timeout, found := i.timeouts[req.Spec().Procedure]
+	if !found {
+		timeout = i.timeout
+	}
+	callCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	resp, err := next(callCtx, req)
+	if i.breaker != nil {
+		if ctx.Err() != nil {
+			// the caller gave up, which tells nothing about the server
+			i.breaker.release()
+		} else {
+			i.breaker.record(failed(err))
+		}
+	}
+	return resp, err
+}
+
+// backoff returns the delay before the [attempt]-th attempt, with full jitter.
+func (i *resilienceInterceptor) backoff(attempt int) time.Duration {
+	d := i.retry.BaseDelay << (attempt - 1)
+	if d <= 0 || d > i.retry.MaxDelay {
+		d = i.retry.MaxDelay
+	}
+	return rand.N(d) + 1
+}
+
+func (i *resilienceInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	// streams last longer than a deadline, and reconnect by themselves
+	return next
+}
+
+func (i *resilienceInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
+
+// failed reports whether [err] tells the server is down, rather than an answer of it.
+func failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := connect.CodeOf(err)
+	return code == connect.CodeUnavailable || code == connect.CodeDeadlineExceeded
+}
+
+// retryable reports whether the call may succeed if sent again. the caller's own deadline is not retried.
+func retryable(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && failed(err) && !errors.Is(err, ErrCircuitOpen)
+}
+
+// breaker is a circuit breaker. it opens after consecutive failures, and lets one trial call through
+// after the open timeout. the trial closes it if succeeded, or opens it again.
+type breaker struct {
+	config BreakerConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	// zero while closed
+	openedAt time.Time
+	// a trial call is in flight
+	probing bool
+}
+
+func newBreaker(config BreakerConfig) *breaker {
+	if config.FailureThreshold == 0 {
+		config.FailureThreshold = defaultFailureThreshold
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaultOpenTimeout
+	}
+	return &breaker{config: config, now: time.Now}
+}
+
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if b.probing {
+		// the trial decides it within a call
+		return &dberr.RetryError{Err: ErrCircuitOpen, After: time.Second}
+	}
+	if elapsed := b.now().Sub(b.openedAt); elapsed < b.config.OpenTimeout {
+		return &dberr.RetryError{Err: ErrCircuitOpen, After: b.config.OpenTimeout - elapsed}
+	}
+	b.probing = true
+	return nil
+}
+
+// release ends a trial call without the result.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) record(failure bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failure {
+		if !b.openedAt.IsZero() {
+			slog.Info("database circuit breaker is closed")
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		b.probing = false
+		return
+	}
+	b.failures++
+	if b.probing || (b.openedAt.IsZero() && b.failures >= b.config.FailureThreshold) {
+		if b.openedAt.IsZero() {
+			slog.Warn("database circuit breaker is open", slog.Int("failures", b.failures))
+		}
+		b.openedAt = b.now()
+		b.probing = false
+	}
+}
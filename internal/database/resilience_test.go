@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/api/v1/apiv1connect"
+	"github.com/yyyoichi/OhAuth0.1/internal/dberr"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// flakyHandler fails the first [failures] calls with [code], or sleeps [delay].
+type flakyHandler struct {
+	apiv1connect.UnimplementedDatabaseServiceHandler
+	failures int32
+	code     connect.Code
+	delay    time.Duration
+	calls    atomic.Int32
+}
+
+func (h *flakyHandler) answer(ctx context.Context) error {
+	if h.calls.Add(1) <= h.failures {
+		return connect.NewError(h.code, errors.New("flaky"))
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(h.delay):
+	}
+	return nil
+}
+
+func (h *flakyHandler) GetUserUnary(ctx context.Context, _ *connect.Request[apiv1.GetUserRequest]) (*connect.Response[apiv1.GetUserResponse], error) {
+	if err := h.answer(ctx); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&apiv1.GetUserResponse{User: &apiv1.UserProfile{Id: "1"}}), nil
+}
+
+func (h *flakyHandler) CreateAccessTokenUnary(ctx context.Context, _ *connect.Request[apiv1.CreateAccessTokenRequest]) (*connect.Response[apiv1.CreateAccessTokenResponse], error) {
+	if err := h.answer(ctx); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&apiv1.CreateAccessTokenResponse{}), nil
+}
+
+func newFlakyClient(t *testing.T, h *flakyHandler, config ClientConfig) *Client {
+	mux := http.NewServeMux()
+	mux.Handle(apiv1connect.NewDatabaseServiceHandler(h))
+	server := httptest.NewServer(h2c.NewHandler(mux, &http2.Server{}))
+	t.Cleanup(server.Close)
+	config.URL = server.URL
+	config.Retry.BaseDelay = time.Millisecond
+	client, err := NewDatabaseClient(context.Background(), config)
+	assert.NoError(t, err)
+	return client
+}
+
+func TestResilientClient(t *testing.T) {
+	ctx := context.Background()
+	t.Run("retry reads", func(t *testing.T) {
+		h := &flakyHandler{failures: 2, code: connect.CodeUnavailable}
+		client := newFlakyClient(t, h, ClientConfig{})
+		user, err := client.GetUserById(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", user.GetId())
+		assert.EqualValues(t, 3, h.calls.Load())
+	})
+	t.Run("give up", func(t *testing.T) {
+		h := &flakyHandler{failures: 5, code: connect.CodeUnavailable}
+		client := newFlakyClient(t, h, ClientConfig{Retry: RetryConfig{MaxAttempts: 2}})
+		_, err := client.GetUserById(ctx, "1")
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.EqualValues(t, 2, h.calls.Load())
+	})
+	t.Run("writes are not retried", func(t *testing.T) {
+		h := &flakyHandler{failures: 1, code: connect.CodeUnavailable}
+		client := newFlakyClient(t, h, ClientConfig{})
+		err := client.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "token"})
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.EqualValues(t, 1, h.calls.Load())
+	})
+	t.Run("answers are not retried", func(t *testing.T) {
+		h := &flakyHandler{failures: 1, code: connect.CodeNotFound}
+		client := newFlakyClient(t, h, ClientConfig{})
+		_, err := client.GetUserById(ctx, "1")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.EqualValues(t, 1, h.calls.Load())
+	})
+	t.Run("timeout", func(t *testing.T) {
+		h := &flakyHandler{delay: time.Second}
+		client := newFlakyClient(t, h, ClientConfig{
+			Timeout:  time.Second,
+			Timeouts: map[string]time.Duration{apiv1connect.DatabaseServiceCreateAccessTokenUnaryProcedure: time.Duration(20) * time.Millisecond},
+			Retry:    RetryConfig{MaxAttempts: 1},
+		})
+		err := client.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "token"})
+		assert.ErrorIs(t, err, ErrTimeout)
+		assert.ErrorIs(t, err, ErrUnavailable)
+	})
+	t.Run("circuit breaker", func(t *testing.T) {
+		h := &flakyHandler{failures: 3, code: connect.CodeUnavailable}
+		client := newFlakyClient(t, h, ClientConfig{
+			Retry:   RetryConfig{MaxAttempts: 1},
+			Breaker: BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Duration(50) * time.Millisecond},
+		})
+		for range 2 {
+			_, err := client.GetUserById(ctx, "1")
+			assert.ErrorIs(t, err, ErrUnavailable)
+		}
+		// fails fast without calling the server
+		_, err := client.GetUserById(ctx, "1")
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.EqualValues(t, 2, h.calls.Load())
+		// the trial fails and opens it again
+		time.Sleep(time.Duration(60) * time.Millisecond)
+		_, err = client.GetUserById(ctx, "1")
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+		_, err = client.GetUserById(ctx, "1")
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		// the trial succeeds and closes it
+		time.Sleep(time.Duration(60) * time.Millisecond)
+		_, err = client.GetUserById(ctx, "1")
+		assert.NoError(t, err)
+		_, err = client.GetUserById(ctx, "1")
+		assert.NoError(t, err)
+	})
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute})
+	b.now = func() time.Time { return now }
+
+	b.record(true)
+	b.record(false)
+	b.record(true)
+	assert.NoError(t, b.allow(), "failures must be consecutive")
+	b.record(true)
+	err := b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	after, _ := dberr.RetryAfter(err)
+	assert.Equal(t, 60, after)
+	now = now.Add(45 * time.Second)
+	after, _ = dberr.RetryAfter(b.allow())
+	assert.Equal(t, 15, after)
+
+	now = now.Add(15 * time.Second)
+	assert.NoError(t, b.allow())
+	// one trial at a time
+	assert.ErrorIs(t, b.allow(), ErrCircuitOpen)
+	// the caller gave up the trial
+	b.release()
+	assert.NoError(t, b.allow())
+	b.record(false)
+	assert.NoError(t, b.allow())
+	assert.NoError(t, b.allow())
+}
@@ -2,189 +2,461 @@ package database
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"time"
 
 	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	healthv1connect "github.com/yyyoichi/OhAuth0.1/api/grpc/health/v1/grpc_health_v1connect"
 	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
 	"github.com/yyyoichi/OhAuth0.1/api/v1/apiv1connect"
-	"golang.org/x/net/http2"
-	"golang.org/x/net/http2/h2c"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+	"github.com/yyyoichi/OhAuth0.1/internal/lifecycle"
+	"github.com/yyyoichi/OhAuth0.1/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 type (
 	ServerConfig struct {
-		Port string
+		Port    string
+		Storage StorageConfig
+		Sweeper SweeperConfig
+		// key of the hashes of codes and tokens. must not change while the rows are kept.
+		// a random pepper is used for the memory storage if empty.
+		TokenPepper []byte
+		// events kept for the watchers to resume. default 4096.
+		WatchCapacity int
+		// serves TLS if set, or plaintext HTTP/2 otherwise. set ClientCAs to verify client certificates.
+		TLS *tls.Config
+		// services allowed to call. required unless Insecure.
+		Callers []Caller
+		// lets anyone call every RPC if Callers is empty, and accepts their keys over plain HTTP. for development only.
+		Insecure bool
+		// in-flight requests are waited this long on shutdown. default 10 seconds.
+		DrainTimeout time.Duration
+		// registers the metrics of the RPCs and the stored rows, and serves them at /metrics. Optional.
+		Registry *prometheus.Registry
+		// traces the RPCs. Optional.
+		TracerProvider trace.TracerProvider
 	}
 	handler struct {
-		*Database
+		Storage
+		hasher  *TokenHasher
+		journal *Journal
+		// nil without the registry
+		revocations *revocations
+		// closed when the server begins to shut down
+		done <-chan struct{}
 		// apiv1connect.UnimplementedDatabaseServiceHandler
 	}
 )
 
-func NewDatabaseServer(config ServerConfig) error {
+// NewDatabaseServer starts the server. Expired rows are swept until [ctx] is done,
+// and then the server is drained and the storage is closed. Wait for it by [lifecycle.Server.Wait].
+func NewDatabaseServer(ctx context.Context, config ServerConfig) (*lifecycle.Server, error) {
 	if config.Port == "" {
 		config.Port = "3306"
 	}
 	addr := fmt.Sprintf(":%s", config.Port)
-	db, err := NewDatabase()
+	s, err := newServer(ctx, config, &http.Server{Addr: addr}, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	rpc := http.NewServeMux()
-	rpc.Handle(apiv1connect.NewDatabaseServiceHandler(&handler{
-		Database: db,
-	}))
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      h2c.NewHandler(rpc, &http2.Server{}),
-		ReadTimeout:  time.Duration(5) * time.Second, // クライアントからのリクエスト読み取りタイムアウト
-		WriteTimeout: time.Duration(5) * time.Second, // レスポンス書き込みタイムアウト
-		IdleTimeout:  0,
-	}
-	go func() {
+	if config.TLS != nil {
+		log.Println("Starting HTTP/2 server with TLS on", addr)
+	} else {
 		log.Println("Starting HTTP/2 server on", addr)
-		if err := server.ListenAndServe(); err != nil {
-			log.Panic(err)
-		}
-	}()
-	return nil
+	}
+	s.Start(ctx)
+	return s, nil
 }
 
-// CreateAccessToken implements apiv1connect.DatabaseServiceHandler.
-func (h *handler) CreateAccessToken(ctx context.Context, stream *connect.BidiStream[apiv1.CreateAccessTokenRequest, apiv1.CreateAccessTokenResponse]) error {
-	for {
-		msg, err := stream.Receive()
-		if errors.Is(err, io.EOF) {
-			return nil
-		} else if err != nil {
-			return err
+// newServer builds the storage and the handler of [server], and binds it to [listener], or its address if nil.
+func newServer(ctx context.Context, config ServerConfig, server *http.Server, listener net.Listener) (*lifecycle.Server, error) {
+	if len(config.TokenPepper) == 0 {
+		if config.Storage.Driver != "" && config.Storage.Driver != StorageMemory {
+			return nil, errors.New("token pepper is required for a persistent storage")
 		}
-		if err := h.Database.CreateAccessToken(ctx, msg.GetToken()); err != nil {
-			return h.newConnectError(err)
+		pepper, err := NewRandomPepper()
+		if err != nil {
+			return nil, err
 		}
-		if err := stream.Send(&apiv1.CreateAccessTokenResponse{}); err != nil {
-			return err
+		config.TokenPepper = pepper
+	}
+	if len(config.Callers) == 0 && !config.Insecure {
+		return nil, errors.New("callers are required unless the server is insecure")
+	}
+	hasher, err := NewTokenHasher(config.TokenPepper)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := NewStorage(config.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	journal := NewJournal(config.WatchCapacity)
+	storage = &watchedStorage{Storage: storage, journal: journal}
+
+	sweeper := NewSweeper(storage, config.Sweeper)
+	go sweeper.Run(ctx)
+
+	// rejected calls are traced, logged and counted as well
+	var interceptors []connect.Interceptor
+	if config.TracerProvider != nil {
+		tracer, err := tracing.NewInterceptor(config.TracerProvider)
+		if err != nil {
+			return nil, errors.Join(err, storage.Close())
 		}
-		continue
+		interceptors = append(interceptors, tracer)
 	}
+	interceptors = append(interceptors, interceptor.NewRequestID(), interceptor.NewLogging(nil))
+	var gatherer prometheus.Gatherer
+	var revocations *revocations
+	if config.Registry != nil {
+		revocations = newRevocations(config.Registry)
+		interceptors = append(interceptors, interceptor.NewMetrics(config.Registry))
+		config.Registry.MustRegister(newRowsCollector(storage), newSweeperCollector(sweeper))
+		gatherer = config.Registry
+	}
+	if len(config.Callers) > 0 {
+		interceptors = append(interceptors, newAuthInterceptor(config.Callers, config.Insecure))
+	} else {
+		slog.Warn("insecure database server accepts any caller")
+	}
+	rpc := http.NewServeMux()
+	rpc.Handle(withTLSState(withoutStreamDeadlines(apiv1connect.NewDatabaseServiceHandler(&handler{
+		Storage: storage,
+		hasher:  hasher,
+		journal: journal,
+		done:    ctx.Done(),
+
+		revocations: revocations,
+	}, connect.WithInterceptors(interceptors...)))))
+	// probes are answered without credentials
+	rpc.Handle(withoutStreamDeadlines(healthv1connect.NewHealthHandler(&healthHandler{done: ctx.Done()})))
+	server.Handler = rpc
+	server.ReadTimeout = time.Duration(5) * time.Second  // クライアントからのリクエスト読み取りタイムアウト
+	server.WriteTimeout = time.Duration(5) * time.Second // レスポンス書き込みタイムアウト
+	server.IdleTimeout = 0
+	if config.TLS != nil {
+		server.TLSConfig = config.TLS.Clone()
+		server.TLSConfig.NextProtos = []string{"h2"}
+	}
+	s, err := lifecycle.Listen(server, lifecycle.Config{
+		DrainTimeout: config.DrainTimeout,
+		OnStop:       storage.Close,
+		H2C:          config.TLS == nil,
+		Metrics:      gatherer,
+		Listener:     listener,
+	})
+	if err != nil {
+		return nil, errors.Join(err, storage.Close())
+	}
+	return s, nil
 }
 
-// CreateAuthorizationCode implements apiv1connect.DatabaseServiceHandler.
-func (h *handler) CreateAuthorizationCode(ctx context.Context, stream *connect.BidiStream[apiv1.CreateAuthorizationCodeRequest, apiv1.CreateAuthorizationCodeResponse]) error {
-	for {
-		msg, err := stream.Receive()
-		if errors.Is(err, io.EOF) {
-			return nil
-		} else if err != nil {
-			return err
-		}
-		if err := h.Database.CreateAuthorizationCode(ctx, msg.GetCode()); err != nil {
-			return h.newConnectError(err)
+var errShuttingDown = errors.New("database server is shutting down")
+
+// streamingProcedures are the procedures of the streaming RPCs served by the database server, by their descriptors.
+var streamingProcedures = func() map[string]bool {
+	procedures := map[string]bool{}
+	for _, file := range []protoreflect.FileDescriptor{apiv1.File_api_v1_ohauth_proto, healthv1.File_grpc_health_v1_health_proto} {
+		for i := range file.Services().Len() {
+			service := file.Services().Get(i)
+			for j := range service.Methods().Len() {
+				method := service.Methods().Get(j)
+				if method.IsStreamingClient() || method.IsStreamingServer() {
+					procedures["/"+string(service.FullName())+"/"+string(method.Name())] = true
+				}
+			}
 		}
-		if err := stream.Send(&apiv1.CreateAuthorizationCodeResponse{}); err != nil {
-			return err
+	}
+	return procedures
+}()
+
+// withoutStreamDeadlines lifts the read and write timeouts of the server from the streaming RPCs,
+// which last longer than a request. the other RPCs keep them.
+func withoutStreamDeadlines(path string, next http.Handler) (string, http.Handler) {
+	return path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamingProcedures[r.URL.Path] {
+			rc := http.NewResponseController(w)
+			if err := rc.SetReadDeadline(time.Time{}); err != nil {
+				slog.WarnContext(r.Context(), "cannot clear read deadline", slog.Any("error", err))
+			}
+			if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+				slog.WarnContext(r.Context(), "cannot clear write deadline", slog.Any("error", err))
+			}
 		}
-		continue
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CreateAccessTokenUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) CreateAccessTokenUnary(ctx context.Context, req *connect.Request[apiv1.CreateAccessTokenRequest]) (*connect.Response[apiv1.CreateAccessTokenResponse], error) {
+	if err := h.Storage.CreateAccessToken(ctx, h.hashAccessToken(req.Msg.GetToken())); err != nil {
+		return nil, h.newConnectError(err)
 	}
+	return connect.NewResponse(&apiv1.CreateAccessTokenResponse{}), nil
 }
 
-// CreateRefreshToken implements apiv1connect.DatabaseServiceHandler.
-func (h *handler) CreateRefreshToken(ctx context.Context, stream *connect.BidiStream[apiv1.CreateRefreshTokenRequest, apiv1.CreateRefreshTokenResponse]) error {
-	for {
-		msg, err := stream.Receive()
-		if errors.Is(err, io.EOF) {
-			return nil
-		} else if err != nil {
-			return err
-		}
-		if err := h.Database.CreateRefreshToken(ctx, msg.GetToken()); err != nil {
-			return h.newConnectError(err)
-		}
-		if err := stream.Send(&apiv1.CreateRefreshTokenResponse{}); err != nil {
-			return err
-		}
-		continue
+// CreateAuthorizationCodeUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) CreateAuthorizationCodeUnary(ctx context.Context, req *connect.Request[apiv1.CreateAuthorizationCodeRequest]) (*connect.Response[apiv1.CreateAuthorizationCodeResponse], error) {
+	if err := h.Storage.CreateAuthorizationCode(ctx, h.hashAuthorizationCode(req.Msg.GetCode())); err != nil {
+		return nil, h.newConnectError(err)
 	}
+	return connect.NewResponse(&apiv1.CreateAuthorizationCodeResponse{}), nil
 }
 
-// GetAccessToken implements apiv1connect.DatabaseServiceHandler.
-// Subtle: this method shadows the method (DatabaseServiceHandler).GetAccessToken of handler.DatabaseServiceHandler.
-func (h *handler) GetAccessToken(ctx context.Context, stream *connect.BidiStream[apiv1.GetAccessTokenRequest, apiv1.GetAccessTokenResponse]) error {
-	for {
-		msg, err := stream.Receive()
-		if errors.Is(err, io.EOF) {
-			return nil
-		} else if err != nil {
-			return err
-		}
-		token, err := h.Database.GetAccessTokenByToken(ctx, msg.GetToken())
-		if err != nil {
-			return h.newConnectError(err)
-		}
-		if err := stream.Send(&apiv1.GetAccessTokenResponse{
-			Token: token,
-		}); err != nil {
-			return err
-		}
-		continue
+// CreateRefreshTokenUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) CreateRefreshTokenUnary(ctx context.Context, req *connect.Request[apiv1.CreateRefreshTokenRequest]) (*connect.Response[apiv1.CreateRefreshTokenResponse], error) {
+	if err := h.Storage.CreateRefreshToken(ctx, h.hashRefreshToken(req.Msg.GetToken())); err != nil {
+		return nil, h.newConnectError(err)
 	}
+	return connect.NewResponse(&apiv1.CreateRefreshTokenResponse{}), nil
 }
 
-// GetAuthorizationCode implements apiv1connect.DatabaseServiceHandler.
-// Subtle: this method shadows the method (DatabaseServiceHandler).GetAuthorizationCode of handler.DatabaseServiceHandler.
-func (h *handler) GetAuthorizationCode(ctx context.Context, stream *connect.BidiStream[apiv1.GetAuthorizationCodeRequest, apiv1.GetAuthorizationCodeResponse]) error {
-	for {
-		msg, err := stream.Receive()
-		if errors.Is(err, io.EOF) {
-			return nil
+// GetAccessTokenUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetAccessTokenUnary(ctx context.Context, req *connect.Request[apiv1.GetAccessTokenRequest]) (*connect.Response[apiv1.GetAccessTokenResponse], error) {
+	token, err := h.Storage.GetAccessTokenByToken(ctx, h.hasher.Hash(req.Msg.GetToken()))
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	// the caller knows the raw value
+	token = proto.Clone(token).(*apiv1.AccessToken)
+	token.Token = req.Msg.GetToken()
+	return connect.NewResponse(&apiv1.GetAccessTokenResponse{
+		Token: token,
+	}), nil
+}
+
+// GetAuthorizationCodeUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetAuthorizationCodeUnary(ctx context.Context, req *connect.Request[apiv1.GetAuthorizationCodeRequest]) (*connect.Response[apiv1.GetAuthorizationCodeResponse], error) {
+	code, err := h.Storage.GetAuthorizationCodeByCode(ctx, h.hasher.Hash(req.Msg.GetCode()))
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	code = proto.Clone(code).(*apiv1.AuthorizationCode)
+	code.Code = req.Msg.GetCode()
+	return connect.NewResponse(&apiv1.GetAuthorizationCodeResponse{
+		Code: code,
+	}), nil
+}
+
+// GetRefreshTokenUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetRefreshTokenUnary(ctx context.Context, req *connect.Request[apiv1.GetRefreshTokenRequest]) (*connect.Response[apiv1.GetRefreshTokenResponse], error) {
+	token, err := h.Storage.GetRefreshTokenByToken(ctx, h.hasher.Hash(req.Msg.GetToken()))
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	token = proto.Clone(token).(*apiv1.RefreshToken)
+	token.Token = req.Msg.GetToken()
+	return connect.NewResponse(&apiv1.GetRefreshTokenResponse{
+		Token: token,
+	}), nil
+}
+
+// GetServiceClientUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetServiceClientUnary(ctx context.Context, req *connect.Request[apiv1.GetServiceClientRequest]) (*connect.Response[apiv1.GetServiceClientResponse], error) {
+	client, err := h.Storage.GetServieClientById(ctx, req.Msg.GetId())
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	return connect.NewResponse(&apiv1.GetServiceClientResponse{
+		Client: client,
+	}), nil
+}
+
+// GetUserUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetUserUnary(ctx context.Context, req *connect.Request[apiv1.GetUserRequest]) (*connect.Response[apiv1.GetUserResponse], error) {
+	user, err := h.Storage.GetUserById(ctx, req.Msg.GetId())
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	return connect.NewResponse(&apiv1.GetUserResponse{
+		User: withoutPassword(ctx, user),
+	}), nil
+}
+
+// UpdateUserProfileUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) UpdateUserProfileUnary(ctx context.Context, req *connect.Request[apiv1.UpdateUserProfileRequest]) (*connect.Response[apiv1.UpdateUserProfileResponse], error) {
+	var user *apiv1.UserProfile
+	var err error
+	if req.Msg.GetVersion() == 0 {
+		user, err = h.Storage.UpdateUserProfile(ctx, req.Msg.GetId(), req.Msg.GetProfile())
+	} else {
+		user, err = h.Storage.CompareAndSwapUserProfile(ctx, req.Msg.GetId(), req.Msg.GetVersion(), req.Msg.GetProfile())
+	}
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	return connect.NewResponse(&apiv1.UpdateUserProfileResponse{
+		User: withoutPassword(ctx, user),
+	}), nil
+}
+
+// withoutPassword clears the password of [user] unless the caller is the authorization server, which only verifies them.
+// the streaming RPCs answer by the unary ones, so they are covered as well.
+func withoutPassword(ctx context.Context, user *apiv1.UserProfile) *apiv1.UserProfile {
+	if caller, ok := callerFrom(ctx); ok && caller.Role != RoleAuthServer {
+		user = proto.Clone(user).(*apiv1.UserProfile)
+		user.Password = ""
+	}
+	return user
+}
+
+// GetResourceServerUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetResourceServerUnary(ctx context.Context, req *connect.Request[apiv1.GetResourceServerRequest]) (*connect.Response[apiv1.GetResourceServerResponse], error) {
+	resourceServer, err := h.Storage.GetResourceServerByUri(ctx, req.Msg.GetUri())
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	return connect.NewResponse(&apiv1.GetResourceServerResponse{
+		ResourceServer: resourceServer,
+	}), nil
+}
+
+// ListResourceServers implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) ListResourceServersUnary(ctx context.Context, _ *connect.Request[apiv1.ListResourceServersRequest]) (*connect.Response[apiv1.ListResourceServersResponse], error) {
+	resourceServers, err := h.Storage.ListResourceServers(ctx)
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	return connect.NewResponse(&apiv1.ListResourceServersResponse{
+		ResourceServers: resourceServers,
+	}), nil
+}
+
+// ConsumeAuthorizationCodeUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) ConsumeAuthorizationCodeUnary(ctx context.Context, req *connect.Request[apiv1.ConsumeAuthorizationCodeRequest]) (*connect.Response[apiv1.ConsumeAuthorizationCodeResponse], error) {
+	code, err := h.Storage.ConsumeAuthorizationCode(ctx, h.hasher.Hash(req.Msg.GetCode()))
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	code = proto.Clone(code).(*apiv1.AuthorizationCode)
+	code.Code = req.Msg.GetCode()
+	return connect.NewResponse(&apiv1.ConsumeAuthorizationCodeResponse{
+		Code: code,
+	}), nil
+}
+
+// RevokeAccessTokenUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) RevokeAccessTokenUnary(ctx context.Context, req *connect.Request[apiv1.RevokeAccessTokenRequest]) (*connect.Response[apiv1.RevokeAccessTokenResponse], error) {
+	token, err := h.Storage.RevokeAccessToken(ctx, h.hasher.Hash(req.Msg.GetToken()))
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	h.revocations.revoked(tokenTypeAccessToken)
+	token = proto.Clone(token).(*apiv1.AccessToken)
+	token.Token = req.Msg.GetToken()
+	return connect.NewResponse(&apiv1.RevokeAccessTokenResponse{
+		Token: token,
+	}), nil
+}
+
+// RevokeRefreshTokenUnary implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) RevokeRefreshTokenUnary(ctx context.Context, req *connect.Request[apiv1.RevokeRefreshTokenRequest]) (*connect.Response[apiv1.RevokeRefreshTokenResponse], error) {
+	token, err := h.Storage.RevokeRefreshToken(ctx, h.hasher.Hash(req.Msg.GetToken()))
+	if err != nil {
+		return nil, h.newConnectError(err)
+	}
+	h.revocations.revoked(tokenTypeRefreshToken)
+	token = proto.Clone(token).(*apiv1.RefreshToken)
+	token.Token = req.Msg.GetToken()
+	return connect.NewResponse(&apiv1.RevokeRefreshTokenResponse{
+		Token: token,
+	}), nil
+}
+
+// BatchGetAccessTokens implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) BatchGetAccessTokens(ctx context.Context, req *connect.Request[apiv1.BatchGetAccessTokensRequest]) (*connect.Response[apiv1.BatchGetAccessTokensResponse], error) {
+	tokens := make([]*apiv1.AccessToken, 0, len(req.Msg.GetTokens()))
+	for _, raw := range req.Msg.GetTokens() {
+		token, err := h.Storage.GetAccessTokenByToken(ctx, h.hasher.Hash(raw))
+		if errors.Is(err, ErrNotFound) {
+			continue
 		} else if err != nil {
-			return err
-		}
-		code, err := h.Database.GetAuthorizationCodeByCode(ctx, msg.GetCode())
-		if err != nil {
-			return h.newConnectError(err)
-		}
-		if err := stream.Send(&apiv1.GetAuthorizationCodeResponse{
-			Code: code,
-		}); err != nil {
-			return err
+			return nil, h.newConnectError(err)
 		}
-		continue
+		token = proto.Clone(token).(*apiv1.AccessToken)
+		token.Token = raw
+		tokens = append(tokens, token)
 	}
+	return connect.NewResponse(&apiv1.BatchGetAccessTokensResponse{
+		Tokens: tokens,
+	}), nil
 }
 
-// GetRefreshToken implements apiv1connect.DatabaseServiceHandler.
-// Subtle: this method shadows the method (DatabaseServiceHandler).GetRefreshToken of handler.DatabaseServiceHandler.
-func (h *handler) GetRefreshToken(ctx context.Context, stream *connect.BidiStream[apiv1.GetRefreshTokenRequest, apiv1.GetRefreshTokenResponse]) error {
+// BatchCreate implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) BatchCreate(ctx context.Context, req *connect.Request[apiv1.BatchCreateRequest]) (*connect.Response[apiv1.BatchCreateResponse], error) {
+	var b Batch
+	for _, row := range req.Msg.GetCodes() {
+		b.AuthorizationCodes = append(b.AuthorizationCodes, h.hashAuthorizationCode(row))
+	}
+	for _, row := range req.Msg.GetAccessTokens() {
+		b.AccessTokens = append(b.AccessTokens, h.hashAccessToken(row))
+	}
+	for _, row := range req.Msg.GetRefreshTokens() {
+		b.RefreshTokens = append(b.RefreshTokens, h.hashRefreshToken(row))
+	}
+	if err := h.Storage.BatchCreate(ctx, b); err != nil {
+		return nil, h.newConnectError(err)
+	}
+	return connect.NewResponse(&apiv1.BatchCreateResponse{}), nil
+}
+
+// Ping implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) Ping(context.Context, *connect.Request[apiv1.PingRequest]) (*connect.Response[apiv1.PingResponse], error) {
+	return &connect.Response[apiv1.PingResponse]{}, nil
+}
+
+// Watch implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) Watch(ctx context.Context, req *connect.Request[apiv1.WatchRequest], stream *connect.ServerStream[apiv1.WatchResponse]) error {
+	cursor := req.Msg.GetCursor()
+	if cursor == "" {
+		cursor = h.journal.Cursor()
+	}
+	resps, changed, err := h.journal.Since(cursor)
+	if err != nil {
+		return h.newConnectError(err)
+	}
+	if err := stream.Send(&apiv1.WatchResponse{Cursor: cursor}); err != nil {
+		return err
+	}
 	for {
-		msg, err := stream.Receive()
-		if errors.Is(err, io.EOF) {
+		for _, resp := range resps {
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+			cursor = resp.Cursor
+		}
+		select {
+		case <-ctx.Done():
 			return nil
-		} else if err != nil {
-			return err
+		case <-h.done:
+			// the client resumes from the cursor on another server
+			return connect.NewError(connect.CodeUnavailable, errShuttingDown)
+		case <-changed:
 		}
-		token, err := h.Database.GetRefreshTokenByToken(ctx, msg.GetToken())
+		resps, changed, err = h.journal.Since(cursor)
 		if err != nil {
 			return h.newConnectError(err)
 		}
-		if err := stream.Send(&apiv1.GetRefreshTokenResponse{
-			Token: token,
-		}); err != nil {
-			return err
-		}
-		continue
 	}
 }
 
-// GetServiceClient implements apiv1connect.DatabaseServiceHandler.
-// Subtle: this method shadows the method (DatabaseServiceHandler).GetServiceClient of handler.DatabaseServiceHandler.
-func (h *handler) GetServiceClient(ctx context.Context, stream *connect.BidiStream[apiv1.GetServiceClientRequest, apiv1.GetServiceClientResponse]) error {
+// serveStream answers each message of [stream] by [unary] in order, until the client closes it or an error.
+func serveStream[Req, Res any](ctx context.Context, stream *connect.BidiStream[Req, Res], unary func(context.Context, *connect.Request[Req]) (*connect.Response[Res], error)) error {
 	for {
 		msg, err := stream.Receive()
 		if errors.Is(err, io.EOF) {
@@ -192,45 +464,84 @@ func (h *handler) GetServiceClient(ctx context.Context, stream *connect.BidiStre
 		} else if err != nil {
 			return err
 		}
-		client, err := h.Database.GetServieClientById(ctx, msg.GetId())
+		resp, err := unary(ctx, connect.NewRequest(msg))
 		if err != nil {
-			return h.newConnectError(err)
+			return err
 		}
-		if err := stream.Send(&apiv1.GetServiceClientResponse{
-			Client: client,
-		}); err != nil {
+		if err := stream.Send(resp.Msg); err != nil {
 			return err
 		}
-		continue
 	}
 }
 
 // GetUser implements apiv1connect.DatabaseServiceHandler.
-// Subtle: this method shadows the method (DatabaseServiceHandler).GetUser of handler.DatabaseServiceHandler.
 func (h *handler) GetUser(ctx context.Context, stream *connect.BidiStream[apiv1.GetUserRequest, apiv1.GetUserResponse]) error {
-	for {
-		msg, err := stream.Receive()
-		if errors.Is(err, io.EOF) {
-			return nil
-		} else if err != nil {
-			return err
-		}
-		user, err := h.Database.GetUserById(ctx, msg.GetId())
-		if err != nil {
-			return h.newConnectError(err)
-		}
-		if err := stream.Send(&apiv1.GetUserResponse{
-			User: user,
-		}); err != nil {
-			return err
-		}
-		continue
-	}
+	return serveStream(ctx, stream, h.GetUserUnary)
 }
 
-// Ping implements apiv1connect.DatabaseServiceHandler.
-func (h *handler) Ping(context.Context, *connect.Request[apiv1.PingRequest]) (*connect.Response[apiv1.PingResponse], error) {
-	return &connect.Response[apiv1.PingResponse]{}, nil
+// UpdateUserProfile implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) UpdateUserProfile(ctx context.Context, stream *connect.BidiStream[apiv1.UpdateUserProfileRequest, apiv1.UpdateUserProfileResponse]) error {
+	return serveStream(ctx, stream, h.UpdateUserProfileUnary)
+}
+
+// GetServiceClient implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetServiceClient(ctx context.Context, stream *connect.BidiStream[apiv1.GetServiceClientRequest, apiv1.GetServiceClientResponse]) error {
+	return serveStream(ctx, stream, h.GetServiceClientUnary)
+}
+
+// GetAuthorizationCode implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetAuthorizationCode(ctx context.Context, stream *connect.BidiStream[apiv1.GetAuthorizationCodeRequest, apiv1.GetAuthorizationCodeResponse]) error {
+	return serveStream(ctx, stream, h.GetAuthorizationCodeUnary)
+}
+
+// CreateAuthorizationCode implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) CreateAuthorizationCode(ctx context.Context, stream *connect.BidiStream[apiv1.CreateAuthorizationCodeRequest, apiv1.CreateAuthorizationCodeResponse]) error {
+	return serveStream(ctx, stream, h.CreateAuthorizationCodeUnary)
+}
+
+// GetAccessToken implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetAccessToken(ctx context.Context, stream *connect.BidiStream[apiv1.GetAccessTokenRequest, apiv1.GetAccessTokenResponse]) error {
+	return serveStream(ctx, stream, h.GetAccessTokenUnary)
+}
+
+// CreateAccessToken implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) CreateAccessToken(ctx context.Context, stream *connect.BidiStream[apiv1.CreateAccessTokenRequest, apiv1.CreateAccessTokenResponse]) error {
+	return serveStream(ctx, stream, h.CreateAccessTokenUnary)
+}
+
+// GetRefreshToken implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetRefreshToken(ctx context.Context, stream *connect.BidiStream[apiv1.GetRefreshTokenRequest, apiv1.GetRefreshTokenResponse]) error {
+	return serveStream(ctx, stream, h.GetRefreshTokenUnary)
+}
+
+// CreateRefreshToken implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) CreateRefreshToken(ctx context.Context, stream *connect.BidiStream[apiv1.CreateRefreshTokenRequest, apiv1.CreateRefreshTokenResponse]) error {
+	return serveStream(ctx, stream, h.CreateRefreshTokenUnary)
+}
+
+// GetResourceServer implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) GetResourceServer(ctx context.Context, stream *connect.BidiStream[apiv1.GetResourceServerRequest, apiv1.GetResourceServerResponse]) error {
+	return serveStream(ctx, stream, h.GetResourceServerUnary)
+}
+
+// ListResourceServers implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) ListResourceServers(ctx context.Context, stream *connect.BidiStream[apiv1.ListResourceServersRequest, apiv1.ListResourceServersResponse]) error {
+	return serveStream(ctx, stream, h.ListResourceServersUnary)
+}
+
+// ConsumeAuthorizationCode implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) ConsumeAuthorizationCode(ctx context.Context, stream *connect.BidiStream[apiv1.ConsumeAuthorizationCodeRequest, apiv1.ConsumeAuthorizationCodeResponse]) error {
+	return serveStream(ctx, stream, h.ConsumeAuthorizationCodeUnary)
+}
+
+// RevokeAccessToken implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) RevokeAccessToken(ctx context.Context, stream *connect.BidiStream[apiv1.RevokeAccessTokenRequest, apiv1.RevokeAccessTokenResponse]) error {
+	return serveStream(ctx, stream, h.RevokeAccessTokenUnary)
+}
+
+// RevokeRefreshToken implements apiv1connect.DatabaseServiceHandler.
+func (h *handler) RevokeRefreshToken(ctx context.Context, stream *connect.BidiStream[apiv1.RevokeRefreshTokenRequest, apiv1.RevokeRefreshTokenResponse]) error {
+	return serveStream(ctx, stream, h.RevokeRefreshTokenUnary)
 }
 
 func (c *handler) newConnectError(err error) error {
@@ -240,5 +551,36 @@ func (c *handler) newConnectError(err error) error {
 	if errors.Is(ErrAlreadyExists, err) {
 		return connect.NewError(connect.CodeAlreadyExists, err)
 	}
+	if errors.Is(err, ErrConflict) {
+		return connect.NewError(connect.CodeAborted, err)
+	}
+	if errors.Is(err, ErrCursorExpired) {
+		return connect.NewError(connect.CodeOutOfRange, err)
+	}
+	if errors.Is(err, ErrInvalidCursor) {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
 	return connect.NewError(connect.CodeInternal, err)
 }
+
+// hashAccessToken returns [row] keyed by the hash of the token.
+func (h *handler) hashAccessToken(row *apiv1.AccessToken) *apiv1.AccessToken {
+	row = proto.Clone(row).(*apiv1.AccessToken)
+	row.TokenPrefix = DisplayPrefix(row.Token)
+	row.Token = h.hasher.Hash(row.Token)
+	return row
+}
+
+func (h *handler) hashAuthorizationCode(row *apiv1.AuthorizationCode) *apiv1.AuthorizationCode {
+	row = proto.Clone(row).(*apiv1.AuthorizationCode)
+	row.CodePrefix = DisplayPrefix(row.Code)
+	row.Code = h.hasher.Hash(row.Code)
+	return row
+}
+
+func (h *handler) hashRefreshToken(row *apiv1.RefreshToken) *apiv1.RefreshToken {
+	row = proto.Clone(row).(*apiv1.RefreshToken)
+	row.TokenPrefix = DisplayPrefix(row.Token)
+	row.Token = h.hasher.Hash(row.Token)
+	return row
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+)
+
+// Storage keeps the rows of the database service.
+type Storage interface {
+	GetUserById(ctx context.Context, id string) (*apiv1.UserProfile, error)
+	UpdateUserProfile(ctx context.Context, id, profile string) (*apiv1.UserProfile, error)
+	// CompareAndSwapUserProfile updates the profile if the user is at [version], or returns [ErrConflict].
+	CompareAndSwapUserProfile(ctx context.Context, id string, version uint64, profile string) (*apiv1.UserProfile, error)
+	GetServieClientById(ctx context.Context, id string) (*apiv1.ServiceClient, error)
+	CreateServiceClient(ctx context.Context, row *apiv1.ServiceClient) error
+	GetAuthorizationCodeByCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error)
+	CreateAuthorizationCode(ctx context.Context, row *apiv1.AuthorizationCode) error
+	// ConsumeAuthorizationCode marks the code consumed if it is unused, or returns [ErrConflict].
+	ConsumeAuthorizationCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error)
+	GetAccessTokenByToken(ctx context.Context, token string) (*apiv1.AccessToken, error)
+	CreateAccessToken(ctx context.Context, row *apiv1.AccessToken) error
+	// RevokeAccessToken revokes the token if it is active, or returns [ErrConflict].
+	RevokeAccessToken(ctx context.Context, token string) (*apiv1.AccessToken, error)
+	GetRefreshTokenByToken(ctx context.Context, token string) (*apiv1.RefreshToken, error)
+	CreateRefreshToken(ctx context.Context, row *apiv1.RefreshToken) error
+	// RevokeRefreshToken revokes the token if it is active, or returns [ErrConflict].
+	RevokeRefreshToken(ctx context.Context, token string) (*apiv1.RefreshToken, error)
+	// BatchCreate creates all rows of the batch, or returns [ErrAlreadyExists] without creating any.
+	BatchCreate(ctx context.Context, b Batch) error
+	GetResourceServerByUri(ctx context.Context, uri string) (*apiv1.ResourceServer, error)
+	ListResourceServers(ctx context.Context) ([]*apiv1.ResourceServer, error)
+	CreateResourceServer(ctx context.Context, row *apiv1.ResourceServer) error
+	// Sweep evicts the codes and tokens which expired before [before].
+	Sweep(before time.Time) SweepResult
+	// CountRows returns the number of the stored rows of each table.
+	CountRows() RowCounts
+	// Close releases the storage. rows must be durable after it returns.
+	Close() error
+}
+
+const (
+	// rows are lost on restart.
+	StorageMemory = "memory"
+	// rows are kept in [StorageConfig.DataDir].
+	StorageFile = "file"
+)
+
+type StorageConfig struct {
+	// [StorageMemory](default) or [StorageFile]
+	Driver string
+	// directory of the file storage
+	DataDir string
+	// interval of the snapshots of the file storage. default 1 minute.
+	SnapshotInterval time.Duration
+	// URLs of the mock rows of a new storage. Optional.
+	Seed Seed
+}
+
+// NewStorage opens the storage selected by [config].
+func NewStorage(config StorageConfig) (Storage, error) {
+	switch config.Driver {
+	case "", StorageMemory:
+		return NewSeededDatabase(config.Seed)
+	case StorageFile:
+		return OpenFileStorage(config)
+	default:
+		return nil, fmt.Errorf("unknown storage driver '%s'", config.Driver)
+	}
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type (
+	rowKind   uint8
+	expiryKey struct {
+		kind rowKind
+		key  string
+	}
+)
+
+const (
+	kindAuthorizationCode rowKind = iota
+	kindAccessToken
+	kindRefreshToken
+)
+
+// index adds a row to the expiry index. rows without expiry never expire. must be called with db.mu held.
+func (db *Database) index(kind rowKind, key string, expires *timestamppb.Timestamp) {
+	if expires == nil {
+		return
+	}
+	db.expiries.Add(expiryKey{kind: kind, key: key}, expires.AsTime())
+}
+
+// SweepResult is the number of evicted rows.
+type SweepResult struct {
+	AuthorizationCodes int
+	AccessTokens       int
+	RefreshTokens      int
+	// evicted rows. not kept by [SweeperStats].
+	Rows []*apiv1.StorageRecord
+}
+
+func (r SweepResult) Total() int {
+	return r.AuthorizationCodes + r.AccessTokens + r.RefreshTokens
+}
+
+func (r SweepResult) add(o SweepResult) SweepResult {
+	r.AuthorizationCodes += o.AuthorizationCodes
+	r.AccessTokens += o.AccessTokens
+	r.RefreshTokens += o.RefreshTokens
+	return r
+}
+
+// Sweep evicts the codes and tokens which expired before [before].
+func (db *Database) Sweep(before time.Time) SweepResult {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var r SweepResult
+	for _, e := range db.expiries.Expired(before) {
+		// skip entries of replaced rows
+		switch e.Key.kind {
+		case kindAuthorizationCode:
+			if row, found := db.authorizationCodeByCode[e.Key.key]; found && row.GetExpires().AsTime().Equal(e.Expires) {
+				delete(db.authorizationCodeByCode, e.Key.key)
+				r.AuthorizationCodes++
+				r.Rows = append(r.Rows, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AuthorizationCode{AuthorizationCode: row}})
+			}
+		case kindAccessToken:
+			if row, found := db.accessTokenByToken[e.Key.key]; found && row.GetExpires().AsTime().Equal(e.Expires) {
+				delete(db.accessTokenByToken, e.Key.key)
+				r.AccessTokens++
+				r.Rows = append(r.Rows, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AccessToken{AccessToken: row}})
+			}
+		case kindRefreshToken:
+			if row, found := db.refreshTokenByToken[e.Key.key]; found && row.GetExpires().AsTime().Equal(e.Expires) {
+				delete(db.refreshTokenByToken, e.Key.key)
+				r.RefreshTokens++
+				r.Rows = append(r.Rows, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_RefreshToken{RefreshToken: row}})
+			}
+		}
+	}
+	return r
+}
+
+// Sweep implements Storage. Evictions are not written to the WAL; rows restored from it are evicted again by the next sweep.
+func (s *FileStorage) Sweep(before time.Time) SweepResult {
+	return s.mem.Sweep(before)
+}
+
+const (
+	defaultSweepInterval    = time.Duration(1) * time.Minute
+	defaultSweepGracePeriod = time.Duration(5) * time.Minute
+)
+
+type (
+	SweeperConfig struct {
+		// default 1 minute
+		Interval time.Duration
+		// rows are kept this long after they expire, so that they are still reported as expired
+		// rather than not found. default 5 minutes.
+		GracePeriod time.Duration
+	}
+	// Sweeper evicts expired codes and tokens of a storage periodically.
+	Sweeper struct {
+		storage Storage
+		config  SweeperConfig
+
+		mu    sync.Mutex
+		stats SweeperStats
+	}
+	SweeperStats struct {
+		Runs    uint64
+		LastRun time.Time
+		// total since start
+		Evicted SweepResult
+	}
+)
+
+func NewSweeper(storage Storage, config SweeperConfig) *Sweeper {
+	if config.Interval <= 0 {
+		config.Interval = defaultSweepInterval
+	}
+	if config.GracePeriod <= 0 {
+		config.GracePeriod = defaultSweepGracePeriod
+	}
+	return &Sweeper{storage: storage, config: config}
+}
+
+// Run sweeps every interval until [ctx] is done.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r := s.Sweep(now)
+			// every sweep is logged, and the ones which evicted rows at info
+			level := slog.LevelDebug
+			if r.Total() > 0 {
+				level = slog.LevelInfo
+			}
+			slog.Log(ctx, level, "swept expired rows",
+				slog.Int("authorization_codes", r.AuthorizationCodes),
+				slog.Int("access_tokens", r.AccessTokens),
+				slog.Int("refresh_tokens", r.RefreshTokens),
+				slog.Duration("elapsed", time.Since(now)),
+			)
+		}
+	}
+}
+
+// Sweep evicts the rows which expired more than the grace period before [now].
+func (s *Sweeper) Sweep(now time.Time) SweepResult {
+	r := s.storage.Sweep(now.Add(-s.config.GracePeriod))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Runs++
+	s.stats.LastRun = now
+	s.stats.Evicted = s.stats.Evicted.add(r)
+	return r
+}
+
+func (s *Sweeper) Stats() SweeperStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestSweeper(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	test := map[string]func(t *testing.T) Storage{
+		"memory": func(t *testing.T) Storage {
+			db, _ := NewDatabase()
+			return db
+		},
+		"file": func(t *testing.T) Storage {
+			s, err := OpenFileStorage(StorageConfig{DataDir: t.TempDir()})
+			assert.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+	for scenario, newStorage := range test {
+		t.Run(scenario, func(t *testing.T) {
+			storage := newStorage(t)
+			expires := func(d time.Duration) *timestamppb.Timestamp {
+				return timestamppb.New(now.Add(d))
+			}
+			assert.NoError(t, storage.CreateAuthorizationCode(ctx, &apiv1.AuthorizationCode{Code: "old", Expires: expires(-time.Hour)}))
+			assert.NoError(t, storage.CreateAuthorizationCode(ctx, &apiv1.AuthorizationCode{Code: "new", Expires: expires(time.Hour)}))
+			assert.NoError(t, storage.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "old", Expires: expires(-time.Hour)}))
+			assert.NoError(t, storage.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "grace", Expires: expires(-time.Second)}))
+			assert.NoError(t, storage.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "forever"}))
+			assert.NoError(t, storage.CreateRefreshToken(ctx, &apiv1.RefreshToken{Token: "old", Expires: expires(-2 * time.Hour)}))
+
+			sweeper := NewSweeper(storage, SweeperConfig{GracePeriod: time.Minute})
+			r := sweeper.Sweep(now)
+			assert.Len(t, r.Rows, 3)
+			r.Rows = nil
+			assert.Equal(t, SweepResult{AuthorizationCodes: 1, AccessTokens: 1, RefreshTokens: 1}, r)
+
+			_, err := storage.GetAuthorizationCodeByCode(ctx, "old")
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = storage.GetAuthorizationCodeByCode(ctx, "new")
+			assert.NoError(t, err)
+			_, err = storage.GetAccessTokenByToken(ctx, "old")
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = storage.GetAccessTokenByToken(ctx, "grace")
+			assert.NoError(t, err)
+			_, err = storage.GetAccessTokenByToken(ctx, "forever")
+			assert.NoError(t, err)
+			_, err = storage.GetRefreshTokenByToken(ctx, "old")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			// after the grace period
+			r = sweeper.Sweep(now.Add(2 * time.Minute))
+			if assert.Len(t, r.Rows, 1) {
+				assert.Equal(t, "grace", r.Rows[0].GetAccessToken().GetToken())
+			}
+			r.Rows = nil
+			assert.Equal(t, SweepResult{AccessTokens: 1}, r)
+			stats := sweeper.Stats()
+			assert.EqualValues(t, 2, stats.Runs)
+			assert.Equal(t, 4, stats.Evicted.Total())
+		})
+	}
+}
+
+func TestSweeperRun(t *testing.T) {
+	db, _ := NewDatabase()
+	err := db.CreateAccessToken(context.Background(), &apiv1.AccessToken{
+		Token:   "old",
+		Expires: timestamppb.New(time.Now().Add(-time.Hour)),
+	})
+	assert.NoError(t, err)
+	sweeper := NewSweeper(db, SweeperConfig{Interval: time.Millisecond, GracePeriod: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sweeper.Run(ctx)
+	}()
+	assert.Eventually(t, func() bool {
+		return sweeper.Stats().Evicted.AccessTokens == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sweeper does not stop")
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestTokenHasher(t *testing.T) {
+	_, err := NewTokenHasher([]byte("short"))
+	assert.Error(t, err)
+
+	h, err := NewTokenHasher([]byte("0123456789abcdef"))
+	assert.NoError(t, err)
+	other, err := NewTokenHasher([]byte("fedcba9876543210"))
+	assert.NoError(t, err)
+	assert.Equal(t, h.Hash("token"), h.Hash("token"))
+	assert.NotEqual(t, h.Hash("token"), h.Hash("token2"))
+	assert.NotEqual(t, h.Hash("token"), other.Hash("token"))
+
+	assert.Equal(t, "3f2a9c", DisplayPrefix("3f2a9c1e-5b7d-4c8e-9f0a-1b2c3d4e5f60"))
+	assert.Empty(t, DisplayPrefix("token"))
+}
+
+func TestServerHashesTokens(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	port := "3367"
+	_, err := NewDatabaseServer(ctx, ServerConfig{
+		Port:        port,
+		Storage:     StorageConfig{Driver: StorageFile, DataDir: dir},
+		TokenPepper: []byte("0123456789abcdef"),
+		Insecure:    true,
+	})
+	assert.NoError(t, err)
+	var client *Client
+	assert.Eventually(t, func() bool {
+		client, err = NewDatabaseClient(ctx, ClientConfig{URL: "http://localhost:" + port})
+		return err == nil && client.Ping(ctx) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	raw := uuid.NewString()
+	err = client.CreateAccessToken(ctx, &apiv1.AccessToken{
+		Token:   raw,
+		UserId:  "1",
+		Expires: timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	assert.NoError(t, err)
+	token, err := client.GetAccessTokenByToken(ctx, raw)
+	assert.NoError(t, err)
+	assert.Equal(t, raw, token.Token)
+	assert.Equal(t, raw[:displayPrefixLength], token.TokenPrefix)
+
+	code := uuid.NewString()
+	assert.NoError(t, client.CreateAuthorizationCode(ctx, &apiv1.AuthorizationCode{Code: code}))
+	got, err := client.GetAuthorizationCodeByCode(ctx, code)
+	assert.NoError(t, err)
+	assert.Equal(t, code, got.Code)
+
+	refresh := uuid.NewString()
+	assert.NoError(t, client.CreateRefreshToken(ctx, &apiv1.RefreshToken{Token: refresh}))
+	_, err = client.GetRefreshTokenByToken(ctx, refresh)
+	assert.NoError(t, err)
+
+	// the raw values are not stored
+	data, err := os.ReadFile(filepath.Join(dir, walFileName))
+	assert.NoError(t, err)
+	for _, v := range []string{raw, code, refresh} {
+		assert.NotContains(t, string(data), v)
+		assert.True(t, strings.Contains(string(data), v[:displayPrefixLength]))
+	}
+
+	// a persistent storage needs a fixed pepper
+	_, err = NewDatabaseServer(ctx, ServerConfig{
+		Port:    "3368",
+		Storage: StorageConfig{Driver: StorageFile, DataDir: t.TempDir()},
+	})
+	assert.Error(t, err)
+}
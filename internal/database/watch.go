@@ -0,0 +1,284 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	// ErrCursorExpired is returned when the events after a cursor are no longer kept.
+	ErrCursorExpired = errors.New("cursor expired")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+const defaultJournalCapacity = 4096
+
+// Journal keeps the latest change events of a storage for watchers.
+//
+// A cursor is '<epoch>.<sequence>'. The epoch changes on every start of the server,
+// so that the cursors of a previous process are rejected rather than resumed at a wrong position.
+type Journal struct {
+	epoch    string
+	capacity int
+
+	mu sync.Mutex
+	// events[i] has the sequence first+i+1
+	events []*apiv1.WatchEvent
+	first  uint64
+	// closed and replaced on every append
+	changed chan struct{}
+}
+
+// NewJournal returns a journal keeping the last [capacity] events. default 4096.
+func NewJournal(capacity int) *Journal {
+	if capacity <= 0 {
+		capacity = defaultJournalCapacity
+	}
+	epoch := make([]byte, 4)
+	_, _ = rand.Read(epoch)
+	return &Journal{
+		epoch:    hex.EncodeToString(epoch),
+		capacity: capacity,
+		changed:  make(chan struct{}),
+	}
+}
+
+func (j *Journal) append(events ...*apiv1.WatchEvent) {
+	if len(events) == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.events = append(j.events, events...)
+	if over := len(j.events) - j.capacity; over > 0 {
+		j.events = append(j.events[:0:0], j.events[over:]...)
+		j.first += uint64(over)
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *Journal) cursor(seq uint64) string {
+	return fmt.Sprintf("%s.%d", j.epoch, seq)
+}
+
+// Cursor returns the current position.
+func (j *Journal) Cursor() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.cursor(j.first + uint64(len(j.events)))
+}
+
+func (j *Journal) parseCursor(cursor string) (uint64, error) {
+	epoch, seq, found := strings.Cut(cursor, ".")
+	if !found {
+		return 0, fmt.Errorf("%w: '%s'", ErrInvalidCursor, cursor)
+	}
+	if epoch != j.epoch {
+		return 0, fmt.Errorf("%w: '%s' is of another epoch", ErrCursorExpired, cursor)
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: '%s'", ErrInvalidCursor, cursor)
+	}
+	return n, nil
+}
+
+// Since returns the events after [cursor] with their cursors, and a channel closed on the next event.
+func (j *Journal) Since(cursor string) ([]*apiv1.WatchResponse, <-chan struct{}, error) {
+	seq, err := j.parseCursor(cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	last := j.first + uint64(len(j.events))
+	if seq < j.first {
+		return nil, nil, fmt.Errorf("%w: '%s' is older than the kept events", ErrCursorExpired, cursor)
+	}
+	if seq > last {
+		return nil, nil, fmt.Errorf("%w: '%s'", ErrInvalidCursor, cursor)
+	}
+	var resps []*apiv1.WatchResponse
+	for i := seq - j.first; i < uint64(len(j.events)); i++ {
+		resps = append(resps, &apiv1.WatchResponse{
+			Cursor: j.cursor(j.first + i + 1),
+			Event:  j.events[i],
+		})
+	}
+	return resps, j.changed, nil
+}
+
+// newWatchEvent returns the event of [record] with the passwords and secrets cleared.
+func newWatchEvent(t apiv1.EventType, record *apiv1.StorageRecord, now time.Time) *apiv1.WatchEvent {
+	record = proto.Clone(record).(*apiv1.StorageRecord)
+	var key string
+	switch row := record.GetRow().(type) {
+	case *apiv1.StorageRecord_User:
+		row.User.Password = ""
+		key = row.User.GetId()
+	case *apiv1.StorageRecord_ServiceClient:
+		row.ServiceClient.Secret = ""
+		key = row.ServiceClient.GetId()
+	case *apiv1.StorageRecord_AuthorizationCode:
+		key = row.AuthorizationCode.GetCode()
+	case *apiv1.StorageRecord_AccessToken:
+		key = row.AccessToken.GetToken()
+	case *apiv1.StorageRecord_RefreshToken:
+		key = row.RefreshToken.GetToken()
+	case *apiv1.StorageRecord_ResourceServer:
+		key = row.ResourceServer.GetUri()
+	}
+	return &apiv1.WatchEvent{
+		Type: t,
+		Key:  key,
+		Row:  record,
+		Time: timestamppb.New(now),
+	}
+}
+
+// watchedStorage records the changes of a storage to a journal.
+type watchedStorage struct {
+	Storage
+	journal *Journal
+	// orders the events as the changes. writes are serialized by the storages anyway.
+	mu sync.Mutex
+}
+
+func (s *watchedStorage) publish(t apiv1.EventType, records ...*apiv1.StorageRecord) {
+	now := time.Now()
+	events := make([]*apiv1.WatchEvent, len(records))
+	for i, record := range records {
+		events[i] = newWatchEvent(t, record, now)
+	}
+	s.journal.append(events...)
+}
+
+func (s *watchedStorage) UpdateUserProfile(ctx context.Context, id, profile string) (*apiv1.UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, err := s.Storage.UpdateUserProfile(ctx, id, profile)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_UPDATED, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_User{User: row}})
+	}
+	return row, err
+}
+
+func (s *watchedStorage) CompareAndSwapUserProfile(ctx context.Context, id string, version uint64, profile string) (*apiv1.UserProfile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, err := s.Storage.CompareAndSwapUserProfile(ctx, id, version, profile)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_UPDATED, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_User{User: row}})
+	}
+	return row, err
+}
+
+func (s *watchedStorage) CreateServiceClient(ctx context.Context, row *apiv1.ServiceClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.Storage.CreateServiceClient(ctx, row)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_CREATED, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_ServiceClient{ServiceClient: row}})
+	}
+	return err
+}
+
+func (s *watchedStorage) CreateAuthorizationCode(ctx context.Context, row *apiv1.AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.Storage.CreateAuthorizationCode(ctx, row)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_CREATED, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AuthorizationCode{AuthorizationCode: row}})
+	}
+	return err
+}
+
+func (s *watchedStorage) ConsumeAuthorizationCode(ctx context.Context, code string) (*apiv1.AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, err := s.Storage.ConsumeAuthorizationCode(ctx, code)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_CONSUMED, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AuthorizationCode{AuthorizationCode: row}})
+	}
+	return row, err
+}
+
+func (s *watchedStorage) CreateAccessToken(ctx context.Context, row *apiv1.AccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.Storage.CreateAccessToken(ctx, row)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_CREATED, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AccessToken{AccessToken: row}})
+	}
+	return err
+}
+
+func (s *watchedStorage) RevokeAccessToken(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, err := s.Storage.RevokeAccessToken(ctx, token)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_REVOKED, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AccessToken{AccessToken: row}})
+	}
+	return row, err
+}
+
+func (s *watchedStorage) CreateRefreshToken(ctx context.Context, row *apiv1.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.Storage.CreateRefreshToken(ctx, row)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_CREATED, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_RefreshToken{RefreshToken: row}})
+	}
+	return err
+}
+
+func (s *watchedStorage) RevokeRefreshToken(ctx context.Context, token string) (*apiv1.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	row, err := s.Storage.RevokeRefreshToken(ctx, token)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_REVOKED, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_RefreshToken{RefreshToken: row}})
+	}
+	return row, err
+}
+
+func (s *watchedStorage) BatchCreate(ctx context.Context, b Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.Storage.BatchCreate(ctx, b)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_CREATED, b.records()...)
+	}
+	return err
+}
+
+func (s *watchedStorage) CreateResourceServer(ctx context.Context, row *apiv1.ResourceServer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.Storage.CreateResourceServer(ctx, row)
+	if err == nil {
+		s.publish(apiv1.EventType_EVENT_TYPE_CREATED, &apiv1.StorageRecord{Row: &apiv1.StorageRecord_ResourceServer{ResourceServer: row}})
+	}
+	return err
+}
+
+func (s *watchedStorage) Sweep(before time.Time) SweepResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.Storage.Sweep(before)
+	s.publish(apiv1.EventType_EVENT_TYPE_EXPIRED, r.Rows...)
+	return r
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestJournal(t *testing.T) {
+	j := NewJournal(2)
+	start := j.Cursor()
+	resps, changed, err := j.Since(start)
+	assert.NoError(t, err)
+	assert.Empty(t, resps)
+
+	j.append(&apiv1.WatchEvent{Key: "1"})
+	select {
+	case <-changed:
+	default:
+		t.Fatal("changed is not closed")
+	}
+	resps, _, err = j.Since(start)
+	assert.NoError(t, err)
+	if assert.Len(t, resps, 1) {
+		assert.Equal(t, "1", resps[0].Event.Key)
+		assert.Equal(t, j.Cursor(), resps[0].Cursor)
+	}
+	resps, _, err = j.Since(j.Cursor())
+	assert.NoError(t, err)
+	assert.Empty(t, resps)
+
+	j.append(&apiv1.WatchEvent{Key: "2"}, &apiv1.WatchEvent{Key: "3"})
+	_, _, err = j.Since(start)
+	assert.ErrorIs(t, err, ErrCursorExpired)
+	resps, _, err = j.Since(j.cursor(1))
+	assert.NoError(t, err)
+	if assert.Len(t, resps, 2) {
+		assert.Equal(t, "2", resps[0].Event.Key)
+		assert.Equal(t, "3", resps[1].Event.Key)
+	}
+
+	_, _, err = NewJournal(2).Since(start)
+	assert.ErrorIs(t, err, ErrCursorExpired)
+	_, _, err = j.Since("invalid")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, _, err = j.Since(j.cursor(99))
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestWatchedStorage(t *testing.T) {
+	ctx := context.Background()
+	db, _ := NewDatabase()
+	j := NewJournal(0)
+	s := &watchedStorage{Storage: db, journal: j}
+	start := j.Cursor()
+
+	_, err := s.UpdateUserProfile(ctx, "1", "watched")
+	assert.NoError(t, err)
+	expires := timestamppb.New(time.Now().Add(-time.Minute))
+	assert.NoError(t, s.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "token", Expires: expires}))
+	_, err = s.RevokeAccessToken(ctx, "token")
+	assert.NoError(t, err)
+	// failures are not recorded
+	assert.ErrorIs(t, s.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "token"}), ErrAlreadyExists)
+	assert.Equal(t, 1, s.Sweep(time.Now()).AccessTokens)
+
+	resps, _, err := j.Since(start)
+	assert.NoError(t, err)
+	if !assert.Len(t, resps, 4) {
+		return
+	}
+	assert.Equal(t, apiv1.EventType_EVENT_TYPE_UPDATED, resps[0].Event.Type)
+	assert.Equal(t, "1", resps[0].Event.Key)
+	assert.Equal(t, "watched", resps[0].Event.Row.GetUser().GetProfile())
+	assert.Empty(t, resps[0].Event.Row.GetUser().GetPassword())
+	for i, typ := range []apiv1.EventType{
+		apiv1.EventType_EVENT_TYPE_CREATED,
+		apiv1.EventType_EVENT_TYPE_REVOKED,
+		apiv1.EventType_EVENT_TYPE_EXPIRED,
+	} {
+		assert.Equal(t, typ, resps[i+1].Event.Type)
+		assert.Equal(t, "token", resps[i+1].Event.Key)
+	}
+	assert.NotNil(t, resps[2].Event.Row.GetAccessToken().GetRevokedAt())
+	// the stored user keeps the password
+	user, err := db.GetUserById(ctx, "1")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, user.Password)
+}
+
+func TestWatch(t *testing.T) {
+	port := "3369"
+	pepper := []byte("0123456789abcdef")
+	_, err := NewDatabaseServer(context.Background(), ServerConfig{
+		Port:        port,
+		TokenPepper: pepper,
+		Insecure:    true,
+	})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, err := NewDatabaseClient(ctx, ClientConfig{
+		URL: "http://localhost:" + port,
+	})
+	assert.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return client.Ping(ctx) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	hasher, _ := NewTokenHasher(pepper)
+
+	sctx, scancel := context.WithCancel(ctx)
+	stream, err := client.client.Watch(sctx, connect.NewRequest(&apiv1.WatchRequest{}))
+	assert.NoError(t, err)
+	defer stream.Close()
+	defer scancel()
+	// the first response tells the cursor
+	assert.True(t, stream.Receive())
+	assert.NotEmpty(t, stream.Msg().GetCursor())
+	assert.Nil(t, stream.Msg().GetEvent())
+
+	assert.NoError(t, client.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "watched-token", Expires: timestamppb.Now()}))
+	_, err = client.RevokeAccessToken(ctx, "watched-token")
+	assert.NoError(t, err)
+
+	assert.True(t, stream.Receive())
+	created := stream.Msg()
+	assert.Equal(t, apiv1.EventType_EVENT_TYPE_CREATED, created.GetEvent().GetType())
+	// keyed by the hash, not the bearer value
+	assert.Equal(t, hasher.Hash("watched-token"), created.GetEvent().GetKey())
+	assert.Equal(t, hasher.Hash("watched-token"), created.GetEvent().GetRow().GetAccessToken().GetToken())
+	assert.True(t, stream.Receive())
+	assert.Equal(t, apiv1.EventType_EVENT_TYPE_REVOKED, stream.Msg().GetEvent().GetType())
+
+	// resume after the creation
+	wctx, wcancel := context.WithCancel(ctx)
+	defer wcancel()
+	var resumed []*apiv1.WatchEvent
+	err = client.Watch(wctx, created.GetCursor(), func(_ string, e *apiv1.WatchEvent) error {
+		resumed = append(resumed, e)
+		wcancel()
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	if assert.Len(t, resumed, 1) {
+		assert.Equal(t, apiv1.EventType_EVENT_TYPE_REVOKED, resumed[0].GetType())
+	}
+
+	err = client.Watch(ctx, "another.1", func(string, *apiv1.WatchEvent) error { return nil })
+	assert.ErrorIs(t, err, ErrCursorExpired)
+}
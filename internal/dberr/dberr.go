@@ -0,0 +1,43 @@
+// Package dberr is the errors of the database shared by its client and the packages responding them,
+// without importing the database.
+package dberr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrUnavailable is returned when the database server cannot answer for now. Try again later.
+	ErrUnavailable = errors.New("database is unavailable")
+	// ErrTimeout is returned when the database server did not answer in time.
+	ErrTimeout = fmt.Errorf("%w: timeout", ErrUnavailable)
+	// ErrCircuitOpen is returned without calling the database server while it keeps failing.
+	ErrCircuitOpen = fmt.Errorf("%w: circuit breaker is open", ErrUnavailable)
+)
+
+// RetryError is a temporary failure which is worth retrying after [RetryError.After].
+type RetryError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", e.Err, e.After)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter returns the seconds to wait before retrying [err], rounded up, if it is a [*RetryError].
+func RetryAfter(err error) (int, bool) {
+	var e *RetryError
+	if !errors.As(err, &e) {
+		return 0, false
+	}
+	return max(1, int(math.Ceil(e.After.Seconds()))), true
+}
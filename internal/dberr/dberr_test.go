@@ -0,0 +1,32 @@
+package dberr
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfter(t *testing.T) {
+	test := []struct {
+		name  string
+		err   error
+		after int
+		ok    bool
+	}{
+		{"seconds", &RetryError{Err: ErrCircuitOpen, After: 5 * time.Second}, 5, true},
+		{"rounded up", &RetryError{Err: ErrCircuitOpen, After: 1200 * time.Millisecond}, 2, true},
+		{"at least 1", &RetryError{Err: ErrCircuitOpen}, 1, true},
+		{"wrapped", fmt.Errorf("get user: %w", &RetryError{Err: ErrCircuitOpen, After: time.Second}), 1, true},
+		{"other", ErrUnavailable, 0, false},
+	}
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			after, ok := RetryAfter(tt.err)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.after, after)
+		})
+	}
+	assert.ErrorIs(t, &RetryError{Err: ErrCircuitOpen}, ErrUnavailable)
+}
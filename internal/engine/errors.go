@@ -1,21 +1,225 @@
 package enging
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
 
-var (
-	BadRequestMessage = gin.H{
-		"status": "Bad Request",
+	"github.com/gin-gonic/gin"
+	"github.com/yyyoichi/OhAuth0.1/internal/dberr"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+)
+
+// Code is the stable, machine-readable code of an error response. Clients branch on it, not on the messages.
+type Code string
+
+const (
+	CodeInvalidRequest Code = "invalid_request"
+	CodeNotFound       Code = "not_found"
+	CodeUnauthorized   Code = "unauthorized"
+	CodeForbidden      Code = "forbidden"
+	CodeInternal       Code = "internal_error"
+	CodeUnavailable    Code = "unavailable"
+
+	// authentication and authorization
+	CodeInvalidCredentials              Code = "invalid_credentials"
+	CodeLoginRequired                   Code = "login_required"
+	CodeConsentRequired                 Code = "consent_required"
+	CodeUnmetAuthenticationRequirements Code = "unmet_authentication_requirements"
+	CodeInvalidScope                    Code = "invalid_scope"
+	CodeInvalidTarget                   Code = "invalid_target"
+	CodeInvalidAuthorizationDetails     Code = "invalid_authorization_details"
+
+	// token endpoint
+	CodeInvalidClient            Code = "invalid_client"
+	CodeUnauthorizedClient       Code = "unauthorized_client"
+	CodeInvalidGrant             Code = "invalid_grant"
+	CodeAuthorizationCodeExpired Code = "authorization_code_expired"
+	CodeAuthorizationCodeUsed    Code = "authorization_code_used"
+	CodeRefreshTokenExpired      Code = "refresh_token_expired"
+	CodeRefreshTokenRevoked      Code = "refresh_token_revoked"
+	CodeInvalidSubjectToken      Code = "invalid_subject_token"
+	CodeInvalidActorToken        Code = "invalid_actor_token"
+	CodeUnsupportedTokenType     Code = "unsupported_token_type"
+
+	// logout
+	CodeSessionNotFound              Code = "session_not_found"
+	CodeInvalidPostLogoutRedirectUri Code = "invalid_post_logout_redirect_uri"
+
+	// resource server
+	CodeInvalidToken                   Code = "invalid_token"
+	CodeTokenExpired                   Code = "token_expired"
+	CodeInsufficientScope              Code = "insufficient_scope"
+	CodeInsufficientUserAuthentication Code = "insufficient_user_authentication"
+)
+
+// oauthErrors are the 'error' of OAuth 2.0 and its extensions of the codes. The codes not listed have none.
+var oauthErrors = map[Code]string{
+	CodeInvalidRequest:                  "invalid_request",
+	CodeInternal:                        "server_error",
+	CodeUnavailable:                     "temporarily_unavailable",
+	CodeLoginRequired:                   "login_required",
+	CodeConsentRequired:                 "consent_required",
+	CodeUnmetAuthenticationRequirements: "unmet_authentication_requirements",
+	CodeInvalidScope:                    "invalid_scope",
+	CodeInvalidTarget:                   "invalid_target",
+	CodeInvalidAuthorizationDetails:     "invalid_authorization_details",
+	CodeInvalidClient:                   "invalid_client",
+	CodeUnauthorizedClient:              "unauthorized_client",
+	CodeInvalidGrant:                    "invalid_grant",
+	CodeAuthorizationCodeExpired:        "invalid_grant",
+	CodeAuthorizationCodeUsed:           "invalid_grant",
+	CodeRefreshTokenExpired:             "invalid_grant",
+	CodeRefreshTokenRevoked:             "invalid_grant",
+	CodeInvalidSubjectToken:             "invalid_request",
+	CodeInvalidActorToken:               "invalid_request",
+	CodeUnsupportedTokenType:            "invalid_request",
+	CodeInvalidToken:                    "invalid_token",
+	CodeTokenExpired:                    "invalid_token",
+	CodeInsufficientScope:               "insufficient_scope",
+	CodeInsufficientUserAuthentication:  "insufficient_user_authentication",
+}
+
+// OAuthError returns the 'error' of OAuth 2.0 for [code], or empty if it has none.
+func OAuthError(code Code) string {
+	return oauthErrors[code]
+}
+
+// ProblemTypePrefix prefixes the code in the 'type' of the problem details.
+const ProblemTypePrefix = "urn:ohauth:error:"
+
+// ProblemContentType is the media type of the problem details (RFC 9457).
+const ProblemContentType = "application/problem+json"
+
+type (
+	// Error is an error response. The cause [Error.Err] is for the logs and never responded.
+	Error struct {
+		Status int
+		Code   Code
+		// human-readable, never with secrets
+		Detail string
+		Err    error
+	}
+	// Problem is the body of the problem details (RFC 9457) for the APIs other than OAuth.
+	Problem struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail,omitempty"`
+		Instance string `json:"instance,omitempty"`
+		// extensions
+		Code      Code   `json:"code"`
+		RequestId string `json:"request_id,omitempty"`
+	}
+	// OAuthErrorResponse is the body of the error response of the OAuth endpoints (RFC 6749 5.2).
+	OAuthErrorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+		// extensions
+		Code      Code   `json:"code"`
+		RequestId string `json:"request_id,omitempty"`
 	}
-	NotFoundMessage = gin.H{
-		"status": "Not Found",
+)
+
+// New returns an error responded with [status].
+func New(status int, code Code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+// Wrap sets the cause [err] and returns [e].
+func (e *Error) Wrap(err error) *Error {
+	e.Err = err
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Code, e.Err)
 	}
-	InternalServerErrorMessage = gin.H{
-		"status": "Internal Server Error",
+	return fmt.Sprintf("%s: %s", e.Code, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// FromError maps the errors of the database: 404 on [dberr.ErrNotFound], 503 on [dberr.ErrUnavailable],
+// or 500 otherwise. An [*Error] is returned as is.
+func FromError(err error) *Error {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, dberr.ErrNotFound):
+		return New(http.StatusNotFound, CodeNotFound, "not found").Wrap(err)
+	case errors.Is(err, dberr.ErrUnavailable):
+		return New(http.StatusServiceUnavailable, CodeUnavailable, "service is temporarily unavailable").Wrap(err)
+	default:
+		return New(http.StatusInternalServerError, CodeInternal, "internal server error").Wrap(err)
 	}
-	ForbiddenErrorMessage = gin.H{
-		"status": "Forbidden",
+}
+
+// Problem returns [e] as the problem details with the request id.
+func (e *Error) Problem(ctx *gin.Context) Problem {
+	return Problem{
+		Type:      ProblemTypePrefix + string(e.Code),
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Instance:  ctx.Request.URL.Path,
+		Code:      e.Code,
+		RequestId: interceptor.RequestID(ctx.Request.Context()),
 	}
-	StatusUnauthorizedErrorMessage = gin.H{
-		"status": "unauthorized",
+}
+
+// OAuthErrorResponse returns [e] as the error response of OAuth. The codes without the 'error' of OAuth are
+// 'invalid_request' if the status is 4xx, or 'server_error' otherwise.
+func (e *Error) OAuthErrorResponse(ctx *gin.Context) OAuthErrorResponse {
+	name := OAuthError(e.Code)
+	if name == "" {
+		name = OAuthError(CodeInvalidRequest)
+		if e.Status >= http.StatusInternalServerError {
+			name = OAuthError(CodeInternal)
+		}
 	}
-)
+	return OAuthErrorResponse{
+		Error:            name,
+		ErrorDescription: e.Detail,
+		Code:             e.Code,
+		RequestId:        interceptor.RequestID(ctx.Request.Context()),
+	}
+}
+
+// RespondProblem responds [err] as the problem details (RFC 9457) and aborts the rest of the handlers.
+// It is mapped by [FromError] unless an [*Error].
+func RespondProblem(ctx *gin.Context, err error) {
+	e := FromError(err)
+	retryAfter(ctx, e)
+	ctx.Header("Content-Type", ProblemContentType)
+	ctx.Abort()
+	ctx.SecureJSON(e.Status, e.Problem(ctx))
+}
+
+// RespondOAuth responds [err] as the error response of OAuth (RFC 6749 5.2) and aborts the rest of the handlers.
+// It is mapped by [FromError] unless an [*Error].
+func RespondOAuth(ctx *gin.Context, err error) {
+	e := FromError(err)
+	retryAfter(ctx, e)
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Abort()
+	ctx.SecureJSON(e.Status, e.OAuthErrorResponse(ctx))
+}
+
+// retryAfter asks to retry a temporary failure of the database, when the circuit breaker lets a call through
+// again if it is open, or in a second otherwise.
+func retryAfter(ctx *gin.Context, e *Error) {
+	if e.Status != http.StatusServiceUnavailable {
+		return
+	}
+	seconds, ok := dberr.RetryAfter(e.Err)
+	if !ok {
+		seconds = 1
+	}
+	ctx.Header("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,113 @@
+package enging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yyyoichi/OhAuth0.1/internal/dberr"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+)
+
+func TestFromError(t *testing.T) {
+	test := map[string]struct {
+		err       error
+		expStatus int
+		expCode   Code
+	}{
+		"not found":   {fmt.Errorf("cannot get client: %w", dberr.ErrNotFound), http.StatusNotFound, CodeNotFound},
+		"unavailable": {dberr.ErrCircuitOpen, http.StatusServiceUnavailable, CodeUnavailable},
+		"unexpected":  {errors.New("unexpected"), http.StatusInternalServerError, CodeInternal},
+		"as is":       {New(http.StatusBadRequest, CodeInvalidScope, "scope"), http.StatusBadRequest, CodeInvalidScope},
+	}
+	for scenario, tt := range test {
+		t.Run(scenario, func(t *testing.T) {
+			e := FromError(tt.err)
+			assert.Equal(t, tt.expStatus, e.Status)
+			assert.Equal(t, tt.expCode, e.Code)
+			assert.ErrorIs(t, e, tt.err)
+		})
+	}
+}
+
+func TestRespond(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(interceptor.GinRequestID())
+	called := false
+	router.GET("/problem", func(ctx *gin.Context) {
+		RespondProblem(ctx, New(http.StatusForbidden, CodeInsufficientScope, "'profile:edit' scope is required"))
+	}, func(ctx *gin.Context) {
+		// aborted
+		called = true
+	})
+	router.POST("/token", func(ctx *gin.Context) {
+		RespondOAuth(ctx, New(http.StatusUnauthorized, CodeAuthorizationCodeExpired, "authorization code is expired").Wrap(errors.New("secret cause")))
+	})
+	router.POST("/unavailable", func(ctx *gin.Context) {
+		RespondOAuth(ctx, dberr.ErrTimeout)
+	})
+	router.GET("/circuit-open", func(ctx *gin.Context) {
+		RespondProblem(ctx, fmt.Errorf("cannot get user: %w", &dberr.RetryError{Err: dberr.ErrCircuitOpen, After: 4200 * time.Millisecond}))
+	})
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(interceptor.RequestIDHeader, "req-1")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := serve(http.MethodGet, "/problem")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Equal(t, ProblemContentType, resp.Header().Get("Content-Type"))
+	assert.False(t, called)
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &problem))
+	assert.Equal(t, Problem{
+		Type:      "urn:ohauth:error:insufficient_scope",
+		Title:     "Forbidden",
+		Status:    http.StatusForbidden,
+		Detail:    "'profile:edit' scope is required",
+		Instance:  "/problem",
+		Code:      CodeInsufficientScope,
+		RequestId: "req-1",
+	}, problem)
+
+	resp = serve(http.MethodPost, "/token")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+	assert.NotContains(t, resp.Body.String(), "secret cause")
+	var oauth OAuthErrorResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &oauth))
+	assert.Equal(t, OAuthErrorResponse{
+		Error:            "invalid_grant",
+		ErrorDescription: "authorization code is expired",
+		Code:             CodeAuthorizationCodeExpired,
+		RequestId:        "req-1",
+	}, oauth)
+
+	resp = serve(http.MethodPost, "/unavailable")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, "1", resp.Header().Get("Retry-After"))
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &oauth))
+	assert.Equal(t, "temporarily_unavailable", oauth.Error)
+
+	resp = serve(http.MethodGet, "/circuit-open")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, "5", resp.Header().Get("Retry-After"), "until the breaker lets a call through")
+}
+
+func TestOAuthErrorResponse(t *testing.T) {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/token", nil)
+	// a code without the 'error' of OAuth
+	assert.Equal(t, "invalid_request", New(http.StatusNotFound, CodeNotFound, "").OAuthErrorResponse(ctx).Error)
+	assert.Equal(t, "server_error", New(http.StatusBadGateway, CodeForbidden, "").OAuthErrorResponse(ctx).Error)
+}
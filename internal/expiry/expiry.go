@@ -0,0 +1,57 @@
+// Package expiry indexes keys by their expiry time in a min-heap,
+// so expired keys are found without scanning every row.
+package expiry
+
+import (
+	"container/heap"
+	"time"
+)
+
+type Entry[K comparable] struct {
+	Key     K
+	Expires time.Time
+}
+
+// Index is not safe for concurrent use. The zero value is ready to use.
+type Index[K comparable] struct {
+	h entries[K]
+}
+
+// Add indexes [key]. A key may be added again with another time; callers
+// compare [Entry.Expires] with the row to skip stale entries.
+func (x *Index[K]) Add(key K, expires time.Time) {
+	heap.Push(&x.h, Entry[K]{Key: key, Expires: expires})
+}
+
+// Expired removes and returns the entries which expired before [before], oldest first.
+func (x *Index[K]) Expired(before time.Time) []Entry[K] {
+	var out []Entry[K]
+	for len(x.h) > 0 && x.h[0].Expires.Before(before) {
+		out = append(out, heap.Pop(&x.h).(Entry[K]))
+	}
+	return out
+}
+
+func (x *Index[K]) Len() int {
+	return len(x.h)
+}
+
+// Reset removes all entries.
+func (x *Index[K]) Reset() {
+	x.h = nil
+}
+
+// entries implements heap.Interface.
+type entries[K comparable] []Entry[K]
+
+func (h entries[K]) Len() int           { return len(h) }
+func (h entries[K]) Less(i, j int) bool { return h[i].Expires.Before(h[j].Expires) }
+func (h entries[K]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *entries[K]) Push(x any)        { *h = append(*h, x.(Entry[K])) }
+func (h *entries[K]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	*h = old[:n-1]
+	return e
+}
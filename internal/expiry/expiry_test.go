@@ -0,0 +1,36 @@
+package expiry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex(t *testing.T) {
+	now := time.Now()
+	var x Index[string]
+	assert.Empty(t, x.Expired(now))
+
+	x.Add("c", now.Add(3*time.Second))
+	x.Add("a", now.Add(time.Second))
+	x.Add("d", now.Add(time.Hour))
+	x.Add("b", now.Add(2*time.Second))
+	assert.Equal(t, 4, x.Len())
+
+	expired := x.Expired(now.Add(2500 * time.Millisecond))
+	assert.Equal(t, []Entry[string]{
+		{Key: "a", Expires: now.Add(time.Second)},
+		{Key: "b", Expires: now.Add(2 * time.Second)},
+	}, expired)
+	assert.Equal(t, 2, x.Len())
+
+	// not before itself
+	assert.Empty(t, x.Expired(now.Add(3*time.Second)))
+	assert.Len(t, x.Expired(now.Add(2*time.Hour)), 2)
+	assert.Zero(t, x.Len())
+
+	x.Add("e", now)
+	x.Reset()
+	assert.Zero(t, x.Len())
+}
@@ -0,0 +1,140 @@
+package interceptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/api/v1/apiv1connect"
+)
+
+// pingHandler records the request id of the last call.
+type pingHandler struct {
+	apiv1connect.UnimplementedDatabaseServiceHandler
+	requestID string
+}
+
+func (h *pingHandler) Ping(ctx context.Context, _ *connect.Request[apiv1.PingRequest]) (*connect.Response[apiv1.PingResponse], error) {
+	h.requestID = RequestID(ctx)
+	return connect.NewResponse(&apiv1.PingResponse{}), nil
+}
+
+func newPingServer(t *testing.T, h *pingHandler, interceptors ...connect.Interceptor) apiv1connect.DatabaseServiceClient {
+	mux := http.NewServeMux()
+	mux.Handle(apiv1connect.NewDatabaseServiceHandler(h, connect.WithInterceptors(interceptors...)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return apiv1connect.NewDatabaseServiceClient(server.Client(), server.URL, connect.WithInterceptors(NewRequestID()))
+}
+
+func TestRequestID(t *testing.T) {
+	h := &pingHandler{}
+	client := newPingServer(t, h, NewRequestID())
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(GinRequestID())
+	router.GET("/", func(ctx *gin.Context) {
+		// the handlers pass the request context to the services, since gin reuses *gin.Context after the response
+		_, err := client.Ping(ctx.Request.Context(), connect.NewRequest(&apiv1.PingRequest{}))
+		assert.NoError(t, err)
+	})
+
+	t.Run("propagated", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, "login-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, "login-1", w.Header().Get(RequestIDHeader))
+		assert.Equal(t, "login-1", h.requestID)
+	})
+	t.Run("issued", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.NotEmpty(t, w.Header().Get(RequestIDHeader))
+		assert.Equal(t, w.Header().Get(RequestIDHeader), h.requestID)
+	})
+	t.Run("without caller", func(t *testing.T) {
+		resp, err := client.Ping(context.Background(), connect.NewRequest(&apiv1.PingRequest{}))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, h.requestID)
+		assert.Equal(t, h.requestID, resp.Header().Get(RequestIDHeader))
+	})
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	client := newPingServer(t, &pingHandler{}, NewRequestID(), NewLogging(logger))
+	ctx := WithRequestID(context.Background(), "login-1")
+	_, err := client.Ping(ctx, connect.NewRequest(&apiv1.PingRequest{}))
+	assert.NoError(t, err)
+	_, err = client.GetUserUnary(ctx, connect.NewRequest(&apiv1.GetUserRequest{}))
+	assert.Error(t, err)
+
+	dec := json.NewDecoder(&buf)
+	var records []map[string]any
+	for dec.More() {
+		var r map[string]any
+		assert.NoError(t, dec.Decode(&r))
+		records = append(records, r)
+	}
+	if !assert.Len(t, records, 2) {
+		return
+	}
+	assert.Equal(t, "INFO", records[0]["level"])
+	assert.Equal(t, "server", records[0]["side"])
+	assert.Equal(t, apiv1connect.DatabaseServicePingProcedure, records[0]["procedure"])
+	assert.Equal(t, "ok", records[0]["code"])
+	assert.Equal(t, "login-1", records[0]["request_id"])
+	assert.Contains(t, records[0], "duration")
+	assert.Equal(t, "WARN", records[1]["level"])
+	assert.Equal(t, "unimplemented", records[1]["code"])
+}
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry)
+	client := newPingServer(t, &pingHandler{}, metrics)
+	for range 2 {
+		_, err := client.Ping(context.Background(), connect.NewRequest(&apiv1.PingRequest{}))
+		assert.NoError(t, err)
+	}
+	_, err := client.GetUserUnary(context.Background(), connect.NewRequest(&apiv1.GetUserRequest{}))
+	assert.Equal(t, connect.CodeUnimplemented, connect.CodeOf(err))
+
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	counts := map[string]float64{}
+	var observed uint64
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			switch f.GetName() {
+			case "rpc_requests_total":
+				counts[labels["procedure"]+" "+labels["code"]] = m.GetCounter().GetValue()
+			case "rpc_duration_seconds":
+				observed += m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		apiv1connect.DatabaseServicePingProcedure + " ok":                    2,
+		apiv1connect.DatabaseServiceGetUserUnaryProcedure + " unimplemented": 1,
+	}, counts)
+	assert.EqualValues(t, 3, observed)
+	// registered twice
+	assert.Panics(t, func() { NewMetrics(registry) })
+	assert.NotPanics(t, func() { NewMetrics(nil) })
+}
@@ -0,0 +1,107 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"connectrpc.com/connect"
+)
+
+// NewLogging returns the interceptor which logs every call with its duration and code.
+// Failed calls are logged as warnings.
+func NewLogging(logger *slog.Logger) connect.Interceptor {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &loggingInterceptor{logger: logger}
+}
+
+type loggingInterceptor struct {
+	logger *slog.Logger
+}
+
+func (i *loggingInterceptor) log(ctx context.Context, spec connect.Spec, start time.Time, err error) {
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	attrs := []slog.Attr{
+		slog.String("side", side(spec)),
+		slog.String("procedure", spec.Procedure),
+		slog.String("code", code(err)),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if id := RequestID(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	i.logger.LogAttrs(ctx, level, "rpc", attrs...)
+}
+
+func (i *loggingInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		start := time.Now()
+		resp, err := next(ctx, req)
+		i.log(ctx, req.Spec(), start, err)
+		return resp, err
+	}
+}
+
+func (i *loggingInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		return &observedClientConn{StreamingClientConn: next(ctx, spec), start: time.Now(), done: func(start time.Time, err error) {
+			i.log(ctx, spec, start, err)
+		}}
+	}
+}
+
+func (i *loggingInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		start := time.Now()
+		err := next(ctx, conn)
+		i.log(ctx, conn.Spec(), start, err)
+		return err
+	}
+}
+
+// observedClientConn reports the end of a client stream with the first error it received.
+type observedClientConn struct {
+	connect.StreamingClientConn
+	start time.Time
+	err   error
+	done  func(start time.Time, err error)
+}
+
+func (c *observedClientConn) Receive(msg any) error {
+	err := c.StreamingClientConn.Receive(msg)
+	if err != nil && !errors.Is(err, io.EOF) && c.err == nil {
+		c.err = err
+	}
+	return err
+}
+
+func (c *observedClientConn) CloseResponse() error {
+	err := c.StreamingClientConn.CloseResponse()
+	c.done(c.start, c.err)
+	return err
+}
+
+func side(spec connect.Spec) string {
+	if spec.IsClient {
+		return "client"
+	}
+	return "server"
+}
+
+// code returns the Connect code of [err], e.g. 'not_found', or 'ok'.
+func code(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return connect.CodeOf(err).String()
+}
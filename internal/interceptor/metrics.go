@@ -0,0 +1,66 @@
+package interceptor
+
+import (
+	"context"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics counts the calls and observes their durations by the procedure and the code.
+type Metrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewMetrics returns the metrics registered to [registerer]. A nil [registerer] keeps them unregistered.
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rpc_requests_total",
+			Help: "Connect RPCs by the side, the procedure and the code.",
+		}, []string{"side", "procedure", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rpc_duration_seconds",
+			Help:    "Durations of the Connect RPCs. streams are observed when they end.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"side", "procedure", "code"}),
+	}
+	if registerer != nil {
+		registerer.MustRegister(m.requests, m.duration)
+	}
+	return m
+}
+
+func (m *Metrics) observe(spec connect.Spec, start time.Time, err error) {
+	labels := prometheus.Labels{"side": side(spec), "procedure": spec.Procedure, "code": code(err)}
+	m.requests.With(labels).Inc()
+	m.duration.With(labels).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		start := time.Now()
+		resp, err := next(ctx, req)
+		m.observe(req.Spec(), start, err)
+		return resp, err
+	}
+}
+
+func (m *Metrics) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		return &observedClientConn{StreamingClientConn: next(ctx, spec), start: time.Now(), done: func(start time.Time, err error) {
+			m.observe(spec, start, err)
+		}}
+	}
+}
+
+func (m *Metrics) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		start := time.Now()
+		err := next(ctx, conn)
+		m.observe(conn.Spec(), start, err)
+		return err
+	}
+}
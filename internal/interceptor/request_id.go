@@ -0,0 +1,95 @@
+// Package interceptor provides the Connect interceptors shared by the database server and its clients.
+package interceptor
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the id of the request which caused the call, so that one login is traced
+// across the servers.
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying [id].
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id of [ctx], or empty.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// GinRequestID takes over the request id of the incoming request, or issues a new one,
+// and returns it in the response. The handlers pass it to the services with the request context,
+// 'ctx.Request.Context()', not with [*gin.Context], which gin reuses for another request after the response.
+func GinRequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = uuid.NewString()
+		}
+		ctx.Request = ctx.Request.WithContext(WithRequestID(ctx.Request.Context(), id))
+		ctx.Header(RequestIDHeader, id)
+		ctx.Next()
+	}
+}
+
+// NewRequestID returns the interceptor which sends the request id of the context with the calls,
+// and receives it into the context of the handlers.
+func NewRequestID() connect.Interceptor {
+	return &requestIDInterceptor{}
+}
+
+type requestIDInterceptor struct{}
+
+func (i *requestIDInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			if id := RequestID(ctx); id != "" {
+				req.Header().Set(RequestIDHeader, id)
+			}
+			return next(ctx, req)
+		}
+		ctx = i.receive(ctx, req.Header().Get(RequestIDHeader))
+		resp, err := next(ctx, req)
+		if err != nil {
+			// the response is a typed nil
+			return nil, err
+		}
+		resp.Header().Set(RequestIDHeader, RequestID(ctx))
+		return resp, nil
+	}
+}
+
+func (i *requestIDInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		conn := next(ctx, spec)
+		if id := RequestID(ctx); id != "" {
+			conn.RequestHeader().Set(RequestIDHeader, id)
+		}
+		return conn
+	}
+}
+
+func (i *requestIDInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		ctx = i.receive(ctx, conn.RequestHeader().Get(RequestIDHeader))
+		conn.ResponseHeader().Set(RequestIDHeader, RequestID(ctx))
+		return next(ctx, conn)
+	}
+}
+
+// receive puts the request id of the caller into [ctx], or a new one if the caller did not send it.
+func (i *requestIDInterceptor) receive(ctx context.Context, id string) context.Context {
+	if id == "" || len(id) > 128 {
+		id = uuid.NewString()
+	}
+	return WithRequestID(ctx, id)
+}
@@ -0,0 +1,235 @@
+// Package lifecycle runs the http servers until a signal, and drains them.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const defaultDrainTimeout = time.Duration(10) * time.Second
+
+const (
+	LivenessPath  = "/livez"
+	ReadinessPath = "/readyz"
+	MetricsPath   = "/metrics"
+)
+
+type (
+	Config struct {
+		// checked by the readiness endpoint. Optional.
+		Ready func(ctx context.Context) error
+		// in-flight requests are waited this long after the shutdown begins. default 10 seconds.
+		DrainTimeout time.Duration
+		// called after the requests are drained, e.g. to close the storage. Optional.
+		OnStop func() error
+		// serves HTTP/2 without TLS as well.
+		H2C bool
+		// served at the metrics endpoint. Optional.
+		Metrics prometheus.Gatherer
+		// accepts the connections instead of the address of the server, e.g. in-memory ones. Optional.
+		Listener net.Listener
+	}
+	// Server serves an [http.Server] until its context is done, and drains it.
+	Server struct {
+		config   Config
+		server   *http.Server
+		listener net.Listener
+		draining atomic.Bool
+		// requests being served. hijacked connections, e.g. h2c, are not waited by [http.Server.Shutdown].
+		inflight atomic.Int64
+		done     chan struct{}
+		err      error
+		// nil if no metrics are served
+		metrics http.Handler
+	}
+)
+
+// SignalContext returns a context cancelled on SIGINT or SIGTERM.
+func SignalContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+}
+
+// NewRegistry returns a registry of a server, with the metrics of the Go runtime and the process.
+// Each server has its own registry, so that servers in a process do not register the same metrics twice.
+func NewRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
+
+// Listen binds the address of [server], so that an unavailable port is reported here.
+// The handler of [server] is wrapped to answer the liveness and readiness endpoints,
+// and the metrics endpoint if [Config.Metrics] is set.
+// TLS is served if [server] has a TLS config.
+func Listen(server *http.Server, config Config) (*Server, error) {
+	if config.DrainTimeout <= 0 {
+		config.DrainTimeout = defaultDrainTimeout
+	}
+	listener := config.Listener
+	if listener == nil {
+		addr := server.Addr
+		if addr == "" {
+			addr = ":http"
+		}
+		var err error
+		listener, err = net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s := &Server{
+		config:   config,
+		server:   server,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	if config.Metrics != nil {
+		s.metrics = promhttp.HandlerFor(config.Metrics, promhttp.HandlerOpts{})
+	}
+	server.Handler = s.handler(server.Handler)
+	if config.H2C {
+		// outside of the counter, since a h2c connection is served as a hijacked request
+		server.Handler = h2c.NewHandler(server.Handler, &http2.Server{})
+	}
+	return s, nil
+}
+
+// Addr returns the bound address.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Start serves in the background. The server stops accepting requests when [ctx] is done,
+// and is closed after the in-flight requests finish or the drain timeout.
+func (s *Server) Start(ctx context.Context) {
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.server.TLSConfig != nil {
+			serveErr <- s.server.ServeTLS(s.listener, "", "")
+		} else {
+			serveErr <- s.server.Serve(s.listener)
+		}
+	}()
+	go func() {
+		defer close(s.done)
+		select {
+		case err := <-serveErr:
+			// the server failed by itself
+			s.draining.Store(true)
+			s.err = errors.Join(err, s.stop())
+			return
+		case <-ctx.Done():
+		}
+		s.err = errors.Join(s.drain(), s.stop())
+		if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+			s.err = errors.Join(s.err, err)
+		}
+	}()
+}
+
+// Wait returns after the server is drained.
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Serve serves until [ctx] is done and the server is drained.
+func (s *Server) Serve(ctx context.Context) error {
+	s.Start(ctx)
+	return s.Wait()
+}
+
+func (s *Server) drain() error {
+	s.draining.Store(true)
+	slog.Info("draining server", slog.String("addr", s.Addr().String()))
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DrainTimeout)
+	defer cancel()
+	err := s.server.Shutdown(ctx)
+	ticker := time.NewTicker(time.Duration(10) * time.Millisecond)
+	defer ticker.Stop()
+	for err == nil && s.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	if err != nil {
+		slog.Warn("server is closed before drained", slog.Int64("inflight", s.inflight.Load()), slog.Any("error", err))
+		return s.server.Close()
+	}
+	slog.Info("server is drained", slog.String("addr", s.Addr().String()))
+	return nil
+}
+
+func (s *Server) stop() error {
+	if s.config.OnStop == nil {
+		return nil
+	}
+	return s.config.OnStop()
+}
+
+// Draining reports whether the server is shutting down.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+func (s *Server) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case LivenessPath:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write([]byte("ok"))
+			return
+		case ReadinessPath:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if err := s.ready(r.Context()); err != nil {
+				slog.WarnContext(r.Context(), "server is not ready", slog.Any("error", err))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte("not ready"))
+				return
+			}
+			_, _ = w.Write([]byte("ok"))
+			return
+		case MetricsPath:
+			if s.metrics != nil {
+				s.metrics.ServeHTTP(w, r)
+				return
+			}
+		}
+		s.inflight.Add(1)
+		defer s.inflight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+var errDraining = errors.New("server is shutting down")
+
+func (s *Server) ready(ctx context.Context) error {
+	if s.Draining() {
+		return errDraining
+	}
+	if s.config.Ready == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(2)*time.Second)
+	defer cancel()
+	return s.config.Ready(ctx)
+}
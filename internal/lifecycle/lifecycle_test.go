@@ -0,0 +1,141 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, s *Server, path string) (int, string) {
+	resp, err := http.Get("http://" + s.Addr().String() + path)
+	if !assert.NoError(t, err) {
+		return 0, ""
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestServer(t *testing.T) {
+	t.Run("health", func(t *testing.T) {
+		var notReady atomic.Bool
+		s, err := Listen(&http.Server{Addr: "localhost:0", Handler: http.NotFoundHandler()}, Config{
+			Ready: func(context.Context) error {
+				if notReady.Load() {
+					return errors.New("database is down")
+				}
+				return nil
+			},
+		})
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		s.Start(ctx)
+
+		code, body := get(t, s, LivenessPath)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ok", body)
+		code, _ = get(t, s, ReadinessPath)
+		assert.Equal(t, http.StatusOK, code)
+		notReady.Store(true)
+		code, body = get(t, s, ReadinessPath)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "not ready", body)
+		// the other paths are served by the handler
+		code, _ = get(t, s, "/")
+		assert.Equal(t, http.StatusNotFound, code)
+
+		cancel()
+		assert.NoError(t, s.Wait())
+		assert.True(t, s.Draining())
+	})
+	t.Run("metrics", func(t *testing.T) {
+		s, err := Listen(&http.Server{Addr: "localhost:0", Handler: http.NotFoundHandler()}, Config{Metrics: NewRegistry()})
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		s.Start(ctx)
+		code, body := get(t, s, MetricsPath)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, "go_goroutines")
+		cancel()
+		assert.NoError(t, s.Wait())
+
+		// served by the handler without a registry
+		s, err = Listen(&http.Server{Addr: "localhost:0", Handler: http.NotFoundHandler()}, Config{})
+		assert.NoError(t, err)
+		ctx, cancel = context.WithCancel(context.Background())
+		s.Start(ctx)
+		code, _ = get(t, s, MetricsPath)
+		assert.Equal(t, http.StatusNotFound, code)
+		cancel()
+		assert.NoError(t, s.Wait())
+	})
+	t.Run("drain", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		var stopped atomic.Bool
+		s, err := Listen(&http.Server{Addr: "localhost:0", Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			_, _ = w.Write([]byte("done"))
+		})}, Config{
+			OnStop: func() error {
+				stopped.Store(true)
+				return nil
+			},
+		})
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		s.Start(ctx)
+
+		result := make(chan string)
+		go func() {
+			_, body := get(t, s, "/")
+			result <- body
+		}()
+		<-started
+		cancel()
+		// new connections are refused while the request is drained
+		assert.Eventually(t, func() bool {
+			resp, err := http.Get("http://" + s.Addr().String() + LivenessPath)
+			if err == nil {
+				resp.Body.Close()
+			}
+			return s.Draining() && err != nil
+		}, time.Second, time.Duration(5)*time.Millisecond)
+		assert.False(t, stopped.Load())
+		close(release)
+		assert.Equal(t, "done", <-result)
+		assert.NoError(t, s.Wait())
+		assert.True(t, stopped.Load())
+	})
+	t.Run("drain timeout", func(t *testing.T) {
+		started := make(chan struct{})
+		s, err := Listen(&http.Server{Addr: "localhost:0", Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-r.Context().Done()
+		})}, Config{DrainTimeout: time.Duration(20) * time.Millisecond})
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		s.Start(ctx)
+		go func() {
+			_, _ = http.Get("http://" + s.Addr().String())
+		}()
+		<-started
+		cancel()
+		assert.NoError(t, s.Wait())
+	})
+	t.Run("address in use", func(t *testing.T) {
+		s, err := Listen(&http.Server{Addr: "localhost:0"}, Config{})
+		assert.NoError(t, err)
+		_, err = Listen(&http.Server{Addr: s.Addr().String()}, Config{})
+		assert.Error(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.NoError(t, s.Serve(ctx))
+	})
+}
@@ -0,0 +1,75 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// EncodePEM returns the PEM-encoded certificate chain and PKCS #8 private key of [cert].
+func EncodePEM(cert tls.Certificate) (certPEM, keyPEM []byte, err error) {
+	for _, der := range cert.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	return certPEM, keyPEM, nil
+}
+
+// TLSCertificate returns the certificate of the CA with its key, e.g. to write them with [EncodePEM].
+func (ca *CA) TLSCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{ca.Certificate.Raw},
+		PrivateKey:  ca.PrivateKey,
+		Leaf:        ca.Certificate,
+	}
+}
+
+// LoadCA reads the PEM files of a CA, such as written by 'dev-certs', to issue more certificates.
+func LoadCA(certFile, keyFile string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("'%s' is not a CA certificate", certFile)
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("CA key is not an ECDSA key")
+	}
+	return &CA{Certificate: cert, PrivateKey: key}, nil
+}
+
+// LoadCertPool returns a pool that trusts the PEM certificates in [file].
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in CA file")
+	}
+	return pool, nil
+}
+
+// LoadMutualTLSConfig returns the [MutualTLSConfig] of the PEM certificate and key files.
+func LoadMutualTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load server certificate: %w", err)
+	}
+	return MutualTLSConfig(cert), nil
+}
@@ -0,0 +1,187 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"time"
+)
+
+// CA is a small certificate authority to mint certificates for local development and testing.
+type CA struct {
+	Certificate *x509.Certificate
+	PrivateKey  *ecdsa.PrivateKey
+}
+
+const (
+	caValidity   = time.Duration(10*365*24) * time.Hour
+	leafValidity = time.Duration(365*24) * time.Hour
+)
+
+func NewCA(commonName string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"OhAuth0.1"}},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Certificate: cert, PrivateKey: key}, nil
+}
+
+// CertPool returns a pool that trusts only this CA.
+func (ca *CA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate)
+	return pool
+}
+
+// IssueServerCertificate issues a certificate for [hosts], which are DNS names or IP addresses.
+func (ca *CA) IssueServerCertificate(hosts ...string) (tls.Certificate, error) {
+	template := &x509.Certificate{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if len(hosts) > 0 {
+		template.Subject = pkix.Name{CommonName: hosts[0]}
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	return ca.issue(template)
+}
+
+// IssueClientCertificate issues a certificate to authenticate a client as [commonName].
+func (ca *CA) IssueClientCertificate(commonName string) (tls.Certificate, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName, Organization: []string{"OhAuth0.1"}},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return ca.issue(template)
+}
+
+func (ca *CA) issue(template *x509.Certificate) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template.SerialNumber = serial
+	template.NotBefore = now.Add(-time.Minute)
+	template.NotAfter = now.Add(leafValidity)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, key.Public(), ca.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return newTLSCertificate(der, key)
+}
+
+// NewSelfSignedCertificate issues a client certificate signed by its own key,
+// as used by 'self_signed_tls_client_auth' (RFC 8705 section 2.2).
+func NewSelfSignedCertificate(commonName string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return newTLSCertificate(der, key)
+}
+
+// Thumbprint returns the base64url-encoded SHA-256 hash of the DER-encoded certificate ('x5t#S256').
+func Thumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// PeerCertificate returns the leaf certificate presented by the peer, or nil.
+func PeerCertificate(state *tls.ConnectionState) *x509.Certificate {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// MutualTLSConfig returns a server config that asks clients for a certificate without verifying it,
+// so that handlers can verify either a CA-issued or a self-signed certificate themselves.
+func MutualTLSConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// ClientTLSConfig returns a client config that trusts [rootCAs], or the system CAs if nil.
+func ClientTLSConfig(rootCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		RootCAs:    rootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+func newTLSCertificate(der []byte, key *ecdsa.PrivateKey) (tls.Certificate, error) {
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
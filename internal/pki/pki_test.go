@@ -0,0 +1,104 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCA(t *testing.T) {
+	ca, err := NewCA("test ca")
+	assert.NoError(t, err)
+
+	client, err := ca.IssueClientCertificate("500")
+	assert.NoError(t, err)
+	_, err = client.Leaf.Verify(x509.VerifyOptions{
+		Roots:     ca.CertPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "500", client.Leaf.Subject.CommonName)
+
+	server, err := ca.IssueServerCertificate("localhost", "127.0.0.1")
+	assert.NoError(t, err)
+	_, err = server.Leaf.Verify(x509.VerifyOptions{
+		Roots:   ca.CertPool(),
+		DNSName: "localhost",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, server.Leaf.IPAddresses, 1)
+
+	// self signed certificate is not trusted by the CA.
+	self, err := NewSelfSignedCertificate("500")
+	assert.NoError(t, err)
+	_, err = self.Leaf.Verify(x509.VerifyOptions{
+		Roots:     ca.CertPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.Error(t, err)
+}
+
+func TestThumbprint(t *testing.T) {
+	a, err := NewSelfSignedCertificate("a")
+	assert.NoError(t, err)
+	b, err := NewSelfSignedCertificate("a")
+	assert.NoError(t, err)
+
+	assert.Equal(t, Thumbprint(a.Leaf), Thumbprint(a.Leaf))
+	assert.NotEqual(t, Thumbprint(a.Leaf), Thumbprint(b.Leaf))
+	assert.Len(t, Thumbprint(a.Leaf), 43) // base64url(sha256) without padding
+
+	assert.Nil(t, PeerCertificate(nil))
+	assert.Nil(t, PeerCertificate(&tls.ConnectionState{}))
+	assert.Equal(t, a.Leaf, PeerCertificate(&tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{a.Leaf},
+	}))
+}
+
+func TestPEM(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, cert tls.Certificate) (string, string) {
+		certPEM, keyPEM, err := EncodePEM(cert)
+		assert.NoError(t, err)
+		certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+		assert.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+		assert.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+		return certFile, keyFile
+	}
+	ca, err := NewCA("test ca")
+	assert.NoError(t, err)
+	caFile, caKeyFile := write("ca", ca.TLSCertificate())
+	server, err := ca.IssueServerCertificate("localhost")
+	assert.NoError(t, err)
+	serverFile, serverKeyFile := write("server", server)
+
+	// the loaded CA issues certificates trusted by the pool of the file
+	loaded, err := LoadCA(caFile, caKeyFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	pool, err := LoadCertPool(caFile)
+	assert.NoError(t, err)
+	client, err := loaded.IssueClientCertificate("500")
+	assert.NoError(t, err)
+	_, err = client.Leaf.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.NoError(t, err)
+
+	config, err := LoadMutualTLSConfig(serverFile, serverKeyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, tls.RequestClientCert, config.ClientAuth)
+
+	// a leaf certificate is not a CA
+	_, err = LoadCA(serverFile, serverKeyFile)
+	assert.Error(t, err)
+	_, err = LoadCA(filepath.Join(dir, "missing.pem"), caKeyFile)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,113 @@
+// Package redact masks passwords, secrets, codes and tokens in the structured logs.
+//
+// The types which have them implement [slog.LogValuer] with [String], and [NewHandler] masks
+// the attributes named like them, in case a call site logs a raw value.
+package redact
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// Mask replaces a redacted value.
+const Mask = "[REDACTED]"
+
+// keys of the attributes whose values are masked by [Handler], normalized by [normalize].
+// 'code' is not, since the RPC logs use it for the status code; log authorization codes as 'authorization_code'.
+var sensitiveKeys = map[string]bool{
+	"password":          true,
+	"secret":            true,
+	"clientsecret":      true,
+	"clientassertion":   true,
+	"authorization":     true,
+	"authorizationcode": true,
+	"jwt":               true,
+	"token":             true,
+	"accesstoken":       true,
+	"refreshtoken":      true,
+	"subjecttoken":      true,
+	"actortoken":        true,
+	"logouttoken":       true,
+	"pepper":            true,
+	"key":               true,
+}
+
+// normalize makes 'client_secret', 'Client-Secret' and 'client secret' the same key.
+func normalize(key string) string {
+	return strings.NewReplacer("_", "", "-", "", " ", "").Replace(strings.ToLower(key))
+}
+
+// Sensitive reports whether the attributes named [key] are masked.
+func Sensitive(key string) bool {
+	return sensitiveKeys[normalize(key)]
+}
+
+// String returns an attribute of a secret [value], masked unless empty.
+// An empty value is kept to tell that it was not presented.
+func String(key, value string) slog.Attr {
+	if value == "" {
+		return slog.String(key, "")
+	}
+	return slog.String(key, Mask)
+}
+
+// Handler masks the values of the sensitive attributes before passing them to the next handler.
+// [slog.LogValuer] values are resolved first, so that the groups they return are masked as well.
+type Handler struct {
+	next slog.Handler
+}
+
+// NewHandler wraps [next].
+func NewHandler(next slog.Handler) *Handler {
+	return &Handler{next: next}
+}
+
+// Enabled implements slog.Handler.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	masked := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		masked.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, masked)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		masked[i] = redactAttr(a)
+	}
+	return &Handler{next: h.next.WithAttrs(masked)}
+}
+
+// WithGroup implements slog.Handler.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		group := a.Value.Group()
+		masked := make([]slog.Attr, len(group))
+		for i, member := range group {
+			masked[i] = redactAttr(member)
+		}
+		a.Value = slog.GroupValue(masked...)
+		return a
+	}
+	if Sensitive(a.Key) {
+		if a.Value.Kind() == slog.KindString && a.Value.String() == "" {
+			return a
+		}
+		a.Value = slog.StringValue(Mask)
+	}
+	return a
+}
@@ -0,0 +1,72 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type credentials struct {
+	user, password string
+}
+
+func (c credentials) LogValue() slog.Value {
+	// a careless implementation, which the handler masks by the key
+	return slog.GroupValue(slog.String("user", c.user), slog.String("password", c.password))
+}
+
+func TestSensitive(t *testing.T) {
+	for _, key := range []string{"password", "client_secret", "Client-Secret", "access token", "Authorization", "refresh_token", "jwt"} {
+		assert.Truef(t, Sensitive(key), key)
+	}
+	for _, key := range []string{"code", "client_id", "error", "access_tokens", "request_id"} {
+		assert.Falsef(t, Sensitive(key), key)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil)))
+	secrets := []string{"p@ssw0rd", "client-secret-value", "Bearer access-token-value", "eyJhbGciOiJIUzI1NiJ9.payload.signature"}
+
+	logger.Info("login",
+		slog.String("client_id", "500"),
+		slog.String("password", "p@ssw0rd"),
+		slog.Group("body", slog.String("client_secret", "client-secret-value")),
+		slog.Any("credentials", credentials{user: "1", password: "p@ssw0rd"}),
+		slog.String("refresh_token", ""),
+		slog.String("code", "ok"),
+	)
+	logger.With(slog.String("Authorization", "Bearer access-token-value")).WithGroup("request").Info("forbidden", slog.String("jwt", secrets[3]))
+
+	for _, secret := range secrets {
+		assert.NotContains(t, buf.String(), secret)
+	}
+	var records []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]any
+		assert.NoError(t, dec.Decode(&record))
+		records = append(records, record)
+	}
+	if !assert.Len(t, records, 2) {
+		return
+	}
+	assert.Equal(t, "500", records[0]["client_id"])
+	assert.Equal(t, Mask, records[0]["password"])
+	assert.Equal(t, map[string]any{"client_secret": Mask}, records[0]["body"])
+	assert.Equal(t, map[string]any{"user": "1", "password": Mask}, records[0]["credentials"])
+	// an absent secret is kept empty
+	assert.Equal(t, "", records[0]["refresh_token"])
+	assert.Equal(t, "ok", records[0]["code"])
+	assert.Equal(t, Mask, records[1]["Authorization"])
+	assert.Equal(t, map[string]any{"jwt": Mask}, records[1]["request"])
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, Mask, String("password", "p@ssw0rd").Value.String())
+	assert.Equal(t, "", String("password", "").Value.String())
+}
@@ -0,0 +1,37 @@
+package resource
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	enging "github.com/yyyoichi/OhAuth0.1/internal/engine"
+)
+
+// errorResponses map the errors of the service to the responses. The first match wins.
+var errorResponses = []struct {
+	target error
+	status int
+	code   enging.Code
+}{
+	{ErrInvalidToken, http.StatusForbidden, enging.CodeInvalidToken},
+	{ErrAccessTokenExpired, http.StatusUnauthorized, enging.CodeTokenExpired},
+	{ErrInvalidAudience, http.StatusUnauthorized, enging.CodeInvalidToken},
+	{ErrCertificateMismatch, http.StatusUnauthorized, enging.CodeInvalidToken},
+	{ErrTokenInadequateSocpe, http.StatusForbidden, enging.CodeInsufficientScope},
+	{ErrInsufficientUserAuthentication, http.StatusUnauthorized, enging.CodeInsufficientUserAuthentication},
+}
+
+// ErrorResponse maps [err] of the service to the response, e.g. [ErrAccessTokenExpired] to 401 'token_expired'.
+// The errors of the database are mapped by [enging.FromError], and a temporary failure of it wins.
+func ErrorResponse(err error) *enging.Error {
+	if errors.Is(err, database.ErrUnavailable) {
+		return enging.FromError(err)
+	}
+	for _, r := range errorResponses {
+		if errors.Is(err, r.target) {
+			return enging.New(r.status, r.code, r.target.Error()).Wrap(err)
+		}
+	}
+	return enging.FromError(err)
+}
@@ -8,8 +8,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/authzdetails"
 	"github.com/yyyoichi/OhAuth0.1/internal/database"
 	enging "github.com/yyyoichi/OhAuth0.1/internal/engine"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+	"github.com/yyyoichi/OhAuth0.1/internal/redact"
+	"github.com/yyyoichi/OhAuth0.1/internal/scope"
+	"github.com/yyyoichi/OhAuth0.1/internal/tracing"
 )
 
 const (
@@ -18,32 +23,46 @@ const (
 
 func SetupRouter(service *Service) *gin.Engine {
 	router := gin.Default()
+	if service.tracerProvider != nil {
+		router.Use(tracing.Gin(service.tracerProvider))
+	}
+	router.Use(interceptor.GinRequestID())
+	if service.metrics != nil {
+		router.Use(service.metrics.Handler)
+	}
 	api := router.Group("/api")
 	v1 := api.Group("/v1")
 	v1.Use(func(ctx *gin.Context) {
 		var h HeaderRequest
 		if err := ctx.ShouldBindHeader(&h); err != nil {
-			ctx.SecureJSON(http.StatusForbidden, enging.ForbiddenErrorMessage)
+			enging.RespondProblem(ctx, enging.New(http.StatusForbidden, enging.CodeInvalidToken, ErrInvalidToken.Error()).Wrap(err))
 			return
 		}
 		accesstoken, err := h.FilterToken()
 		if err != nil {
-			slog.InfoContext(ctx, fmt.Sprintf("has not header: %v", err), slog.String("error", err.Error()), slog.String("Authorization", h.Authorization))
-			ctx.SecureJSON(http.StatusForbidden, enging.ForbiddenErrorMessage)
+			slog.InfoContext(ctx.Request.Context(), fmt.Sprintf("has not header: %v", err), slog.String("error", err.Error()), redact.String("Authorization", h.Authorization))
+			enging.RespondProblem(ctx, ErrorResponse(err))
 			return
 		}
-		token, err := service.VerifyAccessToken(ctx, accesstoken)
+		token, err := service.VerifyAccessToken(ctx.Request.Context(), accesstoken)
 		if err != nil {
-			slog.InfoContext(ctx, "cannot varify accesstoken", slog.String("error", err.Error()), slog.String("access token", accesstoken))
+			slog.InfoContext(ctx.Request.Context(), "cannot varify accesstoken", slog.String("error", err.Error()), redact.String("access token", accesstoken))
 			if errors.Is(err, database.ErrNotFound) {
-				ctx.SecureJSON(http.StatusForbidden, enging.ForbiddenErrorMessage)
+				// an unknown token
+				enging.RespondProblem(ctx, enging.New(http.StatusForbidden, enging.CodeInvalidToken, "access token is unknown").Wrap(err))
 				return
 			}
-			if errors.Is(err, ErrAccessTokenExpired) {
-				ctx.SecureJSON(http.StatusUnauthorized, enging.StatusUnauthorizedErrorMessage)
-				return
+			if errors.Is(err, ErrInvalidAudience) {
+				ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
 			}
-			ctx.SecureJSON(http.StatusInternalServerError, enging.InternalServerErrorMessage)
+			enging.RespondProblem(ctx, ErrorResponse(err))
+			return
+		}
+		tracing.SetAttributes(ctx.Request.Context(), tracing.ClientIdKey.String(token.GetServiceClientId()))
+		if err := service.VerifyCertificateBinding(token, ctx.Request.TLS); err != nil {
+			slog.InfoContext(ctx.Request.Context(), "cannot verify certificate binding", slog.String("error", err.Error()))
+			ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			enging.RespondProblem(ctx, ErrorResponse(err))
 			return
 		}
 		ctx.Set(USER_CONTEXT, token)
@@ -66,21 +85,81 @@ func SetupRouter(service *Service) *gin.Engine {
 	v1.GET("/profile", func(ctx *gin.Context) {
 		user, err := getUser(ctx)
 		if err != nil {
-			slog.ErrorContext(ctx, "cannot get user in context", slog.String("error", err.Error()))
-			ctx.SecureJSON(http.StatusInternalServerError, enging.InternalServerErrorMessage)
+			slog.ErrorContext(ctx.Request.Context(), "cannot get user in context", slog.String("error", err.Error()))
+			enging.RespondProblem(ctx, err)
 			return
 		}
 		// check scope.
-		// Maybe this code should be in the 'scope' package, for example.
-		if user.Scope != "profile:view" {
-			slog.InfoContext(ctx, "user doesnot have 'profile:view' scope", slog.String("scope", user.Scope))
-			ctx.SecureJSON(http.StatusBadRequest, enging.BadRequestMessage)
+		if !scope.Has(user.Scope, "profile:view") {
+			slog.InfoContext(ctx.Request.Context(), "user doesnot have 'profile:view' scope", slog.String("scope", user.Scope))
+			enging.RespondProblem(ctx, enging.New(http.StatusBadRequest, enging.CodeInsufficientScope, "'profile:view' scope is required"))
+			return
+		}
+		// fine-grained permissions (RFC 9396) narrow the fields if granted.
+		details := authzdetails.OfType(authzdetails.FromProto(user.GetAuthorizationDetails()), authzdetails.TypeProfile)
+		allows := func(datatype string) bool {
+			return len(details) == 0 || authzdetails.Allows(details, authzdetails.ActionView, datatype, user.UserId)
+		}
+		if !allows("") {
+			slog.InfoContext(ctx.Request.Context(), "authorization details do not allow 'view'")
+			enging.RespondProblem(ctx, enging.New(http.StatusForbidden, enging.CodeForbidden, "authorization details do not allow 'view'"))
+			return
+		}
+		profile, err := service.ViewUserProfile(ctx.Request.Context(), user.UserId)
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), "cannot view user profile", slog.String("error", err.Error()))
+			enging.RespondProblem(ctx, ErrorResponse(err))
+			return
+		}
+		var resp ProfileGetResponse
+		resp.UserId = profile.Id
+		if allows(authzdetails.DatatypeName) {
+			resp.Name = profile.Name
+		}
+		if allows(authzdetails.DatatypeAge) {
+			resp.Age = profile.Age
+		}
+		if allows(authzdetails.DatatypeProfile) {
+			resp.Profile = profile.Profile
+		}
+		ctx.SecureJSON(http.StatusOK, resp)
+	})
+	v1.PUT("/profile", func(ctx *gin.Context) {
+		user, err := getUser(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), "cannot get user in context", slog.String("error", err.Error()))
+			enging.RespondProblem(ctx, err)
+			return
+		}
+		if !scope.Has(user.Scope, "profile:edit") {
+			slog.InfoContext(ctx.Request.Context(), "user doesnot have 'profile:edit' scope", slog.String("scope", user.Scope))
+			ctx.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="profile:edit"`)
+			enging.RespondProblem(ctx, enging.New(http.StatusForbidden, enging.CodeInsufficientScope, "'profile:edit' scope is required"))
+			return
+		}
+		// fine-grained permissions (RFC 9396) must allow editing the field of the user if granted.
+		details := authzdetails.OfType(authzdetails.FromProto(user.GetAuthorizationDetails()), authzdetails.TypeProfile)
+		if len(details) > 0 && !authzdetails.Allows(details, authzdetails.ActionEdit, authzdetails.DatatypeProfile, user.UserId) {
+			slog.InfoContext(ctx.Request.Context(), "authorization details do not allow 'edit'")
+			enging.RespondProblem(ctx, enging.New(http.StatusForbidden, enging.CodeForbidden, "authorization details do not allow 'edit'"))
+			return
+		}
+		// step-up authentication (RFC 9470)
+		if err := service.VerifyAuthentication(user, ProfileEditStepUp); err != nil {
+			slog.InfoContext(ctx.Request.Context(), "needs step-up authentication", slog.String("error", err.Error()))
+			ctx.Header("WWW-Authenticate", ProfileEditStepUp.Challenge())
+			enging.RespondProblem(ctx, ErrorResponse(err))
+			return
+		}
+		var req ProfilePutRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			enging.RespondProblem(ctx, enging.New(http.StatusBadRequest, enging.CodeInvalidRequest, "request is malformed").Wrap(err))
 			return
 		}
-		profile, err := service.ViewUserProfile(ctx, user.UserId)
+		profile, err := service.EditUserProfile(ctx.Request.Context(), user.UserId, req.Profile)
 		if err != nil {
-			slog.ErrorContext(ctx, "cannot view user profile", slog.String("error", err.Error()))
-			ctx.SecureJSON(http.StatusInternalServerError, enging.InternalServerErrorMessage)
+			slog.ErrorContext(ctx.Request.Context(), "cannot edit user profile", slog.String("error", err.Error()))
+			enging.RespondProblem(ctx, ErrorResponse(err))
 			return
 		}
 		var resp ProfileGetResponse
@@ -1,15 +1,25 @@
 package resource
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"log/slog"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/acr"
+	"github.com/yyyoichi/OhAuth0.1/internal/authzdetails"
 	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	enging "github.com/yyyoichi/OhAuth0.1/internal/engine"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
 	server_test "github.com/yyyoichi/OhAuth0.1/internal/test"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -17,7 +27,8 @@ import (
 func TestHandlerOK(t *testing.T) {
 	db, _ := database.NewDatabase()
 	service := &Service{
-		client: db,
+		client:   db,
+		audience: database.RESOURCE_URI,
 	}
 	headerOption := func() server_test.Option {
 		accesstoken := "token"
@@ -25,6 +36,7 @@ func TestHandlerOK(t *testing.T) {
 			Token:           accesstoken,
 			UserId:          "1",
 			ServiceClientId: "501",
+			Audience:        []string{database.RESOURCE_URI},
 			Expires:         timestamppb.New(time.Now().AddDate(0, 0, 1)),
 			Scope:           "profile:view",
 		})
@@ -68,9 +80,14 @@ func TestHandlerOK(t *testing.T) {
 }
 
 func TestHandlerAuthorizationStatus(t *testing.T) {
+	cert, err := pki.NewSelfSignedCertificate("client")
+	assert.NoError(t, err)
+	other, err := pki.NewSelfSignedCertificate("client")
+	assert.NoError(t, err)
 	db, _ := database.NewDatabase()
 	service := &Service{
-		client: db,
+		client:   db,
+		audience: database.RESOURCE_URI,
 	}
 	router := SetupRouter(service)
 	test := map[string]struct {
@@ -85,6 +102,7 @@ func TestHandlerAuthorizationStatus(t *testing.T) {
 						Token:           accesstoken,
 						UserId:          "1",
 						ServiceClientId: "501",
+						Audience:        []string{database.RESOURCE_URI},
 						Expires:         timestamppb.New(time.Now().AddDate(0, 0, 1)),
 						Scope:           "profile:view",
 					})
@@ -105,6 +123,54 @@ func TestHandlerAuthorizationStatus(t *testing.T) {
 			options: []server_test.Option{server_test.WithHeader("Authorization", "Bearer hogehoge")},
 			expCode: http.StatusForbidden,
 		},
+		"certificate bound": {
+			options: func() []server_test.Option {
+				accesstoken := "bound-token"
+				err := db.CreateAccessToken(context.Background(), &apiv1.AccessToken{
+					Token:                 accesstoken,
+					UserId:                "1",
+					ServiceClientId:       "501",
+					Audience:              []string{database.RESOURCE_URI},
+					Expires:               timestamppb.New(time.Now().AddDate(0, 0, 1)),
+					Scope:                 "profile:view",
+					CertificateThumbprint: pki.Thumbprint(cert.Leaf),
+				})
+				assert.NoError(t, err)
+				return []server_test.Option{
+					server_test.WithHeader("Authorization", "Bearer "+accesstoken),
+					server_test.WithTLS(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}}),
+				}
+			}(),
+			expCode: http.StatusNoContent,
+		},
+		"certificate bound other certificate": {
+			options: []server_test.Option{
+				server_test.WithHeader("Authorization", "Bearer bound-token"),
+				server_test.WithTLS(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{other.Leaf}}),
+			},
+			expCode: http.StatusUnauthorized,
+		},
+		"certificate bound no certificate": {
+			options: []server_test.Option{server_test.WithHeader("Authorization", "Bearer bound-token")},
+			expCode: http.StatusUnauthorized,
+		},
+		"other audience": {
+			options: []server_test.Option{
+				func() server_test.Option {
+					accesstoken := "other-audience-token"
+					err := db.CreateAccessToken(context.Background(), &apiv1.AccessToken{
+						Token:           accesstoken,
+						UserId:          "1",
+						ServiceClientId: "501",
+						Audience:        []string{"https://other.example"}, // !
+						Expires:         timestamppb.New(time.Now().AddDate(0, 0, 1)),
+						Scope:           "profile:view",
+					})
+					assert.NoError(t, err)
+					return server_test.WithHeader("Authorization", "Bearer "+accesstoken)
+				}()},
+			expCode: http.StatusUnauthorized,
+		},
 		"expired": {
 			options: []server_test.Option{
 				func() server_test.Option {
@@ -113,6 +179,7 @@ func TestHandlerAuthorizationStatus(t *testing.T) {
 						Token:           accesstoken,
 						UserId:          "1",
 						ServiceClientId: "501",
+						Audience:        []string{database.RESOURCE_URI},
 						Expires:         timestamppb.New(time.Now().AddDate(0, 0, -1)), // !
 						Scope:           "profile:view",
 					})
@@ -138,7 +205,8 @@ func TestHandlerAuthorizationStatus(t *testing.T) {
 func TestProfileHandler(t *testing.T) {
 	db, _ := database.NewDatabase()
 	service := &Service{
-		client: db,
+		client:   db,
+		audience: database.RESOURCE_URI,
 	}
 	router := SetupRouter(service)
 	test := map[string]struct {
@@ -152,6 +220,7 @@ func TestProfileHandler(t *testing.T) {
 					Token:           accesstoken,
 					UserId:          "1",
 					ServiceClientId: "501",
+					Audience:        []string{database.RESOURCE_URI},
 					Expires:         timestamppb.New(time.Now().AddDate(0, 0, 1)),
 					Scope:           "profile:view",
 				})
@@ -168,6 +237,7 @@ func TestProfileHandler(t *testing.T) {
 					Token:           accesstoken,
 					UserId:          "1",
 					ServiceClientId: "501",
+					Audience:        []string{database.RESOURCE_URI},
 					Expires:         timestamppb.New(time.Now().AddDate(0, 0, 1)),
 					Scope:           "unknwon",
 				})
@@ -189,3 +259,269 @@ func TestProfileHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestProfileHandlerAuthorizationDetails(t *testing.T) {
+	db, _ := database.NewDatabase()
+	service := &Service{
+		client:   db,
+		audience: database.RESOURCE_URI,
+	}
+	router := SetupRouter(service)
+	newToken := func(accesstoken string, details []*apiv1.AuthorizationDetail) server_test.Option {
+		err := db.CreateAccessToken(context.Background(), &apiv1.AccessToken{
+			Token:                accesstoken,
+			UserId:               "1",
+			ServiceClientId:      "501",
+			Audience:             []string{database.RESOURCE_URI},
+			Expires:              timestamppb.New(time.Now().AddDate(0, 0, 1)),
+			Scope:                "profile:view",
+			AuthorizationDetails: details,
+		})
+		assert.NoError(t, err)
+		return server_test.WithHeader("Authorization", "Bearer "+accesstoken)
+	}
+	test := map[string]struct {
+		option  server_test.Option
+		expCode int
+		expBody ProfileGetResponse
+	}{
+		"no details": {
+			option:  newToken("token", nil),
+			expCode: http.StatusOK,
+			expBody: ProfileGetResponse{UserId: "1", Name: "Taro", Age: 20, Profile: "Hello🎈"},
+		},
+		"name only": {
+			option: newToken("name-token", []*apiv1.AuthorizationDetail{
+				{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionView}, Datatypes: []string{authzdetails.DatatypeName}},
+			}),
+			expCode: http.StatusOK,
+			expBody: ProfileGetResponse{UserId: "1", Name: "Taro"},
+		},
+		"all datatypes": {
+			option: newToken("all-token", []*apiv1.AuthorizationDetail{
+				{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionView}},
+			}),
+			expCode: http.StatusOK,
+			expBody: ProfileGetResponse{UserId: "1", Name: "Taro", Age: 20, Profile: "Hello🎈"},
+		},
+		"no view action": {
+			option: newToken("edit-token", []*apiv1.AuthorizationDetail{
+				{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionEdit}},
+			}),
+			expCode: http.StatusForbidden,
+		},
+		"identifier of the user": {
+			option: newToken("identifier-token", []*apiv1.AuthorizationDetail{
+				{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionView}, Datatypes: []string{authzdetails.DatatypeAge}, Identifier: "1"},
+			}),
+			expCode: http.StatusOK,
+			expBody: ProfileGetResponse{UserId: "1", Age: 20},
+		},
+		"identifier of another user": {
+			option: newToken("other-identifier-token", []*apiv1.AuthorizationDetail{
+				{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionView}, Identifier: "2"},
+			}),
+			expCode: http.StatusForbidden,
+		},
+	}
+	for scenario, tt := range test {
+		t.Run(scenario, func(t *testing.T) {
+			config := server_test.Config{
+				Router: router,
+				Method: http.MethodGet,
+				Path:   "/api/v1/profile",
+			}
+			_, resp := server_test.Serve(t, config, tt.option)
+			assert.Equalf(t, tt.expCode, resp.Code, resp.Body.String())
+			if tt.expCode != http.StatusOK {
+				return
+			}
+			var body ProfileGetResponse
+			assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+			assert.Equal(t, tt.expBody, body)
+		})
+	}
+}
+
+func TestProfilePutHandler(t *testing.T) {
+	db, _ := database.NewDatabase()
+	service := &Service{
+		client:   db,
+		audience: database.RESOURCE_URI,
+	}
+	router := SetupRouter(service)
+	newToken := func(accesstoken, scope string, authTime time.Time, details ...*apiv1.AuthorizationDetail) server_test.Option {
+		err := db.CreateAccessToken(context.Background(), &apiv1.AccessToken{
+			Token:           accesstoken,
+			UserId:          "1",
+			ServiceClientId: "501",
+			Audience:        []string{database.RESOURCE_URI},
+			Expires:         timestamppb.New(time.Now().AddDate(0, 0, 1)),
+			Scope:           scope,
+			AuthTime:        timestamppb.New(authTime),
+			Acr:             acr.Password,
+
+			AuthorizationDetails: details,
+		})
+		assert.NoError(t, err)
+		return server_test.WithHeader("Authorization", "Bearer "+accesstoken)
+	}
+	test := map[string]struct {
+		option       server_test.Option
+		expCode      int
+		expChallenge string
+		expErrorCode enging.Code
+	}{
+		"ok": {
+			option:  newToken("token", "profile:view profile:edit", time.Now()),
+			expCode: http.StatusOK,
+		},
+		"edit granted": {
+			option: newToken("edit-token", "profile:view profile:edit", time.Now(),
+				&apiv1.AuthorizationDetail{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionEdit}, Datatypes: []string{authzdetails.DatatypeProfile}, Identifier: "1"},
+			),
+			expCode: http.StatusOK,
+		},
+		"edit not granted": {
+			option: newToken("view-details-token", "profile:view profile:edit", time.Now(),
+				&apiv1.AuthorizationDetail{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionView}},
+			),
+			expCode:      http.StatusForbidden,
+			expErrorCode: enging.CodeForbidden,
+		},
+		"edit another user": {
+			option: newToken("other-edit-token", "profile:view profile:edit", time.Now(),
+				&apiv1.AuthorizationDetail{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionEdit}, Identifier: "2"},
+			),
+			expCode:      http.StatusForbidden,
+			expErrorCode: enging.CodeForbidden,
+		},
+		"edit other fields": {
+			option: newToken("name-edit-token", "profile:view profile:edit", time.Now(),
+				&apiv1.AuthorizationDetail{Type: authzdetails.TypeProfile, Actions: []string{authzdetails.ActionEdit}, Datatypes: []string{authzdetails.DatatypeName}},
+			),
+			expCode:      http.StatusForbidden,
+			expErrorCode: enging.CodeForbidden,
+		},
+		"view only": {
+			option:       newToken("view-token", "profile:view", time.Now()),
+			expCode:      http.StatusForbidden,
+			expChallenge: `Bearer error="insufficient_scope", scope="profile:edit"`,
+			expErrorCode: enging.CodeInsufficientScope,
+		},
+		"old login": {
+			option:       newToken("old-token", "profile:view profile:edit", time.Now().Add(-time.Hour)),
+			expCode:      http.StatusUnauthorized,
+			expChallenge: ProfileEditStepUp.Challenge(),
+			expErrorCode: enging.CodeInsufficientUserAuthentication,
+		},
+		"unknown token": {
+			option:       server_test.WithHeader("Authorization", "Bearer unknown"),
+			expCode:      http.StatusForbidden,
+			expErrorCode: enging.CodeInvalidToken,
+		},
+	}
+	for scenario, tt := range test {
+		t.Run(scenario, func(t *testing.T) {
+			config := server_test.Config{
+				Router: router,
+				Method: http.MethodPut,
+				Path:   "/api/v1/profile",
+			}
+			body := server_test.WithBody(strings.NewReader(`{"profile":"Bye👋"}`))
+			_, resp := server_test.Serve(t, config, tt.option, body)
+			assert.Equalf(t, tt.expCode, resp.Code, resp.Body.String())
+			assert.Equal(t, tt.expChallenge, resp.Header().Get("WWW-Authenticate"))
+			if tt.expCode != http.StatusOK {
+				assert.Equal(t, enging.ProblemContentType, resp.Header().Get("Content-Type"))
+				var problem enging.Problem
+				assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &problem))
+				assert.Equal(t, tt.expErrorCode, problem.Code)
+				assert.Equal(t, tt.expCode, problem.Status)
+				return
+			}
+			var got ProfileGetResponse
+			assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+			assert.Equal(t, "Bye👋", got.Profile)
+		})
+	}
+}
+
+// unavailableClient fails as the database client does while the database server is down.
+type unavailableClient struct {
+	*database.Database
+}
+
+func (c *unavailableClient) GetAccessTokenByToken(context.Context, string) (*apiv1.AccessToken, error) {
+	return nil, database.ErrCircuitOpen
+}
+
+func TestHandlerUnavailable(t *testing.T) {
+	db, _ := database.NewDatabase()
+	service := &Service{
+		client:   &unavailableClient{Database: db},
+		audience: database.RESOURCE_URI,
+	}
+	_, resp := server_test.Serve(t, server_test.Config{
+		Router: SetupRouter(service),
+		Method: http.MethodGet,
+		Path:   "/api/v1/profile",
+	}, server_test.WithHeader("Authorization", "Bearer token"))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	db, _ := database.NewDatabase()
+	metrics := NewMetrics(nil)
+	service := &Service{
+		client:   db,
+		audience: database.RESOURCE_URI,
+		metrics:  metrics,
+	}
+	assert.NoError(t, db.CreateAccessToken(context.Background(), &apiv1.AccessToken{
+		Token:           "token",
+		UserId:          "1",
+		ServiceClientId: "501",
+		Audience:        []string{database.RESOURCE_URI},
+		Expires:         timestamppb.New(time.Now().AddDate(0, 0, 1)),
+		Scope:           "profile:view",
+	}))
+	router := SetupRouter(service)
+	for _, token := range []string{"token", "token", "unknown"} {
+		server_test.Serve(t, server_test.Config{
+			Router: router,
+			Method: http.MethodGet,
+			Path:   "/api/v1/profile",
+		}, server_test.WithHeader("Authorization", "Bearer "+token))
+	}
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.requests.WithLabelValues(http.MethodGet, "/api/v1/profile", "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues(http.MethodGet, "/api/v1/profile", "403")))
+}
+
+func TestHandlerLogs(t *testing.T) {
+	// without the redacting handler, the call sites must not log tokens by themselves
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	db, _ := database.NewDatabase()
+	service := &Service{
+		client:   db,
+		audience: database.RESOURCE_URI,
+	}
+	router := SetupRouter(service)
+	const token = "unknown-access-token-7c1e"
+	for _, authorization := range []string{"Bearer " + token, "Basic " + token} {
+		_, resp := server_test.Serve(t, server_test.Config{
+			Router: router,
+			Method: http.MethodGet,
+			Path:   "/api/v1/profile",
+		}, server_test.WithHeader("Authorization", authorization))
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	}
+	assert.Contains(t, buf.String(), "cannot varify accesstoken")
+	assert.Contains(t, buf.String(), "has not header")
+	assert.NotContains(t, buf.String(), token)
+}
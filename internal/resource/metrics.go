@@ -0,0 +1,34 @@
+package resource
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics counts the requests to the resources by the status.
+type Metrics struct {
+	requests *prometheus.CounterVec
+}
+
+// NewMetrics returns the metrics registered to [registerer]. A nil [registerer] keeps them unregistered.
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "resource_requests_total",
+			Help: "Requests to the resource server by the method, the route and the status.",
+		}, []string{"method", "route", "status"}),
+	}
+	if registerer != nil {
+		registerer.MustRegister(m.requests)
+	}
+	return m
+}
+
+// Handler counts the requests after they are answered. unknown paths are counted in the route ”.
+func (m *Metrics) Handler(ctx *gin.Context) {
+	ctx.Next()
+	// the route, not the path, keeps the number of the labels bounded
+	m.requests.WithLabelValues(ctx.Request.Method, ctx.FullPath(), strconv.Itoa(ctx.Writer.Status())).Inc()
+}
@@ -2,54 +2,181 @@ package resource
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/acr"
 	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
 	Service struct {
 		client clientInterface
+		// resource indicator (RFC 8707) of this resource server. Access tokens must be addressed to it.
+		audience       string
+		metrics        *Metrics
+		tracerProvider trace.TracerProvider
 	}
 	clientInterface interface {
 		GetUserById(ctx context.Context, id string) (*apiv1.UserProfile, error)
+		UpdateUserProfile(ctx context.Context, id, profile string) (*apiv1.UserProfile, error)
 		GetAccessTokenByToken(ctx context.Context, token string) (*apiv1.AccessToken, error)
+		Ping(ctx context.Context) error
 	}
 	Config struct {
 		DatabaseServerURL string
+		// in-memory database called in the process instead of the database server. Optional.
+		Database *database.Database
+		// TLS config and key to authenticate to the database server. Optional.
+		DatabaseTLS *tls.Config
+		DatabaseKey string
+		// connects to the database server instead of the network, e.g. the one in the process. Optional.
+		DatabaseDial func(ctx context.Context, network, addr string) (net.Conn, error)
+		// deadline, retries and circuit breaker of the calls to the database server. Optional.
+		DatabaseTimeout time.Duration
+		DatabaseRetry   database.RetryConfig
+		DatabaseBreaker database.BreakerConfig
+		// registers the metrics of the requests and the calls to the database server. Optional.
+		Registerer prometheus.Registerer
+		// traces the requests and the calls to the database server. Optional.
+		TracerProvider trace.TracerProvider
+		// resource indicator of this server, e.g. 'http://localhost:8088'
+		ResourceURI string
+		TokenCache  TokenCacheConfig
 	}
 )
 
 var (
 	ErrTokenInadequateSocpe = errors.New("access token has inadequate scope")
 	ErrAccessTokenExpired   = errors.New("access token is expired")
+	ErrCertificateMismatch  = errors.New("access token is bound to another certificate")
+	ErrInvalidAudience      = errors.New("access token is not addressed to this resource server")
+
+	ErrInsufficientUserAuthentication = errors.New("insufficient user authentication")
 )
 
+// StepUp is the user authentication a resource requires (RFC 9470).
+type StepUp struct {
+	// the user must have logged in this recently. zero means no limit.
+	MaxAge time.Duration
+	// accepted authentication context classes. empty means any.
+	AcrValues []string
+}
+
+// editing a profile needs a fresh login.
+var ProfileEditStepUp = StepUp{
+	MaxAge:    time.Duration(5) * time.Minute,
+	AcrValues: []string{acr.Password},
+}
+
+// Challenge returns the 'WWW-Authenticate' header value asking the client for a stronger authentication.
+func (p StepUp) Challenge() string {
+	challenge := `Bearer error="insufficient_user_authentication", error_description="A different authentication level is required"`
+	if len(p.AcrValues) > 0 {
+		challenge += fmt.Sprintf(`, acr_values="%s"`, strings.Join(p.AcrValues, " "))
+	}
+	if p.MaxAge > 0 {
+		challenge += fmt.Sprintf(`, max_age="%d"`, int64(p.MaxAge.Seconds()))
+	}
+	return challenge
+}
+
 func NewService(ctx context.Context, config Config) (*Service, error) {
+	service := &Service{
+		audience:       config.ResourceURI,
+		tracerProvider: config.TracerProvider,
+	}
+	if config.Registerer != nil {
+		service.metrics = NewMetrics(config.Registerer)
+	}
+	if config.Database != nil {
+		// reading the rows in the process is as cheap as the cache
+		service.client = config.Database
+		return service, nil
+	}
+	var dbMetrics *interceptor.Metrics
+	if config.Registerer != nil {
+		dbMetrics = interceptor.NewMetrics(config.Registerer)
+	}
 	client, err := database.NewDatabaseClient(ctx, database.ClientConfig{
-		URL: config.DatabaseServerURL,
+		URL:     config.DatabaseServerURL,
+		TLS:     config.DatabaseTLS,
+		Key:     config.DatabaseKey,
+		Timeout: config.DatabaseTimeout,
+		Retry:   config.DatabaseRetry,
+		Breaker: config.DatabaseBreaker,
+		Metrics: dbMetrics,
+		Dial:    config.DatabaseDial,
+
+		TracerProvider: config.TracerProvider,
 	})
 	if err != nil {
 		return nil, err
 	}
-	return &Service{
-		client: client,
-	}, nil
+	service.client = client
+	if config.TokenCache.Size >= 0 {
+		tokens := NewTokenCache(config.TokenCache, client.GetAccessTokenByToken)
+		go tokens.Watch(ctx, client)
+		service.client = &cachedClient{clientInterface: client, tokens: tokens}
+	}
+	return service, nil
+}
+
+// Ping reports whether the database server is reachable.
+func (s *Service) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx)
 }
+
 func (s *Service) VerifyAccessToken(ctx context.Context, accesstoken string) (*apiv1.AccessToken, error) {
 	token, err := s.client.GetAccessTokenByToken(ctx, accesstoken)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get access token: %w", err)
 	}
-	if time.Now().After(token.Expires.AsTime()) {
+	// a revoked token is rejected as well as an expired one, so that the client refreshes it
+	if time.Now().After(token.Expires.AsTime()) || token.GetRevokedAt() != nil {
 		return nil, ErrAccessTokenExpired
 	}
+	if !slices.Contains(token.GetAudience(), s.audience) {
+		return nil, fmt.Errorf("%w: audience %v", ErrInvalidAudience, token.GetAudience())
+	}
 	return token, nil
 }
 
+// 証明書に紐づいたトークン(RFC 8705)は、同じ証明書を提示したTLS接続[state]でのみ利用できる
+func (s *Service) VerifyCertificateBinding(token *apiv1.AccessToken, state *tls.ConnectionState) error {
+	if token.GetCertificateThumbprint() == "" {
+		return nil
+	}
+	cert := pki.PeerCertificate(state)
+	if cert == nil || pki.Thumbprint(cert) != token.GetCertificateThumbprint() {
+		return ErrCertificateMismatch
+	}
+	return nil
+}
+
+// 認証時刻と認証コンテキスト(acr)が[policy]を満たすか検証する
+func (s *Service) VerifyAuthentication(token *apiv1.AccessToken, policy StepUp) error {
+	if policy.MaxAge > 0 {
+		if token.GetAuthTime() == nil || time.Since(token.GetAuthTime().AsTime()) > policy.MaxAge {
+			return fmt.Errorf("%w: login is too old", ErrInsufficientUserAuthentication)
+		}
+	}
+	if len(policy.AcrValues) > 0 && !slices.Contains(policy.AcrValues, token.GetAcr()) {
+		return fmt.Errorf("%w: acr '%s'", ErrInsufficientUserAuthentication, token.GetAcr())
+	}
+	return nil
+}
+
 // Can be used if the scope has a profile:view
 func (s *Service) ViewUserProfile(ctx context.Context, userId string) (*apiv1.UserProfile, error) {
 	user, err := s.client.GetUserById(ctx, userId)
@@ -58,3 +185,12 @@ func (s *Service) ViewUserProfile(ctx context.Context, userId string) (*apiv1.Us
 	}
 	return user, nil
 }
+
+// Can be used if the scope has a profile:edit
+func (s *Service) EditUserProfile(ctx context.Context, userId, profile string) (*apiv1.UserProfile, error) {
+	user, err := s.client.UpdateUserProfile(ctx, userId, profile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot update user: %w", err)
+	}
+	return user, nil
+}
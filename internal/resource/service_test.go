@@ -2,12 +2,16 @@ package resource
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/acr"
 	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -16,22 +20,28 @@ func TestService(t *testing.T) {
 		test := []struct {
 			token    string
 			expires  time.Time
+			audience []string
 			argToken string
 			expErr   error
 		}{
-			{"token", time.Now().AddDate(1, 0, 0), "token", nil},
-			{"token", time.Now().AddDate(1, 0, 0), "not found token", database.ErrNotFound},
-			{"token", time.Now().AddDate(-1, 0, 0), "token", ErrAccessTokenExpired},
+			{"token", time.Now().AddDate(1, 0, 0), []string{database.RESOURCE_URI}, "token", nil},
+			{"token", time.Now().AddDate(1, 0, 0), []string{"https://other.example", database.RESOURCE_URI}, "token", nil},
+			{"token", time.Now().AddDate(1, 0, 0), []string{database.RESOURCE_URI}, "not found token", database.ErrNotFound},
+			{"token", time.Now().AddDate(-1, 0, 0), []string{database.RESOURCE_URI}, "token", ErrAccessTokenExpired},
+			{"token", time.Now().AddDate(1, 0, 0), []string{"https://other.example"}, "token", ErrInvalidAudience},
+			{"token", time.Now().AddDate(1, 0, 0), nil, "token", ErrInvalidAudience},
 		}
 		ctx := context.Background()
 		for _, tt := range test {
 			db, _ := database.NewDatabase()
 			tservice := &Service{
-				client: db,
+				client:   db,
+				audience: database.RESOURCE_URI,
 			}
 			err := db.CreateAccessToken(ctx, &apiv1.AccessToken{
 				UserId:          "1",
 				ServiceClientId: "501",
+				Audience:        tt.audience,
 				Scope:           "profile:view",
 				Token:           tt.token,
 				Expires:         timestamppb.New(tt.expires),
@@ -45,6 +55,19 @@ func TestService(t *testing.T) {
 				assert.ErrorIs(t, err, tt.expErr)
 			}
 		}
+
+		db, _ := database.NewDatabase()
+		tservice := &Service{client: db, audience: database.RESOURCE_URI}
+		err := db.CreateAccessToken(ctx, &apiv1.AccessToken{
+			Token:    "revoked",
+			Audience: []string{database.RESOURCE_URI},
+			Expires:  timestamppb.New(time.Now().AddDate(1, 0, 0)),
+		})
+		assert.NoError(t, err)
+		_, err = db.RevokeAccessToken(ctx, "revoked")
+		assert.NoError(t, err)
+		_, err = tservice.VerifyAccessToken(ctx, "revoked")
+		assert.ErrorIs(t, err, ErrAccessTokenExpired)
 	})
 	t.Run("ViewUserProfile", func(t *testing.T) {
 		test := []struct {
@@ -58,7 +81,8 @@ func TestService(t *testing.T) {
 		for _, tt := range test {
 			db, _ := database.NewDatabase()
 			tservice := &Service{
-				client: db,
+				client:   db,
+				audience: database.RESOURCE_URI,
 			}
 			user, err := tservice.ViewUserProfile(ctx, tt.userId)
 			if tt.expErr == nil {
@@ -69,4 +93,71 @@ func TestService(t *testing.T) {
 			}
 		}
 	})
+	t.Run("VerifyAuthentication", func(t *testing.T) {
+		fresh := timestamppb.New(time.Now().Add(-time.Minute))
+		stale := timestamppb.New(time.Now().Add(-time.Hour))
+		test := map[string]struct {
+			token  *apiv1.AccessToken
+			policy StepUp
+			expErr error
+		}{
+			"no policy":    {&apiv1.AccessToken{}, StepUp{}, nil},
+			"fresh":        {&apiv1.AccessToken{AuthTime: fresh, Acr: acr.Password}, ProfileEditStepUp, nil},
+			"stale":        {&apiv1.AccessToken{AuthTime: stale, Acr: acr.Password}, ProfileEditStepUp, ErrInsufficientUserAuthentication},
+			"no time":      {&apiv1.AccessToken{Acr: acr.Password}, ProfileEditStepUp, ErrInsufficientUserAuthentication},
+			"other acr":    {&apiv1.AccessToken{AuthTime: fresh, Acr: "urn:other"}, ProfileEditStepUp, ErrInsufficientUserAuthentication},
+			"max_age only": {&apiv1.AccessToken{AuthTime: fresh}, StepUp{MaxAge: time.Hour}, nil},
+		}
+		tservice := &Service{}
+		for scenario, tt := range test {
+			t.Run(scenario, func(t *testing.T) {
+				err := tservice.VerifyAuthentication(tt.token, tt.policy)
+				if tt.expErr == nil {
+					assert.NoError(t, err)
+				} else {
+					assert.ErrorIs(t, err, tt.expErr)
+				}
+			})
+		}
+		assert.Equal(t,
+			`Bearer error="insufficient_user_authentication", error_description="A different authentication level is required", acr_values="urn:ohauth0.1:acr:password", max_age="300"`,
+			ProfileEditStepUp.Challenge())
+	})
+	t.Run("EditUserProfile", func(t *testing.T) {
+		db, _ := database.NewDatabase()
+		tservice := &Service{client: db}
+		user, err := tservice.EditUserProfile(context.Background(), "1", "Bye")
+		assert.NoError(t, err)
+		assert.Equal(t, "Bye", user.Profile)
+		_, err = tservice.EditUserProfile(context.Background(), "99", "Bye")
+		assert.ErrorIs(t, err, database.ErrNotFound)
+	})
+	t.Run("VerifyCertificateBinding", func(t *testing.T) {
+		cert, err := pki.NewSelfSignedCertificate("client")
+		assert.NoError(t, err)
+		other, err := pki.NewSelfSignedCertificate("client")
+		assert.NoError(t, err)
+		bound := &apiv1.AccessToken{CertificateThumbprint: pki.Thumbprint(cert.Leaf)}
+		test := []struct {
+			token  *apiv1.AccessToken
+			state  *tls.ConnectionState
+			expErr error
+		}{
+			{&apiv1.AccessToken{}, nil, nil},
+			{&apiv1.AccessToken{}, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}}, nil},
+			{bound, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}}, nil},
+			{bound, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{other.Leaf}}, ErrCertificateMismatch},
+			{bound, &tls.ConnectionState{}, ErrCertificateMismatch},
+			{bound, nil, ErrCertificateMismatch},
+		}
+		tservice := &Service{}
+		for _, tt := range test {
+			err := tservice.VerifyCertificateBinding(tt.token, tt.state)
+			if tt.expErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expErr)
+			}
+		}
+	})
 }
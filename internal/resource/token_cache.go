@@ -0,0 +1,213 @@
+package resource
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultTokenCacheSize        = 1024
+	defaultTokenCacheTTL         = time.Duration(30) * time.Second
+	defaultTokenCacheNegativeTTL = time.Duration(5) * time.Second
+)
+
+type (
+	TokenCacheConfig struct {
+		// maximum number of tokens. default 1024. negative disables the cache.
+		Size int
+		// a token is looked up again after this, so that a revocation is noticed within it
+		// even if the watch misses it. default 30 seconds.
+		TTL time.Duration
+		// unknown tokens are remembered this long. default 5 seconds.
+		NegativeTTL time.Duration
+	}
+	// TokenCache keeps the access tokens looked up from the database.
+	// A valid token is kept no longer than its own expiry.
+	TokenCache struct {
+		config TokenCacheConfig
+		fetch  func(ctx context.Context, token string) (*apiv1.AccessToken, error)
+		group  singleflight.Group
+
+		mu      sync.Mutex
+		entries map[string]*list.Element
+		// least recently used at the back
+		lru *list.List
+	}
+	tokenCacheEntry struct {
+		token string
+		// nil if the token is unknown
+		row     *apiv1.AccessToken
+		expires time.Time
+	}
+	// watcher streams the changes of the database. implemented by [database.Client].
+	watcher interface {
+		Watch(ctx context.Context, cursor string, fn func(cursor string, event *apiv1.WatchEvent) error) error
+	}
+)
+
+// NewTokenCache returns a cache of the tokens looked up by [fetch].
+func NewTokenCache(config TokenCacheConfig, fetch func(ctx context.Context, token string) (*apiv1.AccessToken, error)) *TokenCache {
+	if config.Size == 0 {
+		config.Size = defaultTokenCacheSize
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaultTokenCacheTTL
+	}
+	if config.NegativeTTL <= 0 {
+		config.NegativeTTL = defaultTokenCacheNegativeTTL
+	}
+	return &TokenCache{
+		config:  config,
+		fetch:   fetch,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Get returns the row of [token]. Concurrent lookups of the same token share one request to the database.
+// The row must not be modified.
+func (c *TokenCache) Get(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+	if entry, found := c.lookup(token); found {
+		if entry.row == nil {
+			return nil, fmt.Errorf("%w: cached", database.ErrNotFound)
+		}
+		return entry.row, nil
+	}
+	v, err, _ := c.group.Do(token, func() (any, error) {
+		row, err := c.fetch(ctx, token)
+		if errors.Is(err, database.ErrNotFound) {
+			c.add(token, nil)
+		} else if err == nil {
+			c.add(token, row)
+		}
+		return row, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*apiv1.AccessToken), nil
+}
+
+func (c *TokenCache) lookup(token string) (*tokenCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, found := c.entries[token]
+	if !found {
+		return nil, false
+	}
+	entry := elem.Value.(*tokenCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+func (c *TokenCache) add(token string, row *apiv1.AccessToken) {
+	now := time.Now()
+	entry := &tokenCacheEntry{token: token, row: row}
+	if row == nil {
+		entry.expires = now.Add(c.config.NegativeTTL)
+	} else {
+		entry.expires = now.Add(c.config.TTL)
+		// an expired token stays rejected, so only a valid one is bounded by its expiry
+		if expires := row.GetExpires().AsTime(); expires.After(now) && expires.Before(entry.expires) {
+			entry.expires = expires
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, found := c.entries[token]; found {
+		c.remove(elem)
+	}
+	c.entries[token] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.config.Size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove must be called with c.mu held.
+func (c *TokenCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*tokenCacheEntry).token)
+}
+
+func (c *TokenCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Invalidate drops [token], so that the next lookup asks the database.
+func (c *TokenCache) Invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, found := c.entries[token]; found {
+		c.remove(elem)
+	}
+}
+
+// InvalidateRow drops the tokens which may be [row]. The database reports rows keyed by the hashes,
+// so they are matched by the display prefix and the grant rather than the token itself.
+func (c *TokenCache) InvalidateRow(row *apiv1.AccessToken) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		cached := elem.Value.(*tokenCacheEntry).row
+		if cached != nil &&
+			cached.GetTokenPrefix() == row.GetTokenPrefix() &&
+			cached.GetUserId() == row.GetUserId() &&
+			cached.GetServiceClientId() == row.GetServiceClientId() &&
+			cached.GetExpires().AsTime().Equal(row.GetExpires().AsTime()) {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+// Purge drops all tokens.
+func (c *TokenCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// Watch invalidates the tokens revoked in the database until [ctx] is done.
+// All tokens are dropped when the watch loses events.
+func (c *TokenCache) Watch(ctx context.Context, w watcher) {
+	for {
+		err := w.Watch(ctx, "", func(_ string, event *apiv1.WatchEvent) error {
+			if row := event.GetRow().GetAccessToken(); row != nil && event.GetType() == apiv1.EventType_EVENT_TYPE_REVOKED {
+				c.InvalidateRow(row)
+			}
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		slog.WarnContext(ctx, "token cache lost revocations", slog.Any("error", err))
+		c.Purge()
+	}
+}
+
+// cachedClient looks up the access tokens through the cache.
+type cachedClient struct {
+	clientInterface
+	tokens *TokenCache
+}
+
+func (c *cachedClient) GetAccessTokenByToken(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+	return c.tokens.Get(ctx, token)
+}
@@ -0,0 +1,227 @@
+package resource
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// countingFetch looks up the tokens of [db] and counts the lookups.
+func countingFetch(db *database.Database, count *atomic.Int32) func(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+	return func(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+		count.Add(1)
+		return db.GetAccessTokenByToken(ctx, token)
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	ctx := context.Background()
+	db, _ := database.NewDatabase()
+	expires := timestamppb.New(time.Now().Add(time.Hour))
+	assert.NoError(t, db.CreateAccessToken(ctx, &apiv1.AccessToken{Token: "token", Expires: expires}))
+
+	t.Run("hit", func(t *testing.T) {
+		var count atomic.Int32
+		cache := NewTokenCache(TokenCacheConfig{}, countingFetch(db, &count))
+		for range 3 {
+			row, err := cache.Get(ctx, "token")
+			assert.NoError(t, err)
+			assert.Equal(t, "token", row.Token)
+		}
+		assert.EqualValues(t, 1, count.Load())
+	})
+	t.Run("negative", func(t *testing.T) {
+		var count atomic.Int32
+		cache := NewTokenCache(TokenCacheConfig{NegativeTTL: time.Duration(20) * time.Millisecond}, countingFetch(db, &count))
+		for range 3 {
+			_, err := cache.Get(ctx, "notfound")
+			assert.ErrorIs(t, err, database.ErrNotFound)
+		}
+		assert.EqualValues(t, 1, count.Load())
+		time.Sleep(time.Duration(30) * time.Millisecond)
+		_, err := cache.Get(ctx, "notfound")
+		assert.ErrorIs(t, err, database.ErrNotFound)
+		assert.EqualValues(t, 2, count.Load())
+	})
+	t.Run("errors are not cached", func(t *testing.T) {
+		var count atomic.Int32
+		cache := NewTokenCache(TokenCacheConfig{}, func(context.Context, string) (*apiv1.AccessToken, error) {
+			count.Add(1)
+			return nil, context.DeadlineExceeded
+		})
+		for range 2 {
+			_, err := cache.Get(ctx, "token")
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		}
+		assert.EqualValues(t, 2, count.Load())
+	})
+	t.Run("bounded by expiry", func(t *testing.T) {
+		assert.NoError(t, db.CreateAccessToken(ctx, &apiv1.AccessToken{
+			Token:   "short",
+			Expires: timestamppb.New(time.Now().Add(time.Duration(20) * time.Millisecond)),
+		}))
+		var count atomic.Int32
+		cache := NewTokenCache(TokenCacheConfig{}, countingFetch(db, &count))
+		_, err := cache.Get(ctx, "short")
+		assert.NoError(t, err)
+		time.Sleep(time.Duration(30) * time.Millisecond)
+		_, err = cache.Get(ctx, "short")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 2, count.Load())
+	})
+	t.Run("bounded by size", func(t *testing.T) {
+		var count atomic.Int32
+		cache := NewTokenCache(TokenCacheConfig{Size: 2}, countingFetch(db, &count))
+		for _, token := range []string{"token", "a", "token", "b"} {
+			_, _ = cache.Get(ctx, token)
+		}
+		assert.Equal(t, 2, cache.Len())
+		// 'a' is the least recently used
+		_, _ = cache.Get(ctx, "token")
+		assert.EqualValues(t, 3, count.Load())
+		_, _ = cache.Get(ctx, "a")
+		assert.EqualValues(t, 4, count.Load())
+	})
+	t.Run("singleflight", func(t *testing.T) {
+		var count atomic.Int32
+		release := make(chan struct{})
+		cache := NewTokenCache(TokenCacheConfig{}, func(ctx context.Context, token string) (*apiv1.AccessToken, error) {
+			count.Add(1)
+			<-release
+			return db.GetAccessTokenByToken(ctx, token)
+		})
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				row, err := cache.Get(ctx, "token")
+				assert.NoError(t, err)
+				assert.Equal(t, "token", row.GetToken())
+			}()
+		}
+		time.Sleep(time.Duration(20) * time.Millisecond)
+		close(release)
+		wg.Wait()
+		assert.EqualValues(t, 1, count.Load())
+	})
+	t.Run("invalidate", func(t *testing.T) {
+		var count atomic.Int32
+		cache := NewTokenCache(TokenCacheConfig{}, countingFetch(db, &count))
+		_, _ = cache.Get(ctx, "token")
+		cache.Invalidate("token")
+		_, _ = cache.Get(ctx, "token")
+		assert.EqualValues(t, 2, count.Load())
+		cache.Purge()
+		assert.Equal(t, 0, cache.Len())
+	})
+}
+
+func TestTokenCacheInvalidateRow(t *testing.T) {
+	ctx := context.Background()
+	expires := timestamppb.New(time.Now().Add(time.Hour))
+	rows := map[string]*apiv1.AccessToken{
+		"revoked-token-value1": {Token: "revoked-token-value1", TokenPrefix: "revoke", UserId: "1", ServiceClientId: "500", Expires: expires},
+		"revoked-token-value2": {Token: "revoked-token-value2", TokenPrefix: "revoke", UserId: "2", ServiceClientId: "500", Expires: expires},
+	}
+	cache := NewTokenCache(TokenCacheConfig{}, func(_ context.Context, token string) (*apiv1.AccessToken, error) {
+		return rows[token], nil
+	})
+	for token := range rows {
+		_, _ = cache.Get(ctx, token)
+	}
+	// reported by the database keyed by the hash
+	cache.InvalidateRow(&apiv1.AccessToken{Token: "hash", TokenPrefix: "revoke", UserId: "1", ServiceClientId: "500", Expires: expires})
+	assert.Equal(t, 1, cache.Len())
+	_, found := cache.lookup("revoked-token-value2")
+	assert.True(t, found)
+}
+
+type watcherMock struct {
+	events []*apiv1.WatchEvent
+	// the first watch fails after the events
+	lost  bool
+	calls atomic.Int32
+}
+
+func (w *watcherMock) Watch(ctx context.Context, _ string, fn func(string, *apiv1.WatchEvent) error) error {
+	if w.calls.Add(1) == 1 {
+		for _, e := range w.events {
+			if err := fn("", e); err != nil {
+				return err
+			}
+		}
+		if w.lost {
+			return database.ErrCursorExpired
+		}
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestTokenCacheWatch(t *testing.T) {
+	expires := timestamppb.New(time.Now().Add(time.Hour))
+	event := func(typ apiv1.EventType) *apiv1.WatchEvent {
+		return &apiv1.WatchEvent{
+			Type: typ,
+			Row:  &apiv1.StorageRecord{Row: &apiv1.StorageRecord_AccessToken{AccessToken: &apiv1.AccessToken{Token: "hash", UserId: "1", Expires: expires}}},
+		}
+	}
+	test := map[string]struct {
+		watcher *watcherMock
+		calls   int32
+	}{
+		"revoked": {
+			watcher: &watcherMock{events: []*apiv1.WatchEvent{event(apiv1.EventType_EVENT_TYPE_REVOKED)}},
+			calls:   1,
+		},
+		// the cache is purged and the watch restarts
+		"lost": {
+			watcher: &watcherMock{events: []*apiv1.WatchEvent{event(apiv1.EventType_EVENT_TYPE_CREATED)}, lost: true},
+			calls:   2,
+		},
+	}
+	for name, tt := range test {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cache := NewTokenCache(TokenCacheConfig{}, func(_ context.Context, token string) (*apiv1.AccessToken, error) {
+				return &apiv1.AccessToken{Token: token, UserId: "1", Expires: expires}, nil
+			})
+			_, _ = cache.Get(ctx, "token")
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				cache.Watch(ctx, tt.watcher)
+			}()
+			assert.Eventually(t, func() bool {
+				return tt.watcher.calls.Load() == tt.calls && cache.Len() == 0
+			}, time.Second, time.Millisecond)
+			cancel()
+			<-done
+		})
+	}
+	t.Run("other events", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cache := NewTokenCache(TokenCacheConfig{}, func(_ context.Context, token string) (*apiv1.AccessToken, error) {
+			return &apiv1.AccessToken{Token: token, UserId: "1", Expires: expires}, nil
+		})
+		_, _ = cache.Get(ctx, "token")
+		w := &watcherMock{events: []*apiv1.WatchEvent{event(apiv1.EventType_EVENT_TYPE_CREATED)}}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			cache.Watch(ctx, w)
+		}()
+		assert.Eventually(t, func() bool { return w.calls.Load() == 1 }, time.Second, time.Millisecond)
+		cancel()
+		<-done
+		assert.Equal(t, 1, cache.Len())
+	})
+}
@@ -2,13 +2,21 @@ package resource
 
 import (
 	"errors"
+	"log/slog"
 	"strings"
+
+	"github.com/yyyoichi/OhAuth0.1/internal/redact"
 )
 
 type HeaderRequest struct {
 	Authorization string `header:"Authorization" binding:"required"`
 }
 
+// LogValue implements slog.LogValuer. The bearer token is masked.
+func (r HeaderRequest) LogValue() slog.Value {
+	return slog.GroupValue(redact.String("Authorization", r.Authorization))
+}
+
 var (
 	ErrInvalidToken = errors.New("header token has invalid")
 )
@@ -20,9 +28,14 @@ func (r HeaderRequest) FilterToken() (string, error) {
 	return r.Authorization[7:], nil
 }
 
+// fields not granted by authorization details are omitted.
 type ProfileGetResponse struct {
 	UserId  string
-	Name    string
-	Age     uint32
-	Profile string
+	Name    string `json:",omitempty"`
+	Age     uint32 `json:",omitempty"`
+	Profile string `json:",omitempty"`
+}
+
+type ProfilePutRequest struct {
+	Profile string `json:"profile" binding:"required"`
 }
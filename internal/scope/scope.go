@@ -0,0 +1,50 @@
+package scope
+
+import (
+	"slices"
+	"strings"
+)
+
+// Parse splits a space-delimited scope string (RFC 6749 section 3.3).
+func Parse(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// Join returns the space-delimited scope string of [scopes] without duplicates.
+func Join(scopes []string) string {
+	var out []string
+	for _, s := range scopes {
+		if s != "" && !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// Has reports whether the scope string [scope] contains [want].
+func Has(scope, want string) bool {
+	return slices.Contains(Parse(scope), want)
+}
+
+// Subset reports whether every scope in [scope] is also in [granted].
+func Subset(scope, granted string) bool {
+	g := Parse(granted)
+	for _, s := range Parse(scope) {
+		if !slices.Contains(g, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Intersect returns the scope string of scopes which are in both [a] and [b].
+func Intersect(a, b string) string {
+	bs := Parse(b)
+	var out []string
+	for _, s := range Parse(a) {
+		if slices.Contains(bs, s) {
+			out = append(out, s)
+		}
+	}
+	return Join(out)
+}
@@ -0,0 +1,33 @@
+package scope
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScope(t *testing.T) {
+	assert.Equal(t, []string{"a", "b"}, Parse(" a  b "))
+	assert.Empty(t, Parse(""))
+	assert.Equal(t, "a b", Join([]string{"a", "", "b", "a"}))
+
+	assert.True(t, Has("profile:view profile:edit", "profile:edit"))
+	assert.False(t, Has("profile:view", "profile"))
+
+	test := []struct {
+		scope, granted string
+		exp            bool
+	}{
+		{"a", "a b", true},
+		{"a b", "b a", true},
+		{"", "a", true},
+		{"a c", "a b", false},
+		{"a", "", false},
+	}
+	for _, tt := range test {
+		assert.Equalf(t, tt.exp, Subset(tt.scope, tt.granted), "%s in %s", tt.scope, tt.granted)
+	}
+
+	assert.Equal(t, "b", Intersect("a b", "b c"))
+	assert.Equal(t, "", Intersect("a", "b"))
+}
@@ -0,0 +1,252 @@
+// Package server builds the database, authorization and resource servers from the config.
+// The commands of each service and the all-in-one 'cmd/server' share it.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yyyoichi/OhAuth0.1/internal/auth"
+	"github.com/yyyoichi/OhAuth0.1/internal/config"
+	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"github.com/yyyoichi/OhAuth0.1/internal/lifecycle"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
+	"github.com/yyyoichi/OhAuth0.1/internal/resource"
+	"github.com/yyyoichi/OhAuth0.1/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewTracerProvider returns the provider of 'tracing' for the server named [serviceName], and the shutdown which
+// flushes the spans. The provider is nil if tracing is disabled.
+func NewTracerProvider(cfg *config.Config, serviceName string) (trace.TracerProvider, func(context.Context) error, error) {
+	exporter, err := tracing.NewExporter(cfg.Tracing.Exporter, cfg.Tracing.Endpoint, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if exporter == nil {
+		return nil, func(context.Context) error { return nil }, nil
+	}
+	provider := tracing.NewTracerProvider(serviceName, exporter)
+	return provider, provider.Shutdown, nil
+}
+
+// InProcessDatabase is the database server which the authorization and resource servers call in the process.
+type InProcessDatabase struct {
+	*database.InProcessServer
+	// metrics of the database, served with those of each server in the process
+	registry *prometheus.Registry
+}
+
+// NewInProcessDatabase starts the database server in the process, which hashes the tokens, notifies the watchers and
+// sweeps the expired rows as the database server does. It is drained when [ctx] is done.
+func NewInProcessDatabase(ctx context.Context, cfg *config.Config, tracerProvider trace.TracerProvider) (*InProcessDatabase, error) {
+	// without the metrics of the runtime, which each server in the process serves
+	registry := prometheus.NewRegistry()
+	s, err := database.NewInProcessServer(ctx, database.ServerConfig{
+		Storage: database.StorageConfig{
+			Driver: database.StorageMemory,
+			Seed:   seed(cfg),
+		},
+		Sweeper:      sweeperConfig(cfg),
+		TokenPepper:  []byte(cfg.Database.TokenPepper),
+		DrainTimeout: time.Duration(cfg.Shutdown.DrainTimeout),
+		Registry:     registry,
+
+		TracerProvider: tracerProvider,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InProcessDatabase{InProcessServer: s, registry: registry}, nil
+}
+
+// gatherer returns [registry] with the metrics of [db] if not nil.
+func (db *InProcessDatabase) gatherer(registry *prometheus.Registry) prometheus.Gatherer {
+	if db == nil {
+		return registry
+	}
+	return prometheus.Gatherers{registry, db.registry}
+}
+
+// NewDatabaseServer starts the database server.
+func NewDatabaseServer(ctx context.Context, cfg *config.Config, tracerProvider trace.TracerProvider) (*lifecycle.Server, error) {
+	var tlsConfig *tls.Config
+	if cert := cfg.Database.TLS.Cert; cert != "" {
+		var err error
+		tlsConfig, err = database.LoadServerTLSConfig(cert, cfg.Database.TLS.Key, cfg.Database.TLS.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var callers []database.Caller
+	authKey, resourceKey := cfg.Database.AuthServerKey, cfg.Database.ResourceServerKey
+	if authKey != "" || resourceKey != "" || cfg.Database.TLS.ClientCA != "" {
+		callers = database.Callers(authKey, resourceKey)
+	}
+	return database.NewDatabaseServer(ctx, database.ServerConfig{
+		Port: strconv.Itoa(cfg.Database.Port),
+		Storage: database.StorageConfig{
+			Driver:  cfg.Database.Storage,
+			DataDir: cfg.Database.DataDir,
+			Seed:    seed(cfg),
+		},
+		Sweeper:      sweeperConfig(cfg),
+		TokenPepper:  []byte(cfg.Database.TokenPepper),
+		TLS:          tlsConfig,
+		Callers:      callers,
+		Insecure:     cfg.Database.Insecure,
+		DrainTimeout: time.Duration(cfg.Shutdown.DrainTimeout),
+		Registry:     lifecycle.NewRegistry(),
+
+		TracerProvider: tracerProvider,
+	})
+}
+
+// NewAuthServer listens for the authorization server, which calls [db] if not nil, or the database server.
+func NewAuthServer(ctx context.Context, cfg *config.Config, db *InProcessDatabase, tracerProvider trace.TracerProvider) (*lifecycle.Server, error) {
+	var dbtls *tls.Config
+	if ca := cfg.Database.TLS.CA; ca != "" && db == nil {
+		var err error
+		dbtls, err = database.LoadClientTLSConfig(ca, cfg.Auth.DatabaseCert, cfg.Auth.DatabaseKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// clients may authenticate with their certificates over https
+	var serverTLS *tls.Config
+	if cert := cfg.Auth.TLS.Cert; cert != "" {
+		var err error
+		serverTLS, err = pki.LoadMutualTLSConfig(cert, cfg.Auth.TLS.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	clientCAs, err := loadCertPool(cfg.Auth.TLS.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	// the back-channel logout URIs may be served with the certificates of 'dev-certs'
+	logoutRootCAs, err := loadCertPool(cfg.TLS.CA)
+	if err != nil {
+		return nil, err
+	}
+	// each server has its own registry, since the services in a process register the same metrics
+	registry := lifecycle.NewRegistry()
+	config := auth.Config{
+		DatabaseServerURL: cfg.Database.URL,
+		DatabaseTLS:       dbtls,
+		DatabaseKey:       cfg.Database.AuthServerKey,
+		DatabaseTimeout:   time.Duration(cfg.Database.ClientTimeout),
+		Registerer:        registry,
+		TracerProvider:    tracerProvider,
+		ClientCAs:         clientCAs,
+		LogoutRootCAs:     logoutRootCAs,
+		TokenEndpointURL:  cfg.Auth.URL + "/api/v1/accesstoken",
+
+		TokenExchangePolicy: tokenExchangePolicy(cfg),
+	}
+	if db != nil {
+		config.DatabaseServerURL = database.InProcessURL
+		config.DatabaseKey = db.AuthServerKey
+		config.DatabaseDial = db.Dial
+	}
+	service, err := auth.NewService(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	router := auth.SetupRouter(service, cfg.UI.URL)
+	return lifecycle.Listen(&http.Server{Addr: ":" + strconv.Itoa(cfg.Auth.Port), Handler: router, TLSConfig: serverTLS}, lifecycle.Config{
+		Ready:        service.Ping,
+		DrainTimeout: time.Duration(cfg.Shutdown.DrainTimeout),
+		Metrics:      db.gatherer(registry),
+	})
+}
+
+// NewResourceServer listens for the resource server, which calls [db] if not nil, or the database server.
+func NewResourceServer(ctx context.Context, cfg *config.Config, db *InProcessDatabase, tracerProvider trace.TracerProvider) (*lifecycle.Server, error) {
+	var dbtls *tls.Config
+	if ca := cfg.Database.TLS.CA; ca != "" && db == nil {
+		var err error
+		dbtls, err = database.LoadClientTLSConfig(ca, cfg.Resource.DatabaseCert, cfg.Resource.DatabaseKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// clients present their certificates over https for the certificate-bound tokens
+	var serverTLS *tls.Config
+	if cert := cfg.Resource.TLS.Cert; cert != "" {
+		var err error
+		serverTLS, err = pki.LoadMutualTLSConfig(cert, cfg.Resource.TLS.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	registry := lifecycle.NewRegistry()
+	config := resource.Config{
+		DatabaseServerURL: cfg.Database.URL,
+		DatabaseTLS:       dbtls,
+		DatabaseKey:       cfg.Database.ResourceServerKey,
+		DatabaseTimeout:   time.Duration(cfg.Database.ClientTimeout),
+		Registerer:        registry,
+		TracerProvider:    tracerProvider,
+		ResourceURI:       cfg.Resource.URL,
+		TokenCache: resource.TokenCacheConfig{
+			Size: cfg.Resource.TokenCacheSize,
+			TTL:  time.Duration(cfg.Resource.TokenCacheTTL),
+		},
+	}
+	if db != nil {
+		config.DatabaseServerURL = database.InProcessURL
+		config.DatabaseKey = db.ResourceServerKey
+		config.DatabaseDial = db.Dial
+	}
+	service, err := resource.NewService(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	router := resource.SetupRouter(service)
+	return lifecycle.Listen(&http.Server{Addr: ":" + strconv.Itoa(cfg.Resource.Port), Handler: router, TLSConfig: serverTLS}, lifecycle.Config{
+		Ready:        service.Ping,
+		DrainTimeout: time.Duration(cfg.Shutdown.DrainTimeout),
+		Metrics:      db.gatherer(registry),
+	})
+}
+
+// the mock rows follow the URLs of the resource server and the CLI, which may be https
+func seed(cfg *config.Config) database.Seed {
+	return database.Seed{
+		RedirectURI:          cfg.Client.RedirectURI,
+		ResourceURI:          cfg.Resource.URL,
+		BackchannelLogoutURI: cfg.Client.LogoutURI,
+	}
+}
+
+func sweeperConfig(cfg *config.Config) database.SweeperConfig {
+	return database.SweeperConfig{
+		Interval:    time.Duration(cfg.Database.SweepInterval),
+		GracePeriod: time.Duration(cfg.Database.SweepGracePeriod),
+	}
+}
+
+// tokenExchangePolicy returns the policy of 'auth.token_exchange', which is validated.
+func tokenExchangePolicy(cfg *config.Config) auth.TokenExchangePolicy {
+	rules, _ := cfg.Auth.TokenExchangeRules()
+	policy := make(auth.TokenExchangePolicy, 0, len(rules))
+	for _, rule := range rules {
+		policy = append(policy, auth.TokenExchangeRule(rule))
+	}
+	return policy
+}
+
+// loadCertPool returns nil if [file] is empty.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	if file == "" {
+		return nil, nil
+	}
+	return pki.LoadCertPool(file)
+}
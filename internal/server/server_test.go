@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yyyoichi/OhAuth0.1/internal/auth"
+	"github.com/yyyoichi/OhAuth0.1/internal/config"
+	"github.com/yyyoichi/OhAuth0.1/internal/database"
+	"github.com/yyyoichi/OhAuth0.1/internal/lifecycle"
+	"github.com/yyyoichi/OhAuth0.1/internal/resource"
+)
+
+func TestInProcess(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := config.Default()
+	// any free ports, and the URLs which the mock rows and the CORS expect
+	cfg.Auth.Port, cfg.Resource.Port = 0, 0
+	cfg.Auth.URL = "http://localhost:8080"
+	cfg.Resource.URL = database.RESOURCE_URI
+	cfg.UI.URL = "http://localhost:3000"
+	cfg.Client.RedirectURI = database.REDIRECT_URI
+	cfg.Client.LogoutURI = database.BACKCHANNEL_LOGOUT_URI
+
+	db, err := NewInProcessDatabase(ctx, &cfg, nil)
+	require.NoError(t, err)
+	authServer, err := NewAuthServer(ctx, &cfg, db, nil)
+	require.NoError(t, err)
+	resourceServer, err := NewResourceServer(ctx, &cfg, db, nil)
+	require.NoError(t, err)
+	authServer.Start(ctx)
+	resourceServer.Start(ctx)
+	authURL := "http://" + authServer.Addr().String()
+	resourceURL := "http://" + resourceServer.Addr().String()
+
+	do := func(req *http.Request, v any) int {
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		if v != nil {
+			assert.NoErrorf(t, json.Unmarshal(body, v), string(body))
+		}
+		return resp.StatusCode
+	}
+	post := func(path string, body, v any) int {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, authURL+path, bytes.NewReader(b))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		return do(req, v)
+	}
+	get := func(url, token string, v any) int {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return do(req, v)
+	}
+
+	// both are ready without a database server
+	assert.Equal(t, http.StatusOK, get(authURL+lifecycle.ReadinessPath, "", nil))
+	assert.Equal(t, http.StatusOK, get(resourceURL+lifecycle.ReadinessPath, "", nil))
+
+	// the token issued by the authorization server is accepted by the resource server
+	var authentication auth.AuthenticationResponse
+	require.Equal(t, http.StatusOK, post("/api/v1/authentication", auth.AuthenticationRequest{
+		ClientId: "500", UserId: "1", Password: "password",
+	}, &authentication))
+	var authorization auth.AuthorizationResponse
+	require.Equal(t, http.StatusOK, post("/api/v1/authorization", auth.AuthorizationRequest{
+		JWT: authentication.JWT, ClientId: "500", ResponseType: "code", Scope: "profile:view",
+	}, &authorization))
+	var token auth.AccessTokenResponse
+	require.Equal(t, http.StatusOK, post("/api/v1/accesstoken", auth.AccessTokenRequest{
+		GrantType: auth.GrantTypeAuthorizationCode, ClientId: "500", ClientSecret: database.CLIENT_SECRET, Code: authorization.Code,
+	}, &token))
+	var profile resource.ProfileGetResponse
+	assert.Equal(t, http.StatusOK, get(resourceURL+"/api/v1/profile", token.AccessToken, &profile))
+	assert.Equal(t, "1", profile.UserId)
+	assert.Equal(t, http.StatusForbidden, get(resourceURL+"/api/v1/profile", "unknown", nil))
+
+	// the metrics of the database are served with those of the servers
+	for _, url := range []string{authURL, resourceURL} {
+		req, err := http.NewRequest(http.MethodGet, url+lifecycle.MetricsPath, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `database_rows{table="access_tokens"} 1`)
+		assert.Contains(t, string(body), `rpc_requests_total{code="ok",procedure="/api.v1.DatabaseService/GetAccessTokenUnary",side="server"}`)
+	}
+
+	cancel()
+	assert.NoError(t, authServer.Wait())
+	assert.NoError(t, resourceServer.Wait())
+	assert.NoError(t, db.Wait())
+}
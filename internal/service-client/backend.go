@@ -3,19 +3,30 @@ package serviceclient
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/yyyoichi/OhAuth0.1/internal/auth"
 	"github.com/yyyoichi/OhAuth0.1/internal/resource"
 )
 
 type (
 	CodeReceiver struct {
-		Port   string
+		Port string
+		// serves the redirect URI with https if set
+		TLS    *tls.Config
 		codeCh chan string
 	}
 )
@@ -27,7 +38,12 @@ func NewCodeReceiver(port int) CodeReceiver {
 	return b
 }
 
-func (b *CodeReceiver) Start(ctx context.Context) {
+// Start listens on the port, and receives a code until [ctx] is done.
+func (b *CodeReceiver) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", b.Port)
+	if err != nil {
+		return fmt.Errorf("cannot listen the redirect URI: %w", err)
+	}
 	ctx, cancel := context.WithCancel(ctx)
 
 	mux := http.NewServeMux()
@@ -50,32 +66,22 @@ func (b *CodeReceiver) Start(ctx context.Context) {
 		}
 	}))
 	server := &http.Server{
-		Addr:    b.Port,
-		Handler: mux,
+		Handler:   mux,
+		TLSConfig: b.TLS,
 	}
 	go func() {
-		server.ListenAndServe()
+		if b.TLS != nil {
+			server.ServeTLS(ln, "", "")
+		} else {
+			server.Serve(ln)
+		}
 	}()
 	go func() {
 		<-ctx.Done()
 		close(b.codeCh)
 		server.Shutdown(context.Background())
 	}()
-	connected := make(chan struct{})
-	go func() {
-		defer close(connected)
-		for {
-			resp, err := http.DefaultClient.Get("http://localhost" + b.Port + "/status")
-			if err != nil {
-				continue
-			}
-			if resp.StatusCode != http.StatusOK {
-				continue
-			}
-			return
-		}
-	}()
-	<-connected
+	return nil
 }
 
 func (b *CodeReceiver) Receive() <-chan string {
@@ -89,17 +95,81 @@ type (
 	AccessTokenRequestParam struct {
 		ClientId     string
 		ClientSecret string
+		// authenticate with 'private_key_jwt' instead of ClientSecret if set
+		ClientAssertion *ClientAssertionSigner
+	}
+	// ClientAssertionSigner signs client assertions (RFC 7523) with a key registered on the authorization server.
+	ClientAssertionSigner struct {
+		// *rsa.PrivateKey or *ecdsa.PrivateKey
+		Key   crypto.PrivateKey
+		KeyId string
+		// issuer identifier or token endpoint URL of the authorization server
+		Audience string
 	}
 )
 
-func NewAccessTokenClient(authServerURI string) AccessTokenClient {
+const clientAssertionLifetime = time.Duration(1) * time.Minute
+
+// Sign returns a new one-time client assertion for [clientId].
+func (s *ClientAssertionSigner) Sign(clientId string) (string, error) {
+	var method jwt.SigningMethod
+	switch k := s.Key.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			method = jwt.SigningMethodES256
+		case 384:
+			method = jwt.SigningMethodES384
+		default:
+			method = jwt.SigningMethodES512
+		}
+	default:
+		return "", fmt.Errorf("unsupported key type %T", s.Key)
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(method, jwt.RegisteredClaims{
+		Issuer:    clientId,
+		Subject:   clientId,
+		Audience:  jwt.ClaimStrings{s.Audience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        uuid.NewString(),
+	})
+	if s.KeyId != "" {
+		token.Header["kid"] = s.KeyId
+	}
+	return token.SignedString(s.Key)
+}
+
+func (p AccessTokenRequestParam) apply(req *auth.AccessTokenRequest) error {
+	req.ClientId = p.ClientId
+	if p.ClientAssertion == nil {
+		req.ClientSecret = p.ClientSecret
+		return nil
+	}
+	assertion, err := p.ClientAssertion.Sign(p.ClientId)
+	if err != nil {
+		return fmt.Errorf("cannot sign client assertion: %w", err)
+	}
+	req.ClientAssertionType = auth.ClientAssertionTypeJWTBearer
+	req.ClientAssertion = assertion
+	return nil
+}
+
+// NewAccessTokenClient calls the authorization server with [client], or [http.DefaultClient] if nil.
+func NewAccessTokenClient(authServerURI string, client *http.Client) AccessTokenClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
 	return AccessTokenClient{
 		post: func(ctx context.Context, path string, body io.Reader) (*http.Response, error) {
 			req, err := http.NewRequestWithContext(ctx, http.MethodPost, authServerURI+path, body)
 			if err != nil {
 				return nil, err
 			}
-			return http.DefaultClient.Do(req)
+			return client.Do(req)
 		},
 	}
 }
@@ -109,8 +179,9 @@ func (c *AccessTokenClient) GetByCode(ctx context.Context, code string, param Ac
 ) {
 	var req auth.AccessTokenRequest
 	req.GrantType = "authorization_code"
-	req.ClientId = param.ClientId
-	req.ClientSecret = param.ClientSecret
+	if err := param.apply(&req); err != nil {
+		return nil, err
+	}
 	req.Code = code
 	return c.get(ctx, req)
 }
@@ -120,12 +191,29 @@ func (c *AccessTokenClient) GetByRefreshToken(ctx context.Context, token string,
 ) {
 	var req auth.AccessTokenRequest
 	req.GrantType = "authorization_code"
-	req.ClientId = param.ClientId
-	req.ClientSecret = param.ClientSecret
+	if err := param.apply(&req); err != nil {
+		return nil, err
+	}
 	req.RefreshToken = token
 	return c.get(ctx, req)
 }
 
+// Exchange exchanges [subjectToken] received from a user for a token to call [audience] on behalf of the user (RFC 8693).
+func (c *AccessTokenClient) Exchange(ctx context.Context, subjectToken string, audience []string, scope string, param AccessTokenRequestParam) (
+	*auth.AccessTokenResponse, error,
+) {
+	var req auth.AccessTokenRequest
+	req.GrantType = auth.GrantTypeTokenExchange
+	if err := param.apply(&req); err != nil {
+		return nil, err
+	}
+	req.SubjectToken = subjectToken
+	req.SubjectTokenType = auth.TokenTypeAccessToken
+	req.Audience = audience
+	req.Scope = scope
+	return c.get(ctx, req)
+}
+
 func (c *AccessTokenClient) get(ctx context.Context, req auth.AccessTokenRequest) (*auth.AccessTokenResponse, error) {
 	b, err := json.Marshal(req)
 	if err != nil {
@@ -141,13 +229,7 @@ func (c *AccessTokenClient) get(ctx context.Context, req auth.AccessTokenRequest
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		var body struct {
-			Status string
-		}
-		if err := json.Unmarshal(data, &body); err != nil {
-			return nil, err
-		}
-		return nil, fmt.Errorf("status code is %d: %s", resp.StatusCode, body.Status)
+		return nil, decodeError(resp, data)
 	}
 	var body auth.AccessTokenResponse
 	if err := json.Unmarshal(data, &body); err != nil {
@@ -158,9 +240,26 @@ func (c *AccessTokenClient) get(ctx context.Context, req auth.AccessTokenRequest
 
 type ResourceClient struct {
 	get func(ctx context.Context, path, token string) (*http.Response, error)
+	put func(ctx context.Context, path, token string, body io.Reader) (*http.Response, error)
 }
 
-func NewResourceClient(resourceServerURI string) ResourceClient {
+// StepUpRequiredError is the 'insufficient_user_authentication' challenge of the resource server (RFC 9470).
+type StepUpRequiredError struct {
+	// seconds. nil if not required.
+	MaxAge *int64
+	// space-delimited
+	AcrValues string
+}
+
+func (e *StepUpRequiredError) Error() string {
+	return "step-up authentication is required"
+}
+
+// NewResourceClient calls the resource server with [client], or [http.DefaultClient] if nil.
+func NewResourceClient(resourceServerURI string, client *http.Client) ResourceClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
 	return ResourceClient{
 		get: func(ctx context.Context, path, token string) (*http.Response, error) {
 			req, err := http.NewRequestWithContext(ctx, http.MethodGet, resourceServerURI+path, nil)
@@ -168,7 +267,16 @@ func NewResourceClient(resourceServerURI string) ResourceClient {
 				return nil, err
 			}
 			req.Header.Add("Authorization", "Bearer "+token)
-			return http.DefaultClient.Do(req)
+			return client.Do(req)
+		},
+		put: func(ctx context.Context, path, token string, body io.Reader) (*http.Response, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPut, resourceServerURI+path, body)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Add("Authorization", "Bearer "+token)
+			req.Header.Add("Content-Type", "application/json")
+			return client.Do(req)
 		},
 	}
 }
@@ -185,15 +293,48 @@ func (c *ResourceClient) ViewProfile(ctx context.Context, token string) (*resour
 	}
 	if resp.StatusCode != http.StatusOK {
 		if resp.StatusCode == http.StatusUnauthorized {
-			return nil, resource.ErrAccessTokenExpired
+			return nil, fmt.Errorf("%w: %w", resource.ErrAccessTokenExpired, decodeError(resp, data))
 		}
-		var body struct {
-			Status string
-		}
-		if err := json.Unmarshal(data, &body); err != nil {
-			return nil, err
+		return nil, decodeError(resp, data)
+	}
+	var body resource.ProfileGetResponse
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+func (c *ResourceClient) EditProfile(ctx context.Context, token, profile string) (*resource.ProfileGetResponse, error) {
+	reqBody, err := json.Marshal(resource.ProfilePutRequest{Profile: profile})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.put(ctx, "/api/v1/profile", token, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnauthorized {
+			params := parseBearerChallenge(resp.Header.Get("WWW-Authenticate"))
+			if params["error"] != "insufficient_user_authentication" {
+				return nil, fmt.Errorf("%w: %w", resource.ErrAccessTokenExpired, decodeError(resp, data))
+			}
+			stepUp := &StepUpRequiredError{AcrValues: params["acr_values"]}
+			if v, found := params["max_age"]; found {
+				maxAge, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid max_age '%s': %w", v, err)
+				}
+				stepUp.MaxAge = &maxAge
+			}
+			return nil, stepUp
 		}
-		return nil, fmt.Errorf("status code is %d: %s", resp.StatusCode, body.Status)
+		return nil, decodeError(resp, data)
 	}
 	var body resource.ProfileGetResponse
 	if err := json.Unmarshal(data, &body); err != nil {
@@ -201,3 +342,21 @@ func (c *ResourceClient) ViewProfile(ctx context.Context, token string) (*resour
 	}
 	return &body, nil
 }
+
+// parseBearerChallenge returns the auth-params of a 'Bearer' challenge, e.g. `Bearer error="invalid_token"`.
+// values may not contain '"' or ','.
+func parseBearerChallenge(header string) map[string]string {
+	params := map[string]string{}
+	rest, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		return params
+	}
+	for _, param := range strings.Split(rest, ",") {
+		key, val, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found {
+			continue
+		}
+		params[key] = strings.Trim(val, `"`)
+	}
+	return params
+}
@@ -2,13 +2,23 @@ package serviceclient
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/yyyoichi/OhAuth0.1/internal/acr"
 	"github.com/yyyoichi/OhAuth0.1/internal/auth"
+	enging "github.com/yyyoichi/OhAuth0.1/internal/engine"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+	"github.com/yyyoichi/OhAuth0.1/internal/pki"
 	"github.com/yyyoichi/OhAuth0.1/internal/resource"
 )
 
@@ -18,7 +28,7 @@ func TestCodeReciever(t *testing.T) {
 		turi := "http://localhost:9001"
 		tserver := NewCodeReceiver(9001)
 		ctx := context.Background()
-		tserver.Start(ctx)
+		assert.NoError(t, tserver.Start(ctx))
 		go func() {
 			resp, err := http.DefaultClient.Get(turi + "?code=12345")
 			assert.NoError(t, err)
@@ -34,7 +44,7 @@ func TestCodeReciever(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		tserver.Start(ctx)
+		assert.NoError(t, tserver.Start(ctx))
 		go func() {
 			cancel() // !
 			resp, err := http.DefaultClient.Get(turi + "?code=12345")
@@ -44,6 +54,29 @@ func TestCodeReciever(t *testing.T) {
 		_, ok := <-tserver.Receive()
 		assert.False(t, ok)
 	})
+	t.Run("tls", func(t *testing.T) {
+		t.Parallel()
+		ca, err := pki.NewCA("test ca")
+		assert.NoError(t, err)
+		cert, err := ca.IssueServerCertificate("localhost")
+		assert.NoError(t, err)
+		tserver := NewCodeReceiver(9003)
+		tserver.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		assert.NoError(t, tserver.Start(context.Background()))
+		// the port is in use
+		another := NewCodeReceiver(9003)
+		assert.Error(t, another.Start(context.Background()))
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.CertPool()}}}
+		go func() {
+			resp, err := client.Get("https://localhost:9003?code=12345")
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+		result := <-tserver.Receive()
+		assert.Equal(t, "12345", result)
+	})
 }
 
 func TestAccessTokenClient(t *testing.T) {
@@ -103,3 +136,146 @@ func TestResourceClient(t *testing.T) {
 		}
 	}
 }
+
+func TestResourceClientEditProfile(t *testing.T) {
+	test := map[string]struct {
+		statusCode int
+		challenge  string
+		expErr     error
+		expStepUp  *StepUpRequiredError
+	}{
+		"ok":      {statusCode: http.StatusOK},
+		"expired": {statusCode: http.StatusUnauthorized, challenge: `Bearer error="invalid_token"`, expErr: resource.ErrAccessTokenExpired},
+		"step-up": {
+			statusCode: http.StatusUnauthorized,
+			challenge:  resource.ProfileEditStepUp.Challenge(),
+			expStepUp:  &StepUpRequiredError{MaxAge: func() *int64 { v := int64(300); return &v }(), AcrValues: acr.Password},
+		},
+	}
+	for scenario, tt := range test {
+		t.Run(scenario, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			if tt.challenge != "" {
+				resp.Header().Set("WWW-Authenticate", tt.challenge)
+			}
+			resp.WriteHeader(tt.statusCode)
+			resp.Write([]byte("{}"))
+			client := ResourceClient{
+				put: func(_ context.Context, _0, _1 string, _ io.Reader) (*http.Response, error) {
+					return resp.Result(), nil
+				},
+			}
+			_, err := client.EditProfile(context.Background(), "", "profile")
+			switch {
+			case tt.expStepUp != nil:
+				var stepUp *StepUpRequiredError
+				assert.ErrorAs(t, err, &stepUp)
+				assert.Equal(t, tt.expStepUp, stepUp)
+			case tt.expErr != nil:
+				assert.ErrorIs(t, err, tt.expErr)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAccessTokenClientAssertion(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	var sent auth.AccessTokenRequest
+	client := AccessTokenClient{
+		post: func(_ context.Context, _ string, body io.Reader) (*http.Response, error) {
+			assert.NoError(t, json.NewDecoder(body).Decode(&sent))
+			resp := httptest.NewRecorder()
+			resp.WriteHeader(http.StatusOK)
+			resp.Write([]byte("{}"))
+			return resp.Result(), nil
+		},
+	}
+	_, err = client.GetByCode(context.Background(), "code", AccessTokenRequestParam{
+		ClientId:     "500",
+		ClientSecret: "secret",
+		ClientAssertion: &ClientAssertionSigner{
+			Key:      key,
+			KeyId:    "kid",
+			Audience: auth.Issuer,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, sent.ClientSecret)
+	assert.Equal(t, auth.ClientAssertionTypeJWTBearer, sent.ClientAssertionType)
+
+	var claims jwt.RegisteredClaims
+	token, err := jwt.ParseWithClaims(sent.ClientAssertion, &claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "kid", token.Header["kid"])
+	assert.Equal(t, "500", claims.Issuer)
+	assert.Equal(t, "500", claims.Subject)
+	assert.Equal(t, jwt.ClaimStrings{auth.Issuer}, claims.Audience)
+	assert.NotEmpty(t, claims.ID)
+}
+
+func TestDecodeError(t *testing.T) {
+	test := map[string]struct {
+		statusCode int
+		body       string
+		expErr     Error
+	}{
+		"oauth": {
+			statusCode: http.StatusUnauthorized,
+			body:       `{"error":"invalid_grant","error_description":"authorization code is expired","code":"authorization_code_expired","request_id":"req-1"}`,
+			expErr: Error{
+				StatusCode: http.StatusUnauthorized,
+				Code:       enging.CodeAuthorizationCodeExpired,
+				OAuthError: "invalid_grant",
+				Detail:     "authorization code is expired",
+				RequestId:  "req-1",
+			},
+		},
+		"problem": {
+			statusCode: http.StatusNotFound,
+			body:       `{"type":"urn:ohauth:error:not_found","title":"Not Found","status":404,"detail":"not found","code":"not_found","request_id":"req-2"}`,
+			expErr:     Error{StatusCode: http.StatusNotFound, Code: enging.CodeNotFound, Detail: "not found", RequestId: "req-2"},
+		},
+		"not an error response": {
+			statusCode: http.StatusBadGateway,
+			body:       `<html>Bad Gateway</html>`,
+			expErr:     Error{StatusCode: http.StatusBadGateway, Detail: "Bad Gateway", RequestId: "req-3"},
+		},
+	}
+	for scenario, tt := range test {
+		t.Run(scenario, func(t *testing.T) {
+			client := AccessTokenClient{
+				post: func(_ context.Context, _ string, _ io.Reader) (*http.Response, error) {
+					resp := httptest.NewRecorder()
+					resp.Header().Set(interceptor.RequestIDHeader, "req-3")
+					resp.WriteHeader(tt.statusCode)
+					resp.Write([]byte(tt.body))
+					return resp.Result(), nil
+				},
+			}
+			_, err := client.GetByCode(context.Background(), "code", AccessTokenRequestParam{ClientId: "500", ClientSecret: "secret"})
+			var got *Error
+			if assert.ErrorAs(t, err, &got) {
+				assert.Equal(t, tt.expErr, *got)
+			}
+			assert.Equal(t, tt.expErr.Code, ErrorCode(err))
+		})
+	}
+
+	// an expired token keeps the error response
+	client := ResourceClient{
+		get: func(_ context.Context, _0, _1 string) (*http.Response, error) {
+			resp := httptest.NewRecorder()
+			resp.WriteHeader(http.StatusUnauthorized)
+			resp.Write([]byte(`{"status":401,"code":"token_expired"}`))
+			return resp.Result(), nil
+		},
+	}
+	_, err := client.ViewProfile(context.Background(), "token")
+	assert.ErrorIs(t, err, resource.ErrAccessTokenExpired)
+	assert.Equal(t, enging.CodeTokenExpired, ErrorCode(err))
+}
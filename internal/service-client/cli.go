@@ -2,12 +2,17 @@ package serviceclient
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/yyyoichi/OhAuth0.1/internal/auth"
 	"github.com/yyyoichi/OhAuth0.1/internal/database"
 	"github.com/yyyoichi/OhAuth0.1/internal/resource"
 )
@@ -15,6 +20,7 @@ import (
 type (
 	Brawser struct {
 		codeReceiverPost  int
+		codeReceiverTLS   *tls.Config
 		accessTokenClient AccessTokenClient
 		resourceClient    resourceClientInterface
 		authUiURI         string
@@ -23,23 +29,31 @@ type (
 		currentServiceClientId *string
 		accessTokens           map[string]string
 		refreshTokens          map[string]string
+
+		logoutReceiver *BackChannelLogoutReceiver
 	}
 	resourceClientInterface interface {
 		ViewProfile(ctx context.Context, token string) (*resource.ProfileGetResponse, error)
+		EditProfile(ctx context.Context, token, profile string) (*resource.ProfileGetResponse, error)
 	}
 	BrawserConfig struct {
-		RedirectPort      int
+		RedirectPort int
+		// serves the redirect URI with https if set. Optional.
+		RedirectTLS       *tls.Config
 		AuthServerURI     string
 		ResourceServerURI string
 		AuthUIURI         string
+		// calls the authorization and resource servers, e.g. trusting the CA of 'dev-certs'. Optional.
+		HTTPClient *http.Client
 	}
 )
 
 func NewBrawser(config BrawserConfig) *Brawser {
 	var b Brawser
 	b.codeReceiverPost = config.RedirectPort
-	b.accessTokenClient = NewAccessTokenClient(config.AuthServerURI)
-	resourceClient := NewResourceClient(config.ResourceServerURI)
+	b.codeReceiverTLS = config.RedirectTLS
+	b.accessTokenClient = NewAccessTokenClient(config.AuthServerURI, config.HTTPClient)
+	resourceClient := NewResourceClient(config.ResourceServerURI, config.HTTPClient)
 	b.resourceClient = &resourceClient
 	b.authUiURI = config.AuthUIURI
 
@@ -47,9 +61,28 @@ func NewBrawser(config BrawserConfig) *Brawser {
 	b.currentServiceClientId = nil
 	b.accessTokens = map[string]string{}
 	b.refreshTokens = map[string]string{}
+	b.logoutReceiver = NewBackChannelLogoutReceiver(map[string]string{
+		database.MockServiceClient500.Id: database.CLIENT_SECRET,
+		database.MockServiceClient501.Id: database.CLIENT_SECRET,
+	}, func(clientId string, _ *auth.LogoutClaims) {
+		b.dropTokens(clientId)
+		fmt.Printf("\nLogged out from %s by the authorization server.\n", clientId)
+	})
 	return &b
 }
 
+// BackChannelLogoutHandler receives back-channel logout notifications to drop the tokens of logged out sites.
+func (b *Brawser) BackChannelLogoutHandler() http.Handler {
+	return b.logoutReceiver
+}
+
+func (b *Brawser) dropTokens(clientId string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.accessTokens, clientId)
+	delete(b.refreshTokens, clientId)
+}
+
 func (b *Brawser) Brawse(ctx context.Context, input string) (*output, error) {
 	command := ParseCommand(input)
 
@@ -84,6 +117,12 @@ func (b *Brawser) Brawse(ctx context.Context, input string) (*output, error) {
 			return nil, fmt.Errorf("canno get profile: %w", err)
 		}
 		return newViewProfileOutput(profile), nil
+	case editProfile:
+		profile, err := b.editProfile(ctx, strings.Join(command.args, " "))
+		if err != nil {
+			return nil, fmt.Errorf("cannot edit profile: %w", err)
+		}
+		return newViewProfileOutput(profile), nil
 	}
 
 	return nil, errors.New("unknown command")
@@ -108,6 +147,11 @@ func (b *Brawser) logout() error {
 }
 
 func (b *Brawser) login(ctx context.Context) error {
+	return b.loginWith(ctx, nil)
+}
+
+// loginWith logins with additional parameters of the authorization request, e.g. 'prompt'.
+func (b *Brawser) loginWith(ctx context.Context, params url.Values) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	if b.currentServiceClientId == nil {
@@ -119,9 +163,16 @@ func (b *Brawser) login(ctx context.Context) error {
 	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(3)*time.Minute)
 	defer cancel()
 	codeReceiver := NewCodeReceiver(b.codeReceiverPost)
-	codeReceiver.Start(timeoutCtx)
+	codeReceiver.TLS = b.codeReceiverTLS
+	if err := codeReceiver.Start(timeoutCtx); err != nil {
+		return err
+	}
 
-	fmt.Printf("\n🚀Open %s?clinet_id=%s in your brawer.", b.authUiURI, *b.currentServiceClientId)
+	query := url.Values{"client_id": {*b.currentServiceClientId}}
+	for key, vals := range params {
+		query[key] = vals
+	}
+	fmt.Printf("\n🚀Open %s?%s in your brawer.", b.authUiURI, query.Encode())
 
 	var code string
 	select {
@@ -175,6 +226,53 @@ func (b *Brawser) viewProfile(ctx context.Context) (map[string]any, error) {
 	return profile, nil
 }
 
+func (b *Brawser) editProfile(ctx context.Context, text string) (map[string]any, error) {
+	if b.currentServiceClientId == nil {
+		return nil, ErrNoSite
+	}
+	token, found := b.accessTokens[*b.currentServiceClientId]
+	if !found {
+		return nil, fmt.Errorf("access token is not found")
+	}
+	p, err := b.resourceClient.EditProfile(ctx, token, text)
+	var stepUp *StepUpRequiredError
+	switch {
+	case err == nil:
+	case errors.As(err, &stepUp):
+		// login again to meet the requirements, and retry
+		fmt.Printf("\n🔐The site requires a recent login.")
+		params := url.Values{"prompt": {auth.PromptLogin}}
+		if stepUp.MaxAge != nil {
+			params.Set("max_age", strconv.FormatInt(*stepUp.MaxAge, 10))
+		}
+		if stepUp.AcrValues != "" {
+			params.Set("acr_values", stepUp.AcrValues)
+		}
+		b.dropTokens(*b.currentServiceClientId)
+		if err := b.loginWith(ctx, params); err != nil {
+			return nil, fmt.Errorf("cannot login again: %w", err)
+		}
+		if p, err = b.resourceClient.EditProfile(ctx, b.accessTokens[*b.currentServiceClientId], text); err != nil {
+			return nil, fmt.Errorf("cannot edit profile: %w", err)
+		}
+	case errors.Is(err, resource.ErrAccessTokenExpired):
+		if err := b.refreshToken(ctx); err != nil {
+			return nil, fmt.Errorf("cannot get refresh token: %w", err)
+		}
+		if p, err = b.resourceClient.EditProfile(ctx, b.accessTokens[*b.currentServiceClientId], text); err != nil {
+			return nil, fmt.Errorf("cannot edit profile: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("cannot edit profile: %w", err)
+	}
+	var profile = map[string]any{}
+	profile["id"] = p.UserId
+	profile["age"] = p.Age
+	profile["profile"] = p.Profile
+	profile["name"] = p.Name
+	return profile, nil
+}
+
 func (b *Brawser) refreshToken(ctx context.Context) error {
 	if b.currentServiceClientId == nil {
 		return ErrNoSite
@@ -218,6 +316,7 @@ const (
 	login       command = "login"
 	logout      command = "logout"
 	viewProfile command = "view-profile"
+	editProfile command = "edit-profile"
 
 	unknownMsgId messageId = iota
 	helpMsgId
@@ -246,6 +345,8 @@ func ParseCommand(input string) Command {
 		return Command{command: logout}
 	case viewProfile:
 		return Command{command: viewProfile}
+	case editProfile:
+		return Command{command: editProfile, args: cmds[1:]}
 	default:
 		return Command{command: unknown}
 	}
@@ -264,6 +365,7 @@ var (
 - logout
 - help
 - view-profile
+- edit-profile [text]
 `,
 	}
 
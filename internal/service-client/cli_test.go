@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/yyyoichi/OhAuth0.1/internal/acr"
 	"github.com/yyyoichi/OhAuth0.1/internal/auth"
 	"github.com/yyyoichi/OhAuth0.1/internal/resource"
 )
@@ -102,6 +103,16 @@ func (r *resourceClientMock) ViewProfile(ctx context.Context, token string) (*re
 	return &resource.ProfileGetResponse{}, nil
 }
 
+// EditProfile implements resourceClientInterface.
+func (r *resourceClientMock) EditProfile(ctx context.Context, token, profile string) (*resource.ProfileGetResponse, error) {
+	if r.count == 0 {
+		r.count++
+		maxAge := int64(300)
+		return nil, &StepUpRequiredError{MaxAge: &maxAge, AcrValues: acr.Password}
+	}
+	return &resource.ProfileGetResponse{Profile: profile}, nil
+}
+
 func TestRetryVeiwProfile(t *testing.T) {
 	brawser := newBrawserMock(t)
 	mock := resourceClientMock{}
@@ -114,6 +125,36 @@ func TestRetryVeiwProfile(t *testing.T) {
 	assert.Equal(t, 1, mock.count) // !
 }
 
+func TestStepUpEditProfile(t *testing.T) {
+	brawser := newBrawserMock(t)
+	mock := resourceClientMock{}
+	brawser.resourceClient = &mock
+	_ = brawser.moveToServiceClient("TEST_ID")
+	brawser.accessTokens["TEST_ID"] = "old-token"
+	brawser.refreshTokens["TEST_ID"] = "old-token"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2)*time.Second)
+	defer cancel()
+	go func() {
+		for {
+			resp, err := http.DefaultClient.Get("http://localhost:9010/status")
+			if err != nil || resp.StatusCode != http.StatusOK {
+				continue
+			}
+			resp, err = http.DefaultClient.Get("http://localhost:9010?code=12345")
+			if err != nil || resp.StatusCode != http.StatusOK {
+				continue
+			}
+			return
+		}
+	}()
+	p, err := brawser.editProfile(ctx, "new profile")
+	assert.NoError(t, err)
+	assert.Equal(t, "new profile", p["profile"])
+	assert.Equal(t, 1, mock.count)
+	// tokens of the new login
+	assert.Equal(t, "accesstoken", brawser.accessTokens["TEST_ID"])
+}
+
 func newBrawserMock(t *testing.T) Brawser {
 	var brawser Brawser
 	brawser.codeReceiverPost = 9010
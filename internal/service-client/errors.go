@@ -0,0 +1,78 @@
+package serviceclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	enging "github.com/yyyoichi/OhAuth0.1/internal/engine"
+	"github.com/yyyoichi/OhAuth0.1/internal/interceptor"
+)
+
+// Error is an error response of the authorization server or the resource server,
+// either the problem details (RFC 9457) or the error response of OAuth (RFC 6749 5.2).
+type Error struct {
+	StatusCode int
+	// stable code to branch on. empty if the body is not an error response of the servers.
+	Code enging.Code
+	// the 'error' of OAuth, e.g. 'invalid_grant'. empty for the problem details.
+	OAuthError string
+	Detail     string
+	// the id to look up the logs of the servers
+	RequestId string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("status code is %d", e.StatusCode)
+	if e.Code != "" {
+		msg += ": " + string(e.Code)
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.RequestId != "" {
+		msg += fmt.Sprintf(" (request id '%s')", e.RequestId)
+	}
+	return msg
+}
+
+// ErrorCode returns the code of the error response in [err], or empty.
+func ErrorCode(err error) enging.Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// decodeError decodes the error response [data] of [resp]. A body which is not an error response of the servers,
+// e.g. of a proxy, is kept as the status text.
+func decodeError(resp *http.Response, data []byte) *Error {
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Detail:     http.StatusText(resp.StatusCode),
+		RequestId:  resp.Header.Get(interceptor.RequestIDHeader),
+	}
+	// either of [enging.Problem] or [enging.OAuthErrorResponse]
+	var body struct {
+		Detail           string      `json:"detail"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+		Code             enging.Code `json:"code"`
+		RequestId        string      `json:"request_id"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil || body.Code == "" {
+		return e
+	}
+	e.Code = body.Code
+	e.OAuthError = body.Error
+	e.Detail = body.Detail
+	if body.Error != "" {
+		e.Detail = body.ErrorDescription
+	}
+	if body.RequestId != "" {
+		e.RequestId = body.RequestId
+	}
+	return e
+}
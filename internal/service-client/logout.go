@@ -0,0 +1,53 @@
+package serviceclient
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yyyoichi/OhAuth0.1/internal/auth"
+)
+
+// BackChannelLogoutReceiver receives logout tokens from the authorization server
+// (OpenID Connect Back-Channel Logout 1.0) and calls onLogout for verified ones.
+type BackChannelLogoutReceiver struct {
+	// client secrets by client id, which logout tokens are signed with
+	secrets  map[string]string
+	onLogout func(clientId string, claims *auth.LogoutClaims)
+}
+
+func NewBackChannelLogoutReceiver(secrets map[string]string, onLogout func(clientId string, claims *auth.LogoutClaims)) *BackChannelLogoutReceiver {
+	return &BackChannelLogoutReceiver{
+		secrets:  secrets,
+		onLogout: onLogout,
+	}
+}
+
+func (r *BackChannelLogoutReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	logoutToken := req.PostFormValue("logout_token")
+	// the audience tells which client the token is for
+	var unverified auth.LogoutClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(logoutToken, &unverified); err != nil || len(unverified.Audience) != 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	clientId := unverified.Audience[0]
+	secret, found := r.secrets[clientId]
+	if !found {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	claims, err := auth.ParseLogoutToken(logoutToken, clientId, secret)
+	if err != nil {
+		slog.InfoContext(req.Context(), "cannot verify logout token", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.onLogout(clientId, claims)
+	w.WriteHeader(http.StatusOK)
+}
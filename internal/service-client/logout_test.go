@@ -0,0 +1,42 @@
+package serviceclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/internal/auth"
+)
+
+func TestBackChannelLogoutReceiver(t *testing.T) {
+	var loggedOut []string
+	receiver := NewBackChannelLogoutReceiver(map[string]string{"500": "secret"}, func(clientId string, claims *auth.LogoutClaims) {
+		loggedOut = append(loggedOut, clientId+":"+claims.Subject)
+	})
+	post := func(logoutToken string) int {
+		body := url.Values{"logout_token": {logoutToken}}.Encode()
+		req := httptest.NewRequest(http.MethodPost, "/backchannel_logout", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp := httptest.NewRecorder()
+		receiver.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	token, err := auth.NewLogoutToken(&apiv1.ServiceClient{Id: "500", Secret: "secret"}, "1", "sid")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, post(token))
+	assert.Equal(t, []string{"500:1"}, loggedOut)
+
+	wrongSecret, err := auth.NewLogoutToken(&apiv1.ServiceClient{Id: "500", Secret: "other"}, "1", "sid")
+	assert.NoError(t, err)
+	unknownClient, err := auth.NewLogoutToken(&apiv1.ServiceClient{Id: "999", Secret: "secret"}, "1", "sid")
+	assert.NoError(t, err)
+	for _, tt := range []string{wrongSecret, unknownClient, "", "invalid"} {
+		assert.Equal(t, http.StatusBadRequest, post(tt))
+	}
+	assert.Len(t, loggedOut, 1)
+}
@@ -1,6 +1,7 @@
 package server_test
 
 import (
+	"crypto/tls"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +15,7 @@ type options struct {
 	body    io.Reader
 	query   *url.Values
 	headers map[string]string
+	tls     *tls.ConnectionState
 }
 type Option func(options *options) error
 
@@ -41,6 +43,14 @@ func WithHeader(key, val string) Option {
 	}
 }
 
+// WithTLS serves the request as if it were received on the TLS connection [state].
+func WithTLS(state *tls.ConnectionState) Option {
+	return func(options *options) error {
+		options.tls = state
+		return nil
+	}
+}
+
 type Config struct {
 	Router *gin.Engine
 	Method string
@@ -65,6 +75,9 @@ func Serve(t *testing.T, config Config, opts ...Option) (*http.Request, *httptes
 	for k, v := range options.headers {
 		req.Header.Add(k, v)
 	}
+	if options.tls != nil {
+		req.TLS = options.tls
+	}
 	w := httptest.NewRecorder()
 	config.Router.ServeHTTP(w, req)
 	return req, w
@@ -0,0 +1,131 @@
+// Package tracing traces the requests across the servers with OpenTelemetry.
+// Spans carry the client, the grant type and the result of a request, but never secrets.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"connectrpc.com/connect"
+	"connectrpc.com/otelconnect"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/yyyoichi/OhAuth0.1/internal/tracing"
+
+// exporters of [NewExporter]
+const (
+	// spans are not exported.
+	ExporterNone = "none"
+	// spans are written as JSON, e.g. to the standard output.
+	ExporterStdout = "stdout"
+	// spans are sent to an OpenTelemetry collector with OTLP over HTTP.
+	ExporterOTLP = "otlp"
+)
+
+// attributes of the spans
+const (
+	ClientIdKey  = attribute.Key("oauth.client_id")
+	GrantTypeKey = attribute.Key("oauth.grant_type")
+	// 'success' or 'failure'
+	ResultKey = attribute.Key("oauth.result")
+)
+
+// Propagator carries the trace context across the servers by the 'traceparent' header.
+var Propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// NewExporter returns the exporter named [name]. [ExporterStdout] writes to [w], or to the standard output if nil.
+// [ExporterOTLP] sends to the collector at [endpoint], e.g. 'http://localhost:4318', or at the endpoint of
+// the OTEL_EXPORTER_OTLP_* environment variables if empty. It returns nil for [ExporterNone] or an empty name.
+func NewExporter(name, endpoint string, w io.Writer) (sdktrace.SpanExporter, error) {
+	switch name {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		if w == nil {
+			w = os.Stdout
+		}
+		return stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if endpoint != "" {
+			// plain HTTP if the scheme is 'http'
+			options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		// connects on the first export, not here
+		return otlptracehttp.New(context.Background(), options...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter '%s'", name)
+	}
+}
+
+// NewTracerProvider returns the provider of a server named [serviceName], which sends the spans to [exporter].
+// Any exporter of the SDK, e.g. OTLP, can be passed. Shut it down to flush the spans.
+func NewTracerProvider(serviceName string, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+}
+
+// NewInterceptor traces the Connect RPCs. The messages, which have tokens, are not recorded.
+// The remote parent is trusted, since the callers are the servers of this repository.
+func NewInterceptor(provider trace.TracerProvider) (connect.Interceptor, error) {
+	return otelconnect.NewInterceptor(
+		otelconnect.WithTracerProvider(provider),
+		otelconnect.WithPropagator(Propagator),
+		otelconnect.WithoutMetrics(),
+		otelconnect.WithTrustRemote(),
+	)
+}
+
+// Gin traces the requests of a gin router. The span is the parent of the calls made with the request context.
+// The handlers pass the span to the calls with the request context, 'ctx.Request.Context()'.
+func Gin(provider trace.TracerProvider) gin.HandlerFunc {
+	tracer := provider.Tracer(instrumentationName)
+	return func(ctx *gin.Context) {
+		parent := Propagator.Extract(ctx.Request.Context(), propagation.HeaderCarrier(ctx.Request.Header))
+		// the route, not the path, which may have ids
+		route := ctx.FullPath()
+		name := ctx.Request.Method
+		if route != "" {
+			name += " " + route
+		}
+		spanCtx, span := tracer.Start(parent, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", ctx.Request.Method),
+				attribute.String("http.route", route),
+			),
+		)
+		defer span.End()
+		ctx.Request = ctx.Request.WithContext(spanCtx)
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		result := "success"
+		if status >= 400 {
+			result = "failure"
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status), ResultKey.String(result))
+		if status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("status %d", status))
+		}
+	}
+}
+
+// SetAttributes sets [attrs] on the span of [ctx]. Never pass secrets.
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	apiv1 "github.com/yyyoichi/OhAuth0.1/api/v1"
+	"github.com/yyyoichi/OhAuth0.1/api/v1/apiv1connect"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+type pingHandler struct {
+	apiv1connect.UnimplementedDatabaseServiceHandler
+}
+
+func (h *pingHandler) Ping(context.Context, *connect.Request[apiv1.PingRequest]) (*connect.Response[apiv1.PingResponse], error) {
+	return connect.NewResponse(&apiv1.PingResponse{}), nil
+}
+
+func TestNewExporter(t *testing.T) {
+	for _, name := range []string{"", ExporterNone} {
+		exporter, err := NewExporter(name, "", nil)
+		assert.NoError(t, err)
+		assert.Nil(t, exporter)
+	}
+	_, err := NewExporter("jaeger", "", nil)
+	assert.Error(t, err)
+
+	// spans are written to the writer
+	var buf bytes.Buffer
+	exporter, err := NewExporter(ExporterStdout, "", &buf)
+	assert.NoError(t, err)
+	provider := NewTracerProvider("test-server", exporter)
+	_, span := provider.Tracer("test").Start(context.Background(), "hello")
+	span.End()
+	assert.NoError(t, provider.Shutdown(context.Background()))
+	assert.Contains(t, buf.String(), `"Name":"hello"`)
+	assert.Contains(t, buf.String(), "test-server")
+
+	// spans are sent to the collector
+	received := make(chan string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+	exporter, err = NewExporter(ExporterOTLP, collector.URL, nil)
+	assert.NoError(t, err)
+	provider = NewTracerProvider("test-server", exporter)
+	_, span = provider.Tracer("test").Start(context.Background(), "hello")
+	span.End()
+	assert.NoError(t, provider.Shutdown(context.Background()))
+	select {
+	case path := <-received:
+		assert.Equal(t, "/v1/traces", path)
+	default:
+		t.Fatal("no spans are sent")
+	}
+}
+
+func TestGin(t *testing.T) {
+	provider, exporter := newTestProvider()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Gin(provider))
+	router.GET("/clients/:client_id", func(ctx *gin.Context) {
+		SetAttributes(ctx.Request.Context(), ClientIdKey.String(ctx.Param("client_id")))
+		ctx.Status(http.StatusOK)
+	})
+	router.GET("/error", func(ctx *gin.Context) {
+		ctx.Status(http.StatusInternalServerError)
+	})
+
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	})
+	req := httptest.NewRequest(http.MethodGet, "/clients/500", nil)
+	Propagator.Inject(trace.ContextWithRemoteSpanContext(context.Background(), parent), propagation.HeaderCarrier(req.Header))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/error", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+	// named by the route
+	assert.Equal(t, "GET /clients/:client_id", spans[0].Name)
+	assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind)
+	assert.Equal(t, parent.TraceID(), spans[0].SpanContext.TraceID())
+	assert.Equal(t, parent.SpanID(), spans[0].Parent.SpanID())
+	attrs := attributes(spans[0])
+	assert.Equal(t, "500", attrs[ClientIdKey].AsString())
+	assert.Equal(t, "success", attrs[ResultKey].AsString())
+	assert.Equal(t, "/clients/:client_id", attrs["http.route"].AsString())
+
+	assert.Equal(t, "GET /error", spans[1].Name)
+	assert.Equal(t, "failure", attributes(spans[1])[ResultKey].AsString())
+	assert.Equal(t, codes.Error, spans[1].Status.Code)
+
+	assert.Equal(t, "GET", spans[2].Name)
+	assert.Equal(t, "failure", attributes(spans[2])[ResultKey].AsString())
+	assert.NotEqual(t, codes.Error, spans[2].Status.Code)
+}
+
+func TestInterceptor(t *testing.T) {
+	provider, exporter := newTestProvider()
+	tracer, err := NewInterceptor(provider)
+	assert.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.Handle(apiv1connect.NewDatabaseServiceHandler(&pingHandler{}, connect.WithInterceptors(tracer)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := apiv1connect.NewDatabaseServiceClient(server.Client(), server.URL, connect.WithInterceptors(tracer))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "login")
+	_, err = client.Ping(ctx, connect.NewRequest(&apiv1.PingRequest{}))
+	assert.NoError(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+	byKind := map[trace.SpanKind]tracetest.SpanStub{}
+	for _, span := range spans {
+		byKind[span.SpanKind] = span
+	}
+	clientSpan, serverSpan := byKind[trace.SpanKindClient], byKind[trace.SpanKindServer]
+	assert.Equal(t, "api.v1.DatabaseService/Ping", clientSpan.Name)
+	// the server continues the trace of the caller
+	assert.Equal(t, parent.SpanContext().TraceID(), serverSpan.SpanContext.TraceID())
+	assert.Equal(t, clientSpan.SpanContext.SpanID(), serverSpan.Parent.SpanID())
+	assert.Equal(t, parent.SpanContext().SpanID(), clientSpan.Parent.SpanID())
+}
@@ -8,8 +8,10 @@ import {
 } from "@/app/components/input";
 import { MyUl, MyUlLi } from "@/app/components/list";
 import type { NonNullablePick } from "@/app/components/types";
+import type { AuthorizationDetail } from "@/utils/api";
 
 export type AuthorizationFormProps = {
+	authorizationDetails: AuthorizationDetail[];
 	okButton: NonNullablePick<LoadButtonProps, "active" | "onClick">;
 	cancelButton: NonNullablePick<LoadButtonProps, "active" | "onClick">;
 };
@@ -36,7 +38,13 @@ export const AuthorizationForm = (props: AuthorizationFormProps) => {
 				<MyInputLabel {...labelProps} />
 				<MyInputDescription className="text-wrap" {...descriptionProps} />
 				<MyUl className="py-4">
-					<MyUlLi>{"View your profile"}</MyUlLi>
+					{props.authorizationDetails.length === 0 ? (
+						<MyUlLi>{"View your profile"}</MyUlLi>
+					) : (
+						props.authorizationDetails.map((detail, i) => (
+							<MyUlLi key={`${detail.type}-${i}`}>{describe(detail)}</MyUlLi>
+						))
+					)}
 				</MyUl>
 			</Forms.Content>
 			<Forms.Content>
@@ -48,3 +56,20 @@ export const AuthorizationForm = (props: AuthorizationFormProps) => {
 		</Forms.Container>
 	);
 };
+
+// e.g. 'View your profile: name, age (at http://localhost:8088)'
+const describe = (detail: AuthorizationDetail) => {
+	const actions = (detail.actions ?? []).join(", ");
+	const verb = actions.charAt(0).toUpperCase() + actions.slice(1);
+	let text = `${verb} your ${detail.type}`;
+	if (detail.identifier) {
+		text += ` '${detail.identifier}'`;
+	}
+	if (detail.datatypes && detail.datatypes.length > 0) {
+		text += `: ${detail.datatypes.join(", ")}`;
+	}
+	if (detail.locations && detail.locations.length > 0) {
+		text += ` (at ${detail.locations.join(", ")})`;
+	}
+	return text;
+};
@@ -1,5 +1,5 @@
 "use client";
-import type { ServiceClient } from "@/utils/api";
+import type { AuthenticationRequirements, ServiceClient } from "@/utils/api";
 import { ServiceClientProps } from "../lib/serviceClientProps";
 import {
 	BasicAuthenticationForm,
@@ -16,9 +16,24 @@ import { external } from "../lib/external";
 
 export type V1AuthPageProps = {
 	serviceClient: ServiceClient;
+	resource?: string[];
+	// JSON array of 'authorization_details' (RFC 9396)
+	authorizationDetails?: string;
+	// step-up of the login, forwarded to the authorization server
+	authenticationRequirements?: AuthenticationRequirements;
 };
-export function V1AuthPage({ serviceClient }: V1AuthPageProps) {
-	const sc = new ServiceClientProps(serviceClient);
+export function V1AuthPage({
+	serviceClient,
+	resource,
+	authorizationDetails,
+	authenticationRequirements,
+}: V1AuthPageProps) {
+	const sc = new ServiceClientProps(
+		serviceClient,
+		resource,
+		authorizationDetails,
+		authenticationRequirements,
+	);
 	const props = getV1AuthProps(sc);
 	return (
 		<>
@@ -115,6 +130,7 @@ export const getV1AuthProps = (sc: ServiceClientProps) => {
 		},
 	};
 	const authorizationProps: AuthorizationFormProps = {
+		authorizationDetails: sc.authorizationDetails(),
 		okButton: {
 			active: buttonIsActive,
 			onClick: (e) => {
@@ -125,6 +141,9 @@ export const getV1AuthProps = (sc: ServiceClientProps) => {
 						clientId: sc.clientId(),
 						jwt: Auth.jwt,
 						scope: sc.scope(),
+						resource: sc.resource(),
+						authorizationDetails: sc.rawAuthorizationDetails(),
+						...sc.authenticationRequirements(),
 					})
 					.then((resp) => {
 						if (resp instanceof Error) {
@@ -1,7 +1,16 @@
-import type { ServiceClient as sc } from "@/utils/api";
+import type {
+	AuthenticationRequirements,
+	AuthorizationDetail,
+	ServiceClient as sc,
+} from "@/utils/api";
 
 export class ServiceClientProps {
-	constructor(readonly sc: sc) {}
+	constructor(
+		readonly sc: sc,
+		readonly resources: string[] = [],
+		readonly rawDetails?: string,
+		readonly requirements: AuthenticationRequirements = {},
+	) {}
 	redirect(code: string) {
 		const url = `${this.sc.redirectUri}?code=${code}`;
 		window.location.assign(url);
@@ -10,4 +19,19 @@ export class ServiceClientProps {
 	clientId = () => this.sc.clientId;
 	redirectUri = () => this.sc.redirectUri;
 	scope = () => this.sc.scope;
+	resource = () => this.resources;
+	rawAuthorizationDetails = () => this.rawDetails;
+	authenticationRequirements = () => this.requirements;
+	// invalid details are shown as is and rejected by the authorization server.
+	authorizationDetails = (): AuthorizationDetail[] => {
+		if (!this.rawDetails) {
+			return [];
+		}
+		try {
+			const details = JSON.parse(this.rawDetails);
+			return Array.isArray(details) ? details : [];
+		} catch {
+			return [];
+		}
+	};
 }
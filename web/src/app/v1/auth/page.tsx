@@ -18,6 +18,19 @@ export default async function Page({
 		case "object":
 			clientId = searchParams.client_id[0];
 	}
+	const resource = [searchParams.resource ?? []].flat();
+	const authorizationDetails =
+		typeof searchParams.authorization_details === "string"
+			? searchParams.authorization_details
+			: undefined;
+	const first = (v: string | string[] | undefined) =>
+		typeof v === "object" ? v[0] : v;
+	const maxAge = first(searchParams.max_age);
+	const authenticationRequirements = {
+		prompt: first(searchParams.prompt),
+		maxAge: maxAge === undefined ? undefined : Number(maxAge),
+		acrValues: first(searchParams.acr_values),
+	};
 	const serviceClient = await external.getServiceClient({ clientId });
 	if (serviceClient instanceof Error) {
 		return <div>Error: {serviceClient.message}</div>;
@@ -29,6 +42,9 @@ export default async function Page({
 			scope: serviceClient.scope,
 			redirectUri: serviceClient.redirectUri,
 		},
+		resource,
+		authorizationDetails,
+		authenticationRequirements,
 	};
 	return <V1AuthPage {...pageProps} />;
 }
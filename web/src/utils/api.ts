@@ -1,8 +1,10 @@
 const PORT = process.env.NEXT_PUBLIC_AUTHORIZATION_SERVER_PORT;
-if (!PORT) {
+// e.g. 'https://localhost:8080' with the certificates of 'dev-certs'
+const SERVER_URL = process.env.NEXT_PUBLIC_AUTHORIZATION_SERVER_URL;
+if (!PORT && !SERVER_URL) {
 	console.error("no port");
 }
-const HOST = `http://localhost:${PORT}`;
+const HOST = SERVER_URL || `http://localhost:${PORT}`;
 
 export type GetServiceClient = (param: {
 	clientId: string;
@@ -18,7 +20,25 @@ export type PostAuthorization = (param: {
 	jwt: string;
 	clientId: string;
 	scope: string;
-}) => Promise<Authorization | Error>;
+	resource?: string[];
+	authorizationDetails?: string; // JSON array (RFC 9396)
+} & AuthenticationRequirements) => Promise<Authorization | Error>;
+
+// 'prompt', 'max_age' and 'acr_values' of the authorization request (OpenID Connect Core 1.0)
+export type AuthenticationRequirements = {
+	prompt?: string;
+	maxAge?: number;
+	acrValues?: string;
+};
+
+// an entry of 'authorization_details' (RFC 9396)
+export type AuthorizationDetail = {
+	type: string;
+	locations?: string[];
+	actions?: string[];
+	datatypes?: string[];
+	identifier?: string;
+};
 
 export class ServiceClient {
 	static get: GetServiceClient = async (param) => {
@@ -114,6 +134,11 @@ export class Authorization {
 				client_id: param.clientId,
 				response_type: "code",
 				scope: param.scope,
+				resource: param.resource,
+				authorization_details: param.authorizationDetails,
+				prompt: param.prompt,
+				max_age: param.maxAge,
+				acr_values: param.acrValues,
 			}),
 		});
 		const body = await json<{ code: string }>(resp);
@@ -177,7 +202,8 @@ const json = async <T>(resp: Response) => {
 	const body = await resp.json();
 	const Err = error(resp.status);
 	if (Err !== null) {
-		return new Err(body.status);
+		// problem details (RFC 9457) or the error response of OAuth
+		return new Err(body.detail ?? body.error_description ?? body.code);
 	}
 	return body as T;
 };